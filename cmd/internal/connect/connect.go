@@ -0,0 +1,180 @@
+// Package connect contains the connection and arbitration logic shared by the
+// commands in this repository.
+package connect
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/client"
+	"github.com/antoninbas/p4runtime-go-client/pkg/logging"
+)
+
+const (
+	defaultDeviceID       = 0
+	defaultElectionID     = 1
+	defaultPrimaryTimeout = 5 * time.Second
+)
+
+var (
+	DefaultAddr = fmt.Sprintf("127.0.0.1:%d", client.P4RuntimePort)
+)
+
+// Flags are the command-line flags used to connect to a P4Runtime server.
+type Flags struct {
+	Addr       string
+	DeviceID   uint64
+	ElectionID uint64
+	CACert     string
+	Cert       string
+	Key        string
+	ServerName string
+	AuthToken  string
+}
+
+// RegisterFlags registers -addr, -device-id, -election-id and the TLS and
+// authentication flags in fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Addr, "addr", DefaultAddr, "P4Runtime server socket")
+	fs.Uint64Var(&f.DeviceID, "device-id", defaultDeviceID, "Device id")
+	fs.Uint64Var(&f.ElectionID, "election-id", defaultElectionID, "Election id (low 64 bits)")
+	fs.StringVar(&f.CACert, "ca-cert", "", "CA certificate (PEM) used to verify the server; enables TLS")
+	fs.StringVar(&f.Cert, "cert", "", "Client certificate (PEM) for mutual TLS; enables TLS")
+	fs.StringVar(&f.Key, "key", "", "Client private key (PEM) for mutual TLS")
+	fs.StringVar(&f.ServerName, "server-name", "", "Override the server name used to verify the server certificate")
+	fs.StringVar(&f.AuthToken, "auth-token", "", "Bearer token sent with all RPCs")
+	return f
+}
+
+// ConnectOptions returns the client.ConnectOptions corresponding to the flags.
+func (f *Flags) ConnectOptions() client.ConnectOptions {
+	options := client.DefaultConnectOptions
+	if f.CACert != "" || f.Cert != "" {
+		options.TLS = &client.TLSOptions{
+			CACertFile: f.CACert,
+			CertFile:   f.Cert,
+			KeyFile:    f.Key,
+			ServerName: f.ServerName,
+		}
+	}
+	options.AuthToken = f.AuthToken
+	return options
+}
+
+// Connection is a gRPC connection to a P4Runtime server.
+type Connection struct {
+	Conn         *grpc.ClientConn
+	P4RtClient   p4_v1.P4RuntimeClient
+	Capabilities *p4_v1.CapabilitiesResponse
+}
+
+// Dial connects to the P4Runtime server configured in f and issues a
+// Capabilities RPC to make sure that the server is reachable.
+func Dial(ctx context.Context, f *Flags) (*Connection, error) {
+	log.Infof("Connecting to server at %s", f.Addr)
+	conn, err := client.DialConn(ctx, f.Addr, f.ConnectOptions())
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to server: %v", err)
+	}
+	c := p4_v1.NewP4RuntimeClient(conn)
+	resp, err := c.Capabilities(ctx, &p4_v1.CapabilitiesRequest{})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error in Capabilities RPC: %v", err)
+	}
+	log.Infof("P4Runtime server version is %s", resp.P4RuntimeApiVersion)
+	return &Connection{
+		Conn:         conn,
+		P4RtClient:   c,
+		Capabilities: resp,
+	}, nil
+}
+
+func (c *Connection) Close() error {
+	return c.Conn.Close()
+}
+
+// NewClient creates a Client for the device, without starting the stream
+// channel. Such a Client can only be used for Read RPCs and for
+// GetForwardingPipelineConfig. The Client logs to the standard logrus logger.
+func (c *Connection) NewClient(deviceID uint64, electionID uint64) *client.Client {
+	return client.NewClient(
+		c.P4RtClient,
+		deviceID,
+		&p4_v1.Uint128{High: 0, Low: electionID},
+		client.WithLogger(logging.NewLogrusLogger(log.StandardLogger())),
+	)
+}
+
+// PrimaryOptions configures StartPrimary.
+type PrimaryOptions struct {
+	// MessageCh receives stream messages other than arbitration updates. It
+	// can be nil, in which case these messages are dropped.
+	MessageCh chan *p4_v1.StreamMessageResponse
+	// Timeout is how long to wait for the Client to become primary.
+	Timeout time.Duration
+}
+
+var DefaultPrimaryOptions = PrimaryOptions{
+	MessageCh: nil,
+	Timeout:   defaultPrimaryTimeout,
+}
+
+// StartPrimary creates a Client for the device, runs its stream channel until
+// stopCh is closed, and waits for the Client to become the primary client.
+// Subsequent arbitration updates are logged, as well as the error which
+// terminates the stream channel, if any.
+func (c *Connection) StartPrimary(
+	ctx context.Context,
+	deviceID uint64,
+	electionID uint64,
+	stopCh <-chan struct{},
+	options PrimaryOptions,
+) (*client.Client, error) {
+	p4RtC := c.NewClient(deviceID, electionID)
+	arbitrationCh := make(chan bool)
+	runErrCh := make(chan error, 1)
+	go func() {
+		err := p4RtC.Run(stopCh, arbitrationCh, options.MessageCh)
+		if err != nil {
+			log.Errorf("Stream channel failed: %v", err)
+		}
+		runErrCh <- err
+	}()
+
+	waitCh := make(chan struct{})
+
+	go func() {
+		sent := false
+		for isPrimary := range arbitrationCh {
+			if isPrimary {
+				log.Infof("We are the primary client!")
+				if !sent {
+					close(waitCh)
+					sent = true
+				}
+			} else {
+				log.Infof("We are not the primary client!")
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, options.Timeout)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("could not become the primary client within %v", options.Timeout)
+	case err := <-runErrCh:
+		return nil, fmt.Errorf("stream channel terminated before becoming the primary client: %v", err)
+	case <-waitCh:
+	}
+	return p4RtC, nil
+}
@@ -10,25 +10,20 @@ import (
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 
 	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
 
+	"github.com/antoninbas/p4runtime-go-client/cmd/internal/connect"
 	"github.com/antoninbas/p4runtime-go-client/pkg/client"
+	"github.com/antoninbas/p4runtime-go-client/pkg/logging"
 	"github.com/antoninbas/p4runtime-go-client/pkg/signals"
 	"github.com/antoninbas/p4runtime-go-client/pkg/util/conversion"
 )
 
 const (
-	defaultDeviceID = 0
-	mgrp            = 0xab
-	macTimeout      = 10 * time.Second
-	defaultPorts    = "0,1,2,3,4,5,6,7"
-)
-
-var (
-	defaultAddr = fmt.Sprintf("127.0.0.1:%d", client.P4RuntimePort)
+	mgrp         = 0xab
+	macTimeout   = 10 * time.Second
+	defaultPorts = "0,1,2,3,4,5,6,7"
 )
 
 func portsToSlice(ports string) ([]uint32, error) {
@@ -179,7 +174,7 @@ func handleStreamMessages(ctx context.Context, p4RtC *client.Client, messageCh <
 			log.Debugf("Received IdleTimeoutNotification")
 			forgetEntries(ctx, p4RtC, m.IdleTimeoutNotification)
 		case *p4_v1.StreamMessageResponse_Error:
-			log.Errorf("Received StreamError")
+			log.Errorf("Received StreamError: %v", client.DecodeStreamError(m.Error))
 		default:
 			log.Errorf("Received unknown stream message")
 		}
@@ -237,10 +232,7 @@ func printPortCounters(p4RtC *client.Client, ports []uint32, period time.Duratio
 func main() {
 	ctx := context.Background()
 
-	var addr string
-	flag.StringVar(&addr, "addr", defaultAddr, "P4Runtime server socket")
-	var deviceID uint64
-	flag.Uint64Var(&deviceID, "device-id", defaultDeviceID, "Device id")
+	connFlags := connect.RegisterFlags(flag.CommandLine)
 	var verbose bool
 	flag.BoolVar(&verbose, "verbose", false, "Enable verbose mode with debug log messages")
 	var binPath string
@@ -278,46 +270,23 @@ func main() {
 		}
 	}
 
-	log.Infof("Connecting to server at %s", addr)
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := connect.Dial(ctx, connFlags)
 	if err != nil {
-		log.Fatalf("Cannot connect to server: %v", err)
+		log.Fatalf("%v", err)
 	}
 	defer conn.Close()
 
-	c := p4_v1.NewP4RuntimeClient(conn)
-	resp, err := c.Capabilities(ctx, &p4_v1.CapabilitiesRequest{})
-	if err != nil {
-		log.Fatalf("Error in Capabilities RPC: %v", err)
-	}
-	log.Infof("P4Runtime server version is %s", resp.P4RuntimeApiVersion)
-
-	stopCh := signals.RegisterSignalHandlers()
-
-	electionID := &p4_v1.Uint128{High: 0, Low: 1}
+	stopCh := signals.RegisterSignalHandlersWithLogger(logging.NewLogrusLogger(log.StandardLogger()))
 
-	p4RtC := client.NewClient(c, deviceID, electionID)
-	arbitrationCh := make(chan bool)
 	messageCh := make(chan *p4_v1.StreamMessageResponse, 1000)
 	defer close(messageCh)
-	go p4RtC.Run(stopCh, arbitrationCh, messageCh)
-
-	waitCh := make(chan struct{})
-
-	go func() {
-		sent := false
-		for isPrimary := range arbitrationCh {
-			if isPrimary {
-				log.Infof("We are the primary client!")
-				if !sent {
-					waitCh <- struct{}{}
-					sent = true
-				}
-			} else {
-				log.Infof("We are not the primary client!")
-			}
-		}
-	}()
+	p4RtC, err := conn.StartPrimary(ctx, connFlags.DeviceID, connFlags.ElectionID, stopCh, connect.PrimaryOptions{
+		MessageCh: messageCh,
+		Timeout:   5 * time.Second,
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
 	// it would also be safe to spawn multiple goroutines to handle messages from the channel
 	go func() {
@@ -325,17 +294,6 @@ func main() {
 		handleStreamMessages(ctx, p4RtC, messageCh)
 	}()
 
-	func() {
-		timeout := 5 * time.Second
-		ctx, cancel := context.WithTimeout(ctx, timeout)
-		defer cancel()
-		select {
-		case <-ctx.Done():
-			log.Fatalf("Could not become the primary client within %v", timeout)
-		case <-waitCh:
-		}
-	}()
-
 	log.Info("Setting forwarding pipe")
 	if _, err := p4RtC.SetFwdPipeFromBytes(ctx, binBytes, p4infoBytes, 0); err != nil {
 		log.Fatalf("Error when setting forwarding pipe: %v", err)
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/client"
+)
+
+const (
+	defaultWriteBatchSize = 100
+)
+
+func runCapabilities(ctx context.Context, c *ctl, args []string) error {
+	fs := newFlagSet("capabilities", "")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs); err != nil {
+		return err
+	}
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	if c.json() {
+		return c.writeProtoJSON(conn.Capabilities)
+	}
+	c.printf("P4Runtime API version: %s\n", conn.Capabilities.P4RuntimeApiVersion)
+	return nil
+}
+
+func parseP4InfoEncoding(s string) (client.P4InfoEncoding, error) {
+	switch s {
+	case "text":
+		return client.P4InfoEncodingText, nil
+	case "binary":
+		return client.P4InfoEncodingBinary, nil
+	case "json":
+		return client.P4InfoEncodingJSON, nil
+	}
+	return client.P4InfoEncodingAuto, newUsageError("invalid P4Info format %q", s)
+}
+
+func runGetPipeline(ctx context.Context, c *ctl, args []string) error {
+	fs := newFlagSet("get-pipeline", "")
+	p4infoOut := fs.String("p4info-out", "", "Path of the file to write the P4Info to")
+	p4infoFormat := fs.String("p4info-format", "text", "P4Info file format: text, binary or json")
+	binOut := fs.String("bin-out", "", "Path of the file to write the device config to")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs); err != nil {
+		return err
+	}
+	encoding, err := parseP4InfoEncoding(*p4infoFormat)
+	if err != nil {
+		return err
+	}
+	if *p4infoOut == "" && *binOut == "" {
+		return newUsageError("at least one of -p4info-out and -bin-out is required")
+	}
+
+	p4RtC, err := c.newClient(ctx, false)
+	if err != nil {
+		return err
+	}
+	config, err := p4RtC.GetFwdPipe(ctx, client.GetFwdPipeAll)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return fmt.Errorf("no forwarding pipeline config on the switch")
+	}
+	if *p4infoOut != "" {
+		if config.P4Info == nil {
+			return fmt.Errorf("switch did not return a P4Info")
+		}
+		b, err := client.MarshalP4Info(config.P4Info, encoding)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*p4infoOut, b, 0644); err != nil {
+			return fmt.Errorf("error when writing P4Info: %v", err)
+		}
+	}
+	if *binOut != "" {
+		if err := os.WriteFile(*binOut, config.P4DeviceConfig, 0644); err != nil {
+			return fmt.Errorf("error when writing device config: %v", err)
+		}
+	}
+
+	if c.json() {
+		return c.writeJSON(map[string]interface{}{
+			"cookie":    config.Cookie,
+			"p4info":    *p4infoOut,
+			"binConfig": *binOut,
+		})
+	}
+	c.printf("Cookie: %d\n", config.Cookie)
+	return nil
+}
+
+func runSetPipeline(ctx context.Context, c *ctl, args []string) error {
+	fs := newFlagSet("set-pipeline", "")
+	binPath := fs.String("bin", "", "Path to P4 bin")
+	p4infoPath := fs.String("p4info", "", "Path to P4Info")
+	cookie := fs.Uint64("cookie", 0, "Cookie of the pipeline config")
+	action := fs.String("action", "commit", "One of commit (VERIFY_AND_COMMIT), verify (VERIFY), save (VERIFY_AND_SAVE) or reconcile (RECONCILE_AND_COMMIT)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs); err != nil {
+		return err
+	}
+	if *binPath == "" || *p4infoPath == "" {
+		return newUsageError("-bin and -p4info are required")
+	}
+	var pipelineAction p4_v1.SetForwardingPipelineConfigRequest_Action
+	switch *action {
+	case "commit":
+		pipelineAction = p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_COMMIT
+	case "verify":
+		pipelineAction = p4_v1.SetForwardingPipelineConfigRequest_VERIFY
+	case "save":
+		pipelineAction = p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_SAVE
+	case "reconcile":
+		pipelineAction = p4_v1.SetForwardingPipelineConfigRequest_RECONCILE_AND_COMMIT
+	default:
+		return newUsageError("invalid action %q", *action)
+	}
+	binBytes, err := os.ReadFile(*binPath)
+	if err != nil {
+		return fmt.Errorf("error when reading binary config: %v", err)
+	}
+	p4infoBytes, err := os.ReadFile(*p4infoPath)
+	if err != nil {
+		return fmt.Errorf("error when reading P4Info file: %v", err)
+	}
+
+	p4RtC, err := c.newPrimaryClient(ctx, false)
+	if err != nil {
+		return err
+	}
+	if _, err := p4RtC.SetFwdPipeFromBytesWithAction(ctx, binBytes, p4infoBytes, *cookie, pipelineAction); err != nil {
+		return err
+	}
+	if c.json() {
+		return c.writeJSON(map[string]interface{}{
+			"cookie": *cookie,
+			"action": pipelineAction.String(),
+		})
+	}
+	c.printf("Forwarding pipeline config set (%s)\n", pipelineAction)
+	return nil
+}
+
+func (c *ctl) printTableEntries(p4RtC *client.Client, entries []*p4_v1.TableEntry) error {
+	if c.json() {
+		msgs := make([]proto.Message, 0, len(entries))
+		for _, entry := range entries {
+			msgs = append(msgs, entry)
+		}
+		return c.writeProtoJSONList(msgs)
+	}
+	for _, entry := range entries {
+		s, err := p4RtC.FormatTableEntry(entry)
+		if err != nil {
+			return err
+		}
+		c.printf("%s\n", s)
+	}
+	return nil
+}
+
+func hasTable(p4RtC *client.Client, name string) bool {
+	for _, table := range p4RtC.P4Info().Tables {
+		if table.Preamble.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func runRead(ctx context.Context, c *ctl, args []string) error {
+	fs := newFlagSet("read", "<table>")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs, "<table>"); err != nil {
+		return err
+	}
+	p4RtC, err := c.newClient(ctx, true)
+	if err != nil {
+		return err
+	}
+	table := fs.Arg(0)
+	if !hasTable(p4RtC, table) {
+		return fmt.Errorf("unknown table %q", table)
+	}
+	entries, err := p4RtC.ReadTableEntryWildcard(ctx, table)
+	if err != nil {
+		return err
+	}
+	return c.printTableEntries(p4RtC, entries)
+}
+
+func runWrite(ctx context.Context, c *ctl, args []string) error {
+	fs := newFlagSet("write", "")
+	path := fs.String("f", "", "Path to the file with table entries, - for stdin")
+	updateType := fs.String("type", "insert", "Update type: insert, modify or delete")
+	batchSize := fs.Int("batch-size", defaultWriteBatchSize, "Maximum number of updates per WriteRequest")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs); err != nil {
+		return err
+	}
+	if *path == "" {
+		return newUsageError("-f is required")
+	}
+	if *batchSize <= 0 {
+		return newUsageError("-batch-size must be positive")
+	}
+	var t p4_v1.Update_Type
+	switch *updateType {
+	case "insert":
+		t = p4_v1.Update_INSERT
+	case "modify":
+		t = p4_v1.Update_MODIFY
+	case "delete":
+		t = p4_v1.Update_DELETE
+	default:
+		return newUsageError("invalid update type %q", *updateType)
+	}
+
+	f := os.Stdin
+	if *path != "-" {
+		var err error
+		if f, err = os.Open(*path); err != nil {
+			return fmt.Errorf("error when opening entries file: %v", err)
+		}
+		defer f.Close()
+	}
+
+	p4RtC, err := c.newPrimaryClient(ctx, true)
+	if err != nil {
+		return err
+	}
+	entries, err := p4RtC.ParseTableEntries(f)
+	if err != nil {
+		return err
+	}
+	written := 0
+	for start := 0; start < len(entries); start += *batchSize {
+		end := start + *batchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		updates := make([]*p4_v1.Update, 0, end-start)
+		for _, entry := range entries[start:end] {
+			updates = append(updates, &p4_v1.Update{
+				Type:   t,
+				Entity: &p4_v1.Entity{Entity: &p4_v1.Entity_TableEntry{TableEntry: entry}},
+			})
+		}
+		if err := p4RtC.WriteUpdates(ctx, updates); err != nil {
+			return fmt.Errorf("error when writing entries %d to %d: %v", start+1, end, err)
+		}
+		written += len(updates)
+	}
+	if c.json() {
+		return c.writeJSON(map[string]interface{}{"written": written})
+	}
+	c.printf("%d entries written\n", written)
+	return nil
+}
+
+func runCounters(ctx context.Context, c *ctl, args []string) error {
+	fs := newFlagSet("counters", "<counter>")
+	index := fs.Int64("index", -1, "Only read this index")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs, "<counter>"); err != nil {
+		return err
+	}
+	p4RtC, err := c.newClient(ctx, true)
+	if err != nil {
+		return err
+	}
+	var counterID uint32
+	for _, counter := range p4RtC.P4Info().Counters {
+		if counter.Preamble.Name == fs.Arg(0) {
+			counterID = counter.Preamble.Id
+		}
+	}
+	if counterID == 0 {
+		return fmt.Errorf("unknown counter %q", fs.Arg(0))
+	}
+
+	entry := &p4_v1.CounterEntry{CounterId: counterID}
+	if *index >= 0 {
+		entry.Index = &p4_v1.Index{Index: *index}
+	}
+	var entries []*p4_v1.CounterEntry
+	readEntityCh := make(chan *p4_v1.Entity, 100)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for readEntity := range readEntityCh {
+			if readEntry := readEntity.GetCounterEntry(); readEntry != nil {
+				entries = append(entries, readEntry)
+			}
+		}
+	}()
+	err = p4RtC.ReadEntityWildcard(ctx, &p4_v1.Entity{
+		Entity: &p4_v1.Entity_CounterEntry{CounterEntry: entry},
+	}, readEntityCh)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	if c.json() {
+		msgs := make([]proto.Message, 0, len(entries))
+		for _, entry := range entries {
+			msgs = append(msgs, entry)
+		}
+		return c.writeProtoJSONList(msgs)
+	}
+	for _, entry := range entries {
+		c.printf("%d: packets=%d bytes=%d\n", entry.GetIndex().GetIndex(), entry.GetData().GetPacketCount(), entry.GetData().GetByteCount())
+	}
+	return nil
+}
+
+func runClear(ctx context.Context, c *ctl, args []string) error {
+	fs := newFlagSet("clear", "<table>")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs, "<table>"); err != nil {
+		return err
+	}
+	p4RtC, err := c.newPrimaryClient(ctx, true)
+	if err != nil {
+		return err
+	}
+	table := fs.Arg(0)
+	if !hasTable(p4RtC, table) {
+		return fmt.Errorf("unknown table %q", table)
+	}
+	entries, err := p4RtC.ReadTableEntryWildcard(ctx, table)
+	if err != nil {
+		return err
+	}
+	updates := make([]*p4_v1.Update, 0, len(entries))
+	for _, entry := range entries {
+		// only the key is needed for DELETE
+		key := &p4_v1.TableEntry{
+			TableId:  entry.TableId,
+			Match:    entry.Match,
+			Priority: entry.Priority,
+		}
+		updates = append(updates, &p4_v1.Update{
+			Type:   p4_v1.Update_DELETE,
+			Entity: &p4_v1.Entity{Entity: &p4_v1.Entity_TableEntry{TableEntry: key}},
+		})
+	}
+	if len(updates) > 0 {
+		if err := p4RtC.WriteUpdates(ctx, updates); err != nil {
+			return err
+		}
+	}
+	if c.json() {
+		return c.writeJSON(map[string]interface{}{"deleted": len(updates)})
+	}
+	c.printf("%d entries deleted\n", len(updates))
+	return nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/antoninbas/p4runtime-go-client/cmd/internal/connect"
+	"github.com/antoninbas/p4runtime-go-client/pkg/client"
+)
+
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+
+	outputText = "text"
+	outputJSON = "json"
+
+	defaultTimeout = 30 * time.Second
+)
+
+// usageError is returned by subcommands when the command line is invalid. It
+// results in exit code 2 instead of 1.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func newUsageError(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+type subcommand struct {
+	name  string
+	usage string
+	help  string
+	run   func(ctx context.Context, ctl *ctl, args []string) error
+}
+
+var subcommands = []*subcommand{
+	{"capabilities", "", "Print the P4Runtime API version of the server", runCapabilities},
+	{"get-pipeline", "[-p4info-out <path>] [-p4info-format text|binary|json] [-bin-out <path>]", "Retrieve the forwarding pipeline config and write it to files", runGetPipeline},
+	{"set-pipeline", "-bin <path> -p4info <path> [-cookie <n>] [-action commit|verify|save|reconcile]", "Push a forwarding pipeline config", runSetPipeline},
+	{"read", "<table>", "Read all entries of a table", runRead},
+	{"write", "-f <path> [-type insert|modify|delete] [-batch-size <n>]", "Write table entries from a file in the p4rt-shell text format", runWrite},
+	{"counters", "[-index <n>] <counter>", "Read an indirect counter", runCounters},
+	{"clear", "<table>", "Delete all entries of a table", runClear},
+}
+
+// ctl holds the state shared by all subcommands.
+type ctl struct {
+	connFlags  *connect.Flags
+	output     string
+	p4infoPath string
+	out        io.Writer
+	conn       *connect.Connection
+	stopCh     chan struct{}
+}
+
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "Usage: %s [options] <command> [command options] [args]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range subcommands {
+		fmt.Fprintf(w, "  %s\n        %s\n", strings.TrimSpace(cmd.name+" "+cmd.usage), cmd.help)
+	}
+	fmt.Fprintf(w, "\nOptions:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	c := &ctl{
+		connFlags: connect.RegisterFlags(flag.CommandLine),
+		out:       os.Stdout,
+		stopCh:    make(chan struct{}),
+	}
+	defer close(c.stopCh)
+	flag.StringVar(&c.output, "o", outputText, "Output format: text or json")
+	flag.StringVar(&c.p4infoPath, "p4info", "", "Path to the P4Info used to resolve names (if omitted, it is retrieved from the switch)")
+	var timeout time.Duration
+	flag.DurationVar(&timeout, "timeout", defaultTimeout, "Timeout for the whole command")
+	var verbose bool
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose mode with debug log messages")
+	flag.Usage = usage
+
+	flag.Parse()
+
+	// keep stderr quiet by default, as this tool is meant to be used in scripts
+	log.SetLevel(log.WarnLevel)
+	if verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	if c.output != outputText && c.output != outputJSON {
+		fmt.Fprintf(os.Stderr, "Invalid output format %q\n", c.output)
+		return exitUsage
+	}
+	if flag.NArg() == 0 {
+		usage()
+		return exitUsage
+	}
+	var cmd *subcommand
+	for _, sc := range subcommands {
+		if sc.name == flag.Arg(0) {
+			cmd = sc
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", flag.Arg(0))
+		usage()
+		return exitUsage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	defer func() {
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	}()
+
+	err := cmd.run(ctx, c, flag.Args()[1:])
+	if err == nil {
+		return exitOK
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	var uErr *usageError
+	if errors.As(err, &uErr) {
+		return exitUsage
+	}
+	return exitError
+}
+
+// newFlagSet returns a FlagSet for the subcommand, which returns errors
+// instead of exiting.
+func newFlagSet(cmd string, argsUsage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [options] %s %s\n", os.Args[0], cmd, argsUsage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses the subcommand flags; parsing errors are usage errors.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{msg: err.Error()}
+	}
+	return nil
+}
+
+func (c *ctl) connect(ctx context.Context) (*connect.Connection, error) {
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	conn, err := connect.Dial(ctx, c.connFlags)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// newClient returns a Client which can be used for reads. If requireP4Info is
+// true, the P4Info is loaded from the -p4info file or from the switch.
+func (c *ctl) newClient(ctx context.Context, requireP4Info bool) (*client.Client, error) {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p4RtC := conn.NewClient(c.connFlags.DeviceID, c.connFlags.ElectionID)
+	if requireP4Info {
+		if err := c.loadP4Info(ctx, p4RtC); err != nil {
+			return nil, err
+		}
+	}
+	return p4RtC, nil
+}
+
+// newPrimaryClient returns a Client which is the primary client for the
+// device and can therefore be used for writes.
+func (c *ctl) newPrimaryClient(ctx context.Context, requireP4Info bool) (*client.Client, error) {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p4RtC, err := conn.StartPrimary(ctx, c.connFlags.DeviceID, c.connFlags.ElectionID, c.stopCh, connect.DefaultPrimaryOptions)
+	if err != nil {
+		return nil, err
+	}
+	if requireP4Info {
+		if err := c.loadP4Info(ctx, p4RtC); err != nil {
+			return nil, err
+		}
+	}
+	return p4RtC, nil
+}
+
+func (c *ctl) loadP4Info(ctx context.Context, p4RtC *client.Client) error {
+	if c.p4infoPath != "" {
+		p4Info, err := client.LoadP4InfoFile(c.p4infoPath, client.P4InfoEncodingAuto)
+		if err != nil {
+			return err
+		}
+		p4RtC.SetP4Info(p4Info)
+		return nil
+	}
+	config, err := p4RtC.GetFwdPipe(ctx, client.GetFwdPipeP4InfoAndCookie)
+	if err != nil {
+		return fmt.Errorf("error when retrieving P4Info: %v", err)
+	}
+	if config == nil || config.P4Info == nil {
+		return fmt.Errorf("no forwarding pipeline config on the switch")
+	}
+	return nil
+}
+
+func (c *ctl) json() bool {
+	return c.output == outputJSON
+}
+
+func (c *ctl) printf(format string, args ...interface{}) {
+	fmt.Fprintf(c.out, format, args...)
+}
+
+func (c *ctl) writeJSON(v interface{}) error {
+	encoder := json.NewEncoder(c.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writeProtoJSONList writes the messages as a JSON array, using protojson for
+// each message.
+func (c *ctl) writeProtoJSONList(msgs []proto.Message) error {
+	out := make([]json.RawMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		b, err := protojson.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		out = append(out, b)
+	}
+	return c.writeJSON(out)
+}
+
+func (c *ctl) writeProtoJSON(msg proto.Message) error {
+	b, err := protojson.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.writeJSON(json.RawMessage(b))
+}
+
+func expectArgs(fs *flag.FlagSet, names ...string) error {
+	if fs.NArg() != len(names) {
+		return newUsageError("expected arguments: %s", strings.Join(names, " "))
+	}
+	return nil
+}
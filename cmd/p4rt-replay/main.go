@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/cmd/internal/connect"
+	"github.com/antoninbas/p4runtime-go-client/pkg/recorder"
+)
+
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "Usage:\n")
+	fmt.Fprintf(w, "  %s [options] <recording>\n        Replay the writes from a recording against a server\n", os.Args[0])
+	fmt.Fprintf(w, "  %s -verify <expected recording> <actual recording>\n        Check that two recordings contain the same requests\n", os.Args[0])
+	fmt.Fprintf(w, "\nOptions:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	connFlags := connect.RegisterFlags(flag.CommandLine)
+	var verify, pipeline, preserveTiming, ignoreOutcome, verbose bool
+	flag.BoolVar(&verify, "verify", false, "Compare the requests in two recordings instead of replaying")
+	flag.BoolVar(&pipeline, "pipeline", false, "Also replay SetForwardingPipelineConfig requests")
+	flag.BoolVar(&preserveTiming, "preserve-timing", false, "Wait between requests to reproduce the recorded timing")
+	flag.BoolVar(&ignoreOutcome, "ignore-outcome", false, "Do not stop when the outcome of a request differs from the recording")
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose mode with debug log messages")
+	flag.Usage = usage
+	flag.Parse()
+
+	log.SetLevel(log.WarnLevel)
+	if verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	if verify {
+		if flag.NArg() != 2 {
+			usage()
+			return exitUsage
+		}
+		if err := runVerify(flag.Arg(0), flag.Arg(1)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+		fmt.Println("Recordings contain the same requests")
+		return exitOK
+	}
+
+	if flag.NArg() != 1 {
+		usage()
+		return exitUsage
+	}
+	options := recorder.DefaultReplayOptions
+	options.DeviceID = connFlags.DeviceID
+	options.ElectionID = &p4_v1.Uint128{High: 0, Low: connFlags.ElectionID}
+	options.Pipeline = pipeline
+	options.PreserveTiming = preserveTiming
+	options.IgnoreOutcome = ignoreOutcome
+	stats, err := runReplay(connFlags, flag.Arg(0), options)
+	if stats != nil {
+		fmt.Printf("Replayed %d requests, %d failed\n", stats.Requests, stats.Failures)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func runVerify(expectedPath string, actualPath string) error {
+	expected, err := recorder.ReadRecordsFile(expectedPath)
+	if err != nil {
+		return err
+	}
+	actual, err := recorder.ReadRecordsFile(actualPath)
+	if err != nil {
+		return err
+	}
+	return recorder.CompareRequests(expected, actual)
+}
+
+func runReplay(connFlags *connect.Flags, path string, options recorder.ReplayOptions) (*recorder.ReplayStats, error) {
+	records, err := recorder.ReadRecordsFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := connect.Dial(dialCtx, connFlags)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// the primary client stream must stay open while requests are replayed
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if _, err := conn.StartPrimary(ctx, connFlags.DeviceID, connFlags.ElectionID, stopCh, connect.DefaultPrimaryOptions); err != nil {
+		return nil, err
+	}
+
+	return recorder.Replay(ctx, conn.P4RtClient, records, options)
+}
@@ -0,0 +1,653 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/protobuf/encoding/prototext"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/client"
+	"github.com/antoninbas/p4runtime-go-client/pkg/util/conversion"
+)
+
+type command struct {
+	name    string
+	usage   string
+	help    string
+	handler func(ctx context.Context, args string) error
+}
+
+type shell struct {
+	client   *client.Client
+	out      io.Writer
+	outMutex sync.Mutex
+	commands []*command
+	// whether stream messages (PacketIn, digests, ...) are printed
+	watch atomic.Bool
+	done  bool
+}
+
+func newShell(c *client.Client, out io.Writer) *shell {
+	sh := &shell{
+		client: c,
+		out:    out,
+	}
+	sh.commands = []*command{
+		{"help", "help", "Show this help message", sh.cmdHelp},
+		{"tables", "tables", "List tables and their match fields", sh.cmdTables},
+		{"actions", "actions", "List actions and their parameters", sh.cmdActions},
+		{"action-profiles", "action-profiles", "List action profiles", sh.cmdActionProfiles},
+		{"counters", "counters", "List indirect counters", sh.cmdCounters},
+		{"meters", "meters", "List indirect meters", sh.cmdMeters},
+		{"digests", "digests", "List digests", sh.cmdDigests},
+		{"pipeline", "pipeline", "Show the cookie of the forwarding pipeline", sh.cmdPipeline},
+		{"insert", "insert <entry>", "Insert a table entry", sh.tableEntryWriteHandler(p4_v1.Update_INSERT)},
+		{"modify", "modify <entry>", "Modify a table entry", sh.tableEntryWriteHandler(p4_v1.Update_MODIFY)},
+		{"delete", "delete <entry>", "Delete a table entry", sh.tableEntryWriteHandler(p4_v1.Update_DELETE)},
+		{"read", "read <table>", "Read all entries of a table", sh.cmdRead},
+		{"member", "member insert|modify|delete|read <profile> [<id> [<action>]]", "Manage action profile members", sh.cmdMember},
+		{"group", "group insert|modify|delete|read <profile> [<id> [<member id> ...]]", "Manage action profile groups", sh.cmdGroup},
+		{"mcast", "mcast insert|delete|read [<group id> [<port> ...]]", "Manage multicast groups", sh.cmdMcast},
+		{"clone", "clone insert|delete|read [<session id> [<port> ...]]", "Manage clone sessions", sh.cmdClone},
+		{"counter", "counter <name> [<index>]", "Read an indirect counter", sh.cmdCounter},
+		{"meter", "meter <name> [<index>]", "Read an indirect meter", sh.cmdMeter},
+		{"digest", "digest enable|disable <name>", "Enable or disable a digest", sh.cmdDigest},
+		{"packet-out", "packet-out <hex payload> [<metadata>=<value> ...]", "Send a PacketOut message", sh.cmdPacketOut},
+		{"watch", "watch on|off", "Print stream messages (PacketIn, digests, idle timeouts, errors)", sh.cmdWatch},
+		{"exit", "exit", "Exit the shell", sh.cmdExit},
+		{"quit", "quit", "Exit the shell", sh.cmdExit},
+	}
+	return sh
+}
+
+func (sh *shell) printf(format string, args ...interface{}) {
+	sh.outMutex.Lock()
+	defer sh.outMutex.Unlock()
+	fmt.Fprintf(sh.out, format, args...)
+}
+
+func (sh *shell) findCommand(name string) *command {
+	for _, cmd := range sh.commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func (sh *shell) repl(ctx context.Context, reader lineReader) {
+	for !sh.done {
+		line, err := reader.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				sh.printf("Error when reading input: %v\n", err)
+			}
+			return
+		}
+		if err := sh.execute(ctx, line); err != nil {
+			sh.printf("Error: %v\n", err)
+		}
+	}
+}
+
+func (sh *shell) execute(ctx context.Context, line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	name, args, _ := strings.Cut(line, " ")
+	cmd := sh.findCommand(name)
+	if cmd == nil {
+		return fmt.Errorf("unknown command %s, try help", name)
+	}
+	return cmd.handler(ctx, strings.TrimSpace(args))
+}
+
+func (sh *shell) cmdHelp(ctx context.Context, args string) error {
+	for _, cmd := range sh.commands {
+		sh.printf("  %-70s %s\n", cmd.usage, cmd.help)
+	}
+	sh.printf("\nTable entries use the following format:\n")
+	sh.printf("  <table> <field>=<value> ... [priority=<n>] [idle_timeout=<duration>] -> <action>(<param>=<value>, ...)\n")
+	sh.printf("  <table> default -> <action>(<param>=<value>, ...)\n")
+	return nil
+}
+
+func (sh *shell) p4Info() (*p4_config_v1.P4Info, error) {
+	p4Info := sh.client.P4Info()
+	if p4Info == nil {
+		return nil, fmt.Errorf("no P4Info")
+	}
+	return p4Info, nil
+}
+
+func (sh *shell) cmdTables(ctx context.Context, args string) error {
+	p4Info, err := sh.p4Info()
+	if err != nil {
+		return err
+	}
+	for _, table := range p4Info.Tables {
+		fields := make([]string, 0, len(table.MatchFields))
+		for _, mf := range table.MatchFields {
+			fields = append(fields, fmt.Sprintf("%s(%s, %d)", mf.Name, strings.ToLower(mf.GetMatchType().String()), mf.Bitwidth))
+		}
+		sh.printf("%s: %s\n", table.Preamble.Name, strings.Join(fields, " "))
+	}
+	return nil
+}
+
+func (sh *shell) cmdActions(ctx context.Context, args string) error {
+	p4Info, err := sh.p4Info()
+	if err != nil {
+		return err
+	}
+	for _, action := range p4Info.Actions {
+		params := make([]string, 0, len(action.Params))
+		for _, param := range action.Params {
+			params = append(params, fmt.Sprintf("%s(%d)", param.Name, param.Bitwidth))
+		}
+		sh.printf("%s(%s)\n", action.Preamble.Name, strings.Join(params, ", "))
+	}
+	return nil
+}
+
+func (sh *shell) cmdActionProfiles(ctx context.Context, args string) error {
+	p4Info, err := sh.p4Info()
+	if err != nil {
+		return err
+	}
+	for _, ap := range p4Info.ActionProfiles {
+		sh.printf("%s: size=%d with_selector=%t\n", ap.Preamble.Name, ap.Size, ap.WithSelector)
+	}
+	return nil
+}
+
+func (sh *shell) cmdCounters(ctx context.Context, args string) error {
+	p4Info, err := sh.p4Info()
+	if err != nil {
+		return err
+	}
+	for _, counter := range p4Info.Counters {
+		sh.printf("%s: size=%d unit=%s\n", counter.Preamble.Name, counter.Size, counter.GetSpec().GetUnit())
+	}
+	return nil
+}
+
+func (sh *shell) cmdMeters(ctx context.Context, args string) error {
+	p4Info, err := sh.p4Info()
+	if err != nil {
+		return err
+	}
+	for _, meter := range p4Info.Meters {
+		sh.printf("%s: size=%d unit=%s\n", meter.Preamble.Name, meter.Size, meter.GetSpec().GetUnit())
+	}
+	return nil
+}
+
+func (sh *shell) cmdDigests(ctx context.Context, args string) error {
+	p4Info, err := sh.p4Info()
+	if err != nil {
+		return err
+	}
+	for _, digest := range p4Info.Digests {
+		sh.printf("%s\n", digest.Preamble.Name)
+	}
+	return nil
+}
+
+func (sh *shell) cmdPipeline(ctx context.Context, args string) error {
+	config, err := sh.client.GetFwdPipe(ctx, client.GetFwdPipeCookieOnly)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		sh.printf("No forwarding pipeline\n")
+		return nil
+	}
+	sh.printf("Cookie: %d\n", config.Cookie)
+	return nil
+}
+
+func (sh *shell) tableEntryWriteHandler(updateType p4_v1.Update_Type) func(context.Context, string) error {
+	return func(ctx context.Context, args string) error {
+		entry, err := sh.client.ParseTableEntry(args)
+		if err != nil {
+			return err
+		}
+		return sh.client.WriteUpdate(ctx, &p4_v1.Update{
+			Type:   updateType,
+			Entity: &p4_v1.Entity{Entity: &p4_v1.Entity_TableEntry{TableEntry: entry}},
+		})
+	}
+}
+
+func (sh *shell) cmdRead(ctx context.Context, args string) error {
+	p4Info, err := sh.p4Info()
+	if err != nil {
+		return err
+	}
+	if !hasName(tableNames(p4Info), args) {
+		return fmt.Errorf("unknown table %q", args)
+	}
+	entries, err := sh.client.ReadTableEntryWildcard(ctx, args)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		s, err := sh.client.FormatTableEntry(entry)
+		if err != nil {
+			return err
+		}
+		sh.printf("%s\n", s)
+	}
+	sh.printf("%d entries\n", len(entries))
+	return nil
+}
+
+func parseUint32(s string) (uint32, error) {
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %q", s)
+	}
+	return uint32(v), nil
+}
+
+// parseActionProfileArgs splits "<op> <profile> [<id> [<rest>]]" for the member and
+// group commands.
+func (sh *shell) parseActionProfileArgs(args string) (op string, profile string, id uint32, rest string, err error) {
+	fields := strings.SplitN(args, " ", 4)
+	if len(fields) < 2 {
+		return "", "", 0, "", fmt.Errorf("missing arguments")
+	}
+	op, profile = fields[0], fields[1]
+	p4Info, err := sh.p4Info()
+	if err != nil {
+		return "", "", 0, "", err
+	}
+	if !hasName(actionProfileNames(p4Info), profile) {
+		return "", "", 0, "", fmt.Errorf("unknown action profile %q", profile)
+	}
+	if op == "read" {
+		return op, profile, 0, "", nil
+	}
+	if len(fields) < 3 {
+		return "", "", 0, "", fmt.Errorf("missing id")
+	}
+	id, err = parseUint32(fields[2])
+	if err != nil {
+		return "", "", 0, "", err
+	}
+	if len(fields) == 4 {
+		rest = strings.TrimSpace(fields[3])
+	}
+	return op, profile, id, rest, nil
+}
+
+func (sh *shell) cmdMember(ctx context.Context, args string) error {
+	op, profile, id, rest, err := sh.parseActionProfileArgs(args)
+	if err != nil {
+		return err
+	}
+	member := sh.client.NewActionProfileMember(profile, id, "", nil)
+	// the action is only required for INSERT and MODIFY
+	member.Action = nil
+	if op == "insert" || op == "modify" {
+		if rest == "" {
+			return fmt.Errorf("missing action")
+		}
+		member.Action, err = sh.client.ParseAction(rest)
+		if err != nil {
+			return err
+		}
+	}
+	switch op {
+	case "insert":
+		return sh.client.InsertActionProfileMember(ctx, member)
+	case "modify":
+		return sh.client.ModifyActionProfileMember(ctx, member)
+	case "delete":
+		return sh.client.DeleteActionProfileMember(ctx, member)
+	case "read":
+		members, err := sh.client.ReadActionProfileMemberWildcard(ctx, profile)
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			s, err := sh.client.FormatAction(member.Action)
+			if err != nil {
+				return err
+			}
+			sh.printf("member:%d -> %s\n", member.MemberId, s)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown operation %q", op)
+}
+
+func (sh *shell) cmdGroup(ctx context.Context, args string) error {
+	op, profile, id, rest, err := sh.parseActionProfileArgs(args)
+	if err != nil {
+		return err
+	}
+	var members []*p4_v1.ActionProfileGroup_Member
+	for _, s := range strings.Fields(rest) {
+		memberID, err := parseUint32(s)
+		if err != nil {
+			return err
+		}
+		members = append(members, &p4_v1.ActionProfileGroup_Member{MemberId: memberID, Weight: 1})
+	}
+	group := sh.client.NewActionProfileGroup(profile, id, members, 0)
+	switch op {
+	case "insert":
+		return sh.client.InsertActionProfileGroup(ctx, group)
+	case "modify":
+		return sh.client.ModifyActionProfileGroup(ctx, group)
+	case "delete":
+		return sh.client.DeleteActionProfileGroup(ctx, group)
+	case "read":
+		groups, err := sh.client.ReadActionProfileGroupWildcard(ctx, profile)
+		if err != nil {
+			return err
+		}
+		for _, group := range groups {
+			ids := make([]string, 0, len(group.Members))
+			for _, member := range group.Members {
+				ids = append(ids, fmt.Sprintf("%d*%d", member.MemberId, member.Weight))
+			}
+			sh.printf("group:%d -> [%s]\n", group.GroupId, strings.Join(ids, ", "))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown operation %q", op)
+}
+
+// parsePREArgs parses "<op> [<id> [<port> ...]]" for the mcast and clone
+// commands.
+func parsePREArgs(args string) (op string, id uint32, ports []uint32, err error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return "", 0, nil, fmt.Errorf("missing arguments")
+	}
+	op = fields[0]
+	if op == "read" {
+		return op, 0, nil, nil
+	}
+	if len(fields) < 2 {
+		return "", 0, nil, fmt.Errorf("missing id")
+	}
+	if id, err = parseUint32(fields[1]); err != nil {
+		return "", 0, nil, err
+	}
+	for _, s := range fields[2:] {
+		port, err := parseUint32(s)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		ports = append(ports, port)
+	}
+	return op, id, ports, nil
+}
+
+func formatReplicas(replicas []*p4_v1.Replica) string {
+	out := make([]string, 0, len(replicas))
+	for _, replica := range replicas {
+		out = append(out, fmt.Sprintf("%d", replica.EgressPort))
+	}
+	return strings.Join(out, " ")
+}
+
+func (sh *shell) cmdMcast(ctx context.Context, args string) error {
+	op, id, ports, err := parsePREArgs(args)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case "insert":
+		return sh.client.InsertMulticastGroup(ctx, id, ports)
+	case "delete":
+		return sh.client.DeleteMulticastGroup(ctx, id)
+	case "read":
+		groups, err := sh.client.ReadMulticastGroupWildcard(ctx)
+		if err != nil {
+			return err
+		}
+		for _, group := range groups {
+			sh.printf("%d: %s\n", group.MulticastGroupId, formatReplicas(group.Replicas))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown operation %q", op)
+}
+
+func (sh *shell) cmdClone(ctx context.Context, args string) error {
+	op, id, ports, err := parsePREArgs(args)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case "insert":
+		return sh.client.InsertCloneSession(ctx, id, ports, client.DefaultCloneSessionOptions)
+	case "delete":
+		return sh.client.DeleteCloneSession(ctx, id)
+	case "read":
+		sessions, err := sh.client.ReadCloneSessionWildcard(ctx)
+		if err != nil {
+			return err
+		}
+		for _, session := range sessions {
+			sh.printf("%d: %s\n", session.SessionId, formatReplicas(session.Replicas))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown operation %q", op)
+}
+
+// parseNameIndex parses "<name> [<index>]"; index is -1 if omitted.
+func parseNameIndex(args string, names []string, kind string) (string, int64, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || len(fields) > 2 {
+		return "", 0, fmt.Errorf("expected <name> [<index>]")
+	}
+	if !hasName(names, fields[0]) {
+		return "", 0, fmt.Errorf("unknown %s %q", kind, fields[0])
+	}
+	if len(fields) == 1 {
+		return fields[0], -1, nil
+	}
+	index, err := strconv.ParseInt(fields[1], 0, 64)
+	if err != nil || index < 0 {
+		return "", 0, fmt.Errorf("invalid index %q", fields[1])
+	}
+	return fields[0], index, nil
+}
+
+func (sh *shell) cmdCounter(ctx context.Context, args string) error {
+	p4Info, err := sh.p4Info()
+	if err != nil {
+		return err
+	}
+	name, index, err := parseNameIndex(args, counterNames(p4Info), "counter")
+	if err != nil {
+		return err
+	}
+	if index >= 0 {
+		data, err := sh.client.ReadCounterEntry(ctx, name, index)
+		if err != nil {
+			return err
+		}
+		sh.printf("%d: packets=%d bytes=%d\n", index, data.PacketCount, data.ByteCount)
+		return nil
+	}
+	data, err := sh.client.ReadCounterEntryWildcard(ctx, name)
+	if err != nil {
+		return err
+	}
+	for idx, d := range data {
+		if d.PacketCount == 0 && d.ByteCount == 0 {
+			continue
+		}
+		sh.printf("%d: packets=%d bytes=%d\n", idx, d.PacketCount, d.ByteCount)
+	}
+	return nil
+}
+
+func formatMeterConfig(config *p4_v1.MeterConfig) string {
+	if config == nil {
+		return "default"
+	}
+	return fmt.Sprintf("cir=%d cburst=%d pir=%d pburst=%d", config.Cir, config.Cburst, config.Pir, config.Pburst)
+}
+
+func (sh *shell) cmdMeter(ctx context.Context, args string) error {
+	p4Info, err := sh.p4Info()
+	if err != nil {
+		return err
+	}
+	name, index, err := parseNameIndex(args, meterNames(p4Info), "meter")
+	if err != nil {
+		return err
+	}
+	if index >= 0 {
+		config, err := sh.client.ReadMeterEntry(ctx, name, index)
+		if err != nil {
+			return err
+		}
+		sh.printf("%d: %s\n", index, formatMeterConfig(config))
+		return nil
+	}
+	entries, err := sh.client.ReadMeterEntryWildcard(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		sh.printf("%d: %s\n", entry.GetIndex().GetIndex(), formatMeterConfig(entry.Config))
+	}
+	return nil
+}
+
+func (sh *shell) cmdDigest(ctx context.Context, args string) error {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return fmt.Errorf("expected enable|disable <name>")
+	}
+	p4Info, err := sh.p4Info()
+	if err != nil {
+		return err
+	}
+	if !hasName(digestNames(p4Info), fields[1]) {
+		return fmt.Errorf("unknown digest %q", fields[1])
+	}
+	switch fields[0] {
+	case "enable":
+		return sh.client.EnableDigest(ctx, fields[1], &p4_v1.DigestEntry_Config{})
+	case "disable":
+		return sh.client.DisableDigest(ctx, fields[1])
+	}
+	return fmt.Errorf("unknown operation %q", fields[0])
+}
+
+func (sh *shell) cmdPacketOut(ctx context.Context, args string) error {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return fmt.Errorf("missing payload")
+	}
+	payload, err := hex.DecodeString(strings.TrimPrefix(fields[0], "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid payload: %v", err)
+	}
+	metadata := make(map[string][]byte)
+	for _, field := range fields[1:] {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return fmt.Errorf("invalid metadata %q, expected <name>=<value>", field)
+		}
+		b, err := conversion.ParseLiteral(value, 0)
+		if err != nil {
+			return fmt.Errorf("invalid value for metadata %s: %v", name, err)
+		}
+		metadata[name] = b
+	}
+	pkt, err := sh.client.NewPacketOut(payload, metadata)
+	if err != nil {
+		return err
+	}
+	return sh.client.SendPacketOut(ctx, pkt)
+}
+
+func (sh *shell) cmdWatch(ctx context.Context, args string) error {
+	switch args {
+	case "on":
+		sh.watch.Store(true)
+	case "off":
+		sh.watch.Store(false)
+	case "":
+		sh.printf("watch is %t\n", sh.watch.Load())
+	default:
+		return fmt.Errorf("expected on|off")
+	}
+	return nil
+}
+
+func (sh *shell) cmdExit(ctx context.Context, args string) error {
+	sh.done = true
+	return nil
+}
+
+func formatMetadata(metadata map[string][]byte) string {
+	names := make([]string, 0, len(metadata))
+	for name := range metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		out = append(out, fmt.Sprintf("%s=0x%s", name, hex.EncodeToString(metadata[name])))
+	}
+	return strings.Join(out, " ")
+}
+
+// handleStreamMessages consumes all messages received on the stream. Digest
+// lists are always acked, but messages are only printed if watch is on.
+func (sh *shell) handleStreamMessages(ctx context.Context, messageCh <-chan *p4_v1.StreamMessageResponse) {
+	for message := range messageCh {
+		switch m := message.Update.(type) {
+		case *p4_v1.StreamMessageResponse_Packet:
+			if sh.watch.Load() {
+				sh.printf("PacketIn: payload=0x%s %s\n", hex.EncodeToString(m.Packet.Payload), formatMetadata(sh.client.PacketInMetadata(m.Packet)))
+			}
+		case *p4_v1.StreamMessageResponse_Digest:
+			if sh.watch.Load() {
+				sh.printf("Digest: %s\n", prototext.MarshalOptions{}.Format(m.Digest))
+			}
+			if err := sh.client.AckDigestList(ctx, m.Digest); err != nil {
+				sh.printf("Error when acking digest list: %v\n", err)
+			}
+		case *p4_v1.StreamMessageResponse_IdleTimeoutNotification:
+			if !sh.watch.Load() {
+				continue
+			}
+			for _, entry := range m.IdleTimeoutNotification.TableEntry {
+				s, err := sh.client.FormatTableEntry(entry)
+				if err != nil {
+					s = prototext.MarshalOptions{}.Format(entry)
+				}
+				sh.printf("Idle timeout: %s\n", s)
+			}
+		case *p4_v1.StreamMessageResponse_Error:
+			if sh.watch.Load() {
+				sh.printf("Stream error: %v\n", client.DecodeStreamError(m.Error))
+			}
+		}
+	}
+}
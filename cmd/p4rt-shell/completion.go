@@ -0,0 +1,201 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+)
+
+func hasName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func tableNames(p4Info *p4_config_v1.P4Info) []string {
+	names := make([]string, 0, len(p4Info.Tables))
+	for _, table := range p4Info.Tables {
+		names = append(names, table.Preamble.Name)
+	}
+	return names
+}
+
+func actionNames(p4Info *p4_config_v1.P4Info) []string {
+	names := make([]string, 0, len(p4Info.Actions))
+	for _, action := range p4Info.Actions {
+		names = append(names, action.Preamble.Name)
+	}
+	return names
+}
+
+func actionProfileNames(p4Info *p4_config_v1.P4Info) []string {
+	names := make([]string, 0, len(p4Info.ActionProfiles))
+	for _, ap := range p4Info.ActionProfiles {
+		names = append(names, ap.Preamble.Name)
+	}
+	return names
+}
+
+func counterNames(p4Info *p4_config_v1.P4Info) []string {
+	names := make([]string, 0, len(p4Info.Counters))
+	for _, counter := range p4Info.Counters {
+		names = append(names, counter.Preamble.Name)
+	}
+	return names
+}
+
+func meterNames(p4Info *p4_config_v1.P4Info) []string {
+	names := make([]string, 0, len(p4Info.Meters))
+	for _, meter := range p4Info.Meters {
+		names = append(names, meter.Preamble.Name)
+	}
+	return names
+}
+
+func digestNames(p4Info *p4_config_v1.P4Info) []string {
+	names := make([]string, 0, len(p4Info.Digests))
+	for _, digest := range p4Info.Digests {
+		names = append(names, digest.Preamble.Name)
+	}
+	return names
+}
+
+func findTable(p4Info *p4_config_v1.P4Info, name string) *p4_config_v1.Table {
+	for _, table := range p4Info.Tables {
+		if table.Preamble.Name == name {
+			return table
+		}
+	}
+	return nil
+}
+
+// tableEntryCandidates returns the completion candidates for the word at
+// position idx of a table entry (the table name is word 0).
+func tableEntryCandidates(p4Info *p4_config_v1.P4Info, words []string, idx int) []string {
+	if idx == 0 {
+		return tableNames(p4Info)
+	}
+	for _, word := range words[:idx] {
+		if word == "->" {
+			return actionNames(p4Info)
+		}
+	}
+	table := findTable(p4Info, words[0])
+	if table == nil {
+		return nil
+	}
+	candidates := []string{"default", "->", "priority=", "idle_timeout="}
+	for _, mf := range table.MatchFields {
+		candidates = append(candidates, mf.Name+"=")
+	}
+	return candidates
+}
+
+// candidates returns the possible completions for the last word of words,
+// which may be empty.
+func (sh *shell) candidates(words []string) []string {
+	if len(words) <= 1 {
+		names := make([]string, 0, len(sh.commands))
+		for _, cmd := range sh.commands {
+			names = append(names, cmd.name)
+		}
+		return names
+	}
+	p4Info := sh.client.P4Info()
+	if p4Info == nil {
+		return nil
+	}
+	idx := len(words) - 1
+	switch words[0] {
+	case "insert", "modify", "delete":
+		return tableEntryCandidates(p4Info, words[1:], idx-1)
+	case "read":
+		if idx == 1 {
+			return tableNames(p4Info)
+		}
+	case "member", "group":
+		switch idx {
+		case 1:
+			return []string{"insert", "modify", "delete", "read"}
+		case 2:
+			return actionProfileNames(p4Info)
+		case 4:
+			if words[0] == "member" {
+				return actionNames(p4Info)
+			}
+		}
+	case "mcast", "clone":
+		if idx == 1 {
+			return []string{"insert", "delete", "read"}
+		}
+	case "counter":
+		if idx == 1 {
+			return counterNames(p4Info)
+		}
+	case "meter":
+		if idx == 1 {
+			return meterNames(p4Info)
+		}
+	case "digest":
+		switch idx {
+		case 1:
+			return []string{"enable", "disable"}
+		case 2:
+			return digestNames(p4Info)
+		}
+	case "watch":
+		if idx == 1 {
+			return []string{"on", "off"}
+		}
+	}
+	return nil
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// complete implements tab-completion for the word before pos. If there is a
+// single match, the word is completed (followed by a space unless the match
+// ends with '='). If there are several matches, the word is extended to their
+// common prefix and the matches are passed to list.
+func (sh *shell) complete(line string, pos int, list func([]string)) (string, int, bool) {
+	before := line[:pos]
+	words := strings.Fields(before)
+	if len(before) == 0 || before[len(before)-1] == ' ' {
+		words = append(words, "")
+	}
+	word := words[len(words)-1]
+
+	var matches []string
+	for _, candidate := range sh.candidates(words) {
+		if strings.HasPrefix(candidate, word) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	sort.Strings(matches)
+
+	completion := commonPrefix(matches)
+	if len(matches) == 1 && !strings.HasSuffix(completion, "=") {
+		completion += " "
+	}
+	if len(matches) > 1 && completion == word {
+		list(matches)
+		return "", 0, false
+	}
+	newLine := before[:len(before)-len(word)] + completion + line[pos:]
+	return newLine, pos - len(word) + len(completion), true
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/cmd/internal/connect"
+	"github.com/antoninbas/p4runtime-go-client/pkg/client"
+)
+
+const (
+	prompt = "p4rt> "
+)
+
+// lineReader abstracts reading commands from an interactive terminal (with
+// line editing and tab-completion) or from a pipe.
+type lineReader interface {
+	ReadLine() (string, error)
+}
+
+type scannerLineReader struct {
+	scanner *bufio.Scanner
+}
+
+func (r *scannerLineReader) ReadLine() (string, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+func main() {
+	ctx := context.Background()
+
+	connFlags := connect.RegisterFlags(flag.CommandLine)
+	var verbose bool
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose mode with debug log messages")
+	var binPath string
+	flag.StringVar(&binPath, "bin", "", "Path to P4 bin (if omitted, the pipeline is retrieved from the switch)")
+	var p4infoPath string
+	flag.StringVar(&p4infoPath, "p4info", "", "Path to P4Info (if omitted, the pipeline is retrieved from the switch)")
+
+	flag.Parse()
+
+	if verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	conn, err := connect.Dial(ctx, connFlags)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer conn.Close()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	messageCh := make(chan *p4_v1.StreamMessageResponse, 1000)
+	p4RtC, err := conn.StartPrimary(ctx, connFlags.DeviceID, connFlags.ElectionID, stopCh, connect.PrimaryOptions{
+		MessageCh: messageCh,
+		Timeout:   5 * time.Second,
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if binPath != "" || p4infoPath != "" {
+		if binPath == "" || p4infoPath == "" {
+			log.Fatalf("Both .bin and P4Info are required to set the pipeline")
+		}
+		log.Info("Setting forwarding pipe")
+		if _, err := p4RtC.SetFwdPipe(ctx, binPath, p4infoPath, 0); err != nil {
+			log.Fatalf("Error when setting forwarding pipe: %v", err)
+		}
+	} else {
+		log.Info("Retrieving forwarding pipe")
+		config, err := p4RtC.GetFwdPipe(ctx, client.GetFwdPipeP4InfoAndCookie)
+		if err != nil {
+			log.Fatalf("Error when retrieving forwarding pipe: %v", err)
+		}
+		if config == nil || config.P4Info == nil {
+			log.Fatalf("No forwarding pipe configured on the switch, use -bin and -p4info")
+		}
+	}
+
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			log.Fatalf("Cannot configure terminal: %v", err)
+		}
+		defer term.Restore(int(os.Stdin.Fd()), oldState) //nolint:errcheck
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, prompt)
+		if width, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			t.SetSize(width, height) //nolint:errcheck
+		}
+		sh := newShell(p4RtC, t)
+		t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+			if key != '\t' {
+				return "", 0, false
+			}
+			return sh.complete(line, pos, func(candidates []string) {
+				// the terminal is locked while the callback runs
+				go fmt.Fprintln(t, strings.Join(candidates, "  "))
+			})
+		}
+		// log messages need to go through the terminal, which is in raw mode
+		log.SetOutput(t)
+		go sh.handleStreamMessages(ctx, messageCh)
+		sh.repl(ctx, t)
+		return
+	}
+
+	sh := newShell(p4RtC, os.Stdout)
+	go sh.handleStreamMessages(ctx, messageCh)
+	sh.repl(ctx, &scannerLineReader{scanner: bufio.NewScanner(os.Stdin)})
+}
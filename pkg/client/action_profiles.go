@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"fmt"
 
 	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
 )
@@ -28,12 +29,22 @@ func (c *Client) NewActionProfileMember(
 	action string,
 	params [][]byte,
 ) *p4_v1.ActionProfileMember {
-	actionProfileID := c.actionProfileId(actionProfile)
+	return c.P4InfoView().NewActionProfileMember(actionProfile, memberID, action, params)
+}
+
+func newActionProfileMember(
+	p4Info *p4InfoSnapshot,
+	actionProfile string,
+	memberID uint32,
+	action string,
+	params [][]byte,
+) *p4_v1.ActionProfileMember {
+	actionProfileID := p4Info.actionProfileId(actionProfile)
 
 	entry := &p4_v1.ActionProfileMember{
 		ActionProfileId: actionProfileID,
 		MemberId:        memberID,
-		Action:          c.newAction(action, params),
+		Action:          p4Info.newAction(action, params),
 	}
 
 	return entry
@@ -72,13 +83,48 @@ func (c *Client) DeleteActionProfileMember(ctx context.Context, entry *p4_v1.Act
 	return c.WriteUpdate(ctx, update)
 }
 
+func (c *Client) ReadActionProfileMemberWildcard(ctx context.Context, actionProfile string) ([]*p4_v1.ActionProfileMember, error) {
+	actionProfileID := c.currentP4Info().actionProfileId(actionProfile)
+	if actionProfileID == invalidID {
+		return nil, fmt.Errorf("action profile %s not found", actionProfile)
+	}
+	entry := &p4_v1.ActionProfileMember{
+		ActionProfileId: actionProfileID,
+	}
+	readEntities, err := c.readEntitiesAll(ctx, &p4_v1.Entity{
+		Entity: &p4_v1.Entity_ActionProfileMember{ActionProfileMember: entry},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error when reading action profile members: %v", err)
+	}
+	out := make([]*p4_v1.ActionProfileMember, 0, len(readEntities))
+	for _, readEntity := range readEntities {
+		readEntry := readEntity.GetActionProfileMember()
+		if readEntry == nil {
+			return nil, fmt.Errorf("server returned an entity which is not an action profile member")
+		}
+		out = append(out, readEntry)
+	}
+	return out, nil
+}
+
 func (c *Client) NewActionProfileGroup(
 	actionProfile string,
 	groupID uint32,
 	members []*p4_v1.ActionProfileGroup_Member,
 	size int32,
 ) *p4_v1.ActionProfileGroup {
-	actionProfileID := c.actionProfileId(actionProfile)
+	return c.P4InfoView().NewActionProfileGroup(actionProfile, groupID, members, size)
+}
+
+func newActionProfileGroup(
+	p4Info *p4InfoSnapshot,
+	actionProfile string,
+	groupID uint32,
+	members []*p4_v1.ActionProfileGroup_Member,
+	size int32,
+) *p4_v1.ActionProfileGroup {
+	actionProfileID := p4Info.actionProfileId(actionProfile)
 
 	entry := &p4_v1.ActionProfileGroup{
 		ActionProfileId: actionProfileID,
@@ -122,3 +168,28 @@ func (c *Client) DeleteActionProfileGroup(ctx context.Context, entry *p4_v1.Acti
 
 	return c.WriteUpdate(ctx, update)
 }
+
+func (c *Client) ReadActionProfileGroupWildcard(ctx context.Context, actionProfile string) ([]*p4_v1.ActionProfileGroup, error) {
+	actionProfileID := c.currentP4Info().actionProfileId(actionProfile)
+	if actionProfileID == invalidID {
+		return nil, fmt.Errorf("action profile %s not found", actionProfile)
+	}
+	entry := &p4_v1.ActionProfileGroup{
+		ActionProfileId: actionProfileID,
+	}
+	readEntities, err := c.readEntitiesAll(ctx, &p4_v1.Entity{
+		Entity: &p4_v1.Entity_ActionProfileGroup{ActionProfileGroup: entry},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error when reading action profile groups: %v", err)
+	}
+	out := make([]*p4_v1.ActionProfileGroup, 0, len(readEntities))
+	for _, readEntity := range readEntities {
+		readEntry := readEntity.GetActionProfileGroup()
+		if readEntry == nil {
+			return nil, fmt.Errorf("server returned an entity which is not an action profile group")
+		}
+		out = append(out, readEntry)
+	}
+	return out, nil
+}
@@ -0,0 +1,455 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/protobuf/proto"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// EnableEntityCache attaches an EntityCache to the Client, which mirrors the
+// entities successfully written by the Client. See Client.Cache.
+func EnableEntityCache(options *ClientOptions) {
+	options.EntityCache = true
+}
+
+// EntityCache is a local mirror of the entities installed in the target: table
+// entries (including default entries), action profile members and groups,
+// multicast groups and clone sessions. Other entities (e.g. counter entries)
+// are not cached. It is updated after each successful write (write-through),
+// and can be rebuilt from the target's state with Client.RebuildCache. It is
+// safe for concurrent use.
+type EntityCache struct {
+	mutex sync.RWMutex
+	// entities indexed by key (see entityKey)
+	entities map[string]*p4_v1.Entity
+	// keys indexed by group (table, action profile, ...), for listings
+	groups map[string]map[string]struct{}
+	// set when the outcome of a write could not be determined, until the
+	// next rebuild
+	dirty bool
+	// writes applied while a rebuild is in progress, which are applied again
+	// to the rebuilt contents; nil if there is no rebuild in progress
+	journal []cachedWrite
+	// serializes rebuilds
+	rebuildMutex sync.Mutex
+	// set while a rebuild started by rebuildCacheAsync has not started yet
+	rebuildQueued atomic.Bool
+}
+
+// cachedWrite is a write recorded in the journal during a rebuild.
+type cachedWrite struct {
+	updates []*p4_v1.Update
+	err     error
+}
+
+func newEntityCache() *EntityCache {
+	return &EntityCache{
+		entities: make(map[string]*p4_v1.Entity),
+		groups:   make(map[string]map[string]struct{}),
+	}
+}
+
+// cacheGroup returns the name of the group an entity belongs to, for the
+// purpose of listing entities.
+func cacheGroup(entity *p4_v1.Entity) string {
+	switch e := entity.Entity.(type) {
+	case *p4_v1.Entity_TableEntry:
+		return fmt.Sprintf("table:%d", e.TableEntry.TableId)
+	case *p4_v1.Entity_ActionProfileMember:
+		return fmt.Sprintf("member:%d", e.ActionProfileMember.ActionProfileId)
+	case *p4_v1.Entity_ActionProfileGroup:
+		return fmt.Sprintf("group:%d", e.ActionProfileGroup.ActionProfileId)
+	case *p4_v1.Entity_PacketReplicationEngineEntry:
+		switch e.PacketReplicationEngineEntry.Type.(type) {
+		case *p4_v1.PacketReplicationEngineEntry_MulticastGroupEntry:
+			return "mcast"
+		case *p4_v1.PacketReplicationEngineEntry_CloneSessionEntry:
+			return "clone"
+		}
+	}
+	return "other"
+}
+
+// mirrored returns true for the entities which are mirrored by the
+// EntityCache, i.e. the ones read by readMirroredState.
+func mirrored(entity *p4_v1.Entity) bool {
+	switch entity.GetEntity().(type) {
+	case *p4_v1.Entity_TableEntry, *p4_v1.Entity_ActionProfileMember, *p4_v1.Entity_ActionProfileGroup, *p4_v1.Entity_PacketReplicationEngineEntry:
+		return true
+	}
+	return false
+}
+
+func (cache *EntityCache) store(entity *p4_v1.Entity) error {
+	key, err := entityKey(normalizeEntity(entity))
+	if err != nil {
+		return err
+	}
+	group := cacheGroup(entity)
+	cache.entities[key] = proto.Clone(entity).(*p4_v1.Entity)
+	if cache.groups[group] == nil {
+		cache.groups[group] = make(map[string]struct{})
+	}
+	cache.groups[group][key] = struct{}{}
+	return nil
+}
+
+func (cache *EntityCache) remove(entity *p4_v1.Entity) error {
+	key, err := entityKey(normalizeEntity(entity))
+	if err != nil {
+		return err
+	}
+	group := cacheGroup(entity)
+	delete(cache.entities, key)
+	delete(cache.groups[group], key)
+	return nil
+}
+
+// applyWrite updates the cache after a Write RPC. If the RPC failed, only the
+// updates which are known to have succeeded (based on the per-update error
+// details) are applied. If the outcome of the updates cannot be determined, the
+// cache is marked as dirty and false is returned.
+func (cache *EntityCache) applyWrite(updates []*p4_v1.Update, writeErr error) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.journal != nil {
+		cache.journal = append(cache.journal, cachedWrite{updates: updates, err: writeErr})
+	}
+	return cache.applyWriteLocked(updates, writeErr)
+}
+
+func (cache *EntityCache) applyWriteLocked(updates []*p4_v1.Update, writeErr error) bool {
+	var details []*p4_v1.Error
+	if writeErr != nil {
+		details = WriteErrorDetails(writeErr)
+		if len(details) != len(updates) {
+			// we cannot tell which updates succeeded
+			for _, update := range updates {
+				if mirrored(update.Entity) {
+					cache.dirty = true
+					return false
+				}
+			}
+			return true
+		}
+	}
+	for idx, update := range updates {
+		if details != nil && details[idx].CanonicalCode != int32(code.Code_OK) {
+			continue
+		}
+		if !mirrored(update.Entity) {
+			continue
+		}
+		switch update.Type {
+		case p4_v1.Update_INSERT, p4_v1.Update_MODIFY:
+			_ = cache.store(update.Entity)
+		case p4_v1.Update_DELETE:
+			_ = cache.remove(update.Entity)
+		}
+	}
+	return true
+}
+
+// Dirty returns true if the cache may not reflect the writes of the Client,
+// because the outcome of a write could not be determined. The flag is cleared
+// by Client.RebuildCache.
+func (cache *EntityCache) Dirty() bool {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	return cache.dirty
+}
+
+// Get returns the cached entity with the same key as the provided entity.
+func (cache *EntityCache) Get(entity *p4_v1.Entity) (*p4_v1.Entity, bool) {
+	key, err := entityKey(normalizeEntity(entity))
+	if err != nil {
+		return nil, false
+	}
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	cached, ok := cache.entities[key]
+	return cached, ok
+}
+
+// GetTableEntry returns the cached table entry with the same key (match
+// fields, priority) as the provided one.
+func (cache *EntityCache) GetTableEntry(entry *p4_v1.TableEntry) (*p4_v1.TableEntry, bool) {
+	cached, ok := cache.Get(tableEntryToEntity(entry))
+	if !ok {
+		return nil, false
+	}
+	return cached.GetTableEntry(), true
+}
+
+// list returns the entities in the provided group, sorted by key.
+func (cache *EntityCache) list(group string) []*p4_v1.Entity {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	keys := make([]string, 0, len(cache.groups[group]))
+	for key := range cache.groups[group] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]*p4_v1.Entity, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, cache.entities[key])
+	}
+	return out
+}
+
+// Entities returns all the cached entities.
+func (cache *EntityCache) Entities() []*p4_v1.Entity {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	keys := make([]string, 0, len(cache.entities))
+	for key := range cache.entities {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]*p4_v1.Entity, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, cache.entities[key])
+	}
+	return out
+}
+
+func (cache *EntityCache) TableEntries(tableID uint32) []*p4_v1.TableEntry {
+	entities := cache.list(fmt.Sprintf("table:%d", tableID))
+	out := make([]*p4_v1.TableEntry, 0, len(entities))
+	for _, entity := range entities {
+		out = append(out, entity.GetTableEntry())
+	}
+	return out
+}
+
+func (cache *EntityCache) ActionProfileMembers(actionProfileID uint32) []*p4_v1.ActionProfileMember {
+	entities := cache.list(fmt.Sprintf("member:%d", actionProfileID))
+	out := make([]*p4_v1.ActionProfileMember, 0, len(entities))
+	for _, entity := range entities {
+		out = append(out, entity.GetActionProfileMember())
+	}
+	return out
+}
+
+func (cache *EntityCache) ActionProfileGroups(actionProfileID uint32) []*p4_v1.ActionProfileGroup {
+	entities := cache.list(fmt.Sprintf("group:%d", actionProfileID))
+	out := make([]*p4_v1.ActionProfileGroup, 0, len(entities))
+	for _, entity := range entities {
+		out = append(out, entity.GetActionProfileGroup())
+	}
+	return out
+}
+
+func (cache *EntityCache) MulticastGroups() []*p4_v1.MulticastGroupEntry {
+	entities := cache.list("mcast")
+	out := make([]*p4_v1.MulticastGroupEntry, 0, len(entities))
+	for _, entity := range entities {
+		out = append(out, entity.GetPacketReplicationEngineEntry().GetMulticastGroupEntry())
+	}
+	return out
+}
+
+func (cache *EntityCache) CloneSessions() []*p4_v1.CloneSessionEntry {
+	entities := cache.list("clone")
+	out := make([]*p4_v1.CloneSessionEntry, 0, len(entities))
+	for _, entity := range entities {
+		out = append(out, entity.GetPacketReplicationEngineEntry().GetCloneSessionEntry())
+	}
+	return out
+}
+
+// Len returns the number of cached entities.
+func (cache *EntityCache) Len() int {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	return len(cache.entities)
+}
+
+// Clear removes all entities from the cache.
+func (cache *EntityCache) Clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.entities = make(map[string]*p4_v1.Entity)
+	cache.groups = make(map[string]map[string]struct{})
+	cache.dirty = false
+}
+
+// rebuild replaces the contents of the cache with the entities returned by
+// read. The writes applied while read is in progress are recorded, and applied
+// again to the new contents, since read may or may not reflect them.
+func (cache *EntityCache) rebuild(read func() ([]*p4_v1.Entity, error)) error {
+	cache.rebuildMutex.Lock()
+	defer cache.rebuildMutex.Unlock()
+	cache.mutex.Lock()
+	cache.journal = []cachedWrite{}
+	cache.mutex.Unlock()
+	entities, err := read()
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	journal := cache.journal
+	cache.journal = nil
+	if err != nil {
+		return err
+	}
+	newCache := newEntityCache()
+	for _, entity := range entities {
+		if err := newCache.store(entity); err != nil {
+			return err
+		}
+	}
+	for _, write := range journal {
+		newCache.applyWriteLocked(write.updates, write.err)
+	}
+	cache.entities = newCache.entities
+	cache.groups = newCache.groups
+	cache.dirty = newCache.dirty
+	return nil
+}
+
+// Cache returns the EntityCache attached to the Client, or nil if the Client
+// was created without EnableEntityCache.
+func (c *Client) Cache() *EntityCache {
+	return c.cache
+}
+
+// readMirroredState reads all the entities which can be mirrored by the
+// EntityCache from the target: table entries (including default entries),
+// action profile members and groups, multicast groups and clone sessions.
+func (c *Client) readMirroredState(ctx context.Context) ([]*p4_v1.Entity, error) {
+	p4Info := c.P4Info()
+	if p4Info == nil {
+		return nil, fmt.Errorf("P4Info is missing")
+	}
+	// a single Read RPC with one wildcard entity per entity type
+	entities := []*p4_v1.Entity{
+		tableEntryToEntity(&p4_v1.TableEntry{}),
+	}
+	for _, table := range p4Info.Tables {
+		entities = append(entities, tableEntryToEntity(&p4_v1.TableEntry{
+			TableId:         table.Preamble.Id,
+			IsDefaultAction: true,
+		}))
+	}
+	if len(p4Info.ActionProfiles) > 0 {
+		entities = append(
+			entities,
+			actionProfileMemberToEntity(&p4_v1.ActionProfileMember{}),
+			actionProfileGroupToEntity(&p4_v1.ActionProfileGroup{}),
+		)
+	}
+	entities = append(
+		entities,
+		multicastGroupToEntity(&p4_v1.MulticastGroupEntry{}),
+		cloneSessionToEntity(&p4_v1.CloneSessionEntry{}),
+	)
+	readEntities, err := c.readEntitiesAll(ctx, entities...)
+	if err != nil {
+		return nil, fmt.Errorf("error when reading entities: %v", err)
+	}
+	return readEntities, nil
+}
+
+// RebuildCache replaces the contents of the EntityCache with the state read
+// from the target. It is called automatically by Run once the stream is
+// established, but can also be called explicitly, e.g. after a pipeline change
+// with RECONCILE_AND_COMMIT.
+//
+// Writes made by the Client while the state is read are applied again to the
+// rebuilt cache, so they are not lost.
+func (c *Client) RebuildCache(ctx context.Context) error {
+	if c.cache == nil {
+		return fmt.Errorf("entity cache is not enabled")
+	}
+	return c.cache.rebuild(func() ([]*p4_v1.Entity, error) {
+		return c.readMirroredState(ctx)
+	})
+}
+
+// rebuildCacheAsync rebuilds the cache in the background. The rebuild is
+// cancelled when ctx is done or when the Client is closed. At most one rebuild
+// is queued at a time.
+func (c *Client) rebuildCacheAsync(ctx context.Context) {
+	if !c.cache.rebuildQueued.CompareAndSwap(false, true) {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-c.closeCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	go func() {
+		defer cancel()
+		c.cache.rebuildQueued.Store(false)
+		if err := c.RebuildCache(ctx); err != nil {
+			c.log.Error("Failed to rebuild entity cache", "error", err)
+		}
+	}()
+}
+
+// CacheDrift describes the differences between the EntityCache and the state
+// of the target.
+type CacheDrift struct {
+	// Missing entities are in the cache but not in the target, e.g. because
+	// they were removed by another controller or the device was reset.
+	Missing []*p4_v1.Entity
+	// Unexpected entities are in the target but not in the cache.
+	Unexpected []*p4_v1.Entity
+	// Changed entities are in both, with different values. The value from the
+	// target is provided.
+	Changed []*p4_v1.Entity
+}
+
+// Empty returns true if the cache and the target are in sync.
+func (d *CacheDrift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Unexpected) == 0 && len(d.Changed) == 0
+}
+
+// CheckCacheDrift reads the state of the target and compares it with the
+// contents of the EntityCache.
+func (c *Client) CheckCacheDrift(ctx context.Context) (*CacheDrift, error) {
+	if c.cache == nil {
+		return nil, fmt.Errorf("entity cache is not enabled")
+	}
+	entities, err := c.readMirroredState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cached := newEntitySet()
+	for _, entity := range c.cache.Entities() {
+		if _, err := cached.add(entity); err != nil {
+			return nil, err
+		}
+	}
+	actual := newEntitySet()
+	for _, entity := range entities {
+		if _, err := actual.add(entity); err != nil {
+			return nil, err
+		}
+	}
+	drift := &CacheDrift{}
+	for _, key := range cached.sortedKeys() {
+		actualEntity, ok := actual.normalized[key]
+		if !ok {
+			drift.Missing = append(drift.Missing, cached.entities[key])
+		} else if !sameEntityValue(cached.normalized[key], actualEntity) {
+			drift.Changed = append(drift.Changed, actual.entities[key])
+		}
+	}
+	for _, key := range actual.sortedKeys() {
+		if _, ok := cached.entities[key]; !ok {
+			drift.Unexpected = append(drift.Unexpected, actual.entities[key])
+		}
+	}
+	return drift, nil
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+func TestEntityCache(t *testing.T) {
+	ctx := context.Background()
+	var readEntities []*p4_v1.Entity
+	var writeErr error
+	p4RtClient := &fakeP4RuntimeClient{
+		writeFn: func(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+			return &p4_v1.WriteResponse{}, writeErr
+		},
+		readFn: func(ctx context.Context, in *p4_v1.ReadRequest, opts ...grpc.CallOption) (p4_v1.P4Runtime_ReadClient, error) {
+			done := false
+			return &fakeP4RuntimeReadClient{
+				recvFn: func() (*p4_v1.ReadResponse, error) {
+					if done {
+						return nil, io.EOF
+					}
+					done = true
+					return &p4_v1.ReadResponse{Entities: readEntities}, nil
+				},
+			}, nil
+		},
+	}
+	c := newTestClient(p4RtClient, newReconcileTestP4Info())
+	c.cache = newEntityCache()
+
+	newEntry := func(key byte, param byte) *p4_v1.TableEntry {
+		return c.NewTableEntry(
+			"t",
+			map[string]MatchInterface{"f": &ExactMatch{Value: []byte{key}}},
+			c.NewTableActionDirect("a", [][]byte{{param}}),
+			nil,
+		)
+	}
+	tableID := c.currentP4Info().tableId("t")
+
+	require.NoError(t, c.InsertTableEntry(ctx, newEntry(1, 1)))
+	require.NoError(t, c.InsertTableEntry(ctx, newEntry(2, 2)))
+	require.NoError(t, c.ModifyTableEntry(ctx, newEntry(2, 3)))
+	require.NoError(t, c.InsertActionProfileMember(ctx, c.NewActionProfileMember("ap", 1, "a", [][]byte{{1}})))
+	assert.Equal(t, 3, c.Cache().Len())
+	assert.Len(t, c.Cache().TableEntries(tableID), 2)
+	assert.Len(t, c.Cache().ActionProfileMembers(c.currentP4Info().actionProfileId("ap")), 1)
+	cached, ok := c.Cache().GetTableEntry(&p4_v1.TableEntry{
+		TableId: tableID,
+		Match:   []*p4_v1.FieldMatch{{FieldId: 1, FieldMatchType: &p4_v1.FieldMatch_Exact_{Exact: &p4_v1.FieldMatch_Exact{Value: []byte{0, 2}}}}},
+	})
+	require.True(t, ok)
+	assert.Equal(t, []byte{3}, cached.Action.GetAction().Params[0].Value)
+
+	require.NoError(t, c.DeleteTableEntry(ctx, newEntry(1, 1)))
+	assert.Len(t, c.Cache().TableEntries(tableID), 1)
+
+	// partial batch failure: only the first update succeeds
+	st, err := status.New(codes.Unknown, "batch failed").WithDetails(
+		&p4_v1.Error{CanonicalCode: int32(code.Code_OK)},
+		&p4_v1.Error{CanonicalCode: int32(code.Code_ALREADY_EXISTS)},
+	)
+	require.NoError(t, err)
+	writeErr = st.Err()
+	err = c.WriteUpdates(ctx, []*p4_v1.Update{
+		{Type: p4_v1.Update_INSERT, Entity: tableEntryToEntity(newEntry(4, 4))},
+		{Type: p4_v1.Update_INSERT, Entity: tableEntryToEntity(newEntry(5, 5))},
+	})
+	assert.Error(t, err)
+	writeErr = nil
+	_, ok = c.Cache().GetTableEntry(newEntry(4, 4))
+	assert.True(t, ok)
+	_, ok = c.Cache().GetTableEntry(newEntry(5, 5))
+	assert.False(t, ok)
+
+	// the target lost entry 4, modified entry 2 and has an extra entry 6
+	readEntities = []*p4_v1.Entity{
+		tableEntryToEntity(newEntry(2, 7)),
+		tableEntryToEntity(newEntry(6, 6)),
+		actionProfileMemberToEntity(c.NewActionProfileMember("ap", 1, "a", [][]byte{{0, 1}})),
+	}
+	drift, err := c.CheckCacheDrift(ctx)
+	require.NoError(t, err)
+	require.Len(t, drift.Missing, 1)
+	assert.Equal(t, []byte{4}, drift.Missing[0].GetTableEntry().Match[0].GetExact().Value)
+	require.Len(t, drift.Changed, 1)
+	assert.Equal(t, []byte{7}, drift.Changed[0].GetTableEntry().Action.GetAction().Params[0].Value)
+	require.Len(t, drift.Unexpected, 1)
+	assert.Equal(t, []byte{6}, drift.Unexpected[0].GetTableEntry().Match[0].GetExact().Value)
+
+	require.NoError(t, c.RebuildCache(ctx))
+	assert.Equal(t, 3, c.Cache().Len())
+	drift, err = c.CheckCacheDrift(ctx)
+	require.NoError(t, err)
+	assert.True(t, drift.Empty())
+}
+
+func TestRebuildCacheConcurrentWrite(t *testing.T) {
+	ctx := context.Background()
+	readStartedCh := make(chan struct{})
+	writeDoneCh := make(chan struct{})
+	p4RtClient := &fakeP4RuntimeClient{
+		writeFn: func(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+			return &p4_v1.WriteResponse{}, nil
+		},
+		readFn: func(ctx context.Context, in *p4_v1.ReadRequest, opts ...grpc.CallOption) (p4_v1.P4Runtime_ReadClient, error) {
+			// the write completes after the target state was read
+			close(readStartedCh)
+			<-writeDoneCh
+			return readResponses(), nil
+		},
+	}
+	c := newTestClient(p4RtClient, newReconcileTestP4Info())
+	c.cache = newEntityCache()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.RebuildCache(ctx)
+	}()
+	<-readStartedCh
+	entry := c.NewTableEntry("t", map[string]MatchInterface{"f": &ExactMatch{Value: []byte{1}}}, c.NewTableActionDirect("a", [][]byte{{1}}), nil)
+	require.NoError(t, c.InsertTableEntry(ctx, entry))
+	close(writeDoneCh)
+	require.NoError(t, <-errCh)
+	_, ok := c.Cache().GetTableEntry(entry)
+	assert.True(t, ok)
+}
+
+func TestEntityCacheScope(t *testing.T) {
+	ctx := context.Background()
+	var reads int
+	var writeErr error
+	p4RtClient := &fakeP4RuntimeClient{
+		writeFn: func(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+			return &p4_v1.WriteResponse{}, writeErr
+		},
+		readFn: func(ctx context.Context, in *p4_v1.ReadRequest, opts ...grpc.CallOption) (p4_v1.P4Runtime_ReadClient, error) {
+			reads++
+			return readResponses(), nil
+		},
+	}
+	p4Info := newReconcileTestP4Info()
+	c := newTestClient(p4RtClient, p4Info)
+	c.cache = newEntityCache()
+
+	// counter entries are not mirrored
+	require.NoError(t, c.WriteUpdate(ctx, &p4_v1.Update{
+		Type:   p4_v1.Update_MODIFY,
+		Entity: &p4_v1.Entity{Entity: &p4_v1.Entity_CounterEntry{CounterEntry: &p4_v1.CounterEntry{}}},
+	}))
+	assert.Equal(t, 0, c.Cache().Len())
+	drift, err := c.CheckCacheDrift(ctx)
+	require.NoError(t, err)
+	assert.True(t, drift.Empty())
+
+	// the outcome of the write is unknown: the cache is rebuilt
+	reads = 0
+	writeErr = status.Error(codes.Unavailable, "unavailable")
+	entry := c.NewTableEntry("t", map[string]MatchInterface{"f": &ExactMatch{Value: []byte{1}}}, c.NewTableActionDirect("a", [][]byte{{1}}), nil)
+	assert.Error(t, c.InsertTableEntry(ctx, entry))
+	assert.Eventually(t, func() bool {
+		return !c.Cache().Dirty()
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, reads)
+}
@@ -4,38 +4,161 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	log "github.com/sirupsen/logrus"
-	code "google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 
 	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
 	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/logging"
 )
 
 const (
 	P4RuntimePort = 9559
+
+	defaultSendBufferSize = 1000
+	defaultCloseTimeout   = 5 * time.Second
 )
 
 type ClientOptions struct {
 	CanonicalBytestrings bool
+	// EntityCache enables the local mirror of written entities, see
+	// EnableEntityCache.
+	EntityCache bool
+	// AutoPromote enables the high-availability mode, see WithAutoPromote.
+	AutoPromote *AutoPromoteOptions
+	// BackupPolicy determines how writes and pipeline changes are handled
+	// while the Client is not primary.
+	BackupPolicy BackupPolicy
+	// Metrics receives measurements for RPCs and stream messages. By default,
+	// no measurements are reported.
+	Metrics Metrics
+	// Logger is used for all the log messages of the Client, with the device
+	// id and the role name as fields. Requests, responses and stream messages
+	// are logged at the debug level. By default, nothing is logged.
+	Logger logging.Logger
+	// SendBufferSize is the number of stream messages which can be queued by
+	// SendMessage before it blocks. A negative size is ignored, and the
+	// default size is used instead.
+	SendBufferSize int
+	// CloseTimeout bounds the time spent by Close, or by Run when stopCh is
+	// closed, to send the queued stream messages and to close the stream.
+	CloseTimeout time.Duration
+	// StreamQueues enables separate queues for the stream messages received
+	// from the server, see WithStreamQueues. If nil, all the messages are sent
+	// on the messageCh channel provided to Run or Start.
+	StreamQueues *StreamQueuesOptions
+	// StreamErrorTimeout is how long a message sent with a SendHandle waits
+	// for a StreamError before it is considered successful.
+	StreamErrorTimeout time.Duration
+	// RetryPolicy enables retries for Write and Read RPCs, see
+	// WithRetryPolicy. If nil, failed RPCs are not retried.
+	RetryPolicy *RetryPolicy
 }
 
 var defaultClientOptions = ClientOptions{
 	CanonicalBytestrings: true,
+	EntityCache:          false,
+	Metrics:              noopMetrics{},
+	Logger:               logging.NewNoopLogger(),
+	BackupPolicy:         BackupPolicyFail,
+	SendBufferSize:       defaultSendBufferSize,
+	CloseTimeout:         defaultCloseTimeout,
+	StreamErrorTimeout:   defaultStreamErrorTimeout,
 }
 
 func DisableCanonicalBytestrings(options *ClientOptions) {
 	options.CanonicalBytestrings = false
 }
 
+// WithLogger returns a ClientOptions modifier which sets the Logger.
+func WithLogger(logger logging.Logger) func(*ClientOptions) {
+	return func(options *ClientOptions) {
+		options.Logger = logger
+	}
+}
+
+// WithSendBufferSize returns a ClientOptions modifier which sets the
+// SendBufferSize.
+func WithSendBufferSize(size int) func(*ClientOptions) {
+	return func(options *ClientOptions) {
+		options.SendBufferSize = size
+	}
+}
+
+// WithCloseTimeout returns a ClientOptions modifier which sets the
+// CloseTimeout.
+func WithCloseTimeout(timeout time.Duration) func(*ClientOptions) {
+	return func(options *ClientOptions) {
+		options.CloseTimeout = timeout
+	}
+}
+
 type Client struct {
 	ClientOptions
 	p4_v1.P4RuntimeClient
-	deviceID     uint64
-	electionID   *p4_v1.Uint128
-	p4Info       *p4_config_v1.P4Info
-	role         *p4_v1.Role
-	streamSendCh chan *p4_v1.StreamMessageRequest
+	deviceID uint64
+	// protects electionID and the arbitration state
+	arbitrationMu sync.Mutex
+	electionID    *p4_v1.Uint128
+	// election id provided when creating the Client, which is unique among
+	// the replicas of a controller
+	initialElectionID *p4_v1.Uint128
+	// last arbitration update received, nil until the first one
+	arbitration *ArbitrationStatus
+	// incremented with each arbitration update
+	arbitrationGen uint64
+	// requests queued with BackupPolicyQueue
+	pending  []*pendingRequest
+	flushing bool
+
+	subscribersMu      sync.Mutex
+	primarySubscribers map[chan bool]bool
+
+	// current P4Info, nil until one is set
+	p4InfoState atomic.Pointer[p4InfoSnapshot]
+	// serializes the P4Info changes, and protects pendingP4Info
+	pipelineMu sync.Mutex
+	// P4Info saved with VERIFY_AND_SAVE, waiting for a COMMIT
+	pendingP4Info *p4_config_v1.P4Info
+	role          *p4_v1.Role
+	// nil unless the role includes a RoleConfig
+	roleConfig   *RoleConfig
+	streamSendCh chan *queuedMessage
+	// nil unless EntityCache is enabled in ClientOptions
+	cache *EntityCache
+	// nil unless StreamQueues is set in ClientOptions
+	queues *streamQueues
+
+	subscriptionsMu sync.Mutex
+	subscriptions   map[*Subscription]bool
+
+	// messages sent with a SendHandle, in the order in which they were queued
+	trackedMu sync.Mutex
+	tracked   []*trackedMessage
+	// Logger with the fields identifying the Client
+	log logging.Logger
+	// number of streams started, used to count reconnections
+	runs atomic.Int32
+
+	// protects the stream state
+	streamMu      sync.Mutex
+	streamRunning bool
+	// closed when the current (or last) stream terminates, nil if no stream
+	// was ever started
+	streamDoneCh chan struct{}
+	// error which terminated the last stream
+	streamErr error
+	closed    bool
+	// closed by Close
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	// only set for Clients created with Dial
+	conn *grpc.ClientConn
 }
 
 func NewClient(
@@ -58,93 +181,76 @@ func NewClientForRole(
 	for _, fn := range optionsModifierFns {
 		fn(&options)
 	}
-	return &Client{
-		ClientOptions:   options,
-		P4RuntimeClient: p4RuntimeClient,
-		deviceID:        deviceID,
-		electionID:      electionID,
-		role:            role,
-		streamSendCh:    make(chan *p4_v1.StreamMessageRequest, 1000), // TODO: should be configurable
-	}
-}
-
-func (c *Client) Run(
-	stopCh <-chan struct{},
-	arbitrationCh chan<- bool,
-	messageCh chan<- *p4_v1.StreamMessageResponse, // all other stream messages besides arbitration
-) error {
-	// we use an empty Context which is never cancelled and has no
-	// deadline. We will close the stream by calling CloseSend when the
-	// caller closes the stopCh channel.
-	stream, err := c.StreamChannel(context.Background())
-	if err != nil {
-		return fmt.Errorf("cannot establish stream: %v", err)
-	}
-
-	defer stream.CloseSend()
-
-	connStatusCh := make(chan error)
-
-	go func() {
-		for {
-			in, err := stream.Recv()
-			if err == io.EOF {
-				// TODO: should reconnect
-				return
-			}
-			if err != nil {
-				log.Errorf("Failed to receive a stream message : %v", err)
-				connStatusCh <- err
-				return
-			}
-			arbitration, ok := in.Update.(*p4_v1.StreamMessageResponse_Arbitration)
-			if !ok {
-				messageCh <- in
-				continue
-			}
-			if arbitration.Arbitration.Status.Code != int32(code.Code_OK) {
-				if arbitrationCh != nil {
-					arbitrationCh <- false
-				}
-			} else {
-				if arbitrationCh != nil {
-					arbitrationCh <- true
-				}
-			}
-		}
-	}()
-
-	stream.Send(&p4_v1.StreamMessageRequest{
-		Update: &p4_v1.StreamMessageRequest_Arbitration{Arbitration: &p4_v1.MasterArbitrationUpdate{
-			DeviceId:   c.deviceID,
-			ElectionId: c.electionID,
-			Role:       c.role,
-		}},
-	})
-
-	for {
-		select {
-		case m := <-c.streamSendCh:
-			stream.Send(m)
-		case <-stopCh:
-			return nil
-		case err := <-connStatusCh:
-			return err
+	if options.Logger == nil {
+		options.Logger = logging.NewNoopLogger()
+	}
+	fields := []any{"deviceID", deviceID}
+	if role != nil {
+		fields = append(fields, "role", role.Name)
+	}
+	logger := options.Logger.With(fields...)
+	if options.SendBufferSize < 0 {
+		logger.Warn("Invalid send buffer size, using default", "size", options.SendBufferSize, "default", defaultSendBufferSize)
+		options.SendBufferSize = defaultSendBufferSize
+	}
+	c := &Client{
+		ClientOptions:     options,
+		P4RuntimeClient:   newTracingClient(p4RuntimeClient, logger),
+		deviceID:          deviceID,
+		electionID:        electionID,
+		initialElectionID: electionID,
+		role:              role,
+		log:               logger,
+		streamSendCh:      make(chan *queuedMessage, options.SendBufferSize),
+		closeCh:           make(chan struct{}),
+	}
+	if c.Metrics == nil {
+		c.Metrics = noopMetrics{}
+	}
+	if role.GetConfig() != nil {
+		roleConfig, err := RoleConfigFromAny(role.Config)
+		if err != nil {
+			c.log.Warn("Role config will not be enforced by the client", "error", err)
 		}
+		c.roleConfig = roleConfig
+	}
+	if options.EntityCache {
+		c.cache = newEntityCache()
 	}
+	if options.StreamQueues != nil {
+		c.queues = newStreamQueues(options.StreamQueues, c.Metrics)
+	}
+	return c
 }
 
 func (c *Client) WriteUpdate(ctx context.Context, update *p4_v1.Update) error {
-	req := &p4_v1.WriteRequest{
-		DeviceId:   c.deviceID,
-		ElectionId: c.electionID,
-		Updates:    []*p4_v1.Update{update},
+	return c.WriteUpdates(ctx, []*p4_v1.Update{update})
+}
+
+// WriteUpdates sends all the provided updates in a single WriteRequest. Note
+// that the P4Runtime server is free to apply the updates in any order.
+//
+// The updates are rejected with ErrStaleEntity if one of them refers to P4
+// objects which are not in the current P4Info. If the Client's role includes a
+// RoleConfig, the updates are rejected with ErrOutsideRole if one of them
+// writes an entity outside of the role. While the Client is not primary, the
+// updates are handled according to the BackupPolicy. Updates which fail with a
+// transient error are retried according to the RetryPolicy, if any.
+func (c *Client) WriteUpdates(ctx context.Context, updates []*p4_v1.Update) error {
+	if err := c.checkStale(updates); err != nil {
+		return err
 	}
-	if c.role != nil {
-		req.Role = c.role.Name
+	if err := c.checkRoleWrite(updates); err != nil {
+		return err
 	}
-	_, err := c.Write(ctx, req)
-	return err
+	return c.asPrimary(ctx, func() error {
+		err := c.writeWithRetries(ctx, updates)
+		if c.cache != nil && !c.cache.applyWrite(updates, err) {
+			c.log.Warn("Outcome of write is unknown, rebuilding entity cache", "error", err)
+			c.rebuildCacheAsync(context.Background())
+		}
+		return err
+	})
 }
 
 func (c *Client) ReadEntitySingle(ctx context.Context, entity *p4_v1.Entity) (*p4_v1.Entity, error) {
@@ -155,24 +261,15 @@ func (c *Client) ReadEntitySingle(ctx context.Context, entity *p4_v1.Entity) (*p
 	if c.role != nil {
 		req.Role = c.role.Name
 	}
-	stream, err := c.Read(ctx, req)
-	if err != nil {
-		return nil, err
-	}
 	var readEntity *p4_v1.Entity
 	count := 0
-	for {
-		rep, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
+	if err := c.readAll(ctx, req, func(rep *p4_v1.ReadResponse) {
 		for _, e := range rep.Entities {
 			count++
 			readEntity = e
 		}
+	}); err != nil {
+		return nil, err
 	}
 	if count == 0 {
 		return nil, fmt.Errorf("expected a single entity but got none")
@@ -195,6 +292,58 @@ func (c *Client) ReadEntityWildcard(ctx context.Context, entity *p4_v1.Entity, r
 	if c.role != nil {
 		req.Role = c.role.Name
 	}
+	return c.readAll(ctx, req, func(rep *p4_v1.ReadResponse) {
+		for _, e := range rep.Entities {
+			readEntityCh <- e
+		}
+	})
+}
+
+// readEntitiesAll reads all the provided entities (which may include
+// wildcards) in a single ReadRequest, and returns all the read entities as a
+// slice.
+func (c *Client) readEntitiesAll(ctx context.Context, entities ...*p4_v1.Entity) ([]*p4_v1.Entity, error) {
+	req := &p4_v1.ReadRequest{
+		DeviceId: c.deviceID,
+		Entities: entities,
+	}
+	if c.role != nil {
+		req.Role = c.role.Name
+	}
+	out := make([]*p4_v1.Entity, 0)
+	if err := c.readAll(ctx, req, func(rep *p4_v1.ReadResponse) {
+		out = append(out, rep.Entities...)
+	}); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// readAll issues the provided ReadRequest and calls fn for each response
+// until the RPC completes. If the RPC fails before any response is received,
+// it is retried according to the RetryPolicy.
+func (c *Client) readAll(ctx context.Context, req *p4_v1.ReadRequest, fn func(*p4_v1.ReadResponse)) error {
+	for attempt := 1; ; attempt++ {
+		received := false
+		err := c.readOnce(ctx, req, func(rep *p4_v1.ReadResponse) {
+			received = true
+			fn(rep)
+		})
+		if err == nil || received || !c.canRetry(ctx, attempt, status.Code(err)) {
+			return err
+		}
+		c.log.Info("Retrying read", "attempt", attempt+1, "error", err)
+		if !c.waitRetry(ctx, attempt) {
+			return err
+		}
+	}
+}
+
+func (c *Client) readOnce(ctx context.Context, req *p4_v1.ReadRequest, fn func(*p4_v1.ReadResponse)) (err error) {
+	start := time.Now()
+	defer func() {
+		c.observeRead(req.Entities, start, err)
+	}()
 	stream, err := c.Read(ctx, req)
 	if err != nil {
 		return err
@@ -202,26 +351,13 @@ func (c *Client) ReadEntityWildcard(ctx context.Context, entity *p4_v1.Entity, r
 	for {
 		rep, err := stream.Recv()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
 			return err
 		}
-		for _, e := range rep.Entities {
-			readEntityCh <- e
-		}
-	}
-	return nil
-}
-
-func (c *Client) SendMessage(ctx context.Context, msg *p4_v1.StreamMessageRequest) error {
-	select {
-	case c.streamSendCh <- msg:
-		break
-	case <-ctx.Done():
-		return ctx.Err()
+		fn(rep)
 	}
-	return nil
 }
 
 func (c *Client) SendPacketOut(ctx context.Context, pkt *p4_v1.PacketOut) error {
@@ -2,11 +2,16 @@ package client
 
 import (
 	"context"
+	"testing"
+	"time"
 
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 
 	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
 	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/fakeserver"
 )
 
 type fakeP4RuntimeClient struct {
@@ -79,13 +84,37 @@ func (c *fakeP4RuntimeReadClient) Recv() (*p4_v1.ReadResponse, error) {
 }
 
 func newTestClient(p4RuntimeClient *fakeP4RuntimeClient, p4Info *p4_config_v1.P4Info) *Client {
-	return &Client{
+	c := &Client{
 		ClientOptions:   defaultClientOptions,
 		P4RuntimeClient: p4RuntimeClient,
 		deviceID:        1,
 		electionID:      &p4_v1.Uint128{High: 0, Low: 1},
 		role:            nil,
-		streamSendCh:    make(chan *p4_v1.StreamMessageRequest, 1000),
-		p4Info:          p4Info,
+		streamSendCh:    make(chan *queuedMessage, defaultSendBufferSize),
+		closeCh:         make(chan struct{}),
+		log:             defaultClientOptions.Logger,
+	}
+	if p4Info != nil {
+		c.SetP4Info(p4Info)
+	}
+	return c
+}
+
+// newFakeServerClient returns a Client connected to the fake server, using the
+// provided election id. If run is true, the Client is run until the end of the
+// test.
+func newFakeServerClient(t *testing.T, s *fakeserver.Server, electionID uint64, run bool, optionsModifierFns ...func(*ClientOptions)) *Client {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := s.Dial(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	c := NewClient(p4_v1.NewP4RuntimeClient(conn), 1, &p4_v1.Uint128{High: 0, Low: electionID}, optionsModifierFns...)
+	if run {
+		stopCh := make(chan struct{})
+		t.Cleanup(func() { close(stopCh) })
+		arbitrationCh := make(chan bool, 100)
+		go c.Run(stopCh, arbitrationCh, make(chan *p4_v1.StreamMessageResponse, 100)) //nolint:errcheck
 	}
+	return c
 }
@@ -13,7 +13,7 @@ const (
 )
 
 func (c *Client) ModifyCounterEntry(ctx context.Context, counter string, index int64, data *p4_v1.CounterData) error {
-	counterID := c.counterId(counter)
+	counterID := c.currentP4Info().counterId(counter)
 	entry := &p4_v1.CounterEntry{
 		CounterId: counterID,
 		Index:     &p4_v1.Index{Index: index},
@@ -29,7 +29,7 @@ func (c *Client) ModifyCounterEntry(ctx context.Context, counter string, index i
 }
 
 func (c *Client) ReadCounterEntry(ctx context.Context, counter string, index int64) (*p4_v1.CounterData, error) {
-	counterID := c.counterId(counter)
+	counterID := c.currentP4Info().counterId(counter)
 	entry := &p4_v1.CounterEntry{
 		CounterId: counterID,
 		Index:     &p4_v1.Index{Index: index},
@@ -48,7 +48,7 @@ func (c *Client) ReadCounterEntry(ctx context.Context, counter string, index int
 }
 
 func (c *Client) ReadCounterEntryWildcard(ctx context.Context, counter string) ([]*p4_v1.CounterData, error) {
-	p4Counter := c.findCounter(counter)
+	p4Counter := c.currentP4Info().findCounter(counter)
 	entry := &p4_v1.CounterEntry{
 		CounterId: p4Counter.Preamble.Id,
 	}
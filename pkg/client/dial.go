@@ -0,0 +1,216 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+const (
+	defaultMaxMsgSize = 64 * 1024 * 1024
+)
+
+// TLSOptions configures TLS for the gRPC connection. Files are in PEM format.
+type TLSOptions struct {
+	// CACertFile is used to verify the server certificate. If empty, the
+	// system roots are used.
+	CACertFile string
+	// CertFile and KeyFile are the client certificate and private key, for
+	// mutual TLS. They are both empty if the server does not verify client
+	// certificates.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name used to verify the server certificate,
+	// which defaults to the host in the address.
+	ServerName string
+	// InsecureSkipVerify disables the verification of the server
+	// certificate, and should only be used for testing.
+	InsecureSkipVerify bool
+}
+
+// ConnectOptions configures DialConn and Dial.
+type ConnectOptions struct {
+	// TLS enables TLS. If nil, the connection is insecure.
+	TLS *TLSOptions
+	// Keepalive configures gRPC keepalive pings. If nil, no pings are sent.
+	Keepalive *keepalive.ClientParameters
+	// MaxMsgSize is the maximum size in bytes of messages sent and received
+	// by the client. Responses to wildcard Read RPCs can be large.
+	MaxMsgSize int
+	// Metadata is added to all RPCs.
+	Metadata map[string]string
+	// AuthToken, if not empty, is sent with all RPCs as a bearer token in the
+	// "authorization" metadata.
+	AuthToken string
+	// DialOptions are appended to the options created by DialConn.
+	DialOptions []grpc.DialOption
+
+	// The following options are only used by Dial.
+
+	// Role is the role of the Client, nil for the default role.
+	Role *p4_v1.Role
+	// ArbitrationCh, if not nil, receives the arbitration updates, as with
+	// Run.
+	ArbitrationCh chan<- bool
+	// MessageCh receives stream messages other than arbitration updates, as
+	// with Run. It can be nil, in which case these messages are dropped.
+	MessageCh chan<- *p4_v1.StreamMessageResponse
+}
+
+var DefaultConnectOptions = ConnectOptions{
+	MaxMsgSize: defaultMaxMsgSize,
+}
+
+func (options *ConnectOptions) transportCredentials() (credentials.TransportCredentials, error) {
+	if options.TLS == nil {
+		return insecure.NewCredentials(), nil
+	}
+	tlsOptions := options.TLS
+	tlsConfig := &tls.Config{
+		ServerName:         tlsOptions.ServerName,
+		InsecureSkipVerify: tlsOptions.InsecureSkipVerify,
+	}
+	if tlsOptions.CACertFile != "" {
+		pem, err := os.ReadFile(tlsOptions.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificate in %s", tlsOptions.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if tlsOptions.CertFile != "" || tlsOptions.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsOptions.CertFile, tlsOptions.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+func (options *ConnectOptions) outgoingMetadata() []string {
+	var kv []string
+	for k, v := range options.Metadata {
+		kv = append(kv, k, v)
+	}
+	if options.AuthToken != "" {
+		kv = append(kv, "authorization", "Bearer "+options.AuthToken)
+	}
+	return kv
+}
+
+func (options *ConnectOptions) dialOptions() ([]grpc.DialOption, error) {
+	creds, err := options.transportCredentials()
+	if err != nil {
+		return nil, err
+	}
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if options.Keepalive != nil {
+		dialOptions = append(dialOptions, grpc.WithKeepaliveParams(*options.Keepalive))
+	}
+	if options.MaxMsgSize > 0 {
+		dialOptions = append(dialOptions, grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(options.MaxMsgSize),
+			grpc.MaxCallSendMsgSize(options.MaxMsgSize),
+		))
+	}
+	if kv := options.outgoingMetadata(); len(kv) > 0 {
+		dialOptions = append(dialOptions,
+			grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+				return invoker(metadata.AppendToOutgoingContext(ctx, kv...), method, req, reply, cc, opts...)
+			}),
+			grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+				return streamer(metadata.AppendToOutgoingContext(ctx, kv...), desc, cc, method, opts...)
+			}),
+		)
+	}
+	return append(dialOptions, options.DialOptions...), nil
+}
+
+// DialConn creates a gRPC connection to the P4Runtime server at addr. The
+// connection is established in the background: errors are reported by the
+// first RPC.
+func DialConn(ctx context.Context, addr string, options ConnectOptions) (*grpc.ClientConn, error) {
+	dialOptions, err := options.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+	return grpc.DialContext(ctx, addr, dialOptions...)
+}
+
+// Dial connects to the P4Runtime server at addr, creates a Client and runs its
+// stream channel. It returns once the first arbitration update has been
+// received, i.e. when the Client is either primary or backup, or when ctx is
+// done. The stream and the connection are closed by Close.
+func Dial(
+	ctx context.Context,
+	addr string,
+	deviceID uint64,
+	electionID *p4_v1.Uint128,
+	options ConnectOptions,
+	optionsModifierFns ...func(*ClientOptions),
+) (*Client, error) {
+	conn, err := DialConn(ctx, addr, options)
+	if err != nil {
+		return nil, err
+	}
+	c := NewClientForRole(p4_v1.NewP4RuntimeClient(conn), deviceID, electionID, options.Role, optionsModifierFns...)
+	c.conn = conn
+
+	arbitrationCh := make(chan bool)
+	readyCh := make(chan struct{})
+	go func() {
+		ready := false
+		for {
+			select {
+			case isPrimary := <-arbitrationCh:
+				if !ready {
+					close(readyCh)
+					ready = true
+				}
+				if options.ArbitrationCh != nil {
+					select {
+					case options.ArbitrationCh <- isPrimary:
+					case <-c.closeCh:
+						return
+					}
+				}
+			case <-c.closeCh:
+				return
+			}
+		}
+	}()
+	// the stream must outlive ctx, which only bounds the wait for arbitration
+	if err := c.Start(context.Background(), arbitrationCh, options.MessageCh); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	select {
+	case <-readyCh:
+		return c, nil
+	case <-c.Done():
+		err := c.Err()
+		c.Close()
+		if err == nil {
+			err = fmt.Errorf("stream channel terminated before arbitration")
+		}
+		return nil, err
+	case <-ctx.Done():
+		c.Close()
+		return nil, fmt.Errorf("no arbitration update received: %w", ctx.Err())
+	}
+}
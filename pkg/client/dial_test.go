@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/fakeserver"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	tlsCert tls.Certificate
+}
+
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		tlsCert: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+	}
+}
+
+// writePEM writes the certificate and its key to dir, and returns the paths.
+func (c *testCert) writePEM(t *testing.T, dir string, name string) (string, string) {
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certPath, keyPath
+}
+
+func TestDialMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	notAfter := time.Now().Add(time.Hour)
+	ca := newTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	serverCert := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "switch"},
+		DNSNames:     []string{"switch"},
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	clientCert := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "controller"},
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	caPath, _ := ca.writePEM(t, dir, "ca")
+	clientCertPath, clientKeyPath := clientCert.writePEM(t, dir, "client")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	checkToken := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if values := md.Get("authorization"); len(values) != 1 || values[0] != "Bearer secret" {
+			return status.Error(codes.Unauthenticated, "invalid token")
+		}
+		return nil
+	}
+	grpcServer := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{serverCert.tlsCert},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		})),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := checkToken(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := checkToken(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	p4_v1.RegisterP4RuntimeServer(grpcServer, fakeserver.NewServer(1, &p4_config_v1.P4Info{}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(lis) //nolint:errcheck
+	defer grpcServer.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	options := DefaultConnectOptions
+	options.TLS = &TLSOptions{
+		CACertFile: caPath,
+		CertFile:   clientCertPath,
+		KeyFile:    clientKeyPath,
+		ServerName: "switch",
+	}
+	options.AuthToken = "secret"
+	arbitrationCh := make(chan bool, 1)
+	options.ArbitrationCh = arbitrationCh
+
+	c, err := Dial(ctx, lis.Addr().String(), 1, &p4_v1.Uint128{High: 0, Low: 1}, options)
+	require.NoError(t, err)
+	assert.True(t, <-arbitrationCh)
+	_, err = c.Capabilities(ctx, &p4_v1.CapabilitiesRequest{})
+	assert.NoError(t, err)
+	assert.NoError(t, c.Close())
+
+	// wrong token
+	options.AuthToken = "wrong"
+	_, err = Dial(ctx, lis.Addr().String(), 1, &p4_v1.Uint128{High: 0, Low: 1}, options)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// no client certificate
+	options.AuthToken = "secret"
+	options.TLS.CertFile = ""
+	options.TLS.KeyFile = ""
+	_, err = Dial(ctx, lis.Addr().String(), 1, &p4_v1.Uint128{High: 0, Low: 1}, options)
+	assert.Error(t, err)
+}
+
+func TestDialInvalidTLSOptions(t *testing.T) {
+	options := DefaultConnectOptions
+	options.TLS = &TLSOptions{CACertFile: filepath.Join(t.TempDir(), "missing.crt")}
+	_, err := DialConn(context.Background(), "127.0.0.1:9559", options)
+	assert.Error(t, err)
+}
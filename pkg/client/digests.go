@@ -6,18 +6,21 @@ import (
 	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
 )
 
-func (c *Client) AckDigestList(ctx context.Context, digestList *p4_v1.DigestList) error {
-	m := &p4_v1.StreamMessageRequest{
+func digestListAck(digestList *p4_v1.DigestList) *p4_v1.StreamMessageRequest {
+	return &p4_v1.StreamMessageRequest{
 		Update: &p4_v1.StreamMessageRequest_DigestAck{DigestAck: &p4_v1.DigestListAck{
 			DigestId: digestList.DigestId,
 			ListId:   digestList.ListId,
 		}},
 	}
-	return c.SendMessage(ctx, m)
+}
+
+func (c *Client) AckDigestList(ctx context.Context, digestList *p4_v1.DigestList) error {
+	return c.SendMessage(ctx, digestListAck(digestList))
 }
 
 func (c *Client) EnableDigest(ctx context.Context, digest string, config *p4_v1.DigestEntry_Config) error {
-	digestID := c.digestId(digest)
+	digestID := c.currentP4Info().digestId(digest)
 	entry := &p4_v1.DigestEntry{
 		DigestId: digestID,
 		Config:   config,
@@ -32,7 +35,7 @@ func (c *Client) EnableDigest(ctx context.Context, digest string, config *p4_v1.
 }
 
 func (c *Client) ModifyDigest(ctx context.Context, digest string, config *p4_v1.DigestEntry_Config) error {
-	digestID := c.digestId(digest)
+	digestID := c.currentP4Info().digestId(digest)
 	entry := &p4_v1.DigestEntry{
 		DigestId: digestID,
 		Config:   config,
@@ -47,7 +50,7 @@ func (c *Client) ModifyDigest(ctx context.Context, digest string, config *p4_v1.
 }
 
 func (c *Client) DisableDigest(ctx context.Context, digest string) error {
-	digestID := c.digestId(digest)
+	digestID := c.currentP4Info().digestId(digest)
 	entry := &p4_v1.DigestEntry{
 		DigestId: digestID,
 	}
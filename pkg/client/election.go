@@ -0,0 +1,230 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+const (
+	defaultPromotionTimeout = 5 * time.Second
+)
+
+// ArbitrationStatus is the status reported by the last arbitration update
+// received on the stream.
+type ArbitrationStatus struct {
+	IsPrimary bool
+	// Code is OK for the primary, ALREADY_EXISTS for a backup when there is
+	// a primary, and NOT_FOUND when there is no primary. It is UNAVAILABLE
+	// after the stream terminated, until the next arbitration update.
+	Code codes.Code
+	// PrimaryElectionID is the election id of the primary or, when there is
+	// no primary, the highest election id seen by the server for the role. It
+	// is nil if it was not included in the update.
+	PrimaryElectionID *p4_v1.Uint128
+}
+
+// AutoPromoteOptions configures the high-availability mode, in which a backup
+// Client becomes primary when the primary disconnects, see WithAutoPromote.
+type AutoPromoteOptions struct {
+	// Delay is how long the Client waits after learning that there is no
+	// primary before promoting itself. When several replicas of a controller
+	// use auto-promotion, they should use different delays, so that the
+	// replica with the shortest delay takes over and the other ones remain
+	// backups. Replicas which promote themselves at the same time use
+	// different election ids, and the one with the highest initial election
+	// id becomes primary.
+	Delay time.Duration
+}
+
+// WithAutoPromote returns a ClientOptions modifier which enables the
+// high-availability mode: when the server reports that there is no primary,
+// the Client waits for delay and, if there is still no primary, sets its
+// election id to the highest id known by the server plus the election id the
+// Client was created with, to become primary. The election ids of the
+// replicas must be unique, as required by P4Runtime, so that they never
+// promote themselves with the same election id.
+func WithAutoPromote(delay time.Duration) func(*ClientOptions) {
+	return func(options *ClientOptions) {
+		options.AutoPromote = &AutoPromoteOptions{Delay: delay}
+	}
+}
+
+// CompareElectionIDs returns -1 if a < b, 0 if a == b and 1 if a > b. A nil
+// election id is lower than all other election ids.
+func CompareElectionIDs(a, b *p4_v1.Uint128) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	case a.High != b.High:
+		if a.High < b.High {
+			return -1
+		}
+		return 1
+	case a.Low != b.Low:
+		if a.Low < b.Low {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// NextElectionID returns the election id immediately after id.
+func NextElectionID(id *p4_v1.Uint128) *p4_v1.Uint128 {
+	if id == nil {
+		return &p4_v1.Uint128{High: 0, Low: 1}
+	}
+	if id.Low == ^uint64(0) {
+		return &p4_v1.Uint128{High: id.High + 1, Low: 0}
+	}
+	return &p4_v1.Uint128{High: id.High, Low: id.Low + 1}
+}
+
+// addElectionIDs returns a + b, wrapping around on overflow.
+func addElectionIDs(a, b *p4_v1.Uint128) *p4_v1.Uint128 {
+	low := a.GetLow() + b.GetLow()
+	high := a.GetHigh() + b.GetHigh()
+	if low < a.GetLow() {
+		high++
+	}
+	return &p4_v1.Uint128{High: high, Low: low}
+}
+
+// ElectionID returns the current election id of the Client.
+func (c *Client) ElectionID() *p4_v1.Uint128 {
+	c.arbitrationMu.Lock()
+	defer c.arbitrationMu.Unlock()
+	return c.electionID
+}
+
+// ArbitrationStatus returns the status reported by the last arbitration
+// update. ok is false if no update has been received yet.
+func (c *Client) ArbitrationStatus() (status ArbitrationStatus, ok bool) {
+	c.arbitrationMu.Lock()
+	defer c.arbitrationMu.Unlock()
+	if c.arbitration == nil {
+		return ArbitrationStatus{}, false
+	}
+	return *c.arbitration, true
+}
+
+func (c *Client) arbitrationRequest() *p4_v1.StreamMessageRequest {
+	return &p4_v1.StreamMessageRequest{
+		Update: &p4_v1.StreamMessageRequest_Arbitration{Arbitration: &p4_v1.MasterArbitrationUpdate{
+			DeviceId:   c.deviceID,
+			ElectionId: c.ElectionID(),
+			Role:       c.role,
+		}},
+	}
+}
+
+// SetElectionID changes the election id of the Client and sends a new
+// arbitration update on the stream, which must be running. A higher election
+// id than the primary's can be used to take over (see TakeOver), and a lower
+// one to step down gracefully. The outcome is reported by the next
+// arbitration update received from the server.
+func (c *Client) SetElectionID(ctx context.Context, electionID *p4_v1.Uint128) error {
+	c.arbitrationMu.Lock()
+	c.electionID = electionID
+	c.arbitrationMu.Unlock()
+	return c.SendMessage(ctx, c.arbitrationRequest())
+}
+
+// TakeOver makes the Client primary, by setting its election id to the next
+// id after the one of the current primary (or after the highest id known by
+// the server if there is no primary). It is a no-op if the Client is already
+// primary.
+func (c *Client) TakeOver(ctx context.Context) error {
+	return c.takeOver(ctx, nil)
+}
+
+// takeOver sets the election id of the Client to the highest known election
+// id plus step, or to the next id if step is nil or zero.
+func (c *Client) takeOver(ctx context.Context, step *p4_v1.Uint128) error {
+	c.arbitrationMu.Lock()
+	if c.arbitration != nil && c.arbitration.IsPrimary {
+		c.arbitrationMu.Unlock()
+		return nil
+	}
+	highest := c.electionID
+	if c.arbitration != nil && CompareElectionIDs(c.arbitration.PrimaryElectionID, highest) > 0 {
+		highest = c.arbitration.PrimaryElectionID
+	}
+	c.arbitrationMu.Unlock()
+	if CompareElectionIDs(step, &p4_v1.Uint128{}) <= 0 {
+		return c.SetElectionID(ctx, NextElectionID(highest))
+	}
+	return c.SetElectionID(ctx, addElectionIDs(highest, step))
+}
+
+// resetArbitration is called when the stream terminates: the Client is no
+// longer primary (the server drops the primary when its stream ends), so
+// requests are handled according to the BackupPolicy until the next
+// arbitration update. A pending promotion is cancelled.
+func (c *Client) resetArbitration() {
+	c.arbitrationMu.Lock()
+	defer c.arbitrationMu.Unlock()
+	if c.arbitration == nil {
+		return
+	}
+	wasPrimary := c.arbitration.IsPrimary
+	c.arbitration = &ArbitrationStatus{Code: codes.Unavailable}
+	c.arbitrationGen++
+	if wasPrimary {
+		c.notifyPrimaryStatus(false)
+	}
+}
+
+// handleArbitration records the arbitration update received from the server,
+// notifies primary status subscribers and flushes the queued requests when the
+// Client becomes primary. In high-availability mode, it also schedules the
+// promotion of the Client if there is no primary.
+func (c *Client) handleArbitration(update *p4_v1.MasterArbitrationUpdate) ArbitrationStatus {
+	status := ArbitrationStatus{
+		Code:              codes.Code(update.GetStatus().GetCode()),
+		PrimaryElectionID: update.ElectionId,
+	}
+	status.IsPrimary = status.Code == codes.OK
+	c.arbitrationMu.Lock()
+	defer c.arbitrationMu.Unlock()
+	changed := c.arbitration == nil || c.arbitration.IsPrimary != status.IsPrimary
+	c.arbitration = &status
+	c.arbitrationGen++
+	if changed {
+		c.notifyPrimaryStatus(status.IsPrimary)
+	}
+	if status.IsPrimary && len(c.pending) > 0 && !c.flushing {
+		c.flushing = true
+		go c.flushPending()
+	}
+	if status.Code == codes.NotFound && c.AutoPromote != nil {
+		gen := c.arbitrationGen
+		time.AfterFunc(c.AutoPromote.Delay, func() {
+			c.arbitrationMu.Lock()
+			stale := c.arbitrationGen != gen
+			c.arbitrationMu.Unlock()
+			if stale {
+				// another update was received, e.g. another replica
+				// became primary
+				return
+			}
+			c.log.Info("No primary client, promoting self")
+			ctx, cancel := context.WithTimeout(context.Background(), defaultPromotionTimeout)
+			defer cancel()
+			// replicas with the same delay promote themselves with
+			// different election ids
+			if err := c.takeOver(ctx, c.initialElectionID); err != nil {
+				c.log.Error("Failed to promote self", "error", err)
+			}
+		})
+	}
+	return status
+}
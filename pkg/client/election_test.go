@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/fakeserver"
+)
+
+func waitArbitrationStatus(t *testing.T, c *Client, code codes.Code) ArbitrationStatus {
+	var status ArbitrationStatus
+	require.Eventually(t, func() bool {
+		var ok bool
+		status, ok = c.ArbitrationStatus()
+		return ok && status.Code == code
+	}, 5*time.Second, 10*time.Millisecond)
+	return status
+}
+
+func TestElectionID(t *testing.T) {
+	ctx := context.Background()
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c1 := newFakeServerClient(t, s, 1, true)
+	waitArbitrationStatus(t, c1, codes.OK)
+	c2 := newFakeServerClient(t, s, 2, true)
+	waitArbitrationStatus(t, c2, codes.OK)
+	status := waitArbitrationStatus(t, c1, codes.AlreadyExists)
+	assert.False(t, status.IsPrimary)
+	assert.Equal(t, uint64(2), status.PrimaryElectionID.Low)
+
+	// take over from c2
+	require.NoError(t, c1.TakeOver(ctx))
+	assert.Equal(t, uint64(3), c1.ElectionID().Low)
+	status = waitArbitrationStatus(t, c1, codes.OK)
+	assert.True(t, status.IsPrimary)
+	waitArbitrationStatus(t, c2, codes.AlreadyExists)
+	// no-op for the primary
+	require.NoError(t, c1.TakeOver(ctx))
+	assert.Equal(t, uint64(3), c1.ElectionID().Low)
+
+	// step down: there is no primary until a client uses an election id
+	// which is at least 3
+	require.NoError(t, c1.SetElectionID(ctx, &p4_v1.Uint128{High: 0, Low: 1}))
+	status = waitArbitrationStatus(t, c2, codes.NotFound)
+	assert.Equal(t, uint64(3), status.PrimaryElectionID.Low)
+	waitArbitrationStatus(t, c1, codes.NotFound)
+}
+
+func TestAutoPromote(t *testing.T) {
+	ctx := context.Background()
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	primary := newFakeServerClient(t, s, 10, true)
+	waitArbitrationStatus(t, primary, codes.OK)
+	backup1 := newFakeServerClient(t, s, 1, true, WithAutoPromote(10*time.Millisecond))
+	backup2 := newFakeServerClient(t, s, 2, true, WithAutoPromote(time.Second))
+	waitArbitrationStatus(t, backup1, codes.AlreadyExists)
+	waitArbitrationStatus(t, backup2, codes.AlreadyExists)
+
+	// the primary steps down, and the backup with the shortest delay takes
+	// over
+	require.NoError(t, primary.SetElectionID(ctx, &p4_v1.Uint128{High: 0, Low: 5}))
+	waitArbitrationStatus(t, backup1, codes.OK)
+	assert.Equal(t, uint64(11), backup1.ElectionID().Low)
+	waitArbitrationStatus(t, backup2, codes.AlreadyExists)
+	time.Sleep(1100 * time.Millisecond)
+	status, _ := backup2.ArbitrationStatus()
+	assert.Equal(t, codes.AlreadyExists, status.Code)
+	assert.Equal(t, uint64(2), backup2.ElectionID().Low)
+}
+
+func TestAutoPromoteSameDelay(t *testing.T) {
+	ctx := context.Background()
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	primary := newFakeServerClient(t, s, 10, true)
+	waitArbitrationStatus(t, primary, codes.OK)
+	backup1 := newFakeServerClient(t, s, 1, true, WithAutoPromote(10*time.Millisecond))
+	backup2 := newFakeServerClient(t, s, 2, true, WithAutoPromote(10*time.Millisecond))
+	waitArbitrationStatus(t, backup1, codes.AlreadyExists)
+	waitArbitrationStatus(t, backup2, codes.AlreadyExists)
+
+	// both backups promote themselves, with different election ids, and the
+	// one with the highest initial election id becomes primary
+	require.NoError(t, primary.SetElectionID(ctx, &p4_v1.Uint128{High: 0, Low: 5}))
+	waitArbitrationStatus(t, backup2, codes.OK)
+	assert.Equal(t, uint64(12), backup2.ElectionID().Low)
+	status := waitArbitrationStatus(t, backup1, codes.AlreadyExists)
+	assert.Equal(t, uint64(12), status.PrimaryElectionID.Low)
+	assert.Equal(t, uint64(11), backup1.ElectionID().Low)
+	// the stream of the other backup is still running
+	assert.NoError(t, backup1.Err())
+}
+
+func TestAddElectionIDs(t *testing.T) {
+	assert.Equal(t, uint64(3), addElectionIDs(&p4_v1.Uint128{Low: 1}, &p4_v1.Uint128{Low: 2}).Low)
+	sum := addElectionIDs(&p4_v1.Uint128{High: 1, Low: ^uint64(0)}, &p4_v1.Uint128{Low: 2})
+	assert.Equal(t, &p4_v1.Uint128{High: 2, Low: 1}, sum)
+}
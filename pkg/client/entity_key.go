@@ -0,0 +1,188 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/util/conversion"
+)
+
+var deterministicMarshal = proto.MarshalOptions{Deterministic: true}
+
+// normalizeEntity returns a copy of the entity in which all bytestrings are
+// canonical and all repeated fields with "set" semantics (match fields, group
+// members, replicas, ...) are sorted. Read-only fields which are not part of
+// the entity's configuration (statistics, time since last hit) are cleared.
+// Two entities which are equivalent from the point of view of the P4Runtime
+// server are equal (proto.Equal) after normalization.
+func normalizeEntity(entity *p4_v1.Entity) *p4_v1.Entity {
+	entity = proto.Clone(entity).(*p4_v1.Entity)
+	switch e := entity.Entity.(type) {
+	case *p4_v1.Entity_TableEntry:
+		normalizeTableEntry(e.TableEntry)
+	case *p4_v1.Entity_ActionProfileMember:
+		normalizeAction(e.ActionProfileMember.Action)
+	case *p4_v1.Entity_ActionProfileGroup:
+		sort.Slice(e.ActionProfileGroup.Members, func(i, j int) bool {
+			return e.ActionProfileGroup.Members[i].MemberId < e.ActionProfileGroup.Members[j].MemberId
+		})
+	case *p4_v1.Entity_PacketReplicationEngineEntry:
+		switch pre := e.PacketReplicationEngineEntry.Type.(type) {
+		case *p4_v1.PacketReplicationEngineEntry_MulticastGroupEntry:
+			sortReplicas(pre.MulticastGroupEntry.Replicas)
+		case *p4_v1.PacketReplicationEngineEntry_CloneSessionEntry:
+			sortReplicas(pre.CloneSessionEntry.Replicas)
+		}
+	case *p4_v1.Entity_CounterEntry:
+		e.CounterEntry.Data = nil
+	case *p4_v1.Entity_MeterEntry:
+		e.MeterEntry.CounterData = nil
+	case *p4_v1.Entity_DirectCounterEntry:
+		normalizeTableEntry(e.DirectCounterEntry.TableEntry)
+		e.DirectCounterEntry.Data = nil
+	case *p4_v1.Entity_DirectMeterEntry:
+		normalizeTableEntry(e.DirectMeterEntry.TableEntry)
+		e.DirectMeterEntry.CounterData = nil
+	}
+	return entity
+}
+
+func normalizeTableEntry(entry *p4_v1.TableEntry) {
+	if entry == nil {
+		return
+	}
+	for _, mf := range entry.Match {
+		switch m := mf.FieldMatchType.(type) {
+		case *p4_v1.FieldMatch_Exact_:
+			m.Exact.Value = conversion.ToCanonicalBytestring(m.Exact.Value)
+		case *p4_v1.FieldMatch_Lpm:
+			m.Lpm.Value = conversion.ToCanonicalBytestring(m.Lpm.Value)
+		case *p4_v1.FieldMatch_Ternary_:
+			m.Ternary.Value = conversion.ToCanonicalBytestring(m.Ternary.Value)
+			m.Ternary.Mask = conversion.ToCanonicalBytestring(m.Ternary.Mask)
+		case *p4_v1.FieldMatch_Range_:
+			m.Range.Low = conversion.ToCanonicalBytestring(m.Range.Low)
+			m.Range.High = conversion.ToCanonicalBytestring(m.Range.High)
+		case *p4_v1.FieldMatch_Optional_:
+			m.Optional.Value = conversion.ToCanonicalBytestring(m.Optional.Value)
+		}
+	}
+	sort.Slice(entry.Match, func(i, j int) bool {
+		return entry.Match[i].FieldId < entry.Match[j].FieldId
+	})
+	switch a := entry.Action.GetType().(type) {
+	case *p4_v1.TableAction_Action:
+		normalizeAction(a.Action)
+	case *p4_v1.TableAction_ActionProfileActionSet:
+		for _, apAction := range a.ActionProfileActionSet.ActionProfileActions {
+			normalizeAction(apAction.Action)
+		}
+	}
+	entry.CounterData = nil
+	entry.MeterCounterData = nil
+	entry.TimeSinceLastHit = nil
+}
+
+func normalizeAction(action *p4_v1.Action) {
+	if action == nil {
+		return
+	}
+	for _, p := range action.Params {
+		p.Value = conversion.ToCanonicalBytestring(p.Value)
+	}
+	sort.Slice(action.Params, func(i, j int) bool {
+		return action.Params[i].ParamId < action.Params[j].ParamId
+	})
+}
+
+func sortReplicas(replicas []*p4_v1.Replica) {
+	sort.Slice(replicas, func(i, j int) bool {
+		if replicas[i].EgressPort != replicas[j].EgressPort {
+			return replicas[i].EgressPort < replicas[j].EgressPort
+		}
+		return replicas[i].Instance < replicas[j].Instance
+	})
+}
+
+// entityKey returns a string which uniquely identifies the entity on the
+// P4Runtime server: two entities have the same key if and only if writing one
+// of them with MODIFY would overwrite the other. The entity should be
+// normalized with normalizeEntity first.
+func entityKey(entity *p4_v1.Entity) (string, error) {
+	var prefix string
+	var key proto.Message
+	switch e := entity.Entity.(type) {
+	case *p4_v1.Entity_TableEntry:
+		prefix = "table"
+		key = tableEntryKey(e.TableEntry)
+	case *p4_v1.Entity_ActionProfileMember:
+		prefix = "member"
+		key = &p4_v1.ActionProfileMember{
+			ActionProfileId: e.ActionProfileMember.ActionProfileId,
+			MemberId:        e.ActionProfileMember.MemberId,
+		}
+	case *p4_v1.Entity_ActionProfileGroup:
+		prefix = "group"
+		key = &p4_v1.ActionProfileGroup{
+			ActionProfileId: e.ActionProfileGroup.ActionProfileId,
+			GroupId:         e.ActionProfileGroup.GroupId,
+		}
+	case *p4_v1.Entity_PacketReplicationEngineEntry:
+		switch pre := e.PacketReplicationEngineEntry.Type.(type) {
+		case *p4_v1.PacketReplicationEngineEntry_MulticastGroupEntry:
+			prefix = "mcast"
+			key = &p4_v1.MulticastGroupEntry{MulticastGroupId: pre.MulticastGroupEntry.MulticastGroupId}
+		case *p4_v1.PacketReplicationEngineEntry_CloneSessionEntry:
+			prefix = "clone"
+			key = &p4_v1.CloneSessionEntry{SessionId: pre.CloneSessionEntry.SessionId}
+		default:
+			return "", fmt.Errorf("unsupported PRE entry type %T", pre)
+		}
+	case *p4_v1.Entity_CounterEntry:
+		prefix = "counter"
+		key = &p4_v1.CounterEntry{CounterId: e.CounterEntry.CounterId, Index: e.CounterEntry.Index}
+	case *p4_v1.Entity_MeterEntry:
+		prefix = "meter"
+		key = &p4_v1.MeterEntry{MeterId: e.MeterEntry.MeterId, Index: e.MeterEntry.Index}
+	case *p4_v1.Entity_DirectCounterEntry:
+		prefix = "direct_counter"
+		key = tableEntryKey(e.DirectCounterEntry.TableEntry)
+	case *p4_v1.Entity_DirectMeterEntry:
+		prefix = "direct_meter"
+		key = tableEntryKey(e.DirectMeterEntry.TableEntry)
+	case *p4_v1.Entity_RegisterEntry:
+		prefix = "register"
+		key = &p4_v1.RegisterEntry{RegisterId: e.RegisterEntry.RegisterId, Index: e.RegisterEntry.Index}
+	case *p4_v1.Entity_DigestEntry:
+		prefix = "digest"
+		key = &p4_v1.DigestEntry{DigestId: e.DigestEntry.DigestId}
+	case *p4_v1.Entity_ValueSetEntry:
+		prefix = "value_set"
+		key = &p4_v1.ValueSetEntry{ValueSetId: e.ValueSetEntry.ValueSetId}
+	default:
+		return "", fmt.Errorf("unsupported entity type %T", e)
+	}
+	b, err := deterministicMarshal.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(prefix)
+	buf.WriteByte(':')
+	buf.Write(b)
+	return buf.String(), nil
+}
+
+func tableEntryKey(entry *p4_v1.TableEntry) *p4_v1.TableEntry {
+	return &p4_v1.TableEntry{
+		TableId:         entry.GetTableId(),
+		Match:           entry.GetMatch(),
+		Priority:        entry.GetPriority(),
+		IsDefaultAction: entry.GetIsDefaultAction(),
+	}
+}
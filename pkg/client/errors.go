@@ -0,0 +1,51 @@
+package client
+
+import (
+	"errors"
+
+	"google.golang.org/grpc/status"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// ErrOutsideRole is returned (wrapped) when the Client rejects a request
+// because it is not in the scope of its role, see RoleConfig.
+var ErrOutsideRole = errors.New("outside of the client role")
+
+// ErrNotPrimary is returned when the Client rejects a request which requires
+// it to be the primary client, see BackupPolicy.
+var ErrNotPrimary = errors.New("client is not primary")
+
+// ErrStaleEntity is returned (wrapped) when writing an entity which does not
+// match the current P4Info, typically because it was built before the P4Info
+// was changed, or when writing with a P4InfoView after the P4Info was changed.
+var ErrStaleEntity = errors.New("entity does not match the current P4Info")
+
+// ErrClosed is returned (possibly wrapped) when sending a stream message after
+// the stream was closed, or when using a Client after Close.
+var ErrClosed = errors.New("stream is closed")
+
+// WriteErrorDetails extracts the per-update errors from an error returned by
+// the Write RPC. As per the P4Runtime specification, when a batch fails, the
+// gRPC status includes one p4.v1.Error message for each update in the batch,
+// in the same order as the updates (with an OK canonical code for the updates
+// which succeeded). If err does not include this information, nil is returned.
+func WriteErrorDetails(err error) []*p4_v1.Error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil
+	}
+	details := st.Details()
+	if len(details) == 0 {
+		return nil
+	}
+	out := make([]*p4_v1.Error, 0, len(details))
+	for _, d := range details {
+		p4Error, ok := d.(*p4_v1.Error)
+		if !ok {
+			return nil
+		}
+		out = append(out, p4Error)
+	}
+	return out
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/util/conversion"
+)
+
+const (
+	defaultSubscriptionQueueCapacity = 256
+)
+
+// EventFilter selects the stream messages delivered to a Subscription. A
+// message is delivered if it matches all the non-empty fields. Names are
+// resolved with the P4Info of the Client when the message is received.
+type EventFilter struct {
+	// MessageTypes are the accepted message types (StreamMessagePacket,
+	// StreamMessageDigest, ...). All types, including arbitration updates, are
+	// accepted if empty.
+	MessageTypes []string
+	// DigestNames restricts the DigestLists to the given digests.
+	DigestNames []string
+	// PacketInMetadata restricts the PacketIns to the ones with the given
+	// metadata values, indexed by their name in the "packet_in" controller
+	// header of the P4Info (e.g. the ingress port). Values are compared as
+	// canonical bytestrings.
+	PacketInMetadata map[string][]byte
+	// IdleTimeoutTables restricts the IdleTimeoutNotifications to the ones
+	// which include at least one entry for one of the given tables.
+	IdleTimeoutTables []string
+	// Match, if not nil, is called for the messages which match all the other
+	// fields, and the message is delivered only if it returns true.
+	Match func(*p4_v1.StreamMessageResponse) bool
+}
+
+// SubscribeOptions configures a Subscription.
+type SubscribeOptions struct {
+	// Queue configures the queue of messages waiting to be consumed by the
+	// subscriber. OverflowBlock stops the processing of all stream messages
+	// while the queue is full. Fields which are not set are taken from
+	// DefaultSubscribeOptions.
+	Queue QueueOptions
+}
+
+var DefaultSubscribeOptions = SubscribeOptions{
+	Queue: QueueOptions{Capacity: defaultSubscriptionQueueCapacity, Overflow: OverflowDropOldest},
+}
+
+// Subscription receives the stream messages matching an EventFilter, see
+// Subscribe and SubscribeFunc.
+type Subscription struct {
+	c      *Client
+	filter EventFilter
+	queue  *messageQueue[*p4_v1.StreamMessageResponse]
+	// true for Subscriptions created with SubscribeFunc
+	callback bool
+	once     sync.Once
+}
+
+// C returns the channel on which messages are delivered. It returns nil for
+// Subscriptions created with SubscribeFunc. The channel is never closed.
+func (s *Subscription) C() <-chan *p4_v1.StreamMessageResponse {
+	if s.callback {
+		return nil
+	}
+	return s.queue.ch
+}
+
+// Stats returns the statistics of the Subscription's queue.
+func (s *Subscription) Stats() QueueStats {
+	return s.queue.stats()
+}
+
+// Unsubscribe stops the delivery of messages to the Subscription.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		s.c.subscriptionsMu.Lock()
+		delete(s.c.subscriptions, s)
+		s.c.subscriptionsMu.Unlock()
+		close(s.queue.abandonedCh)
+	})
+}
+
+// Subscribe registers a subscriber for the stream messages which match filter.
+// Any number of subscribers can be registered, and each one receives its own
+// copy of the matching messages on the channel returned by C, in addition to
+// the consumers provided to Run or Start. Dropped messages are only counted in
+// Stats.
+func (c *Client) Subscribe(filter EventFilter, options SubscribeOptions) *Subscription {
+	s := newSubscription(c, filter, options)
+	c.addSubscription(s)
+	return s
+}
+
+// SubscribeFunc is like Subscribe, but calls fn for each matching message,
+// from a goroutine dedicated to the Subscription, until Unsubscribe is called.
+func (c *Client) SubscribeFunc(filter EventFilter, options SubscribeOptions, fn func(*p4_v1.StreamMessageResponse)) *Subscription {
+	s := newSubscription(c, filter, options)
+	s.callback = true
+	go func() {
+		for {
+			select {
+			case msg := <-s.queue.ch:
+				fn(msg)
+			case <-s.queue.abandonedCh:
+				return
+			}
+		}
+	}()
+	c.addSubscription(s)
+	return s
+}
+
+func newSubscription(c *Client, filter EventFilter, options SubscribeOptions) *Subscription {
+	s := &Subscription{
+		c:      c,
+		filter: filter,
+		queue:  newMessageQueue[*p4_v1.StreamMessageResponse]("", options.Queue, DefaultSubscribeOptions.Queue, noopMetrics{}),
+	}
+	s.queue.abandonedCh = make(chan struct{})
+	return s
+}
+
+func (c *Client) addSubscription(s *Subscription) {
+	c.subscriptionsMu.Lock()
+	defer c.subscriptionsMu.Unlock()
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[*Subscription]bool)
+	}
+	c.subscriptions[s] = true
+}
+
+// publish delivers a stream message to the matching subscribers. It returns
+// false if ctx is done while blocking.
+func (c *Client) publish(ctx context.Context, msg *p4_v1.StreamMessageResponse) bool {
+	c.subscriptionsMu.Lock()
+	subscriptions := make([]*Subscription, 0, len(c.subscriptions))
+	for s := range c.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	c.subscriptionsMu.Unlock()
+	for _, s := range subscriptions {
+		if !c.matchEvent(&s.filter, msg) {
+			continue
+		}
+		if !s.queue.push(ctx, msg) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) matchEvent(filter *EventFilter, msg *p4_v1.StreamMessageResponse) bool {
+	if len(filter.MessageTypes) > 0 && !containsString(filter.MessageTypes, streamResponseType(msg)) {
+		return false
+	}
+	switch update := msg.Update.(type) {
+	case *p4_v1.StreamMessageResponse_Packet:
+		if !c.matchPacketIn(filter.PacketInMetadata, update.Packet) {
+			return false
+		}
+	case *p4_v1.StreamMessageResponse_Digest:
+		if len(filter.DigestNames) > 0 && !c.matchDigest(filter.DigestNames, update.Digest.DigestId) {
+			return false
+		}
+	case *p4_v1.StreamMessageResponse_IdleTimeoutNotification:
+		if len(filter.IdleTimeoutTables) > 0 && !c.matchIdleTimeout(filter.IdleTimeoutTables, update.IdleTimeoutNotification) {
+			return false
+		}
+	}
+	return filter.Match == nil || filter.Match(msg)
+}
+
+func (c *Client) matchPacketIn(metadata map[string][]byte, pkt *p4_v1.PacketIn) bool {
+	if len(metadata) == 0 {
+		return true
+	}
+	values := c.PacketInMetadata(pkt)
+	for name, expected := range metadata {
+		value, ok := values[name]
+		if !ok || !bytes.Equal(conversion.ToCanonicalBytestring(value), conversion.ToCanonicalBytestring(expected)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Client) matchDigest(names []string, digestID uint32) bool {
+	p4Info := c.currentP4Info()
+	for _, name := range names {
+		if id := p4Info.digestId(name); id != invalidID && id == digestID {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) matchIdleTimeout(tables []string, notification *p4_v1.IdleTimeoutNotification) bool {
+	p4Info := c.currentP4Info()
+	for _, name := range tables {
+		id := p4Info.tableId(name)
+		if id == invalidID {
+			continue
+		}
+		for _, entry := range notification.TableEntry {
+			if entry.TableId == id {
+				return true
+			}
+		}
+	}
+	return false
+}
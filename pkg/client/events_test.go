@@ -0,0 +1,188 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/fakeserver"
+)
+
+const (
+	eventsDigestID         = 1
+	eventsTableID          = 10
+	eventsIngressPortID    = 1
+	eventsOtherTableID     = 11
+	eventsSubscriptionWait = 5 * time.Second
+)
+
+func newEventsP4Info() *p4_config_v1.P4Info {
+	return &p4_config_v1.P4Info{
+		Tables: []*p4_config_v1.Table{
+			{Preamble: &p4_config_v1.Preamble{Name: "dmac", Id: eventsTableID}},
+			{Preamble: &p4_config_v1.Preamble{Name: "smac", Id: eventsOtherTableID}},
+		},
+		Digests: []*p4_config_v1.Digest{
+			{Preamble: &p4_config_v1.Preamble{Name: "learn", Id: eventsDigestID}},
+		},
+		ControllerPacketMetadata: []*p4_config_v1.ControllerPacketMetadata{
+			{
+				Preamble: &p4_config_v1.Preamble{Name: packetInMetadataName},
+				Metadata: []*p4_config_v1.ControllerPacketMetadata_Metadata{{Id: eventsIngressPortID, Name: "ingress_port", Bitwidth: 9}},
+			},
+		},
+	}
+}
+
+func packetInResponse(port byte) *p4_v1.StreamMessageResponse {
+	return &p4_v1.StreamMessageResponse{Update: &p4_v1.StreamMessageResponse_Packet{Packet: &p4_v1.PacketIn{
+		Payload:  []byte{port},
+		Metadata: []*p4_v1.PacketMetadata{{MetadataId: eventsIngressPortID, Value: []byte{port}}},
+	}}}
+}
+
+func TestMatchEvent(t *testing.T) {
+	c := newTestClient(&fakeP4RuntimeClient{}, newEventsP4Info())
+	digest := &p4_v1.StreamMessageResponse{Update: &p4_v1.StreamMessageResponse_Digest{Digest: &p4_v1.DigestList{DigestId: eventsDigestID}}}
+	idleTimeout := &p4_v1.StreamMessageResponse{Update: &p4_v1.StreamMessageResponse_IdleTimeoutNotification{IdleTimeoutNotification: &p4_v1.IdleTimeoutNotification{
+		TableEntry: []*p4_v1.TableEntry{{TableId: eventsOtherTableID}, {TableId: eventsTableID}},
+	}}}
+	arbitration := &p4_v1.StreamMessageResponse{Update: &p4_v1.StreamMessageResponse_Arbitration{Arbitration: &p4_v1.MasterArbitrationUpdate{}}}
+
+	testCases := []struct {
+		name     string
+		filter   EventFilter
+		msg      *p4_v1.StreamMessageResponse
+		expected bool
+	}{
+		{"empty filter", EventFilter{}, arbitration, true},
+		{"message type", EventFilter{MessageTypes: []string{StreamMessageDigest}}, digest, true},
+		{"other message type", EventFilter{MessageTypes: []string{StreamMessageDigest}}, packetInResponse(1), false},
+		{"digest name", EventFilter{DigestNames: []string{"learn"}}, digest, true},
+		{"unknown digest name", EventFilter{DigestNames: []string{"foo"}}, digest, false},
+		// the other fields only apply to the corresponding message type
+		{"digest name for packet", EventFilter{DigestNames: []string{"foo"}}, packetInResponse(1), true},
+		{"packet metadata", EventFilter{PacketInMetadata: map[string][]byte{"ingress_port": {0, 1}}}, packetInResponse(1), true},
+		{"other packet metadata", EventFilter{PacketInMetadata: map[string][]byte{"ingress_port": {2}}}, packetInResponse(1), false},
+		{"unknown packet metadata", EventFilter{PacketInMetadata: map[string][]byte{"foo": {1}}}, packetInResponse(1), false},
+		{"idle timeout table", EventFilter{IdleTimeoutTables: []string{"dmac"}}, idleTimeout, true},
+		{"other idle timeout table", EventFilter{IdleTimeoutTables: []string{"foo"}}, idleTimeout, false},
+		{"match func", EventFilter{Match: func(*p4_v1.StreamMessageResponse) bool { return false }}, arbitration, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, c.matchEvent(&tc.filter, tc.msg))
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx := context.Background()
+	p4Info := newEventsP4Info()
+	s := fakeserver.NewServer(1, p4Info)
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c := newFakeServerClient(t, s, 1, false)
+	defer c.Close()
+	c.SetP4Info(p4Info)
+
+	port1Sub := c.Subscribe(EventFilter{
+		MessageTypes:     []string{StreamMessagePacket},
+		PacketInMetadata: map[string][]byte{"ingress_port": {1}},
+	}, DefaultSubscribeOptions)
+	digestSub := c.Subscribe(EventFilter{DigestNames: []string{"learn"}, MessageTypes: []string{StreamMessageDigest}}, DefaultSubscribeOptions)
+	var mu sync.Mutex
+	var payloads []byte
+	packetSub := c.SubscribeFunc(EventFilter{MessageTypes: []string{StreamMessagePacket}}, DefaultSubscribeOptions, func(msg *p4_v1.StreamMessageResponse) {
+		mu.Lock()
+		defer mu.Unlock()
+		payloads = append(payloads, msg.GetPacket().Payload...)
+	})
+	assert.Nil(t, packetSub.C())
+
+	messageCh := make(chan *p4_v1.StreamMessageResponse, 10)
+	arbitrationCh := make(chan bool, 10)
+	require.NoError(t, c.Start(ctx, arbitrationCh, messageCh))
+	assert.True(t, waitPrimaryStatus(t, arbitrationCh))
+
+	for _, port := range []byte{1, 2, 1} {
+		require.NoError(t, s.SendPacketIn(packetInResponse(port).GetPacket()))
+	}
+	require.NoError(t, c.EnableDigest(ctx, "learn", nil))
+	listID, err := s.SendDigestList(eventsDigestID, nil)
+	require.NoError(t, err)
+
+	next := func(sub *Subscription) *p4_v1.StreamMessageResponse {
+		select {
+		case msg := <-sub.C():
+			return msg
+		case <-time.After(eventsSubscriptionWait):
+			require.FailNow(t, "timeout when waiting for subscription")
+		}
+		return nil
+	}
+	assert.Equal(t, []byte{1}, next(port1Sub).GetPacket().Payload)
+	assert.Equal(t, []byte{1}, next(port1Sub).GetPacket().Payload)
+	assert.Equal(t, listID, next(digestSub).GetDigest().ListId)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(payloads) == 3
+	}, eventsSubscriptionWait, 10*time.Millisecond)
+	assert.Equal(t, []byte{1, 2, 1}, payloads)
+	// the messages are still delivered on messageCh
+	require.Eventually(t, func() bool {
+		return len(messageCh) == 4
+	}, eventsSubscriptionWait, 10*time.Millisecond)
+
+	port1Sub.Unsubscribe()
+	port1Sub.Unsubscribe()
+	require.NoError(t, s.SendPacketIn(packetInResponse(1).GetPacket()))
+	require.Eventually(t, func() bool {
+		return len(messageCh) == 5
+	}, eventsSubscriptionWait, 10*time.Millisecond)
+	assert.Empty(t, port1Sub.C())
+	assert.Equal(t, QueueStats{Len: 0, Capacity: defaultSubscriptionQueueCapacity}, port1Sub.Stats())
+}
+
+func TestSubscribeZeroOptions(t *testing.T) {
+	c := newTestClient(&fakeP4RuntimeClient{}, nil)
+	sub := c.Subscribe(EventFilter{}, SubscribeOptions{})
+	defer sub.Unsubscribe()
+	ctx, cancel := context.WithTimeout(context.Background(), eventsSubscriptionWait)
+	defer cancel()
+	// the subscriber never consumes the messages, which must not block the
+	// stream
+	for i := 0; i < defaultSubscriptionQueueCapacity+1; i++ {
+		require.True(t, c.publish(ctx, packetInResponse(1)))
+	}
+	assert.Equal(t, QueueStats{Len: defaultSubscriptionQueueCapacity, Capacity: defaultSubscriptionQueueCapacity, Dropped: 1}, sub.Stats())
+}
+
+func TestSubscribeBlockUnsubscribe(t *testing.T) {
+	c := newTestClient(&fakeP4RuntimeClient{}, nil)
+	sub := c.Subscribe(EventFilter{}, SubscribeOptions{Queue: QueueOptions{Capacity: 1, Overflow: OverflowBlock}})
+	ctx := context.Background()
+	require.True(t, c.publish(ctx, packetInResponse(1)))
+
+	doneCh := make(chan bool)
+	go func() {
+		doneCh <- c.publish(ctx, packetInResponse(2))
+	}()
+	// an abandoned subscriber does not block the stream
+	sub.Unsubscribe()
+	select {
+	case ok := <-doneCh:
+		assert.True(t, ok)
+	case <-time.After(eventsSubscriptionWait):
+		require.FailNow(t, "publish is blocked")
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"os"
 
-	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
 
 	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
 	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
@@ -17,10 +17,24 @@ type FwdPipeConfig struct {
 	Cookie         uint64
 }
 
+// SetFwdPipeFromBytesWithAction pushes a new pipeline config to the target
+// using the provided action. The encoding of p4infoBytes (text, binary or JSON)
+// is detected automatically, see LoadP4Info.
 func (c *Client) SetFwdPipeFromBytesWithAction(ctx context.Context, binBytes, p4infoBytes []byte, cookie uint64, action p4_v1.SetForwardingPipelineConfigRequest_Action) (*FwdPipeConfig, error) {
-	p4Info := &p4_config_v1.P4Info{}
-	if err := prototext.Unmarshal(p4infoBytes, p4Info); err != nil {
-		return nil, fmt.Errorf("failed to decode P4Info Protobuf message: %v", err)
+	p4Info, err := LoadP4Info(p4infoBytes, P4InfoEncodingAuto)
+	if err != nil {
+		return nil, err
+	}
+	return c.SetFwdPipeFromP4InfoWithAction(ctx, binBytes, p4Info, cookie, action)
+}
+
+// SetFwdPipeFromP4InfoWithAction is like SetFwdPipeFromBytesWithAction, but
+// takes an already-decoded P4Info message.
+func (c *Client) SetFwdPipeFromP4InfoWithAction(ctx context.Context, binBytes []byte, p4Info *p4_config_v1.P4Info, cookie uint64, action p4_v1.SetForwardingPipelineConfigRequest_Action) (*FwdPipeConfig, error) {
+	if action != p4_v1.SetForwardingPipelineConfigRequest_VERIFY {
+		if err := c.checkRolePipeline(); err != nil {
+			return nil, err
+		}
 	}
 	config := &p4_v1.ForwardingPipelineConfig{
 		P4Info:         p4Info,
@@ -32,41 +46,113 @@ func (c *Client) SetFwdPipeFromBytesWithAction(ctx context.Context, binBytes, p4
 
 	req := &p4_v1.SetForwardingPipelineConfigRequest{
 		DeviceId:   c.deviceID,
-		ElectionId: c.electionID,
+		ElectionId: c.ElectionID(),
 		Action:     action,
 		Config:     config,
 	}
 	if c.role != nil {
 		req.Role = c.role.Name
 	}
-	_, err := c.SetForwardingPipelineConfig(ctx, req)
-	if err == nil {
-		c.p4Info = p4Info
-		return &FwdPipeConfig{
-			P4Info:         p4Info,
-			P4DeviceConfig: binBytes,
-			Cookie:         cookie,
-		}, nil
+	if err := c.asPrimary(ctx, func() error {
+		req.ElectionId = c.ElectionID()
+		_, err := c.SetForwardingPipelineConfig(ctx, req)
+		return err
+	}); err != nil {
+		return nil, err
 	}
 
-	return nil, err
+	c.pipelineMu.Lock()
+	switch action {
+	case p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_COMMIT:
+		c.swapP4Info(p4Info)
+		c.pendingP4Info = nil
+		// the forwarding state is cleared by the target
+		if c.cache != nil {
+			c.cache.Clear()
+		}
+	case p4_v1.SetForwardingPipelineConfigRequest_RECONCILE_AND_COMMIT:
+		c.swapP4Info(p4Info)
+		c.pendingP4Info = nil
+	case p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_SAVE:
+		// the saved config only takes effect on the next COMMIT, see
+		// CommitFwdPipe
+		c.pendingP4Info = p4Info
+	}
+	c.pipelineMu.Unlock()
+
+	return &FwdPipeConfig{
+		P4Info:         p4Info,
+		P4DeviceConfig: binBytes,
+		Cookie:         cookie,
+	}, nil
 }
 
 func (c *Client) SetFwdPipeFromBytes(ctx context.Context, binBytes, p4infoBytes []byte, cookie uint64) (*FwdPipeConfig, error) {
 	return c.SetFwdPipeFromBytesWithAction(ctx, binBytes, p4infoBytes, cookie, p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_COMMIT)
 }
 
+// SetFwdPipeFromP4Info is like SetFwdPipeFromBytes, but takes an
+// already-decoded P4Info message.
+func (c *Client) SetFwdPipeFromP4Info(ctx context.Context, binBytes []byte, p4Info *p4_config_v1.P4Info, cookie uint64) (*FwdPipeConfig, error) {
+	return c.SetFwdPipeFromP4InfoWithAction(ctx, binBytes, p4Info, cookie, p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_COMMIT)
+}
+
+// VerifyFwdPipeFromBytes asks the target to validate the provided pipeline
+// config, without saving or applying it. The P4Info used by the Client is left
+// unchanged.
+func (c *Client) VerifyFwdPipeFromBytes(ctx context.Context, binBytes, p4infoBytes []byte, cookie uint64) (*FwdPipeConfig, error) {
+	return c.SetFwdPipeFromBytesWithAction(ctx, binBytes, p4infoBytes, cookie, p4_v1.SetForwardingPipelineConfigRequest_VERIFY)
+}
+
+// ReconcileAndCommitFwdPipeFromBytes applies the provided pipeline config
+// while asking the target to preserve the forwarding state (i.e. the entities
+// which are still valid with the new config). On success, the Client switches
+// to the new P4Info.
+func (c *Client) ReconcileAndCommitFwdPipeFromBytes(ctx context.Context, binBytes, p4infoBytes []byte, cookie uint64) (*FwdPipeConfig, error) {
+	return c.SetFwdPipeFromBytesWithAction(ctx, binBytes, p4infoBytes, cookie, p4_v1.SetForwardingPipelineConfigRequest_RECONCILE_AND_COMMIT)
+}
+
+// SaveFwdPipeFromBytes verifies and saves the provided pipeline config in the
+// target, without applying it. The P4Info is recorded as pending by the Client
+// and will only be used once CommitFwdPipe succeeds.
 func (c *Client) SaveFwdPipeFromBytes(ctx context.Context, binBytes, p4infoBytes []byte, cookie uint64) (*FwdPipeConfig, error) {
 	return c.SetFwdPipeFromBytesWithAction(ctx, binBytes, p4infoBytes, cookie, p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_SAVE)
 }
 
+// CommitFwdPipe applies the pipeline config previously saved with
+// SaveFwdPipeFromBytes. If the commit succeeds, the Client switches to the
+// P4Info which was saved.
 func (c *Client) CommitFwdPipe(ctx context.Context) (*p4_v1.SetForwardingPipelineConfigResponse, error) {
+	if err := c.checkRolePipeline(); err != nil {
+		return nil, err
+	}
 	req := &p4_v1.SetForwardingPipelineConfigRequest{
 		DeviceId:   c.deviceID,
-		ElectionId: c.electionID,
+		ElectionId: c.ElectionID(),
 		Action:     p4_v1.SetForwardingPipelineConfigRequest_COMMIT,
 	}
-	return c.SetForwardingPipelineConfig(ctx, req)
+	if c.role != nil {
+		req.Role = c.role.Name
+	}
+	var resp *p4_v1.SetForwardingPipelineConfigResponse
+	if err := c.asPrimary(ctx, func() error {
+		req.ElectionId = c.ElectionID()
+		var err error
+		resp, err = c.SetForwardingPipelineConfig(ctx, req)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	c.pipelineMu.Lock()
+	if c.pendingP4Info != nil {
+		c.swapP4Info(c.pendingP4Info)
+		c.pendingP4Info = nil
+	}
+	c.pipelineMu.Unlock()
+	if c.cache != nil {
+		c.cache.Clear()
+	}
+	return resp, nil
 }
 
 func (c *Client) SetFwdPipe(ctx context.Context, binPath string, p4infoPath string, cookie uint64) (*FwdPipeConfig, error) {
@@ -76,11 +162,29 @@ func (c *Client) SetFwdPipe(ctx context.Context, binPath string, p4infoPath stri
 	}
 	p4infoBytes, err := os.ReadFile(p4infoPath)
 	if err != nil {
-		return nil, fmt.Errorf("error when reading P4Info text file: %v", err)
+		return nil, fmt.Errorf("error when reading P4Info file: %v", err)
 	}
 	return c.SetFwdPipeFromBytes(ctx, binBytes, p4infoBytes, cookie)
 }
 
+// HasPendingFwdPipe returns true if a pipeline config was saved with
+// SaveFwdPipeFromBytes but has not been committed yet.
+func (c *Client) HasPendingFwdPipe() bool {
+	c.pipelineMu.Lock()
+	defer c.pipelineMu.Unlock()
+	return c.pendingP4Info != nil
+}
+
+// DiscardPendingFwdPipe forgets the pipeline config saved with
+// SaveFwdPipeFromBytes, which will not be committed, so that a later
+// CommitFwdPipe does not switch the Client to its P4Info. The config remains
+// saved in the target until it is replaced.
+func (c *Client) DiscardPendingFwdPipe() {
+	c.pipelineMu.Lock()
+	defer c.pipelineMu.Unlock()
+	c.pendingP4Info = nil
+}
+
 type GetFwdPipeResponseType int32
 
 const (
@@ -124,7 +228,11 @@ func (c *Client) GetFwdPipe(ctx context.Context, responseType GetFwdPipeResponse
 
 	// save P4info for later use
 	if pipeConfig.P4Info != nil {
-		c.p4Info = pipeConfig.P4Info
+		c.pipelineMu.Lock()
+		if !proto.Equal(pipeConfig.P4Info, c.P4Info()) {
+			c.swapP4Info(pipeConfig.P4Info)
+		}
+		c.pipelineMu.Unlock()
 	}
 
 	return pipeConfig, nil
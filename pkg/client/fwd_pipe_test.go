@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+func newTestP4Info(tableName string) *p4_config_v1.P4Info {
+	return &p4_config_v1.P4Info{
+		Tables: []*p4_config_v1.Table{
+			{
+				Preamble: &p4_config_v1.Preamble{
+					Name: tableName,
+					Id:   1,
+				},
+			},
+		},
+	}
+}
+
+func TestFwdPipeActions(t *testing.T) {
+	oldP4Info := newTestP4Info("old")
+	newP4Info := newTestP4Info("new")
+	newP4InfoBytes, err := prototext.Marshal(newP4Info)
+	require.NoError(t, err)
+
+	var actions []p4_v1.SetForwardingPipelineConfigRequest_Action
+	var failCommit bool
+	p4RtClient := &fakeP4RuntimeClient{
+		setForwardingPipelineConfigFn: func(ctx context.Context, in *p4_v1.SetForwardingPipelineConfigRequest, opts ...grpc.CallOption) (*p4_v1.SetForwardingPipelineConfigResponse, error) {
+			actions = append(actions, in.Action)
+			if in.Action == p4_v1.SetForwardingPipelineConfigRequest_COMMIT && failCommit {
+				return nil, fmt.Errorf("commit failed")
+			}
+			return &p4_v1.SetForwardingPipelineConfigResponse{}, nil
+		},
+	}
+	ctx := context.Background()
+
+	t.Run("verify", func(t *testing.T) {
+		c := newTestClient(p4RtClient, oldP4Info)
+		_, err := c.VerifyFwdPipeFromBytes(ctx, nil, newP4InfoBytes, 0)
+		require.NoError(t, err)
+		assert.Equal(t, "old", c.P4Info().Tables[0].Preamble.Name)
+		assert.False(t, c.HasPendingFwdPipe())
+	})
+
+	t.Run("reconcile and commit", func(t *testing.T) {
+		c := newTestClient(p4RtClient, oldP4Info)
+		_, err := c.ReconcileAndCommitFwdPipeFromBytes(ctx, nil, newP4InfoBytes, 0)
+		require.NoError(t, err)
+		assert.Equal(t, "new", c.P4Info().Tables[0].Preamble.Name)
+	})
+
+	t.Run("save and commit", func(t *testing.T) {
+		c := newTestClient(p4RtClient, oldP4Info)
+		_, err := c.SaveFwdPipeFromBytes(ctx, nil, newP4InfoBytes, 0)
+		require.NoError(t, err)
+		assert.Equal(t, "old", c.P4Info().Tables[0].Preamble.Name)
+		assert.True(t, c.HasPendingFwdPipe())
+		_, err = c.CommitFwdPipe(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "new", c.P4Info().Tables[0].Preamble.Name)
+		assert.False(t, c.HasPendingFwdPipe())
+	})
+
+	t.Run("save and failed commit", func(t *testing.T) {
+		failCommit = true
+		defer func() { failCommit = false }()
+		c := newTestClient(p4RtClient, oldP4Info)
+		_, err := c.SaveFwdPipeFromBytes(ctx, nil, newP4InfoBytes, 0)
+		require.NoError(t, err)
+		_, err = c.CommitFwdPipe(ctx)
+		assert.Error(t, err)
+		assert.Equal(t, "old", c.P4Info().Tables[0].Preamble.Name)
+		assert.True(t, c.HasPendingFwdPipe())
+	})
+
+	assert.Equal(t, []p4_v1.SetForwardingPipelineConfigRequest_Action{
+		p4_v1.SetForwardingPipelineConfigRequest_VERIFY,
+		p4_v1.SetForwardingPipelineConfigRequest_RECONCILE_AND_COMMIT,
+		p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_SAVE,
+		p4_v1.SetForwardingPipelineConfigRequest_COMMIT,
+		p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_SAVE,
+		p4_v1.SetForwardingPipelineConfigRequest_COMMIT,
+	}, actions)
+}
@@ -13,7 +13,7 @@ const (
 )
 
 func (c *Client) ReadMeterEntry(ctx context.Context, meter string, index int64) (*p4_v1.MeterConfig, error) {
-	meterID := c.meterId(meter)
+	meterID := c.currentP4Info().meterId(meter)
 	if meterID == invalidID {
 		return nil, fmt.Errorf("meter %s not found", meter)
 	}
@@ -35,7 +35,7 @@ func (c *Client) ReadMeterEntry(ctx context.Context, meter string, index int64)
 }
 
 func (c *Client) ReadMeterEntryWildcard(ctx context.Context, meter string) ([]*p4_v1.MeterEntry, error) {
-	p4Meter := c.findMeter(meter)
+	p4Meter := c.currentP4Info().findMeter(meter)
 	if p4Meter == nil {
 		return nil, fmt.Errorf("meter %s not found", meter)
 	}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// Entity types used in EntityLabels.
+const (
+	EntityTypeTableEntry          = "table_entry"
+	EntityTypeActionProfileMember = "action_profile_member"
+	EntityTypeActionProfileGroup  = "action_profile_group"
+	EntityTypeMulticastGroup      = "multicast_group"
+	EntityTypeCloneSession        = "clone_session"
+	EntityTypeCounterEntry        = "counter_entry"
+	EntityTypeDirectCounterEntry  = "direct_counter_entry"
+	EntityTypeMeterEntry          = "meter_entry"
+	EntityTypeDirectMeterEntry    = "direct_meter_entry"
+	EntityTypeDigestEntry         = "digest_entry"
+	EntityTypeOther               = "other"
+)
+
+// Stream message types reported to Metrics.
+const (
+	StreamMessageArbitration             = "arbitration"
+	StreamMessagePacket                  = "packet"
+	StreamMessageDigest                  = "digest"
+	StreamMessageDigestAck               = "digest_ack"
+	StreamMessageIdleTimeoutNotification = "idle_timeout_notification"
+	StreamMessageError                   = "error"
+	StreamMessageOther                   = "other"
+)
+
+// EntityLabels identifies the entities of a Write or Read RPC for metrics.
+type EntityLabels struct {
+	EntityType string
+	// Table is the name of the table for table entries and direct
+	// resources, and is empty for other entity types. It is the table id if
+	// the table cannot be found in the P4Info.
+	Table string
+}
+
+// Metrics receives measurements from the Client. Implementations must be safe
+// for concurrent use and must not block. A Prometheus implementation is
+// provided by the prommetrics package.
+type Metrics interface {
+	// ObserveWrite is called after each Write RPC, once for each distinct
+	// EntityLabels in the batch. code is OK if all the corresponding updates
+	// succeeded, and the code of the first failed update otherwise.
+	ObserveWrite(labels EntityLabels, code codes.Code, latency time.Duration)
+	// ObserveRead is called after each Read RPC, once for each distinct
+	// EntityLabels in the request.
+	ObserveRead(labels EntityLabels, code codes.Code, latency time.Duration)
+	// StreamMessageSent is called for each message sent on the stream.
+	StreamMessageSent(msgType string)
+	// StreamMessageReceived is called for each message received on the
+	// stream.
+	StreamMessageReceived(msgType string)
+	// StreamSendQueueDepth reports the number of messages waiting to be sent
+	// on the stream.
+	StreamSendQueueDepth(depth int)
+	// StreamSendDropped is called when a message could not be queued for
+	// sending before the caller's context expired, or when a queued message
+	// is dropped because the stream terminated before it was sent.
+	StreamSendDropped()
+	// StreamMessageDropped is called when a received stream message is
+	// dropped because its queue is full, see WithStreamQueues.
+	StreamMessageDropped(msgType string)
+	// ArbitrationTransition is called when the client becomes primary or
+	// backup.
+	ArbitrationTransition(isPrimary bool)
+	// StreamReconnect is called when Run or Start is called again to
+	// re-establish the stream.
+	StreamReconnect()
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveWrite(EntityLabels, codes.Code, time.Duration) {}
+func (noopMetrics) ObserveRead(EntityLabels, codes.Code, time.Duration)  {}
+func (noopMetrics) StreamMessageSent(string)                             {}
+func (noopMetrics) StreamMessageReceived(string)                         {}
+func (noopMetrics) StreamSendQueueDepth(int)                             {}
+func (noopMetrics) StreamSendDropped()                                   {}
+func (noopMetrics) StreamMessageDropped(string)                          {}
+func (noopMetrics) ArbitrationTransition(bool)                           {}
+func (noopMetrics) StreamReconnect()                                     {}
+
+// WithMetrics returns a ClientOptions modifier which sets the Metrics
+// implementation.
+func WithMetrics(metrics Metrics) func(*ClientOptions) {
+	return func(options *ClientOptions) {
+		options.Metrics = metrics
+	}
+}
+
+func (c *Client) tableLabel(tableID uint32) string {
+	if table := c.currentP4Info().findTableByID(tableID); table != nil {
+		return table.Preamble.Name
+	}
+	return strconv.FormatUint(uint64(tableID), 10)
+}
+
+func (c *Client) entityLabels(entity *p4_v1.Entity) EntityLabels {
+	switch e := entity.GetEntity().(type) {
+	case *p4_v1.Entity_TableEntry:
+		return EntityLabels{EntityType: EntityTypeTableEntry, Table: c.tableLabel(e.TableEntry.TableId)}
+	case *p4_v1.Entity_ActionProfileMember:
+		return EntityLabels{EntityType: EntityTypeActionProfileMember}
+	case *p4_v1.Entity_ActionProfileGroup:
+		return EntityLabels{EntityType: EntityTypeActionProfileGroup}
+	case *p4_v1.Entity_PacketReplicationEngineEntry:
+		if e.PacketReplicationEngineEntry.GetCloneSessionEntry() != nil {
+			return EntityLabels{EntityType: EntityTypeCloneSession}
+		}
+		return EntityLabels{EntityType: EntityTypeMulticastGroup}
+	case *p4_v1.Entity_CounterEntry:
+		return EntityLabels{EntityType: EntityTypeCounterEntry}
+	case *p4_v1.Entity_DirectCounterEntry:
+		return EntityLabels{EntityType: EntityTypeDirectCounterEntry, Table: c.tableLabel(e.DirectCounterEntry.GetTableEntry().GetTableId())}
+	case *p4_v1.Entity_MeterEntry:
+		return EntityLabels{EntityType: EntityTypeMeterEntry}
+	case *p4_v1.Entity_DirectMeterEntry:
+		return EntityLabels{EntityType: EntityTypeDirectMeterEntry, Table: c.tableLabel(e.DirectMeterEntry.GetTableEntry().GetTableId())}
+	case *p4_v1.Entity_DigestEntry:
+		return EntityLabels{EntityType: EntityTypeDigestEntry}
+	}
+	return EntityLabels{EntityType: EntityTypeOther}
+}
+
+// observeWrite reports a Write RPC to Metrics.
+func (c *Client) observeWrite(updates []*p4_v1.Update, start time.Time, err error) {
+	latency := time.Since(start)
+	rpcCode := status.Code(err)
+	details := WriteErrorDetails(err)
+	if len(details) != len(updates) {
+		details = nil
+	}
+	var labels []EntityLabels
+	codesByLabels := make(map[EntityLabels]codes.Code)
+	for idx, update := range updates {
+		l := c.entityLabels(update.Entity)
+		updateCode := rpcCode
+		if details != nil {
+			updateCode = codes.Code(details[idx].CanonicalCode)
+		}
+		code, ok := codesByLabels[l]
+		if !ok {
+			labels = append(labels, l)
+		}
+		if !ok || code == codes.OK {
+			codesByLabels[l] = updateCode
+		}
+	}
+	for _, l := range labels {
+		c.Metrics.ObserveWrite(l, codesByLabels[l], latency)
+	}
+}
+
+// observeRead reports a Read RPC to Metrics.
+func (c *Client) observeRead(entities []*p4_v1.Entity, start time.Time, err error) {
+	latency := time.Since(start)
+	code := status.Code(err)
+	seen := make(map[EntityLabels]bool)
+	for _, entity := range entities {
+		l := c.entityLabels(entity)
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+		c.Metrics.ObserveRead(l, code, latency)
+	}
+}
+
+func streamRequestType(msg *p4_v1.StreamMessageRequest) string {
+	switch msg.Update.(type) {
+	case *p4_v1.StreamMessageRequest_Arbitration:
+		return StreamMessageArbitration
+	case *p4_v1.StreamMessageRequest_Packet:
+		return StreamMessagePacket
+	case *p4_v1.StreamMessageRequest_DigestAck:
+		return StreamMessageDigestAck
+	}
+	return StreamMessageOther
+}
+
+func streamResponseType(msg *p4_v1.StreamMessageResponse) string {
+	switch msg.Update.(type) {
+	case *p4_v1.StreamMessageResponse_Arbitration:
+		return StreamMessageArbitration
+	case *p4_v1.StreamMessageResponse_Packet:
+		return StreamMessagePacket
+	case *p4_v1.StreamMessageResponse_Digest:
+		return StreamMessageDigest
+	case *p4_v1.StreamMessageResponse_IdleTimeoutNotification:
+		return StreamMessageIdleTimeoutNotification
+	case *p4_v1.StreamMessageResponse_Error:
+		return StreamMessageError
+	}
+	return StreamMessageOther
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+type rpcObservation struct {
+	labels EntityLabels
+	code   codes.Code
+}
+
+type fakeMetrics struct {
+	noopMetrics
+	mu      sync.Mutex
+	writes  []rpcObservation
+	reads   []rpcObservation
+	depths  []int
+	dropped int
+	// dropped received messages, by message type
+	droppedMessages map[string]int
+}
+
+func (m *fakeMetrics) ObserveWrite(labels EntityLabels, code codes.Code, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writes = append(m.writes, rpcObservation{labels, code})
+}
+
+func (m *fakeMetrics) ObserveRead(labels EntityLabels, code codes.Code, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reads = append(m.reads, rpcObservation{labels, code})
+}
+
+func (m *fakeMetrics) StreamSendQueueDepth(depth int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.depths = append(m.depths, depth)
+}
+
+func (m *fakeMetrics) StreamSendDropped() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropped++
+}
+
+func (m *fakeMetrics) StreamMessageDropped(msgType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.droppedMessages == nil {
+		m.droppedMessages = make(map[string]int)
+	}
+	m.droppedMessages[msgType]++
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	var writeErr, readErr error
+	p4RtClient := &fakeP4RuntimeClient{
+		writeFn: func(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+			return &p4_v1.WriteResponse{}, writeErr
+		},
+		readFn: func(ctx context.Context, in *p4_v1.ReadRequest, opts ...grpc.CallOption) (p4_v1.P4Runtime_ReadClient, error) {
+			return &fakeP4RuntimeReadClient{
+				recvFn: func() (*p4_v1.ReadResponse, error) {
+					if readErr != nil {
+						return nil, readErr
+					}
+					return nil, io.EOF
+				},
+			}, nil
+		},
+	}
+	metrics := &fakeMetrics{}
+	c := newTestClient(p4RtClient, newReconcileTestP4Info())
+	c.Metrics = metrics
+
+	entry := func(key byte) *p4_v1.Entity {
+		return tableEntryToEntity(c.NewTableEntry(
+			"t",
+			map[string]MatchInterface{"f": &ExactMatch{Value: []byte{key}}},
+			c.NewTableActionDirect("a", [][]byte{{1}}),
+			nil,
+		))
+	}
+	member := actionProfileMemberToEntity(c.NewActionProfileMember("ap", 1, "a", [][]byte{{1}}))
+	tableLabels := EntityLabels{EntityType: EntityTypeTableEntry, Table: "t"}
+	memberLabels := EntityLabels{EntityType: EntityTypeActionProfileMember}
+
+	// one observation for each distinct entity type and table in the batch
+	require.NoError(t, c.WriteUpdates(ctx, []*p4_v1.Update{
+		{Type: p4_v1.Update_INSERT, Entity: entry(1)},
+		{Type: p4_v1.Update_INSERT, Entity: entry(2)},
+		{Type: p4_v1.Update_INSERT, Entity: member},
+	}))
+	assert.Equal(t, []rpcObservation{{tableLabels, codes.OK}, {memberLabels, codes.OK}}, metrics.writes)
+
+	// per-update error codes are used when available
+	metrics.writes = nil
+	st, err := status.New(codes.Unknown, "batch failed").WithDetails(
+		&p4_v1.Error{CanonicalCode: int32(code.Code_OK)},
+		&p4_v1.Error{CanonicalCode: int32(code.Code_ALREADY_EXISTS)},
+		&p4_v1.Error{CanonicalCode: int32(code.Code_OK)},
+	)
+	require.NoError(t, err)
+	writeErr = st.Err()
+	require.Error(t, c.WriteUpdates(ctx, []*p4_v1.Update{
+		{Type: p4_v1.Update_INSERT, Entity: entry(1)},
+		{Type: p4_v1.Update_INSERT, Entity: entry(2)},
+		{Type: p4_v1.Update_INSERT, Entity: member},
+	}))
+	assert.Equal(t, []rpcObservation{{tableLabels, codes.AlreadyExists}, {memberLabels, codes.OK}}, metrics.writes)
+
+	// otherwise the RPC status code is used for all updates
+	metrics.writes = nil
+	writeErr = status.Error(codes.Unavailable, "unavailable")
+	require.Error(t, c.WriteUpdates(ctx, []*p4_v1.Update{{Type: p4_v1.Update_INSERT, Entity: member}}))
+	assert.Equal(t, []rpcObservation{{memberLabels, codes.Unavailable}}, metrics.writes)
+
+	_, err = c.ReadTableEntryWildcard(ctx, "t")
+	require.NoError(t, err)
+	readErr = status.Error(codes.PermissionDenied, "denied")
+	_, err = c.ReadTableEntryWildcard(ctx, "t")
+	require.Error(t, err)
+	assert.Equal(t, []rpcObservation{{tableLabels, codes.OK}, {tableLabels, codes.PermissionDenied}}, metrics.reads)
+}
+
+func TestMetricsStreamSendQueue(t *testing.T) {
+	metrics := &fakeMetrics{}
+	c := newTestClient(&fakeP4RuntimeClient{}, nil)
+	c.Metrics = metrics
+	c.streamSendCh = make(chan *queuedMessage, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.NoError(t, c.SendPacketOut(ctx, &p4_v1.PacketOut{}))
+	assert.ErrorIs(t, c.SendPacketOut(ctx, &p4_v1.PacketOut{}), context.DeadlineExceeded)
+	assert.Equal(t, []int{1}, metrics.depths)
+	assert.Equal(t, 1, metrics.dropped)
+}
@@ -1,16 +1,172 @@
 package client
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+
 	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
 )
 
 const invalidID = 0
 
-func (c *Client) tableId(name string) uint32 {
-	if c.p4Info == nil {
+// P4InfoEncoding is the serialization format of a P4Info message.
+type P4InfoEncoding int
+
+const (
+	// P4InfoEncodingAuto detects the encoding from the contents: JSON if the
+	// first non-whitespace character is '{' and the contents are valid JSON,
+	// otherwise text if the contents are valid prototext, and binary Protobuf
+	// as a last resort.
+	P4InfoEncodingAuto P4InfoEncoding = iota
+	P4InfoEncodingText
+	P4InfoEncodingBinary
+	P4InfoEncodingJSON
+)
+
+func (e P4InfoEncoding) String() string {
+	switch e {
+	case P4InfoEncodingAuto:
+		return "auto"
+	case P4InfoEncodingText:
+		return "text"
+	case P4InfoEncodingBinary:
+		return "binary"
+	case P4InfoEncodingJSON:
+		return "json"
+	}
+	return fmt.Sprintf("P4InfoEncoding(%d)", int(e))
+}
+
+// LoadP4Info decodes a P4Info message serialized with the provided encoding.
+func LoadP4Info(p4infoBytes []byte, encoding P4InfoEncoding) (*p4_config_v1.P4Info, error) {
+	p4Info := &p4_config_v1.P4Info{}
+	var err error
+	switch encoding {
+	case P4InfoEncodingText:
+		err = prototext.Unmarshal(p4infoBytes, p4Info)
+	case P4InfoEncodingBinary:
+		err = proto.Unmarshal(p4infoBytes, p4Info)
+	case P4InfoEncodingJSON:
+		err = protojson.Unmarshal(p4infoBytes, p4Info)
+	case P4InfoEncodingAuto:
+		encodings := []P4InfoEncoding{P4InfoEncodingText, P4InfoEncodingBinary}
+		if trimmed := bytes.TrimSpace(p4infoBytes); len(trimmed) > 0 && trimmed[0] == '{' {
+			// binary messages can also start with '{' once leading bytes
+			// which happen to be whitespace are trimmed
+			encodings = append([]P4InfoEncoding{P4InfoEncodingJSON}, encodings...)
+		}
+		var firstErr error
+		for _, e := range encodings {
+			decoded, decodeErr := LoadP4Info(p4infoBytes, e)
+			if decodeErr == nil {
+				return decoded, nil
+			}
+			// report the error for the most likely encoding
+			if firstErr == nil {
+				firstErr = decodeErr
+			}
+		}
+		return nil, firstErr
+	default:
+		return nil, fmt.Errorf("unknown P4Info encoding: %v", encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode P4Info Protobuf message (%v encoding): %v", encoding, err)
+	}
+	return p4Info, nil
+}
+
+// MarshalP4Info serializes a P4Info message with the provided encoding. Text
+// and JSON output is indented; P4InfoEncodingAuto is not a valid encoding.
+func MarshalP4Info(p4Info *p4_config_v1.P4Info, encoding P4InfoEncoding) ([]byte, error) {
+	switch encoding {
+	case P4InfoEncodingText:
+		return prototext.MarshalOptions{Multiline: true}.Marshal(p4Info)
+	case P4InfoEncodingBinary:
+		return proto.Marshal(p4Info)
+	case P4InfoEncodingJSON:
+		return protojson.MarshalOptions{Multiline: true}.Marshal(p4Info)
+	}
+	return nil, fmt.Errorf("invalid P4Info encoding for serialization: %v", encoding)
+}
+
+// LoadP4InfoFile reads the file at p4infoPath and decodes it as a P4Info
+// message serialized with the provided encoding.
+func LoadP4InfoFile(p4infoPath string, encoding P4InfoEncoding) (*p4_config_v1.P4Info, error) {
+	p4infoBytes, err := os.ReadFile(p4infoPath)
+	if err != nil {
+		return nil, fmt.Errorf("error when reading P4Info file: %v", err)
+	}
+	return LoadP4Info(p4infoBytes, encoding)
+}
+
+// p4InfoSnapshot is a version of the P4Info used by the Client. It is never
+// modified once published: a new snapshot is swapped in when the P4Info
+// changes, so that a builder which loads the snapshot once resolves all the
+// names against the same P4Info.
+type p4InfoSnapshot struct {
+	// nil if no P4Info has been set yet
+	p4Info *p4_config_v1.P4Info
+	// incremented each time the P4Info is changed, 0 if no P4Info has been
+	// set yet
+	version uint64
+	// built on demand to validate written entities, see checkStale
+	indexOnce sync.Once
+	index     *p4InfoIndex
+}
+
+var emptyP4InfoSnapshot = &p4InfoSnapshot{}
+
+// currentP4Info returns the current P4Info snapshot, which is never nil.
+func (c *Client) currentP4Info() *p4InfoSnapshot {
+	if p := c.p4InfoState.Load(); p != nil {
+		return p
+	}
+	return emptyP4InfoSnapshot
+}
+
+// swapP4Info makes p4Info the current P4Info, with a new version. The caller
+// must hold pipelineMu.
+func (c *Client) swapP4Info(p4Info *p4_config_v1.P4Info) {
+	c.p4InfoState.Store(&p4InfoSnapshot{
+		p4Info:  p4Info,
+		version: c.currentP4Info().version + 1,
+	})
+}
+
+// P4Info returns the P4Info currently used by the Client to resolve names, or
+// nil if none has been set yet. The returned message must not be modified.
+func (c *Client) P4Info() *p4_config_v1.P4Info {
+	return c.currentP4Info().p4Info
+}
+
+// P4InfoVersion returns the version of the P4Info currently used by the
+// Client, which is incremented each time the P4Info changes (e.g. after a
+// successful SetFwdPipe). It is 0 if no P4Info has been set yet.
+func (c *Client) P4InfoVersion() uint64 {
+	return c.currentP4Info().version
+}
+
+// SetP4Info sets the P4Info used by the Client to resolve names, without
+// pushing a pipeline config to the target. This is useful when connecting to a
+// target which has already been configured, e.g. by another controller.
+func (c *Client) SetP4Info(p4Info *p4_config_v1.P4Info) {
+	c.pipelineMu.Lock()
+	defer c.pipelineMu.Unlock()
+	c.swapP4Info(p4Info)
+}
+
+func (p *p4InfoSnapshot) tableId(name string) uint32 {
+	if p.p4Info == nil {
 		return invalidID
 	}
-	for _, table := range c.p4Info.Tables {
+	for _, table := range p.p4Info.Tables {
 		if table.Preamble.Name == name {
 			return table.Preamble.Id
 		}
@@ -18,11 +174,11 @@ func (c *Client) tableId(name string) uint32 {
 	return invalidID
 }
 
-func (c *Client) findTable(name string) *p4_config_v1.Table {
-	if c.p4Info == nil {
+func (p *p4InfoSnapshot) findTable(name string) *p4_config_v1.Table {
+	if p.p4Info == nil {
 		return nil
 	}
-	for _, table := range c.p4Info.Tables {
+	for _, table := range p.p4Info.Tables {
 		if table.Preamble.Name == name {
 			return table
 		}
@@ -30,11 +186,11 @@ func (c *Client) findTable(name string) *p4_config_v1.Table {
 	return nil
 }
 
-func (c *Client) matchFieldId(tableName, fieldName string) uint32 {
-	if c.p4Info == nil {
+func (p *p4InfoSnapshot) matchFieldId(tableName, fieldName string) uint32 {
+	if p.p4Info == nil {
 		return invalidID
 	}
-	table := c.findTable(tableName)
+	table := p.findTable(tableName)
 	if table == nil {
 		return invalidID
 	}
@@ -46,11 +202,11 @@ func (c *Client) matchFieldId(tableName, fieldName string) uint32 {
 	return invalidID
 }
 
-func (c *Client) actionId(name string) uint32 {
-	if c.p4Info == nil {
+func (p *p4InfoSnapshot) actionId(name string) uint32 {
+	if p.p4Info == nil {
 		return invalidID
 	}
-	for _, action := range c.p4Info.Actions {
+	for _, action := range p.p4Info.Actions {
 		if action.Preamble.Name == name {
 			return action.Preamble.Id
 		}
@@ -58,11 +214,11 @@ func (c *Client) actionId(name string) uint32 {
 	return invalidID
 }
 
-func (c *Client) actionProfileId(name string) uint32 {
-	if c.p4Info == nil {
+func (p *p4InfoSnapshot) actionProfileId(name string) uint32 {
+	if p.p4Info == nil {
 		return invalidID
 	}
-	for _, actionProfile := range c.p4Info.ActionProfiles {
+	for _, actionProfile := range p.p4Info.ActionProfiles {
 		if actionProfile.Preamble.Name == name {
 			return actionProfile.Preamble.Id
 		}
@@ -70,11 +226,11 @@ func (c *Client) actionProfileId(name string) uint32 {
 	return invalidID
 }
 
-func (c *Client) digestId(name string) uint32 {
-	if c.p4Info == nil {
+func (p *p4InfoSnapshot) digestId(name string) uint32 {
+	if p.p4Info == nil {
 		return invalidID
 	}
-	for _, digest := range c.p4Info.Digests {
+	for _, digest := range p.p4Info.Digests {
 		if digest.Preamble.Name == name {
 			return digest.Preamble.Id
 		}
@@ -82,11 +238,11 @@ func (c *Client) digestId(name string) uint32 {
 	return invalidID
 }
 
-func (c *Client) findCounter(name string) *p4_config_v1.Counter {
-	if c.p4Info == nil {
+func (p *p4InfoSnapshot) findCounter(name string) *p4_config_v1.Counter {
+	if p.p4Info == nil {
 		return nil
 	}
-	for _, counter := range c.p4Info.Counters {
+	for _, counter := range p.p4Info.Counters {
 		if counter.Preamble.Name == name {
 			return counter
 		}
@@ -94,19 +250,19 @@ func (c *Client) findCounter(name string) *p4_config_v1.Counter {
 	return nil
 }
 
-func (c *Client) counterId(name string) uint32 {
-	counter := c.findCounter(name)
+func (p *p4InfoSnapshot) counterId(name string) uint32 {
+	counter := p.findCounter(name)
 	if counter == nil {
 		return invalidID
 	}
 	return counter.Preamble.Id
 }
 
-func (c *Client) findMeter(name string) *p4_config_v1.Meter {
-	if c.p4Info == nil {
+func (p *p4InfoSnapshot) findMeter(name string) *p4_config_v1.Meter {
+	if p.p4Info == nil {
 		return nil
 	}
-	for _, meter := range c.p4Info.Meters {
+	for _, meter := range p.p4Info.Meters {
 		if meter.Preamble.Name == name {
 			return meter
 		}
@@ -114,10 +270,58 @@ func (c *Client) findMeter(name string) *p4_config_v1.Meter {
 	return nil
 }
 
-func (c *Client) meterId(name string) uint32 {
-	meter := c.findMeter(name)
+func (p *p4InfoSnapshot) meterId(name string) uint32 {
+	meter := p.findMeter(name)
 	if meter == nil {
 		return invalidID
 	}
 	return meter.Preamble.Id
 }
+
+func (p *p4InfoSnapshot) findTableByID(id uint32) *p4_config_v1.Table {
+	if p.p4Info == nil {
+		return nil
+	}
+	for _, table := range p.p4Info.Tables {
+		if table.Preamble.Id == id {
+			return table
+		}
+	}
+	return nil
+}
+
+func (p *p4InfoSnapshot) findAction(name string) *p4_config_v1.Action {
+	if p.p4Info == nil {
+		return nil
+	}
+	for _, action := range p.p4Info.Actions {
+		if action.Preamble.Name == name {
+			return action
+		}
+	}
+	return nil
+}
+
+func (p *p4InfoSnapshot) findActionByID(id uint32) *p4_config_v1.Action {
+	if p.p4Info == nil {
+		return nil
+	}
+	for _, action := range p.p4Info.Actions {
+		if action.Preamble.Id == id {
+			return action
+		}
+	}
+	return nil
+}
+
+func (p *p4InfoSnapshot) findControllerPacketMetadata(name string) *p4_config_v1.ControllerPacketMetadata {
+	if p.p4Info == nil {
+		return nil
+	}
+	for _, cpm := range p.p4Info.ControllerPacketMetadata {
+		if cpm.Preamble.Name == name {
+			return cpm
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+)
+
+func TestLoadP4Info(t *testing.T) {
+	p4Info := newTestP4Info("IngressImpl.dmac")
+	textBytes, err := prototext.Marshal(p4Info)
+	require.NoError(t, err)
+	binaryBytes, err := proto.Marshal(p4Info)
+	require.NoError(t, err)
+	jsonBytes, err := protojson.Marshal(p4Info)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		in       []byte
+		encoding P4InfoEncoding
+	}{
+		{"text", textBytes, P4InfoEncodingText},
+		{"binary", binaryBytes, P4InfoEncodingBinary},
+		{"json", jsonBytes, P4InfoEncodingJSON},
+		{"auto text", textBytes, P4InfoEncodingAuto},
+		{"auto binary", binaryBytes, P4InfoEncodingAuto},
+		{"auto json", jsonBytes, P4InfoEncodingAuto},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := LoadP4Info(tc.in, tc.encoding)
+			require.NoError(t, err)
+			assert.True(t, proto.Equal(p4Info, out))
+		})
+	}
+
+	// the serialized PkgInfo is 123 bytes long, so the binary message starts
+	// with "\n{"
+	p4Info.PkgInfo = &p4_config_v1.PkgInfo{Name: strings.Repeat("a", 121)}
+	binaryBytes, err = proto.Marshal(p4Info)
+	require.NoError(t, err)
+	require.Equal(t, []byte("\n{"), binaryBytes[:2])
+	out, err := LoadP4Info(binaryBytes, P4InfoEncodingAuto)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(p4Info, out))
+
+	_, err = LoadP4Info(jsonBytes, P4InfoEncodingText)
+	assert.Error(t, err)
+	_, err = LoadP4Info([]byte("tables { preamble { id: \"foo\" } }"), P4InfoEncodingAuto)
+	assert.Error(t, err)
+}
+
+func TestMarshalP4Info(t *testing.T) {
+	p4Info := newTestP4Info("IngressImpl.dmac")
+	for _, encoding := range []P4InfoEncoding{P4InfoEncodingText, P4InfoEncodingBinary, P4InfoEncodingJSON} {
+		t.Run(encoding.String(), func(t *testing.T) {
+			b, err := MarshalP4Info(p4Info, encoding)
+			require.NoError(t, err)
+			out, err := LoadP4Info(b, P4InfoEncodingAuto)
+			require.NoError(t, err)
+			assert.True(t, proto.Equal(p4Info, out))
+		})
+	}
+	_, err := MarshalP4Info(p4Info, P4InfoEncodingAuto)
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// P4InfoView is an immutable view of the P4Info of a Client, as it was when
+// the view was obtained with P4InfoView. All the entities built with the same
+// view resolve names against the same P4Info, even if the P4Info of the Client
+// changes concurrently (the corresponding Client methods load the current
+// P4Info on each call, so an entry and its action may be built against
+// different versions). Entities built with a view should be written with
+// WriteUpdates, which detects that they are stale.
+type P4InfoView struct {
+	client *Client
+	p4Info *p4InfoSnapshot
+}
+
+// P4InfoView returns a view of the current P4Info of the Client.
+func (c *Client) P4InfoView() *P4InfoView {
+	return &P4InfoView{
+		client: c,
+		p4Info: c.currentP4Info(),
+	}
+}
+
+// P4Info returns the P4Info of the view, nil if the Client did not have a
+// P4Info. The returned message must not be modified.
+func (v *P4InfoView) P4Info() *p4_config_v1.P4Info {
+	return v.p4Info.p4Info
+}
+
+// Version returns the version of the P4Info of the view, see
+// Client.P4InfoVersion.
+func (v *P4InfoView) Version() uint64 {
+	return v.p4Info.version
+}
+
+// Stale returns true if the P4Info of the Client has changed since the view
+// was obtained.
+func (v *P4InfoView) Stale() bool {
+	return v.client.currentP4Info() != v.p4Info
+}
+
+// NewTableEntry is like Client.NewTableEntry, using the P4Info of the view.
+func (v *P4InfoView) NewTableEntry(
+	table string,
+	mfs map[string]MatchInterface,
+	action *p4_v1.TableAction,
+	options *TableEntryOptions,
+) *p4_v1.TableEntry {
+	return v.client.newTableEntry(v.p4Info, table, mfs, action, options)
+}
+
+// NewTableActionDirect is like Client.NewTableActionDirect, using the P4Info
+// of the view.
+func (v *P4InfoView) NewTableActionDirect(action string, params [][]byte) *p4_v1.TableAction {
+	return newTableActionDirect(v.p4Info, action, params)
+}
+
+// NewActionProfileActionSet is like Client.NewActionProfileActionSet, using
+// the P4Info of the view.
+func (v *P4InfoView) NewActionProfileActionSet() *ActionProfileActionSet {
+	return newActionProfileActionSet(v.p4Info)
+}
+
+// NewActionProfileMember is like Client.NewActionProfileMember, using the
+// P4Info of the view.
+func (v *P4InfoView) NewActionProfileMember(
+	actionProfile string,
+	memberID uint32,
+	action string,
+	params [][]byte,
+) *p4_v1.ActionProfileMember {
+	return newActionProfileMember(v.p4Info, actionProfile, memberID, action, params)
+}
+
+// NewActionProfileGroup is like Client.NewActionProfileGroup, using the
+// P4Info of the view.
+func (v *P4InfoView) NewActionProfileGroup(
+	actionProfile string,
+	groupID uint32,
+	members []*p4_v1.ActionProfileGroup_Member,
+	size int32,
+) *p4_v1.ActionProfileGroup {
+	return newActionProfileGroup(v.p4Info, actionProfile, groupID, members, size)
+}
+
+func (v *P4InfoView) WriteUpdate(ctx context.Context, update *p4_v1.Update) error {
+	return v.WriteUpdates(ctx, []*p4_v1.Update{update})
+}
+
+// WriteUpdates is like Client.WriteUpdates, but the updates are rejected with
+// ErrStaleEntity if the P4Info of the Client has changed since the view was
+// obtained, even if the entities happen to be valid for the new P4Info.
+func (v *P4InfoView) WriteUpdates(ctx context.Context, updates []*p4_v1.Update) error {
+	if current := v.client.currentP4Info(); current != v.p4Info {
+		return fmt.Errorf("%w: built with P4Info version %d, current version is %d", ErrStaleEntity, v.p4Info.version, current.version)
+	}
+	return v.client.WriteUpdates(ctx, updates)
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"fmt"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+const (
+	packetOutMetadataName = "packet_out"
+	packetInMetadataName  = "packet_in"
+)
+
+// NewPacketOut builds a PacketOut message. Metadata fields are identified by
+// their name in the "packet_out" controller header of the P4Info.
+func (c *Client) NewPacketOut(payload []byte, metadata map[string][]byte) (*p4_v1.PacketOut, error) {
+	pkt := &p4_v1.PacketOut{
+		Payload: payload,
+	}
+	if len(metadata) == 0 {
+		return pkt, nil
+	}
+	cpm := c.currentP4Info().findControllerPacketMetadata(packetOutMetadataName)
+	if cpm == nil {
+		return nil, fmt.Errorf("no %s controller header in P4Info", packetOutMetadataName)
+	}
+	known := make(map[string]bool, len(cpm.Metadata))
+	// use the P4Info order for metadata fields
+	for _, m := range cpm.Metadata {
+		known[m.Name] = true
+		if value, ok := metadata[m.Name]; ok {
+			pkt.Metadata = append(pkt.Metadata, &p4_v1.PacketMetadata{
+				MetadataId: m.Id,
+				Value:      ToCanonicalIf(value, c.CanonicalBytestrings),
+			})
+		}
+	}
+	for name := range metadata {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown %s metadata field %s", packetOutMetadataName, name)
+		}
+	}
+	return pkt, nil
+}
+
+// PacketInMetadata returns the metadata fields of a PacketIn message, indexed
+// by their name in the "packet_in" controller header of the P4Info. Unknown
+// fields are ignored.
+func (c *Client) PacketInMetadata(pkt *p4_v1.PacketIn) map[string][]byte {
+	out := make(map[string][]byte, len(pkt.Metadata))
+	cpm := c.currentP4Info().findControllerPacketMetadata(packetInMetadataName)
+	if cpm == nil {
+		return out
+	}
+	for _, m := range pkt.Metadata {
+		for _, p4m := range cpm.Metadata {
+			if p4m.Id == m.MetadataId {
+				out[p4m.Name] = m.Value
+				break
+			}
+		}
+	}
+	return out
+}
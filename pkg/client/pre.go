@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"fmt"
 
 	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
 )
@@ -131,3 +132,57 @@ func (c *Client) DeleteMulticastGroup(ctx context.Context, mgid uint32) error {
 
 	return c.WriteUpdate(ctx, update)
 }
+
+// ReadCloneSessionWildcard returns all the clone sessions configured in the
+// target.
+func (c *Client) ReadCloneSessionWildcard(ctx context.Context) ([]*p4_v1.CloneSessionEntry, error) {
+	// a session id of 0 means wildcard read
+	readEntities, err := c.readEntitiesAll(ctx, &p4_v1.Entity{
+		Entity: &p4_v1.Entity_PacketReplicationEngineEntry{
+			PacketReplicationEngineEntry: &p4_v1.PacketReplicationEngineEntry{
+				Type: &p4_v1.PacketReplicationEngineEntry_CloneSessionEntry{
+					CloneSessionEntry: &p4_v1.CloneSessionEntry{},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error when reading clone sessions: %v", err)
+	}
+	out := make([]*p4_v1.CloneSessionEntry, 0, len(readEntities))
+	for _, readEntity := range readEntities {
+		readEntry := readEntity.GetPacketReplicationEngineEntry().GetCloneSessionEntry()
+		if readEntry == nil {
+			return nil, fmt.Errorf("server returned an entity which is not a clone session")
+		}
+		out = append(out, readEntry)
+	}
+	return out, nil
+}
+
+// ReadMulticastGroupWildcard returns all the multicast groups configured in
+// the target.
+func (c *Client) ReadMulticastGroupWildcard(ctx context.Context) ([]*p4_v1.MulticastGroupEntry, error) {
+	// a multicast group id of 0 means wildcard read
+	readEntities, err := c.readEntitiesAll(ctx, &p4_v1.Entity{
+		Entity: &p4_v1.Entity_PacketReplicationEngineEntry{
+			PacketReplicationEngineEntry: &p4_v1.PacketReplicationEngineEntry{
+				Type: &p4_v1.PacketReplicationEngineEntry_MulticastGroupEntry{
+					MulticastGroupEntry: &p4_v1.MulticastGroupEntry{},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error when reading multicast groups: %v", err)
+	}
+	out := make([]*p4_v1.MulticastGroupEntry, 0, len(readEntities))
+	for _, readEntity := range readEntities {
+		readEntry := readEntity.GetPacketReplicationEngineEntry().GetMulticastGroupEntry()
+		if readEntry == nil {
+			return nil, fmt.Errorf("server returned an entity which is not a multicast group")
+		}
+		out = append(out, readEntry)
+	}
+	return out, nil
+}
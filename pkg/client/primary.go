@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// BackupPolicy determines how the Client handles writes and pipeline changes
+// while it is not the primary client. The policy only applies once the Client
+// has received an arbitration update, i.e. when Run is used.
+type BackupPolicy int
+
+const (
+	// BackupPolicyFail rejects the requests with ErrNotPrimary, without
+	// sending them to the server.
+	BackupPolicyFail BackupPolicy = iota
+	// BackupPolicyQueue blocks the requests until the Client becomes primary
+	// (or until their context is done), and then sends them in order.
+	BackupPolicyQueue
+	// BackupPolicySend sends the requests anyway, in which case the server
+	// rejects them with PERMISSION_DENIED.
+	BackupPolicySend
+)
+
+// WithBackupPolicy returns a ClientOptions modifier which sets the
+// BackupPolicy.
+func WithBackupPolicy(policy BackupPolicy) func(*ClientOptions) {
+	return func(options *ClientOptions) {
+		options.BackupPolicy = policy
+	}
+}
+
+// pendingRequest is a request queued with BackupPolicyQueue.
+type pendingRequest struct {
+	fn        func() error
+	doneCh    chan error
+	cancelled atomic.Bool
+}
+
+// IsPrimary returns true if the last arbitration update received by the
+// Client made it the primary client.
+func (c *Client) IsPrimary() bool {
+	c.arbitrationMu.Lock()
+	defer c.arbitrationMu.Unlock()
+	return c.arbitration != nil && c.arbitration.IsPrimary
+}
+
+// SubscribePrimaryStatus returns a channel which receives the primary status
+// of the Client each time it changes, and a function to cancel the
+// subscription. The channel only holds the latest status: if the subscriber
+// does not keep up, intermediate changes are skipped.
+func (c *Client) SubscribePrimaryStatus() (<-chan bool, func()) {
+	ch := make(chan bool, 1)
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	if c.primarySubscribers == nil {
+		c.primarySubscribers = make(map[chan bool]bool)
+	}
+	c.primarySubscribers[ch] = true
+	return ch, func() {
+		c.subscribersMu.Lock()
+		defer c.subscribersMu.Unlock()
+		delete(c.primarySubscribers, ch)
+	}
+}
+
+func (c *Client) notifyPrimaryStatus(isPrimary bool) {
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	for ch := range c.primarySubscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- isPrimary
+	}
+}
+
+// asPrimary calls fn, which sends a request which requires the Client to be
+// primary, according to the BackupPolicy.
+func (c *Client) asPrimary(ctx context.Context, fn func() error) error {
+	c.arbitrationMu.Lock()
+	if c.arbitration == nil || c.BackupPolicy == BackupPolicySend || (c.arbitration.IsPrimary && len(c.pending) == 0) {
+		c.arbitrationMu.Unlock()
+		return fn()
+	}
+	if !c.arbitration.IsPrimary && c.BackupPolicy == BackupPolicyFail {
+		c.arbitrationMu.Unlock()
+		return ErrNotPrimary
+	}
+	// either the Client is a backup, or the queue is being flushed and
+	// this request must be sent after the queued ones
+	req := &pendingRequest{fn: fn, doneCh: make(chan error, 1)}
+	c.pending = append(c.pending, req)
+	c.arbitrationMu.Unlock()
+	select {
+	case err := <-req.doneCh:
+		return err
+	case <-ctx.Done():
+		req.cancelled.Store(true)
+		return ctx.Err()
+	}
+}
+
+// flushPending sends the queued requests in order, as long as the Client
+// remains primary. c.flushing must be set by the caller.
+func (c *Client) flushPending() {
+	for {
+		c.arbitrationMu.Lock()
+		if len(c.pending) == 0 || !c.arbitration.IsPrimary {
+			c.flushing = false
+			c.arbitrationMu.Unlock()
+			return
+		}
+		req := c.pending[0]
+		c.pending = c.pending[1:]
+		c.arbitrationMu.Unlock()
+		if req.cancelled.Load() {
+			continue
+		}
+		req.doneCh <- req.fn()
+	}
+}
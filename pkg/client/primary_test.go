@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/fakeserver"
+)
+
+func waitPrimaryStatus(t *testing.T, ch <-chan bool) bool {
+	select {
+	case isPrimary := <-ch:
+		return isPrimary
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timeout when waiting for primary status")
+	}
+	return false
+}
+
+func TestBackupPolicyFail(t *testing.T) {
+	ctx := context.Background()
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c1 := newFakeServerClient(t, s, 1, true)
+	statusCh, unsubscribe := c1.SubscribePrimaryStatus()
+	defer unsubscribe()
+	assert.True(t, waitPrimaryStatus(t, statusCh))
+	assert.True(t, c1.IsPrimary())
+	require.NoError(t, c1.InsertMulticastGroup(ctx, 1, []uint32{1}))
+
+	c2 := newFakeServerClient(t, s, 2, true)
+	waitArbitrationStatus(t, c2, codes.OK)
+	assert.False(t, waitPrimaryStatus(t, statusCh))
+	assert.False(t, c1.IsPrimary())
+	assert.ErrorIs(t, c1.InsertMulticastGroup(ctx, 2, []uint32{1}), ErrNotPrimary)
+	_, err := c1.SetFwdPipeFromP4Info(ctx, nil, &p4_config_v1.P4Info{}, 1)
+	assert.ErrorIs(t, err, ErrNotPrimary)
+	// reads are still allowed
+	groups, err := c1.ReadMulticastGroupWildcard(ctx)
+	require.NoError(t, err)
+	assert.Len(t, groups, 1)
+}
+
+func TestPrimaryStatusStreamClosed(t *testing.T) {
+	ctx := context.Background()
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c := newFakeServerClient(t, s, 1, false)
+	defer c.Close()
+	statusCh, unsubscribe := c.SubscribePrimaryStatus()
+	defer unsubscribe()
+	require.NoError(t, c.Start(ctx, nil, nil))
+	assert.True(t, waitPrimaryStatus(t, statusCh))
+	require.NoError(t, c.InsertMulticastGroup(ctx, 1, []uint32{1}))
+
+	s.CloseStreams()
+	waitDone(t, c)
+	assert.False(t, waitPrimaryStatus(t, statusCh))
+	assert.False(t, c.IsPrimary())
+	status, ok := c.ArbitrationStatus()
+	require.True(t, ok)
+	assert.Equal(t, codes.Unavailable, status.Code)
+	// the request is rejected locally instead of being sent to the server
+	assert.ErrorIs(t, c.InsertMulticastGroup(ctx, 2, []uint32{1}), ErrNotPrimary)
+}
+
+func TestBackupPolicyQueue(t *testing.T) {
+	ctx := context.Background()
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c2 := newFakeServerClient(t, s, 2, true)
+	waitArbitrationStatus(t, c2, codes.OK)
+	c1 := newFakeServerClient(t, s, 1, true, WithBackupPolicy(BackupPolicyQueue))
+	waitArbitrationStatus(t, c1, codes.AlreadyExists)
+
+	// requests whose context expires are dropped from the queue
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c1.InsertMulticastGroup(timeoutCtx, 3, []uint32{1}), context.DeadlineExceeded)
+
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- c1.InsertMulticastGroup(ctx, 1, []uint32{1})
+		errCh <- c1.DeleteMulticastGroup(ctx, 1)
+	}()
+	select {
+	case err := <-errCh:
+		require.FailNow(t, "request was not queued", "error: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the queued requests are sent in order once c1 is primary
+	require.NoError(t, c1.TakeOver(ctx))
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errCh:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timeout when waiting for queued request")
+		}
+	}
+	groups, err := c1.ReadMulticastGroupWildcard(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+}
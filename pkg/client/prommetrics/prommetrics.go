@@ -0,0 +1,161 @@
+// Package prommetrics implements client.Metrics with Prometheus collectors.
+//
+//	m, err := prommetrics.NewMetrics(prometheus.DefaultRegisterer, prommetrics.DefaultOptions)
+//	...
+//	p4RtC := client.NewClient(p4RtClient, deviceID, electionID, client.WithMetrics(m))
+//
+// When several clients share a Registerer, use ConstLabels (e.g. the device
+// id) to distinguish their metrics.
+package prommetrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/client"
+)
+
+// Options configures the Prometheus collectors.
+type Options struct {
+	// Namespace is the prefix of all metric names.
+	Namespace string
+	// ConstLabels are added to all metrics.
+	ConstLabels prometheus.Labels
+	// LatencyBuckets are the buckets of the Write and Read latency
+	// histograms, in seconds.
+	LatencyBuckets []float64
+}
+
+var DefaultOptions = Options{
+	Namespace:      "p4runtime_client",
+	LatencyBuckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+}
+
+// Metrics implements client.Metrics.
+type Metrics struct {
+	writeLatency           *prometheus.HistogramVec
+	readLatency            *prometheus.HistogramVec
+	streamMessages         *prometheus.CounterVec
+	streamSendQueueDepth   prometheus.Gauge
+	streamSendDrops        prometheus.Counter
+	streamMessageDrops     *prometheus.CounterVec
+	arbitrationTransitions *prometheus.CounterVec
+	streamReconnects       prometheus.Counter
+}
+
+// Metrics implements the client.Metrics interface
+var _ client.Metrics = &Metrics{}
+
+// NewMetrics creates the collectors and registers them with reg.
+func NewMetrics(reg prometheus.Registerer, options Options) (*Metrics, error) {
+	rpcLabels := []string{"entity_type", "table", "code"}
+	m := &Metrics{
+		writeLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   options.Namespace,
+			Name:        "write_duration_seconds",
+			Help:        "Latency of Write RPCs, by entity type, table and status code.",
+			ConstLabels: options.ConstLabels,
+			Buckets:     options.LatencyBuckets,
+		}, rpcLabels),
+		readLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   options.Namespace,
+			Name:        "read_duration_seconds",
+			Help:        "Latency of Read RPCs, by entity type, table and status code.",
+			ConstLabels: options.ConstLabels,
+			Buckets:     options.LatencyBuckets,
+		}, rpcLabels),
+		streamMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Name:        "stream_messages_total",
+			Help:        "Number of stream messages, by direction (sent or received) and type.",
+			ConstLabels: options.ConstLabels,
+		}, []string{"direction", "type"}),
+		streamSendQueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   options.Namespace,
+			Name:        "stream_send_queue_depth",
+			Help:        "Number of messages waiting to be sent on the stream.",
+			ConstLabels: options.ConstLabels,
+		}),
+		streamSendDrops: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Name:        "stream_send_dropped_total",
+			Help:        "Number of messages which could not be queued for sending on the stream, or which were dropped from the queue when the stream terminated.",
+			ConstLabels: options.ConstLabels,
+		}),
+		streamMessageDrops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Name:        "stream_messages_dropped_total",
+			Help:        "Number of received stream messages dropped because their queue was full, by type.",
+			ConstLabels: options.ConstLabels,
+		}, []string{"type"}),
+		arbitrationTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Name:        "arbitration_transitions_total",
+			Help:        "Number of transitions to primary or backup.",
+			ConstLabels: options.ConstLabels,
+		}, []string{"state"}),
+		streamReconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Name:        "stream_reconnects_total",
+			Help:        "Number of times the stream was re-established.",
+			ConstLabels: options.ConstLabels,
+		}),
+	}
+	for _, c := range []prometheus.Collector{
+		m.writeLatency,
+		m.readLatency,
+		m.streamMessages,
+		m.streamSendQueueDepth,
+		m.streamSendDrops,
+		m.streamMessageDrops,
+		m.arbitrationTransitions,
+		m.streamReconnects,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Metrics) ObserveWrite(labels client.EntityLabels, code codes.Code, latency time.Duration) {
+	m.writeLatency.WithLabelValues(labels.EntityType, labels.Table, code.String()).Observe(latency.Seconds())
+}
+
+func (m *Metrics) ObserveRead(labels client.EntityLabels, code codes.Code, latency time.Duration) {
+	m.readLatency.WithLabelValues(labels.EntityType, labels.Table, code.String()).Observe(latency.Seconds())
+}
+
+func (m *Metrics) StreamMessageSent(msgType string) {
+	m.streamMessages.WithLabelValues("sent", msgType).Inc()
+}
+
+func (m *Metrics) StreamMessageReceived(msgType string) {
+	m.streamMessages.WithLabelValues("received", msgType).Inc()
+}
+
+func (m *Metrics) StreamSendQueueDepth(depth int) {
+	m.streamSendQueueDepth.Set(float64(depth))
+}
+
+func (m *Metrics) StreamSendDropped() {
+	m.streamSendDrops.Inc()
+}
+
+func (m *Metrics) StreamMessageDropped(msgType string) {
+	m.streamMessageDrops.WithLabelValues(msgType).Inc()
+}
+
+func (m *Metrics) ArbitrationTransition(isPrimary bool) {
+	state := "backup"
+	if isPrimary {
+		state = "primary"
+	}
+	m.arbitrationTransitions.WithLabelValues(state).Inc()
+}
+
+func (m *Metrics) StreamReconnect() {
+	m.streamReconnects.Inc()
+}
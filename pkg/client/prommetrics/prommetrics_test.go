@@ -0,0 +1,52 @@
+package prommetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/client"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	options := DefaultOptions
+	options.ConstLabels = prometheus.Labels{"device_id": "1"}
+	m, err := NewMetrics(reg, options)
+	require.NoError(t, err)
+
+	labels := client.EntityLabels{EntityType: client.EntityTypeTableEntry, Table: "dmac"}
+	m.ObserveWrite(labels, codes.OK, time.Millisecond)
+	m.ObserveWrite(labels, codes.AlreadyExists, time.Millisecond)
+	m.ObserveRead(labels, codes.OK, time.Millisecond)
+	m.StreamMessageSent(client.StreamMessageArbitration)
+	m.StreamMessageReceived(client.StreamMessagePacket)
+	m.StreamMessageReceived(client.StreamMessagePacket)
+	m.StreamSendQueueDepth(3)
+	m.StreamSendDropped()
+	m.StreamMessageDropped(client.StreamMessagePacket)
+	m.ArbitrationTransition(true)
+	m.StreamReconnect()
+
+	assert.Equal(t, 2, testutil.CollectAndCount(m.writeLatency))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.readLatency))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.streamMessages.WithLabelValues("received", client.StreamMessagePacket)))
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.streamSendQueueDepth))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.streamSendDrops))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.streamMessageDrops.WithLabelValues(client.StreamMessagePacket)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.arbitrationTransitions.WithLabelValues("primary")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.streamReconnects))
+
+	// a second client can share the registry with different const labels
+	options.ConstLabels = prometheus.Labels{"device_id": "2"}
+	_, err = NewMetrics(reg, options)
+	assert.NoError(t, err)
+	// but not with the same ones
+	_, err = NewMetrics(reg, options)
+	assert.Error(t, err)
+}
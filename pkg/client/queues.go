@@ -0,0 +1,273 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+const (
+	defaultPacketInQueueCapacity    = 1024
+	defaultDigestListQueueCapacity  = 256
+	defaultIdleTimeoutQueueCapacity = 256
+	defaultStreamErrorQueueCapacity = 256
+)
+
+// OverflowPolicy determines what happens when a stream message is received and
+// its queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDefault uses the default policy of the queue, see
+	// DefaultStreamQueuesOptions and DefaultSubscribeOptions.
+	OverflowDefault OverflowPolicy = iota
+	// OverflowBlock waits for the consumer to make room in the queue. Note
+	// that this stops the processing of all the stream messages, including
+	// arbitration updates, until then.
+	OverflowBlock
+	// OverflowDropOldest drops the oldest message in the queue to make room
+	// for the new one.
+	OverflowDropOldest
+	// OverflowDropNewest drops the new message.
+	OverflowDropNewest
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDefault:
+		return "default"
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	}
+	return "unknown"
+}
+
+// QueueOptions configures the queue for one type of stream message. The zero
+// value of each field selects the default for the queue.
+type QueueOptions struct {
+	// Capacity is the maximum number of messages in the queue. If not
+	// positive, the default capacity of the queue is used.
+	Capacity int
+	Overflow OverflowPolicy
+}
+
+// withDefaults returns the options, with the fields which are not set taken
+// from defaults.
+func (o QueueOptions) withDefaults(defaults QueueOptions) QueueOptions {
+	if o.Capacity <= 0 {
+		o.Capacity = defaults.Capacity
+	}
+	if o.Overflow == OverflowDefault {
+		o.Overflow = defaults.Overflow
+	}
+	return o
+}
+
+// StreamQueuesOptions configures the queues for the stream messages received
+// from the server, see WithStreamQueues. Queues which are not configured use
+// the options from DefaultStreamQueuesOptions.
+type StreamQueuesOptions struct {
+	PacketIn                QueueOptions
+	DigestList              QueueOptions
+	IdleTimeoutNotification QueueOptions
+	StreamError             QueueOptions
+}
+
+// DefaultStreamQueuesOptions never blocks the processing of stream messages.
+// DigestLists are dropped when the queue is full, rather than older ones, since
+// the server sends them again if they are not acked.
+var DefaultStreamQueuesOptions = StreamQueuesOptions{
+	PacketIn:                QueueOptions{Capacity: defaultPacketInQueueCapacity, Overflow: OverflowDropOldest},
+	DigestList:              QueueOptions{Capacity: defaultDigestListQueueCapacity, Overflow: OverflowDropNewest},
+	IdleTimeoutNotification: QueueOptions{Capacity: defaultIdleTimeoutQueueCapacity, Overflow: OverflowDropOldest},
+	StreamError:             QueueOptions{Capacity: defaultStreamErrorQueueCapacity, Overflow: OverflowDropOldest},
+}
+
+// WithStreamQueues returns a ClientOptions modifier which enables the stream
+// message queues. PacketIns, DigestLists, IdleTimeoutNotifications and
+// StreamErrors are then delivered on the channels returned by PacketIns,
+// DigestLists, IdleTimeoutNotifications and StreamErrors respectively, instead
+// of the messageCh channel provided to Run or Start, so that a slow consumer
+// for one type of message does not delay the other ones.
+func WithStreamQueues(options StreamQueuesOptions) func(*ClientOptions) {
+	return func(o *ClientOptions) {
+		o.StreamQueues = &options
+	}
+}
+
+// QueueStats are the statistics of a stream message queue.
+type QueueStats struct {
+	Len      int
+	Capacity int
+	// Dropped is the number of messages dropped because the queue was full.
+	Dropped uint64
+}
+
+// messageQueue is a bounded queue with a single producer, the receive loop of
+// the stream.
+type messageQueue[T any] struct {
+	msgType  string
+	ch       chan T
+	overflow OverflowPolicy
+	dropped  atomic.Uint64
+	metrics  Metrics
+	// if not nil, closed when the consumer goes away, which unblocks push
+	abandonedCh chan struct{}
+}
+
+func newMessageQueue[T any](msgType string, options QueueOptions, defaults QueueOptions, metrics Metrics) *messageQueue[T] {
+	options = options.withDefaults(defaults)
+	return &messageQueue[T]{
+		msgType:  msgType,
+		ch:       make(chan T, options.Capacity),
+		overflow: options.Overflow,
+		metrics:  metrics,
+	}
+}
+
+func (q *messageQueue[T]) drop() {
+	q.dropped.Add(1)
+	q.metrics.StreamMessageDropped(q.msgType)
+}
+
+// push queues msg according to the overflow policy. It returns false if ctx is
+// done while blocking.
+func (q *messageQueue[T]) push(ctx context.Context, msg T) bool {
+	switch q.overflow {
+	case OverflowDropNewest:
+		select {
+		case q.ch <- msg:
+		default:
+			q.drop()
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.ch <- msg:
+				return true
+			default:
+			}
+			// the consumer may empty the queue concurrently
+			select {
+			case <-q.ch:
+				q.drop()
+			default:
+			}
+		}
+	default:
+		select {
+		case q.ch <- msg:
+		case <-q.abandonedCh:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+func (q *messageQueue[T]) stats() QueueStats {
+	return QueueStats{
+		Len:      len(q.ch),
+		Capacity: cap(q.ch),
+		Dropped:  q.dropped.Load(),
+	}
+}
+
+// streamQueues are the queues enabled by WithStreamQueues.
+type streamQueues struct {
+	packetIn    *messageQueue[*p4_v1.PacketIn]
+	digestList  *messageQueue[*p4_v1.DigestList]
+	idleTimeout *messageQueue[*p4_v1.IdleTimeoutNotification]
+	streamError *messageQueue[*p4_v1.StreamError]
+}
+
+func newStreamQueues(options *StreamQueuesOptions, metrics Metrics) *streamQueues {
+	return &streamQueues{
+		packetIn:    newMessageQueue[*p4_v1.PacketIn](StreamMessagePacket, options.PacketIn, DefaultStreamQueuesOptions.PacketIn, metrics),
+		digestList:  newMessageQueue[*p4_v1.DigestList](StreamMessageDigest, options.DigestList, DefaultStreamQueuesOptions.DigestList, metrics),
+		idleTimeout: newMessageQueue[*p4_v1.IdleTimeoutNotification](StreamMessageIdleTimeoutNotification, options.IdleTimeoutNotification, DefaultStreamQueuesOptions.IdleTimeoutNotification, metrics),
+		streamError: newMessageQueue[*p4_v1.StreamError](StreamMessageError, options.StreamError, DefaultStreamQueuesOptions.StreamError, metrics),
+	}
+}
+
+// dispatch queues a stream message other than an arbitration update. It
+// returns false if ctx is done while blocking.
+func (c *Client) dispatch(ctx context.Context, msg *p4_v1.StreamMessageResponse, messageCh chan<- *p4_v1.StreamMessageResponse) bool {
+	if q := c.queues; q != nil {
+		switch update := msg.Update.(type) {
+		case *p4_v1.StreamMessageResponse_Packet:
+			return q.packetIn.push(ctx, update.Packet)
+		case *p4_v1.StreamMessageResponse_Digest:
+			return q.digestList.push(ctx, update.Digest)
+		case *p4_v1.StreamMessageResponse_IdleTimeoutNotification:
+			return q.idleTimeout.push(ctx, update.IdleTimeoutNotification)
+		case *p4_v1.StreamMessageResponse_Error:
+			return q.streamError.push(ctx, update.Error)
+		}
+	}
+	if messageCh == nil {
+		return true
+	}
+	select {
+	case messageCh <- msg:
+	case <-ctx.Done():
+		return false
+	}
+	return true
+}
+
+// PacketIns returns the PacketIn queue. It returns nil if the stream queues are
+// not enabled with WithStreamQueues. The channel is never closed, use Done to
+// find out when the stream terminates.
+func (c *Client) PacketIns() <-chan *p4_v1.PacketIn {
+	if c.queues == nil {
+		return nil
+	}
+	return c.queues.packetIn.ch
+}
+
+// DigestLists returns the DigestList queue, see PacketIns.
+func (c *Client) DigestLists() <-chan *p4_v1.DigestList {
+	if c.queues == nil {
+		return nil
+	}
+	return c.queues.digestList.ch
+}
+
+// IdleTimeoutNotifications returns the IdleTimeoutNotification queue, see
+// PacketIns.
+func (c *Client) IdleTimeoutNotifications() <-chan *p4_v1.IdleTimeoutNotification {
+	if c.queues == nil {
+		return nil
+	}
+	return c.queues.idleTimeout.ch
+}
+
+// StreamErrors returns the StreamError queue, see PacketIns.
+func (c *Client) StreamErrors() <-chan *p4_v1.StreamError {
+	if c.queues == nil {
+		return nil
+	}
+	return c.queues.streamError.ch
+}
+
+// StreamQueueStats returns the statistics of each stream message queue, by
+// message type (StreamMessagePacket, StreamMessageDigest,
+// StreamMessageIdleTimeoutNotification and StreamMessageError). It returns nil
+// if the stream queues are not enabled.
+func (c *Client) StreamQueueStats() map[string]QueueStats {
+	if c.queues == nil {
+		return nil
+	}
+	return map[string]QueueStats{
+		StreamMessagePacket:                  c.queues.packetIn.stats(),
+		StreamMessageDigest:                  c.queues.digestList.stats(),
+		StreamMessageIdleTimeoutNotification: c.queues.idleTimeout.stats(),
+		StreamMessageError:                   c.queues.streamError.stats(),
+	}
+}
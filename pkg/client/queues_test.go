@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/fakeserver"
+)
+
+func TestMessageQueueOverflow(t *testing.T) {
+	ctx := context.Background()
+	drain := func(q *messageQueue[int]) []int {
+		var out []int
+		for len(q.ch) > 0 {
+			out = append(out, <-q.ch)
+		}
+		return out
+	}
+
+	q := newMessageQueue[int](StreamMessagePacket, QueueOptions{Capacity: 2, Overflow: OverflowDropOldest}, QueueOptions{}, noopMetrics{})
+	for i := 1; i <= 4; i++ {
+		assert.True(t, q.push(ctx, i))
+	}
+	assert.Equal(t, QueueStats{Len: 2, Capacity: 2, Dropped: 2}, q.stats())
+	assert.Equal(t, []int{3, 4}, drain(q))
+
+	q = newMessageQueue[int](StreamMessagePacket, QueueOptions{Capacity: 2, Overflow: OverflowDropNewest}, QueueOptions{}, noopMetrics{})
+	for i := 1; i <= 4; i++ {
+		assert.True(t, q.push(ctx, i))
+	}
+	assert.Equal(t, QueueStats{Len: 2, Capacity: 2, Dropped: 2}, q.stats())
+	assert.Equal(t, []int{1, 2}, drain(q))
+
+	q = newMessageQueue[int](StreamMessagePacket, QueueOptions{Capacity: 1, Overflow: OverflowBlock}, QueueOptions{}, noopMetrics{})
+	assert.True(t, q.push(ctx, 1))
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.False(t, q.push(ctx, 2))
+	assert.Equal(t, QueueStats{Len: 1, Capacity: 1, Dropped: 0}, q.stats())
+}
+
+func TestMessageQueueDefaults(t *testing.T) {
+	ctx := context.Background()
+	defaults := QueueOptions{Capacity: 2, Overflow: OverflowDropOldest}
+
+	// the zero value does not block
+	q := newMessageQueue[int](StreamMessagePacket, QueueOptions{}, defaults, noopMetrics{})
+	for i := 1; i <= 4; i++ {
+		assert.True(t, q.push(ctx, i))
+	}
+	assert.Equal(t, QueueStats{Len: 2, Capacity: 2, Dropped: 2}, q.stats())
+
+	// OverflowDropOldest with no capacity would never make room
+	q = newMessageQueue[int](StreamMessagePacket, QueueOptions{Capacity: -1, Overflow: OverflowDropOldest}, defaults, noopMetrics{})
+	assert.True(t, q.push(ctx, 1))
+	assert.Equal(t, QueueStats{Len: 1, Capacity: 2, Dropped: 0}, q.stats())
+
+	q = newMessageQueue[int](StreamMessagePacket, QueueOptions{Overflow: OverflowDropNewest}, defaults, noopMetrics{})
+	assert.Equal(t, OverflowDropNewest, q.overflow)
+	assert.Equal(t, 2, cap(q.ch))
+
+	queues := newStreamQueues(&StreamQueuesOptions{PacketIn: QueueOptions{Capacity: 8}}, noopMetrics{})
+	assert.Equal(t, 8, cap(queues.packetIn.ch))
+	assert.Equal(t, OverflowDropOldest, queues.packetIn.overflow)
+	assert.Equal(t, defaultDigestListQueueCapacity, cap(queues.digestList.ch))
+	assert.Equal(t, OverflowDropNewest, queues.digestList.overflow)
+}
+
+func TestStreamQueues(t *testing.T) {
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	metrics := &fakeMetrics{}
+	options := DefaultStreamQueuesOptions
+	options.PacketIn = QueueOptions{Capacity: 4, Overflow: OverflowDropOldest}
+	c := newFakeServerClient(t, s, 1, false, WithStreamQueues(options), WithMetrics(metrics))
+	defer c.Close()
+	// messageCh is never read: the PacketIns must not be sent there
+	messageCh := make(chan *p4_v1.StreamMessageResponse)
+	arbitrationCh := make(chan bool, 10)
+	require.NoError(t, c.Start(context.Background(), arbitrationCh, messageCh))
+	assert.True(t, waitPrimaryStatus(t, arbitrationCh))
+
+	const numPackets = 10
+	for i := 0; i < numPackets; i++ {
+		require.NoError(t, s.SendPacketIn(&p4_v1.PacketIn{Payload: []byte{byte(i)}}))
+	}
+	require.Eventually(t, func() bool {
+		return c.StreamQueueStats()[StreamMessagePacket].Dropped == numPackets-4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// arbitration updates are not stuck behind the PacketIns
+	s.DemotePrimary("")
+	assert.False(t, waitPrimaryStatus(t, arbitrationCh))
+
+	for i := numPackets - 4; i < numPackets; i++ {
+		pkt := <-c.PacketIns()
+		assert.Equal(t, []byte{byte(i)}, pkt.Payload)
+	}
+	assert.Equal(t, QueueStats{Len: 0, Capacity: 4, Dropped: numPackets - 4}, c.StreamQueueStats()[StreamMessagePacket])
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	assert.Equal(t, numPackets-4, metrics.droppedMessages[StreamMessagePacket])
+}
+
+func TestStreamQueuesDisabled(t *testing.T) {
+	c := newTestClient(&fakeP4RuntimeClient{}, nil)
+	assert.Nil(t, c.PacketIns())
+	assert.Nil(t, c.DigestLists())
+	assert.Nil(t, c.IdleTimeoutNotifications())
+	assert.Nil(t, c.StreamErrors())
+	assert.Nil(t, c.StreamQueueStats())
+}
@@ -0,0 +1,402 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// DesiredState is the intended configuration for a subset of the target's
+// entities. Only the tables and action profiles listed in DesiredState are
+// reconciled: entries belonging to other tables or action profiles are never
+// read or modified.
+type DesiredState struct {
+	// Tables lists the names of the tables to reconcile. Existing entries in
+	// these tables which are not in TableEntries are deleted. Default entries
+	// are only modified if a default entry is included in TableEntries.
+	Tables       []string
+	TableEntries []*p4_v1.TableEntry
+	// ActionProfiles lists the names of the action profiles to reconcile.
+	ActionProfiles       []string
+	ActionProfileMembers []*p4_v1.ActionProfileMember
+	ActionProfileGroups  []*p4_v1.ActionProfileGroup
+	// ReconcileMulticastGroups must be set for MulticastGroups to be taken
+	// into account. Otherwise multicast groups are left untouched.
+	ReconcileMulticastGroups bool
+	MulticastGroups          []*p4_v1.MulticastGroupEntry
+	// ReconcileCloneSessions must be set for CloneSessions to be taken into
+	// account. Otherwise clone sessions are left untouched.
+	ReconcileCloneSessions bool
+	CloneSessions          []*p4_v1.CloneSessionEntry
+}
+
+type ReconcileOptions struct {
+	// DryRun computes the diff and returns it in the report, without writing
+	// anything to the target.
+	DryRun bool
+}
+
+var DefaultReconcileOptions = ReconcileOptions{
+	DryRun: false,
+}
+
+// ReconcileReport lists the entities which were inserted, modified and deleted
+// by Reconcile (or which would have been, in dry-run mode).
+type ReconcileReport struct {
+	Inserted []*p4_v1.Entity
+	Modified []*p4_v1.Entity
+	Deleted  []*p4_v1.Entity
+}
+
+// Empty returns true if the target was already in the desired state.
+func (r *ReconcileReport) Empty() bool {
+	return len(r.Inserted) == 0 && len(r.Modified) == 0 && len(r.Deleted) == 0
+}
+
+// entitySet is a set of entities indexed by their key (see entityKey).
+type entitySet struct {
+	entities   map[string]*p4_v1.Entity
+	normalized map[string]*p4_v1.Entity
+}
+
+func newEntitySet() *entitySet {
+	return &entitySet{
+		entities:   make(map[string]*p4_v1.Entity),
+		normalized: make(map[string]*p4_v1.Entity),
+	}
+}
+
+func (s *entitySet) add(entity *p4_v1.Entity) (bool, error) {
+	normalized := normalizeEntity(entity)
+	key, err := entityKey(normalized)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := s.entities[key]; ok {
+		return false, nil
+	}
+	s.entities[key] = entity
+	s.normalized[key] = normalized
+	return true, nil
+}
+
+func (s *entitySet) sortedKeys() []string {
+	keys := make([]string, 0, len(s.entities))
+	for key := range s.entities {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// entityDiff is the set of updates required to go from one entitySet to
+// another.
+type entityDiff struct {
+	inserts  []*p4_v1.Entity
+	modifies []*p4_v1.Entity
+	deletes  []*p4_v1.Entity
+}
+
+func sameEntityValue(desired, actual *p4_v1.Entity) bool {
+	if desiredEntry := desired.GetTableEntry(); desiredEntry != nil && desiredEntry.MeterConfig == nil {
+		// the target may report a default meter config for direct meters
+		actual = proto.Clone(actual).(*p4_v1.Entity)
+		actual.GetTableEntry().MeterConfig = nil
+	}
+	return proto.Equal(desired, actual)
+}
+
+func diffEntitySets(desired, actual *entitySet) *entityDiff {
+	diff := &entityDiff{}
+	for _, key := range desired.sortedKeys() {
+		actualEntity, ok := actual.normalized[key]
+		if !ok {
+			if desired.entities[key].GetTableEntry().GetIsDefaultAction() {
+				// default entries always exist and can only be modified
+				diff.modifies = append(diff.modifies, desired.entities[key])
+			} else {
+				diff.inserts = append(diff.inserts, desired.entities[key])
+			}
+			continue
+		}
+		if !sameEntityValue(desired.normalized[key], actualEntity) {
+			diff.modifies = append(diff.modifies, desired.entities[key])
+		}
+	}
+	for _, key := range actual.sortedKeys() {
+		if _, ok := desired.entities[key]; ok {
+			continue
+		}
+		if actual.entities[key].GetTableEntry().GetIsDefaultAction() {
+			continue
+		}
+		diff.deletes = append(diff.deletes, actual.entities[key])
+	}
+	return diff
+}
+
+// Reconcile brings the target to the desired state with a minimal number of
+// updates. The current state is obtained with wildcard reads, and entities are
+// matched using their key (e.g. table id, match fields and priority for table
+// entries). Updates are applied in an order which respects dependencies
+// between entities: for example, action profile members are inserted before
+// the groups and table entries which refer to them, and deleted after.
+//
+// If an error occurs while applying updates, the returned report includes the
+// updates which were applied successfully before the error.
+func (c *Client) Reconcile(ctx context.Context, desired *DesiredState, options ReconcileOptions) (*ReconcileReport, error) {
+	desiredTables := newEntitySet()
+	actualTables := newEntitySet()
+	desiredMembers := newEntitySet()
+	actualMembers := newEntitySet()
+	desiredGroups := newEntitySet()
+	actualGroups := newEntitySet()
+	desiredPRE := newEntitySet()
+	actualPRE := newEntitySet()
+
+	addDesired := func(set *entitySet, entity *p4_v1.Entity) error {
+		added, err := set.add(entity)
+		if err != nil {
+			return err
+		}
+		if !added {
+			return fmt.Errorf("duplicate entity in desired state: %v", entity)
+		}
+		return nil
+	}
+	addActual := func(set *entitySet, entity *p4_v1.Entity) error {
+		_, err := set.add(entity)
+		return err
+	}
+
+	// desired state
+
+	// all names are resolved with the same P4Info
+	p4Info := c.currentP4Info()
+	tableIDs := make(map[uint32]string)
+	for _, table := range desired.Tables {
+		tableID := p4Info.tableId(table)
+		if tableID == invalidID {
+			return nil, fmt.Errorf("table %s not found", table)
+		}
+		tableIDs[tableID] = table
+	}
+	tablesWithDefaultEntry := make(map[uint32]bool)
+	for _, entry := range desired.TableEntries {
+		if _, ok := tableIDs[entry.TableId]; !ok {
+			return nil, fmt.Errorf("desired table entry belongs to table %d which is not being reconciled", entry.TableId)
+		}
+		if entry.IsDefaultAction {
+			tablesWithDefaultEntry[entry.TableId] = true
+		}
+		if err := addDesired(desiredTables, tableEntryToEntity(entry)); err != nil {
+			return nil, err
+		}
+	}
+
+	actionProfileIDs := make(map[uint32]string)
+	for _, actionProfile := range desired.ActionProfiles {
+		actionProfileID := p4Info.actionProfileId(actionProfile)
+		if actionProfileID == invalidID {
+			return nil, fmt.Errorf("action profile %s not found", actionProfile)
+		}
+		actionProfileIDs[actionProfileID] = actionProfile
+	}
+	for _, member := range desired.ActionProfileMembers {
+		if _, ok := actionProfileIDs[member.ActionProfileId]; !ok {
+			return nil, fmt.Errorf("desired member belongs to action profile %d which is not being reconciled", member.ActionProfileId)
+		}
+		if err := addDesired(desiredMembers, actionProfileMemberToEntity(member)); err != nil {
+			return nil, err
+		}
+	}
+	for _, group := range desired.ActionProfileGroups {
+		if _, ok := actionProfileIDs[group.ActionProfileId]; !ok {
+			return nil, fmt.Errorf("desired group belongs to action profile %d which is not being reconciled", group.ActionProfileId)
+		}
+		if err := addDesired(desiredGroups, actionProfileGroupToEntity(group)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, group := range desired.MulticastGroups {
+		if !desired.ReconcileMulticastGroups {
+			return nil, fmt.Errorf("desired state includes multicast groups but ReconcileMulticastGroups is not set")
+		}
+		if err := addDesired(desiredPRE, multicastGroupToEntity(group)); err != nil {
+			return nil, err
+		}
+	}
+	for _, session := range desired.CloneSessions {
+		if !desired.ReconcileCloneSessions {
+			return nil, fmt.Errorf("desired state includes clone sessions but ReconcileCloneSessions is not set")
+		}
+		if err := addDesired(desiredPRE, cloneSessionToEntity(session)); err != nil {
+			return nil, err
+		}
+	}
+
+	// actual state
+
+	for _, table := range desired.Tables {
+		entries, err := c.ReadTableEntryWildcard(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		tableID := p4Info.tableId(table)
+		if tablesWithDefaultEntry[tableID] {
+			defaultEntity, err := c.ReadEntitySingle(ctx, tableEntryToEntity(&p4_v1.TableEntry{
+				TableId:         tableID,
+				IsDefaultAction: true,
+			}))
+			if err != nil {
+				return nil, fmt.Errorf("error when reading default entry for table %s: %v", table, err)
+			}
+			if defaultEntry := defaultEntity.GetTableEntry(); defaultEntry != nil {
+				entries = append(entries, defaultEntry)
+			}
+		}
+		for _, entry := range entries {
+			if err := addActual(actualTables, tableEntryToEntity(entry)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, actionProfile := range desired.ActionProfiles {
+		members, err := c.ReadActionProfileMemberWildcard(ctx, actionProfile)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if err := addActual(actualMembers, actionProfileMemberToEntity(member)); err != nil {
+				return nil, err
+			}
+		}
+		groups, err := c.ReadActionProfileGroupWildcard(ctx, actionProfile)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			if err := addActual(actualGroups, actionProfileGroupToEntity(group)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if desired.ReconcileMulticastGroups {
+		groups, err := c.ReadMulticastGroupWildcard(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			if err := addActual(actualPRE, multicastGroupToEntity(group)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if desired.ReconcileCloneSessions {
+		sessions, err := c.ReadCloneSessionWildcard(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, session := range sessions {
+			if err := addActual(actualPRE, cloneSessionToEntity(session)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// diff and apply
+
+	tablesDiff := diffEntitySets(desiredTables, actualTables)
+	membersDiff := diffEntitySets(desiredMembers, actualMembers)
+	groupsDiff := diffEntitySets(desiredGroups, actualGroups)
+	preDiff := diffEntitySets(desiredPRE, actualPRE)
+
+	report := &ReconcileReport{}
+	type stage struct {
+		updateType p4_v1.Update_Type
+		entities   []*p4_v1.Entity
+	}
+	// table entries are deleted first, as they may refer to members and groups
+	// which are about to be deleted, and inserted last, as they may refer to
+	// members and groups which are about to be inserted.
+	stages := []stage{
+		{p4_v1.Update_DELETE, tablesDiff.deletes},
+		{p4_v1.Update_INSERT, membersDiff.inserts},
+		{p4_v1.Update_MODIFY, membersDiff.modifies},
+		{p4_v1.Update_INSERT, groupsDiff.inserts},
+		{p4_v1.Update_MODIFY, groupsDiff.modifies},
+		{p4_v1.Update_INSERT, preDiff.inserts},
+		{p4_v1.Update_MODIFY, preDiff.modifies},
+		{p4_v1.Update_INSERT, tablesDiff.inserts},
+		{p4_v1.Update_MODIFY, tablesDiff.modifies},
+		{p4_v1.Update_DELETE, groupsDiff.deletes},
+		{p4_v1.Update_DELETE, membersDiff.deletes},
+		{p4_v1.Update_DELETE, preDiff.deletes},
+	}
+	for _, s := range stages {
+		if len(s.entities) == 0 {
+			continue
+		}
+		if !options.DryRun {
+			updates := make([]*p4_v1.Update, 0, len(s.entities))
+			for _, entity := range s.entities {
+				updates = append(updates, &p4_v1.Update{Type: s.updateType, Entity: entity})
+			}
+			if err := c.WriteUpdates(ctx, updates); err != nil {
+				return report, fmt.Errorf("error when applying %v updates: %v", s.updateType, err)
+			}
+		}
+		switch s.updateType {
+		case p4_v1.Update_INSERT:
+			report.Inserted = append(report.Inserted, s.entities...)
+		case p4_v1.Update_MODIFY:
+			report.Modified = append(report.Modified, s.entities...)
+		case p4_v1.Update_DELETE:
+			report.Deleted = append(report.Deleted, s.entities...)
+		}
+	}
+	return report, nil
+}
+
+func tableEntryToEntity(entry *p4_v1.TableEntry) *p4_v1.Entity {
+	return &p4_v1.Entity{
+		Entity: &p4_v1.Entity_TableEntry{TableEntry: entry},
+	}
+}
+
+func actionProfileMemberToEntity(member *p4_v1.ActionProfileMember) *p4_v1.Entity {
+	return &p4_v1.Entity{
+		Entity: &p4_v1.Entity_ActionProfileMember{ActionProfileMember: member},
+	}
+}
+
+func actionProfileGroupToEntity(group *p4_v1.ActionProfileGroup) *p4_v1.Entity {
+	return &p4_v1.Entity{
+		Entity: &p4_v1.Entity_ActionProfileGroup{ActionProfileGroup: group},
+	}
+}
+
+func multicastGroupToEntity(group *p4_v1.MulticastGroupEntry) *p4_v1.Entity {
+	return &p4_v1.Entity{
+		Entity: &p4_v1.Entity_PacketReplicationEngineEntry{
+			PacketReplicationEngineEntry: &p4_v1.PacketReplicationEngineEntry{
+				Type: &p4_v1.PacketReplicationEngineEntry_MulticastGroupEntry{MulticastGroupEntry: group},
+			},
+		},
+	}
+}
+
+func cloneSessionToEntity(session *p4_v1.CloneSessionEntry) *p4_v1.Entity {
+	return &p4_v1.Entity{
+		Entity: &p4_v1.Entity_PacketReplicationEngineEntry{
+			PacketReplicationEngineEntry: &p4_v1.PacketReplicationEngineEntry{
+				Type: &p4_v1.PacketReplicationEngineEntry_CloneSessionEntry{CloneSessionEntry: session},
+			},
+		},
+	}
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+func newReconcileTestP4Info() *p4_config_v1.P4Info {
+	return &p4_config_v1.P4Info{
+		Tables: []*p4_config_v1.Table{
+			{
+				Preamble: &p4_config_v1.Preamble{Name: "t", Id: 1},
+				MatchFields: []*p4_config_v1.MatchField{
+					{Id: 1, Name: "f", Bitwidth: 16},
+				},
+			},
+		},
+		Actions: []*p4_config_v1.Action{
+			{
+				Preamble: &p4_config_v1.Preamble{Name: "a", Id: 10},
+				Params: []*p4_config_v1.Action_Param{
+					{Id: 1, Name: "p", Bitwidth: 16},
+				},
+			},
+		},
+		ActionProfiles: []*p4_config_v1.ActionProfile{
+			{
+				Preamble: &p4_config_v1.Preamble{Name: "ap", Id: 100},
+			},
+		},
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	p4Info := newReconcileTestP4Info()
+	var c *Client
+	newEntry := func(key byte, param byte) *p4_v1.TableEntry {
+		return c.NewTableEntry(
+			"t",
+			map[string]MatchInterface{"f": &ExactMatch{Value: []byte{0, key}}},
+			c.NewTableActionDirect("a", [][]byte{{0, param}}),
+			nil,
+		)
+	}
+	newMember := func(memberID uint32) *p4_v1.ActionProfileMember {
+		return c.NewActionProfileMember("ap", memberID, "a", [][]byte{{byte(memberID)}})
+	}
+
+	var writes []*p4_v1.WriteRequest
+	p4RtClient := &fakeP4RuntimeClient{
+		readFn: func(ctx context.Context, in *p4_v1.ReadRequest, opts ...grpc.CallOption) (p4_v1.P4Runtime_ReadClient, error) {
+			var entities []*p4_v1.Entity
+			switch in.Entities[0].Entity.(type) {
+			case *p4_v1.Entity_TableEntry:
+				for _, entry := range []*p4_v1.TableEntry{newEntry(1, 1), newEntry(2, 2), newEntry(3, 3)} {
+					entities = append(entities, tableEntryToEntity(entry))
+				}
+			case *p4_v1.Entity_ActionProfileMember:
+				entities = append(entities, actionProfileMemberToEntity(newMember(1)))
+			case *p4_v1.Entity_ActionProfileGroup:
+			}
+			done := false
+			return &fakeP4RuntimeReadClient{
+				recvFn: func() (*p4_v1.ReadResponse, error) {
+					if done {
+						return nil, io.EOF
+					}
+					done = true
+					return &p4_v1.ReadResponse{Entities: entities}, nil
+				},
+			}, nil
+		},
+		writeFn: func(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+			writes = append(writes, in)
+			return &p4_v1.WriteResponse{}, nil
+		},
+	}
+	c = newTestClient(p4RtClient, p4Info)
+
+	// the first entry is unchanged but uses a non-canonical action parameter
+	unchangedEntry := newEntry(1, 1)
+	unchangedEntry.Action.GetAction().Params[0].Value = []byte{0, 0, 1}
+	desired := &DesiredState{
+		Tables:               []string{"t"},
+		TableEntries:         []*p4_v1.TableEntry{unchangedEntry, newEntry(2, 5), newEntry(4, 4)},
+		ActionProfiles:       []string{"ap"},
+		ActionProfileMembers: []*p4_v1.ActionProfileMember{newMember(1), newMember(2)},
+	}
+
+	report, err := c.Reconcile(context.Background(), desired, ReconcileOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Empty(t, writes)
+	assert.Len(t, report.Inserted, 2)
+	assert.Len(t, report.Modified, 1)
+	assert.Len(t, report.Deleted, 1)
+
+	report, err = c.Reconcile(context.Background(), desired, DefaultReconcileOptions)
+	require.NoError(t, err)
+	assert.Len(t, report.Inserted, 2)
+	assert.Len(t, report.Modified, 1)
+	assert.Len(t, report.Deleted, 1)
+
+	require.Len(t, writes, 4)
+	// delete table entries first, then insert members, then update table entries
+	assert.Equal(t, p4_v1.Update_DELETE, writes[0].Updates[0].Type)
+	assert.Equal(t, []byte{3}, writes[0].Updates[0].Entity.GetTableEntry().Match[0].GetExact().Value)
+	assert.Equal(t, p4_v1.Update_INSERT, writes[1].Updates[0].Type)
+	assert.Equal(t, uint32(2), writes[1].Updates[0].Entity.GetActionProfileMember().MemberId)
+	assert.Equal(t, p4_v1.Update_INSERT, writes[2].Updates[0].Type)
+	assert.Equal(t, []byte{4}, writes[2].Updates[0].Entity.GetTableEntry().Match[0].GetExact().Value)
+	assert.Equal(t, p4_v1.Update_MODIFY, writes[3].Updates[0].Type)
+	assert.Equal(t, []byte{2}, writes[3].Updates[0].Entity.GetTableEntry().Match[0].GetExact().Value)
+
+	_, err = c.Reconcile(context.Background(), &DesiredState{
+		TableEntries: []*p4_v1.TableEntry{newEntry(1, 1)},
+	}, DefaultReconcileOptions)
+	assert.Error(t, err, "table entries must belong to a reconciled table")
+}
@@ -0,0 +1,259 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+const (
+	defaultRetryMaxAttempts    = 5
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 2 * time.Second
+)
+
+// RetryPolicy determines how the Client retries Write and Read RPCs which fail
+// with a transient error.
+//
+// Because the outcome of a failed Write is not always known, only the updates
+// which did not succeed are retried, based on the per-update error details
+// (see WriteErrorDetails) when the server provides them, and all the updates
+// otherwise. When a retried INSERT fails with ALREADY_EXISTS, the Client reads
+// the entity, and considers the INSERT successful if the entity matches what
+// was written. Likewise, a retried DELETE which fails with NOT_FOUND is
+// considered successful.
+//
+// A Read is only retried if the server did not send any response yet, as the
+// entities are delivered to the caller as they are received.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts for a request, including
+	// the first one.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, which is doubled
+	// for each following retry, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Codes are the gRPC status codes which are retried. If empty,
+	// UNAVAILABLE and DEADLINE_EXCEEDED are retried.
+	Codes []codes.Code
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    defaultRetryMaxAttempts,
+	InitialBackoff: defaultRetryInitialBackoff,
+	MaxBackoff:     defaultRetryMaxBackoff,
+}
+
+// WithRetryPolicy returns a ClientOptions modifier which enables retries for
+// Write and Read RPCs, according to the provided policy.
+func WithRetryPolicy(policy RetryPolicy) func(*ClientOptions) {
+	return func(options *ClientOptions) {
+		options.RetryPolicy = &policy
+	}
+}
+
+func (p *RetryPolicy) retryable(code codes.Code) bool {
+	if len(p.Codes) == 0 {
+		return code == codes.Unavailable || code == codes.DeadlineExceeded
+	}
+	for _, c := range p.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return backoff
+}
+
+// canRetry returns true if a request which failed with code after the provided
+// number of attempts should be retried.
+func (c *Client) canRetry(ctx context.Context, attempt int, code codes.Code) bool {
+	policy := c.RetryPolicy
+	return policy != nil && attempt < policy.MaxAttempts && policy.retryable(code) && ctx.Err() == nil
+}
+
+// waitRetry waits before the next attempt, and returns false if ctx is done
+// first.
+func (c *Client) waitRetry(ctx context.Context, attempt int) bool {
+	timer := time.NewTimer(c.RetryPolicy.backoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// writeWithRetries writes the updates, retrying the ones which fail with a
+// retryable error according to the RetryPolicy.
+func (c *Client) writeWithRetries(ctx context.Context, updates []*p4_v1.Update) error {
+	// nil until the outcome of the corresponding update is known
+	results := make([]*p4_v1.Error, len(updates))
+	// true for the updates which may have been applied by a previous attempt
+	uncertain := make([]bool, len(updates))
+	pending := make([]int, len(updates))
+	for idx := range updates {
+		pending[idx] = idx
+	}
+	for attempt := 1; ; attempt++ {
+		batch := make([]*p4_v1.Update, len(pending))
+		for i, idx := range pending {
+			batch[i] = updates[idx]
+		}
+		err := c.writeOnce(ctx, batch)
+		if err == nil {
+			if attempt == 1 {
+				return nil
+			}
+			for _, idx := range pending {
+				results[idx] = &p4_v1.Error{CanonicalCode: int32(codes.OK)}
+			}
+			break
+		}
+		details := WriteErrorDetails(err)
+		if len(details) != len(batch) {
+			// the outcome is the same for all the updates
+			st, _ := status.FromError(err)
+			p4Error := &p4_v1.Error{CanonicalCode: int32(st.Code()), Message: st.Message()}
+			details = make([]*p4_v1.Error, len(batch))
+			for i := range details {
+				details[i] = p4Error
+			}
+		}
+		var retry []int
+		for i, idx := range pending {
+			code := codes.Code(details[i].CanonicalCode)
+			switch {
+			case code == codes.OK:
+				results[idx] = details[i]
+			case uncertain[idx] && c.alreadyApplied(ctx, updates[idx], code):
+				c.log.Debug("Retried update was already applied", "update", idx, "code", code)
+				results[idx] = &p4_v1.Error{CanonicalCode: int32(codes.OK)}
+			case c.canRetry(ctx, attempt, code):
+				uncertain[idx] = true
+				retry = append(retry, idx)
+			default:
+				results[idx] = details[i]
+			}
+		}
+		if len(retry) == 0 {
+			if attempt == 1 {
+				return err
+			}
+			break
+		}
+		c.log.Info("Retrying write", "attempt", attempt+1, "updates", len(retry), "error", err)
+		if !c.waitRetry(ctx, attempt) {
+			for _, idx := range retry {
+				st := status.FromContextError(ctx.Err())
+				results[idx] = &p4_v1.Error{CanonicalCode: int32(st.Code()), Message: st.Message()}
+			}
+			break
+		}
+		pending = retry
+	}
+	return writeResultsError(results)
+}
+
+// writeOnce sends the updates in a single WriteRequest.
+func (c *Client) writeOnce(ctx context.Context, updates []*p4_v1.Update) error {
+	req := &p4_v1.WriteRequest{
+		DeviceId:   c.deviceID,
+		ElectionId: c.ElectionID(),
+		Updates:    updates,
+	}
+	if c.role != nil {
+		req.Role = c.role.Name
+	}
+	start := time.Now()
+	_, err := c.Write(ctx, req)
+	c.observeWrite(updates, start, err)
+	return err
+}
+
+// alreadyApplied returns true if an update which may have been applied by a
+// previous attempt failed with code because it was indeed applied.
+func (c *Client) alreadyApplied(ctx context.Context, update *p4_v1.Update, code codes.Code) bool {
+	switch {
+	case update.Type == p4_v1.Update_DELETE && code == codes.NotFound:
+		return true
+	case update.Type == p4_v1.Update_INSERT && code == codes.AlreadyExists:
+		key := entityReadKey(update.Entity)
+		if key == nil {
+			return false
+		}
+		entities, err := c.readEntitiesAll(ctx, key)
+		if err != nil || len(entities) != 1 {
+			return false
+		}
+		return sameEntityValue(normalizeEntity(update.Entity), normalizeEntity(entities[0]))
+	}
+	return false
+}
+
+// entityReadKey returns an entity which can be used to read the provided
+// entity, or nil for entities which cannot be inserted.
+func entityReadKey(entity *p4_v1.Entity) *p4_v1.Entity {
+	switch e := entity.Entity.(type) {
+	case *p4_v1.Entity_TableEntry:
+		return tableEntryToEntity(tableEntryKey(e.TableEntry))
+	case *p4_v1.Entity_ActionProfileMember:
+		return actionProfileMemberToEntity(&p4_v1.ActionProfileMember{
+			ActionProfileId: e.ActionProfileMember.ActionProfileId,
+			MemberId:        e.ActionProfileMember.MemberId,
+		})
+	case *p4_v1.Entity_ActionProfileGroup:
+		return actionProfileGroupToEntity(&p4_v1.ActionProfileGroup{
+			ActionProfileId: e.ActionProfileGroup.ActionProfileId,
+			GroupId:         e.ActionProfileGroup.GroupId,
+		})
+	case *p4_v1.Entity_PacketReplicationEngineEntry:
+		switch pre := e.PacketReplicationEngineEntry.Type.(type) {
+		case *p4_v1.PacketReplicationEngineEntry_MulticastGroupEntry:
+			return multicastGroupToEntity(&p4_v1.MulticastGroupEntry{MulticastGroupId: pre.MulticastGroupEntry.MulticastGroupId})
+		case *p4_v1.PacketReplicationEngineEntry_CloneSessionEntry:
+			return cloneSessionToEntity(&p4_v1.CloneSessionEntry{SessionId: pre.CloneSessionEntry.SessionId})
+		}
+	}
+	return nil
+}
+
+// writeResultsError builds the error for a write which was retried, in the
+// same format as the errors returned by P4Runtime servers: if at least one of
+// the updates failed, the status includes one p4.v1.Error for each update.
+func writeResultsError(results []*p4_v1.Error) error {
+	failed := 0
+	for _, result := range results {
+		if result.CanonicalCode != int32(codes.OK) {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	st := status.New(codes.Unknown, fmt.Sprintf("write failure: %d update(s) failed after retries", failed))
+	for _, result := range results {
+		stWithDetails, err := st.WithDetails(result)
+		if err != nil {
+			return st.Err()
+		}
+		st = stWithDetails
+	}
+	return st.Err()
+}
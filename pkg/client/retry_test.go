@@ -0,0 +1,297 @@
+package client
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/fakeserver"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     10 * time.Millisecond,
+}
+
+func writeStatusError(t *testing.T, updateCodes ...codes.Code) error {
+	st := status.New(codes.Unknown, "batch failed")
+	for _, code := range updateCodes {
+		var err error
+		st, err = st.WithDetails(&p4_v1.Error{CanonicalCode: int32(code)})
+		require.NoError(t, err)
+	}
+	return st.Err()
+}
+
+func readResponses(entities ...*p4_v1.Entity) p4_v1.P4Runtime_ReadClient {
+	done := false
+	return &fakeP4RuntimeReadClient{
+		recvFn: func() (*p4_v1.ReadResponse, error) {
+			if done {
+				return nil, io.EOF
+			}
+			done = true
+			return &p4_v1.ReadResponse{Entities: entities}, nil
+		},
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	assert.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 300*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, 300*time.Millisecond, policy.backoff(10))
+	assert.True(t, policy.retryable(codes.Unavailable))
+	assert.True(t, policy.retryable(codes.DeadlineExceeded))
+	assert.False(t, policy.retryable(codes.InvalidArgument))
+	policy.Codes = []codes.Code{codes.ResourceExhausted}
+	assert.False(t, policy.retryable(codes.Unavailable))
+	assert.True(t, policy.retryable(codes.ResourceExhausted))
+}
+
+func TestWriteRetries(t *testing.T) {
+	ctx := context.Background()
+	p4Info := newReconcileTestP4Info()
+	var c *Client
+	newEntry := func(key byte, param byte) *p4_v1.TableEntry {
+		return c.NewTableEntry(
+			"t",
+			map[string]MatchInterface{"f": &ExactMatch{Value: []byte{0, key}}},
+			c.NewTableActionDirect("a", [][]byte{{0, param}}),
+			nil,
+		)
+	}
+	insert := func(entry *p4_v1.TableEntry) *p4_v1.Update {
+		return &p4_v1.Update{Type: p4_v1.Update_INSERT, Entity: tableEntryToEntity(entry)}
+	}
+
+	var writes []*p4_v1.WriteRequest
+	var writeErrs []error
+	var readEntities []*p4_v1.Entity
+	p4RtClient := &fakeP4RuntimeClient{
+		writeFn: func(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+			writes = append(writes, in)
+			var err error
+			if len(writeErrs) > 0 {
+				err, writeErrs = writeErrs[0], writeErrs[1:]
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &p4_v1.WriteResponse{}, nil
+		},
+		readFn: func(ctx context.Context, in *p4_v1.ReadRequest, opts ...grpc.CallOption) (p4_v1.P4Runtime_ReadClient, error) {
+			return readResponses(readEntities...), nil
+		},
+	}
+	newClient := func(policy *RetryPolicy) *Client {
+		c = newTestClient(p4RtClient, p4Info)
+		c.RetryPolicy = policy
+		writes = nil
+		return c
+	}
+
+	t.Run("no retry policy", func(t *testing.T) {
+		c := newClient(nil)
+		writeErrs = []error{status.Error(codes.Unavailable, "unavailable")}
+		err := c.InsertTableEntry(ctx, newEntry(1, 1))
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Len(t, writes, 1)
+	})
+
+	t.Run("transient errors", func(t *testing.T) {
+		c := newClient(&testRetryPolicy)
+		writeErrs = []error{status.Error(codes.Unavailable, "unavailable"), status.Error(codes.DeadlineExceeded, "timeout")}
+		assert.NoError(t, c.InsertTableEntry(ctx, newEntry(1, 1)))
+		assert.Len(t, writes, 3)
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		c := newClient(&testRetryPolicy)
+		unavailable := status.Error(codes.Unavailable, "unavailable")
+		writeErrs = []error{unavailable, unavailable, unavailable, unavailable}
+		err := c.InsertTableEntry(ctx, newEntry(1, 1))
+		details := WriteErrorDetails(err)
+		require.Len(t, details, 1)
+		assert.Equal(t, int32(codes.Unavailable), details[0].CanonicalCode)
+		assert.Len(t, writes, 3)
+		writeErrs = nil
+	})
+
+	t.Run("non-retryable error", func(t *testing.T) {
+		c := newClient(&testRetryPolicy)
+		writeErrs = []error{status.Error(codes.InvalidArgument, "invalid")}
+		err := c.InsertTableEntry(ctx, newEntry(1, 1))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Len(t, writes, 1)
+	})
+
+	t.Run("already exists after retry", func(t *testing.T) {
+		c := newClient(&testRetryPolicy)
+		writeErrs = []error{status.Error(codes.Unavailable, "unavailable"), writeStatusError(t, codes.AlreadyExists)}
+		readEntities = []*p4_v1.Entity{tableEntryToEntity(newEntry(1, 1))}
+		assert.NoError(t, c.InsertTableEntry(ctx, newEntry(1, 1)))
+		assert.Len(t, writes, 2)
+
+		// the existing entry does not match what was written
+		writeErrs = []error{status.Error(codes.Unavailable, "unavailable"), writeStatusError(t, codes.AlreadyExists)}
+		readEntities = []*p4_v1.Entity{tableEntryToEntity(newEntry(1, 2))}
+		err := c.InsertTableEntry(ctx, newEntry(1, 1))
+		details := WriteErrorDetails(err)
+		require.Len(t, details, 1)
+		assert.Equal(t, int32(codes.AlreadyExists), details[0].CanonicalCode)
+	})
+
+	t.Run("already exists on first attempt", func(t *testing.T) {
+		c := newClient(&testRetryPolicy)
+		writeErrs = []error{writeStatusError(t, codes.AlreadyExists)}
+		readEntities = []*p4_v1.Entity{tableEntryToEntity(newEntry(1, 1))}
+		assert.Error(t, c.InsertTableEntry(ctx, newEntry(1, 1)))
+		assert.Len(t, writes, 1)
+	})
+
+	t.Run("not found after retry", func(t *testing.T) {
+		c := newClient(&testRetryPolicy)
+		writeErrs = []error{status.Error(codes.Unavailable, "unavailable"), writeStatusError(t, codes.NotFound)}
+		assert.NoError(t, c.DeleteTableEntry(ctx, newEntry(1, 1)))
+		assert.Len(t, writes, 2)
+	})
+
+	t.Run("partial batch failure", func(t *testing.T) {
+		c := newClient(&testRetryPolicy)
+		writeErrs = []error{writeStatusError(t, codes.OK, codes.Unavailable, codes.InvalidArgument)}
+		updates := []*p4_v1.Update{insert(newEntry(1, 1)), insert(newEntry(2, 2)), insert(newEntry(3, 3))}
+		err := c.WriteUpdates(ctx, updates)
+		require.Len(t, writes, 2)
+		// only the update which failed with a transient error is retried
+		require.Len(t, writes[1].Updates, 1)
+		assert.Equal(t, updates[1], writes[1].Updates[0])
+		details := WriteErrorDetails(err)
+		require.Len(t, details, 3)
+		assert.Equal(t, int32(codes.OK), details[0].CanonicalCode)
+		assert.Equal(t, int32(codes.OK), details[1].CanonicalCode)
+		assert.Equal(t, int32(codes.InvalidArgument), details[2].CanonicalCode)
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		c := newClient(&RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour})
+		writeErrs = []error{status.Error(codes.Unavailable, "unavailable")}
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		err := c.InsertTableEntry(ctx, newEntry(1, 1))
+		details := WriteErrorDetails(err)
+		require.Len(t, details, 1)
+		assert.Equal(t, int32(codes.DeadlineExceeded), details[0].CanonicalCode)
+		assert.Len(t, writes, 1)
+	})
+}
+
+func TestReadRetries(t *testing.T) {
+	ctx := context.Background()
+	var reads int
+	var readErrs []error
+	entity := tableEntryToEntity(&p4_v1.TableEntry{TableId: 1})
+	p4RtClient := &fakeP4RuntimeClient{
+		readFn: func(ctx context.Context, in *p4_v1.ReadRequest, opts ...grpc.CallOption) (p4_v1.P4Runtime_ReadClient, error) {
+			reads++
+			if len(readErrs) > 0 {
+				err := readErrs[0]
+				readErrs = readErrs[1:]
+				return nil, err
+			}
+			return readResponses(entity), nil
+		},
+	}
+	c := newTestClient(p4RtClient, newReconcileTestP4Info())
+	c.RetryPolicy = &testRetryPolicy
+
+	readErrs = []error{status.Error(codes.Unavailable, "unavailable")}
+	readEntity, err := c.ReadEntitySingle(ctx, entity)
+	require.NoError(t, err)
+	assert.Equal(t, entity, readEntity)
+	assert.Equal(t, 2, reads)
+
+	reads = 0
+	readErrs = []error{status.Error(codes.PermissionDenied, "denied")}
+	_, err = c.ReadEntitySingle(ctx, entity)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, 1, reads)
+}
+
+func TestWriteRetriesLostResponse(t *testing.T) {
+	ctx := context.Background()
+	p4Info := newReconcileTestP4Info()
+	p4Info.Tables[0].MatchFields[0].Match = &p4_config_v1.MatchField_MatchType_{MatchType: p4_config_v1.MatchField_EXACT}
+	p4Info.Tables[0].ActionRefs = []*p4_config_v1.ActionRef{{Id: p4Info.Actions[0].Preamble.Id}}
+	s := fakeserver.NewServer(1, p4Info)
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c := newFakeServerClient(t, s, 1, false, WithRetryPolicy(testRetryPolicy))
+	defer c.Close()
+	arbitrationCh := make(chan bool, 10)
+	require.NoError(t, c.Start(ctx, arbitrationCh, nil))
+	assert.True(t, waitPrimaryStatus(t, arbitrationCh))
+	c.SetP4Info(p4Info)
+
+	entry := c.NewTableEntry(
+		"t",
+		map[string]MatchInterface{"f": &ExactMatch{Value: []byte{0, 1}}},
+		c.NewTableActionDirect("a", [][]byte{{0, 1}}),
+		nil,
+	)
+	// the first attempt is applied, but the client receives an error
+	lostResponse := &fakeserver.FaultPlan{
+		WriteFaults: []fakeserver.WriteFault{{Every: 1, Count: 1, Applied: true}},
+	}
+	s.SetFaultPlan(lostResponse)
+	require.NoError(t, c.InsertTableEntry(ctx, entry))
+	assert.Len(t, s.TableEntries(1), 1)
+	s.SetFaultPlan(lostResponse)
+	require.NoError(t, c.DeleteTableEntry(ctx, entry))
+	assert.Empty(t, s.TableEntries(1))
+}
+
+func TestReadRetriesPartialResponse(t *testing.T) {
+	ctx := context.Background()
+	p4Info := newReconcileTestP4Info()
+	p4Info.Counters = []*p4_config_v1.Counter{{Preamble: &p4_config_v1.Preamble{Name: "c", Id: 200}, Size: 4}}
+	s := fakeserver.NewServer(1, p4Info)
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c := newFakeServerClient(t, s, 1, false, WithRetryPolicy(testRetryPolicy))
+	defer c.Close()
+	c.SetP4Info(p4Info)
+
+	// no entity was received, the read is retried
+	s.SetFaultPlan(&fakeserver.FaultPlan{
+		ReadFaults: []fakeserver.ReadFault{{AfterEntities: 0, Count: 1}},
+	})
+	data, err := c.ReadCounterEntryWildcard(ctx, "c")
+	require.NoError(t, err)
+	assert.Len(t, data, 4)
+
+	// some entities were already delivered to the caller, the read fails
+	s.SetFaultPlan(&fakeserver.FaultPlan{
+		ReadFaults: []fakeserver.ReadFault{{AfterEntities: 2, Count: 1}},
+	})
+	_, err = c.ReadCounterEntryWildcard(ctx, "c")
+	assert.ErrorContains(t, err, "injected fault after 2 entities")
+	// the fault only applied to the first read
+	data, err = c.ReadCounterEntryWildcard(ctx, "c")
+	require.NoError(t, err)
+	assert.Len(t, data, 4)
+}
@@ -0,0 +1,304 @@
+package client
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// P4RoleConfigTypeURL is the type URL of the Any message built by
+// RoleConfig.ToAny. The format of the role config is out-of-scope of the
+// P4Runtime specification; RoleConfig uses the wire format of the
+// P4RoleConfig message defined by Stratum.
+const P4RoleConfigTypeURL = "type.googleapis.com/stratum.P4RoleConfig"
+
+// PacketInFilter restricts the PacketIn messages received by a role to the
+// ones for which the metadata field with id MetadataID is equal to Value.
+type PacketInFilter struct {
+	MetadataID uint32
+	Value      []byte
+}
+
+// RoleConfig describes which P4 objects are in the scope of a role. When the
+// Client is created with a role which includes a RoleConfig, writes to entities
+// outside of the role are rejected by the Client with ErrOutsideRole, without
+// sending them to the server.
+type RoleConfig struct {
+	// ExclusiveP4IDs are the ids of the P4 objects (tables, action
+	// profiles, counters, meters, registers, value sets, digests, ...) which
+	// can only be written by this role.
+	ExclusiveP4IDs []uint32
+	// SharedP4IDs are the ids of the P4 objects which can be written by
+	// this role and by other roles.
+	SharedP4IDs       []uint32
+	PacketInFilter    *PacketInFilter
+	ReceivesPacketIns bool
+	CanPushPipeline   bool
+}
+
+// NewRole returns a Role for NewClientForRole. config can be nil, in which
+// case the role has full pipeline access.
+func NewRole(name string, config *RoleConfig) *p4_v1.Role {
+	role := &p4_v1.Role{Name: name}
+	if config != nil {
+		role.Config = config.ToAny()
+	}
+	return role
+}
+
+// Allows returns true if the P4 object with the provided id is in the scope of
+// the role.
+func (rc *RoleConfig) Allows(p4ID uint32) bool {
+	for _, id := range rc.ExclusiveP4IDs {
+		if id == p4ID {
+			return true
+		}
+	}
+	for _, id := range rc.SharedP4IDs {
+		if id == p4ID {
+			return true
+		}
+	}
+	return false
+}
+
+// ToAny encodes the RoleConfig for the config field of p4_v1.Role.
+func (rc *RoleConfig) ToAny() *anypb.Any {
+	var b []byte
+	appendPacked := func(num protowire.Number, ids []uint32) {
+		if len(ids) == 0 {
+			return
+		}
+		var packed []byte
+		for _, id := range ids {
+			packed = protowire.AppendVarint(packed, uint64(id))
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, packed)
+	}
+	appendPacked(1, rc.ExclusiveP4IDs)
+	appendPacked(2, rc.SharedP4IDs)
+	if rc.PacketInFilter != nil {
+		var filter []byte
+		if rc.PacketInFilter.MetadataID != 0 {
+			filter = protowire.AppendTag(filter, 1, protowire.VarintType)
+			filter = protowire.AppendVarint(filter, uint64(rc.PacketInFilter.MetadataID))
+		}
+		if len(rc.PacketInFilter.Value) > 0 {
+			filter = protowire.AppendTag(filter, 2, protowire.BytesType)
+			filter = protowire.AppendBytes(filter, rc.PacketInFilter.Value)
+		}
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, filter)
+	}
+	appendBool := func(num protowire.Number, v bool) {
+		if v {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, 1)
+		}
+	}
+	appendBool(4, rc.ReceivesPacketIns)
+	appendBool(5, rc.CanPushPipeline)
+	return &anypb.Any{TypeUrl: P4RoleConfigTypeURL, Value: b}
+}
+
+// RoleConfigFromAny decodes a RoleConfig encoded with ToAny. Unknown fields
+// are ignored.
+func RoleConfigFromAny(a *anypb.Any) (*RoleConfig, error) {
+	if a.GetTypeUrl() != P4RoleConfigTypeURL {
+		return nil, fmt.Errorf("unexpected type URL for role config: '%s'", a.GetTypeUrl())
+	}
+	rc := &RoleConfig{}
+	err := forEachField(a.Value, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case (num == 1 || num == 2) && typ == protowire.BytesType:
+			for len(v) > 0 {
+				id, n := protowire.ConsumeVarint(v)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				rc.appendID(num, id)
+				v = v[n:]
+			}
+		case (num == 1 || num == 2) && typ == protowire.VarintType:
+			id, _ := protowire.ConsumeVarint(v)
+			rc.appendID(num, id)
+		case num == 3 && typ == protowire.BytesType:
+			rc.PacketInFilter = &PacketInFilter{}
+			return forEachField(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch {
+				case num == 1 && typ == protowire.VarintType:
+					id, _ := protowire.ConsumeVarint(v)
+					rc.PacketInFilter.MetadataID = uint32(id)
+				case num == 2 && typ == protowire.BytesType:
+					rc.PacketInFilter.Value = append([]byte(nil), v...)
+				}
+				return nil
+			})
+		case num == 4 && typ == protowire.VarintType:
+			x, _ := protowire.ConsumeVarint(v)
+			rc.ReceivesPacketIns = x != 0
+		case num == 5 && typ == protowire.VarintType:
+			x, _ := protowire.ConsumeVarint(v)
+			rc.CanPushPipeline = x != 0
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid role config: %w", err)
+	}
+	return rc, nil
+}
+
+func (rc *RoleConfig) appendID(num protowire.Number, id uint64) {
+	if num == 1 {
+		rc.ExclusiveP4IDs = append(rc.ExclusiveP4IDs, uint32(id))
+	} else {
+		rc.SharedP4IDs = append(rc.SharedP4IDs, uint32(id))
+	}
+}
+
+// forEachField calls fn for each field in b. For varint fields, v is the
+// encoded varint; for length-delimited fields, v is the content.
+func forEachField(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			v = b
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n >= 0 {
+				v = b[:n]
+			}
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if err := fn(num, typ, v); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// P4IDsByName returns the ids of the P4 objects with the provided names (or
+// aliases), e.g. to build a RoleConfig. All the P4 objects which can be
+// written are considered: tables, action profiles, counters, direct counters,
+// meters, direct meters, registers, value sets and digests.
+func P4IDsByName(p4Info *p4_config_v1.P4Info, names ...string) ([]uint32, error) {
+	var preambles []*p4_config_v1.Preamble
+	for _, t := range p4Info.GetTables() {
+		preambles = append(preambles, t.Preamble)
+	}
+	for _, ap := range p4Info.GetActionProfiles() {
+		preambles = append(preambles, ap.Preamble)
+	}
+	for _, c := range p4Info.GetCounters() {
+		preambles = append(preambles, c.Preamble)
+	}
+	for _, c := range p4Info.GetDirectCounters() {
+		preambles = append(preambles, c.Preamble)
+	}
+	for _, m := range p4Info.GetMeters() {
+		preambles = append(preambles, m.Preamble)
+	}
+	for _, m := range p4Info.GetDirectMeters() {
+		preambles = append(preambles, m.Preamble)
+	}
+	for _, r := range p4Info.GetRegisters() {
+		preambles = append(preambles, r.Preamble)
+	}
+	for _, vs := range p4Info.GetValueSets() {
+		preambles = append(preambles, vs.Preamble)
+	}
+	for _, d := range p4Info.GetDigests() {
+		preambles = append(preambles, d.Preamble)
+	}
+	ids := make([]uint32, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, preamble := range preambles {
+			if preamble.Name == name || preamble.Alias == name {
+				ids = append(ids, preamble.Id)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no P4 object named '%s'", name)
+		}
+	}
+	return ids, nil
+}
+
+// RoleConfig returns the RoleConfig of the Client's role, or nil if the Client
+// uses the default role or if the role config is not a RoleConfig.
+func (c *Client) RoleConfig() *RoleConfig {
+	return c.roleConfig
+}
+
+// entityP4ID returns the id of the P4 object the entity belongs to. ok is false
+// for entities which do not belong to a P4 object (i.e. PRE entries).
+func entityP4ID(entity *p4_v1.Entity) (id uint32, ok bool) {
+	switch e := entity.GetEntity().(type) {
+	case *p4_v1.Entity_TableEntry:
+		return e.TableEntry.TableId, true
+	case *p4_v1.Entity_ActionProfileMember:
+		return e.ActionProfileMember.ActionProfileId, true
+	case *p4_v1.Entity_ActionProfileGroup:
+		return e.ActionProfileGroup.ActionProfileId, true
+	case *p4_v1.Entity_CounterEntry:
+		return e.CounterEntry.CounterId, true
+	case *p4_v1.Entity_DirectCounterEntry:
+		return e.DirectCounterEntry.GetTableEntry().GetTableId(), true
+	case *p4_v1.Entity_MeterEntry:
+		return e.MeterEntry.MeterId, true
+	case *p4_v1.Entity_DirectMeterEntry:
+		return e.DirectMeterEntry.GetTableEntry().GetTableId(), true
+	case *p4_v1.Entity_RegisterEntry:
+		return e.RegisterEntry.RegisterId, true
+	case *p4_v1.Entity_ValueSetEntry:
+		return e.ValueSetEntry.ValueSetId, true
+	case *p4_v1.Entity_DigestEntry:
+		return e.DigestEntry.DigestId, true
+	case *p4_v1.Entity_ExternEntry:
+		return e.ExternEntry.ExternId, true
+	}
+	return 0, false
+}
+
+// checkRoleWrite returns an error wrapping ErrOutsideRole if one of the updates
+// writes an entity outside of the Client's role.
+func (c *Client) checkRoleWrite(updates []*p4_v1.Update) error {
+	if c.roleConfig == nil {
+		return nil
+	}
+	for idx, update := range updates {
+		id, ok := entityP4ID(update.Entity)
+		if ok && !c.roleConfig.Allows(id) {
+			return fmt.Errorf("%w: update %d writes P4 object %d, which is not in role '%s'", ErrOutsideRole, idx, id, c.role.Name)
+		}
+	}
+	return nil
+}
+
+// checkRolePipeline returns an error wrapping ErrOutsideRole if the Client's
+// role cannot push a forwarding pipeline config.
+func (c *Client) checkRolePipeline() error {
+	if c.roleConfig == nil || c.roleConfig.CanPushPipeline {
+		return nil
+	}
+	return fmt.Errorf("%w: role '%s' cannot push a pipeline config", ErrOutsideRole, c.role.Name)
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+func TestRoleConfigEncoding(t *testing.T) {
+	rc := &RoleConfig{
+		ExclusiveP4IDs:    []uint32{1, 300},
+		SharedP4IDs:       []uint32{100},
+		PacketInFilter:    &PacketInFilter{MetadataID: 2, Value: []byte{0xab}},
+		ReceivesPacketIns: true,
+		CanPushPipeline:   false,
+	}
+	a := rc.ToAny()
+	assert.Equal(t, P4RoleConfigTypeURL, a.TypeUrl)
+	decoded, err := RoleConfigFromAny(a)
+	require.NoError(t, err)
+	assert.Equal(t, rc, decoded)
+
+	_, err = RoleConfigFromAny(&anypb.Any{TypeUrl: "type.googleapis.com/other"})
+	assert.Error(t, err)
+	a.Value = []byte{0x0a, 0x05}
+	_, err = RoleConfigFromAny(a)
+	assert.Error(t, err)
+}
+
+func TestP4IDsByName(t *testing.T) {
+	p4Info := newReconcileTestP4Info()
+	ids, err := P4IDsByName(p4Info, "ap", "t")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{100, 1}, ids)
+	_, err = P4IDsByName(p4Info, "a")
+	assert.Error(t, err)
+}
+
+func TestRoleEnforcement(t *testing.T) {
+	ctx := context.Background()
+	writes := 0
+	p4RtClient := &fakeP4RuntimeClient{
+		writeFn: func(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+			writes++
+			assert.Equal(t, "r", in.Role)
+			return &p4_v1.WriteResponse{}, nil
+		},
+	}
+	p4Info := newReconcileTestP4Info()
+	ids, err := P4IDsByName(p4Info, "t")
+	require.NoError(t, err)
+	role := NewRole("r", &RoleConfig{SharedP4IDs: ids})
+	c := NewClientForRole(p4RtClient, 1, &p4_v1.Uint128{High: 0, Low: 1}, role)
+	c.SetP4Info(p4Info)
+	require.NotNil(t, c.RoleConfig())
+
+	entry := c.NewTableEntry("t", map[string]MatchInterface{"f": &ExactMatch{Value: []byte{1}}}, c.NewTableActionDirect("a", [][]byte{{1}}), nil)
+	assert.NoError(t, c.InsertTableEntry(ctx, entry))
+	assert.Equal(t, 1, writes)
+
+	err = c.InsertActionProfileMember(ctx, c.NewActionProfileMember("ap", 1, "a", [][]byte{{1}}))
+	assert.ErrorIs(t, err, ErrOutsideRole)
+	assert.Equal(t, 1, writes)
+
+	_, err = c.SetFwdPipeFromP4Info(ctx, nil, p4Info, 1)
+	assert.ErrorIs(t, err, ErrOutsideRole)
+}
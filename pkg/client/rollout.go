@@ -0,0 +1,208 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+const defaultRolloutRollbackTimeout = 30 * time.Second
+
+type RolloutOptions struct {
+	// MaxConcurrency is the maximum number of devices which are being
+	// configured at the same time. 0 means no limit.
+	MaxConcurrency int
+	// NoRollback disables the rollback to the previous pipeline config when a
+	// COMMIT fails on one of the devices.
+	NoRollback bool
+	// RollbackTimeout bounds the time spent rolling back the devices. The
+	// rollback is not cancelled with the context provided to RolloutFwdPipe,
+	// as the COMMIT may have failed precisely because that context was done.
+	// 0 means the default timeout (30s).
+	RollbackTimeout time.Duration
+}
+
+var DefaultRolloutOptions = RolloutOptions{
+	MaxConcurrency:  0,
+	NoRollback:      false,
+	RollbackTimeout: defaultRolloutRollbackTimeout,
+}
+
+// RolloutDeviceResult is the outcome of a rollout for a single device.
+type RolloutDeviceResult struct {
+	Client *Client
+	// Previous is the pipeline config retrieved from the device before the
+	// rollout started, if any.
+	Previous *FwdPipeConfig
+	// FetchErr, SaveErr and CommitErr are the errors encountered during each
+	// phase of the rollout.
+	FetchErr error
+	SaveErr  error
+	// Discarded is true if the config was saved on the device, but was not
+	// committed because saving it failed on another device. The pending
+	// config is discarded by the Client (see DiscardPendingFwdPipe).
+	Discarded bool
+	CommitErr error
+	Committed bool
+	// RolledBack is true if the device was committed successfully and the
+	// previous pipeline config was then restored because of a failure on
+	// another device.
+	RolledBack  bool
+	RollbackErr error
+}
+
+// RolloutReport is returned by RolloutFwdPipe. Devices are in the same order
+// as the clients provided to RolloutFwdPipe.
+type RolloutReport struct {
+	Devices []*RolloutDeviceResult
+}
+
+// Succeeded returns true if the new pipeline config was committed on all
+// devices.
+func (r *RolloutReport) Succeeded() bool {
+	for _, d := range r.Devices {
+		if !d.Committed || d.RolledBack {
+			return false
+		}
+	}
+	return true
+}
+
+// RolloutFwdPipe pushes the same pipeline config to several devices using a
+// two-phase commit. First, the current config of each device is retrieved and
+// the new config is sent with VERIFY_AND_SAVE to all devices. Only if this
+// succeeds for all of them is a COMMIT issued to each device. If any COMMIT
+// fails, the devices which were already committed are rolled back to their
+// previous config (unless options.NoRollback is set), even if ctx is done. A
+// report is always returned, even when an error is returned.
+func RolloutFwdPipe(
+	ctx context.Context,
+	clients []*Client,
+	binBytes []byte,
+	p4Info *p4_config_v1.P4Info,
+	cookie uint64,
+	options RolloutOptions,
+) (*RolloutReport, error) {
+	report := &RolloutReport{
+		Devices: make([]*RolloutDeviceResult, len(clients)),
+	}
+	for idx, c := range clients {
+		report.Devices[idx] = &RolloutDeviceResult{Client: c}
+	}
+
+	// forEachDevice calls fn for each device, with the context error if ctx
+	// is done before the device could be processed.
+	forEachDevice := func(ctx context.Context, fn func(d *RolloutDeviceResult, err error)) {
+		var wg sync.WaitGroup
+		var sem chan struct{}
+		if options.MaxConcurrency > 0 {
+			sem = make(chan struct{}, options.MaxConcurrency)
+		}
+		for _, d := range report.Devices {
+			if sem != nil {
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					fn(d, ctx.Err())
+					continue
+				}
+			}
+			wg.Add(1)
+			go func(d *RolloutDeviceResult) {
+				defer wg.Done()
+				if sem != nil {
+					defer func() { <-sem }()
+				}
+				fn(d, nil)
+			}(d)
+		}
+		wg.Wait()
+	}
+	countFailures := func(getErr func(d *RolloutDeviceResult) error) int {
+		count := 0
+		for _, d := range report.Devices {
+			if getErr(d) != nil {
+				count++
+			}
+		}
+		return count
+	}
+
+	// phase 0: retrieve the current config, needed for rollback
+	forEachDevice(ctx, func(d *RolloutDeviceResult, err error) {
+		if err == nil {
+			d.Previous, err = d.Client.GetFwdPipe(ctx, GetFwdPipeAll)
+		}
+		d.FetchErr = err
+	})
+	if n := countFailures(func(d *RolloutDeviceResult) error { return d.FetchErr }); n > 0 {
+		return report, fmt.Errorf("failed to retrieve current pipeline config from %d device(s)", n)
+	}
+
+	// phase 1: VERIFY_AND_SAVE
+	forEachDevice(ctx, func(d *RolloutDeviceResult, err error) {
+		if err == nil {
+			_, err = d.Client.SetFwdPipeFromP4InfoWithAction(ctx, binBytes, p4Info, cookie, p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_SAVE)
+		}
+		d.SaveErr = err
+	})
+	if n := countFailures(func(d *RolloutDeviceResult) error { return d.SaveErr }); n > 0 {
+		// the saved config will not be committed
+		for _, d := range report.Devices {
+			if d.SaveErr == nil {
+				d.Client.DiscardPendingFwdPipe()
+				d.Discarded = true
+			}
+		}
+		return report, fmt.Errorf("failed to save pipeline config on %d device(s), no device was committed", n)
+	}
+
+	// phase 2: COMMIT
+	forEachDevice(ctx, func(d *RolloutDeviceResult, err error) {
+		if err == nil {
+			_, err = d.Client.CommitFwdPipe(ctx)
+		}
+		d.CommitErr = err
+		d.Committed = (err == nil)
+	})
+	numCommitFailures := countFailures(func(d *RolloutDeviceResult) error { return d.CommitErr })
+	if numCommitFailures == 0 {
+		return report, nil
+	}
+	if options.NoRollback {
+		return report, fmt.Errorf("failed to commit pipeline config on %d device(s)", numCommitFailures)
+	}
+
+	// rollback the devices which were committed, even if ctx is done
+	rollbackTimeout := options.RollbackTimeout
+	if rollbackTimeout <= 0 {
+		rollbackTimeout = defaultRolloutRollbackTimeout
+	}
+	rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	// all the committed devices must be rolled back, so there is no
+	// short-circuit when waiting for the other devices
+	forEachDevice(context.WithoutCancel(ctx), func(d *RolloutDeviceResult, err error) {
+		if !d.Committed {
+			return
+		}
+		if err == nil && (d.Previous == nil || d.Previous.P4Info == nil) {
+			err = fmt.Errorf("no previous pipeline config to roll back to")
+		}
+		if err == nil {
+			_, err = d.Client.SetFwdPipeFromP4InfoWithAction(
+				rollbackCtx, d.Previous.P4DeviceConfig, d.Previous.P4Info, d.Previous.Cookie, p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_COMMIT,
+			)
+		}
+		d.RollbackErr = err
+		d.RolledBack = (err == nil)
+	})
+	if n := countFailures(func(d *RolloutDeviceResult) error { return d.RollbackErr }); n > 0 {
+		return report, fmt.Errorf("failed to commit pipeline config on %d device(s), and failed to roll back %d device(s)", numCommitFailures, n)
+	}
+	return report, fmt.Errorf("failed to commit pipeline config on %d device(s), committed devices were rolled back", numCommitFailures)
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+type fakeDevice struct {
+	mutex   sync.Mutex
+	config  *p4_v1.ForwardingPipelineConfig
+	saved   *p4_v1.ForwardingPipelineConfig
+	actions []p4_v1.SetForwardingPipelineConfigRequest_Action
+	failOn  p4_v1.SetForwardingPipelineConfigRequest_Action
+	// if not nil, called for each GetForwardingPipelineConfig RPC
+	onGet func()
+	// if not nil, called for each SetForwardingPipelineConfig RPC
+	onSet func(action p4_v1.SetForwardingPipelineConfigRequest_Action)
+}
+
+func (d *fakeDevice) p4RuntimeClient() *fakeP4RuntimeClient {
+	return &fakeP4RuntimeClient{
+		setForwardingPipelineConfigFn: func(ctx context.Context, in *p4_v1.SetForwardingPipelineConfigRequest, opts ...grpc.CallOption) (*p4_v1.SetForwardingPipelineConfigResponse, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if d.onSet != nil {
+				d.onSet(in.Action)
+			}
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			d.actions = append(d.actions, in.Action)
+			if in.Action == d.failOn {
+				return nil, fmt.Errorf("%v failed", in.Action)
+			}
+			switch in.Action {
+			case p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_SAVE:
+				d.saved = in.Config
+			case p4_v1.SetForwardingPipelineConfigRequest_COMMIT:
+				d.config = d.saved
+			case p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_COMMIT:
+				d.config = in.Config
+			}
+			return &p4_v1.SetForwardingPipelineConfigResponse{}, nil
+		},
+		getForwardingPipelineConfigFn: func(ctx context.Context, in *p4_v1.GetForwardingPipelineConfigRequest, opts ...grpc.CallOption) (*p4_v1.GetForwardingPipelineConfigResponse, error) {
+			if d.onGet != nil {
+				d.onGet()
+			}
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			return &p4_v1.GetForwardingPipelineConfigResponse{Config: d.config}, nil
+		},
+	}
+}
+
+func TestRolloutFwdPipe(t *testing.T) {
+	oldP4Info := newTestP4Info("old")
+	newP4Info := newTestP4Info("new")
+	const numDevices = 4
+	ctx := context.Background()
+
+	setup := func(failDevice int, failOn p4_v1.SetForwardingPipelineConfigRequest_Action) ([]*fakeDevice, []*Client) {
+		devices := make([]*fakeDevice, numDevices)
+		clients := make([]*Client, numDevices)
+		for i := range devices {
+			devices[i] = &fakeDevice{
+				config: &p4_v1.ForwardingPipelineConfig{P4Info: oldP4Info},
+				failOn: -1,
+			}
+			if i == failDevice {
+				devices[i].failOn = failOn
+			}
+			clients[i] = newTestClient(devices[i].p4RuntimeClient(), oldP4Info)
+		}
+		return devices, clients
+	}
+
+	t.Run("success", func(t *testing.T) {
+		devices, clients := setup(-1, -1)
+		report, err := RolloutFwdPipe(ctx, clients, nil, newP4Info, 1, RolloutOptions{MaxConcurrency: 2})
+		require.NoError(t, err)
+		assert.True(t, report.Succeeded())
+		for i := range devices {
+			assert.Equal(t, "new", devices[i].config.P4Info.Tables[0].Preamble.Name)
+			assert.Equal(t, "new", clients[i].P4Info().Tables[0].Preamble.Name)
+		}
+	})
+
+	t.Run("save failure", func(t *testing.T) {
+		devices, clients := setup(1, p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_SAVE)
+		report, err := RolloutFwdPipe(ctx, clients, nil, newP4Info, 1, DefaultRolloutOptions)
+		assert.Error(t, err)
+		assert.False(t, report.Succeeded())
+		assert.Error(t, report.Devices[1].SaveErr)
+		for i := range devices {
+			assert.NotContains(t, devices[i].actions, p4_v1.SetForwardingPipelineConfigRequest_COMMIT)
+			assert.Equal(t, "old", devices[i].config.P4Info.Tables[0].Preamble.Name)
+			assert.Equal(t, "old", clients[i].P4Info().Tables[0].Preamble.Name)
+			// the config saved on the other devices is discarded
+			assert.Equal(t, i != 1, report.Devices[i].Discarded)
+			assert.False(t, clients[i].HasPendingFwdPipe())
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		devices, clients := setup(-1, -1)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		devices[0].onGet = func() {
+			cancel()
+			// the next device is waiting for the first one to finish
+			time.Sleep(50 * time.Millisecond)
+		}
+		report, err := RolloutFwdPipe(ctx, clients, nil, newP4Info, 1, RolloutOptions{MaxConcurrency: 1})
+		assert.Error(t, err)
+		assert.NoError(t, report.Devices[0].FetchErr)
+		for i := 1; i < numDevices; i++ {
+			assert.ErrorIs(t, report.Devices[i].FetchErr, context.Canceled)
+		}
+		for i := range devices {
+			assert.Empty(t, devices[i].actions)
+		}
+	})
+
+	t.Run("commit failure", func(t *testing.T) {
+		devices, clients := setup(2, p4_v1.SetForwardingPipelineConfigRequest_COMMIT)
+		report, err := RolloutFwdPipe(ctx, clients, nil, newP4Info, 1, DefaultRolloutOptions)
+		assert.Error(t, err)
+		assert.False(t, report.Succeeded())
+		for i, d := range report.Devices {
+			if i == 2 {
+				assert.False(t, d.Committed)
+				assert.Error(t, d.CommitErr)
+				continue
+			}
+			assert.True(t, d.Committed)
+			assert.True(t, d.RolledBack)
+			assert.NoError(t, d.RollbackErr)
+		}
+		for i := range devices {
+			assert.Equal(t, "old", devices[i].config.P4Info.Tables[0].Preamble.Name)
+			assert.Equal(t, "old", clients[i].P4Info().Tables[0].Preamble.Name)
+		}
+	})
+	t.Run("commit failure with context cancelled", func(t *testing.T) {
+		devices, clients := setup(2, p4_v1.SetForwardingPipelineConfigRequest_COMMIT)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		devices[2].onSet = func(action p4_v1.SetForwardingPipelineConfigRequest_Action) {
+			if action == p4_v1.SetForwardingPipelineConfigRequest_COMMIT {
+				cancel()
+			}
+		}
+		report, err := RolloutFwdPipe(ctx, clients, nil, newP4Info, 1, RolloutOptions{MaxConcurrency: 1})
+		assert.Error(t, err)
+		for i := 0; i < 2; i++ {
+			assert.True(t, report.Devices[i].Committed)
+			assert.True(t, report.Devices[i].RolledBack)
+			assert.NoError(t, report.Devices[i].RollbackErr)
+		}
+		assert.Error(t, report.Devices[2].CommitErr)
+		assert.ErrorIs(t, report.Devices[3].CommitErr, context.Canceled)
+		for i := range devices {
+			assert.Equal(t, "old", devices[i].config.P4Info.Tables[0].Preamble.Name)
+			assert.Equal(t, "old", clients[i].P4Info().Tables[0].Preamble.Name)
+		}
+	})
+}
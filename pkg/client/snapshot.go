@@ -0,0 +1,334 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+const (
+	// SnapshotVersion is the version of the snapshot file format written by
+	// this version of the client.
+	SnapshotVersion = 1
+
+	// maximum number of updates per WriteRequest when restoring a snapshot
+	snapshotRestoreBatchSize = 1000
+)
+
+// Snapshot is the full state of a device: table entries (including default
+// entries), action profile members and groups, multicast groups, clone
+// sessions, counters, meters (including direct counters and meters),
+// registers and digest configs.
+type Snapshot struct {
+	Version   int
+	Timestamp time.Time
+	DeviceID  uint64
+	// Cookie is the cookie of the pipeline config at the time of the snapshot.
+	Cookie uint64
+	// P4Info is the P4Info used by the Client at the time of the snapshot. All
+	// entity ids are only valid for this P4Info.
+	P4Info   *p4_config_v1.P4Info
+	Entities []*p4_v1.Entity
+}
+
+// snapshotFile is the JSON representation of a Snapshot. Protobuf messages
+// are encoded with protojson.
+type snapshotFile struct {
+	Version   int               `json:"version"`
+	Timestamp time.Time         `json:"timestamp"`
+	DeviceID  uint64            `json:"deviceId"`
+	Cookie    uint64            `json:"cookie"`
+	P4Info    json.RawMessage   `json:"p4info,omitempty"`
+	Entities  []json.RawMessage `json:"entities"`
+}
+
+func (s *Snapshot) MarshalJSON() ([]byte, error) {
+	f := snapshotFile{
+		Version:   s.Version,
+		Timestamp: s.Timestamp,
+		DeviceID:  s.DeviceID,
+		Cookie:    s.Cookie,
+		Entities:  make([]json.RawMessage, 0, len(s.Entities)),
+	}
+	if s.P4Info != nil {
+		b, err := protojson.Marshal(s.P4Info)
+		if err != nil {
+			return nil, err
+		}
+		f.P4Info = b
+	}
+	for _, entity := range s.Entities {
+		b, err := protojson.Marshal(entity)
+		if err != nil {
+			return nil, err
+		}
+		f.Entities = append(f.Entities, b)
+	}
+	return json.Marshal(&f)
+}
+
+func (s *Snapshot) UnmarshalJSON(data []byte) error {
+	var f snapshotFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	if f.Version < 1 || f.Version > SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", f.Version)
+	}
+	s.Version = f.Version
+	s.Timestamp = f.Timestamp
+	s.DeviceID = f.DeviceID
+	s.Cookie = f.Cookie
+	s.P4Info = nil
+	if len(f.P4Info) > 0 {
+		s.P4Info = &p4_config_v1.P4Info{}
+		if err := protojson.Unmarshal(f.P4Info, s.P4Info); err != nil {
+			return fmt.Errorf("failed to decode P4Info: %v", err)
+		}
+	}
+	s.Entities = make([]*p4_v1.Entity, 0, len(f.Entities))
+	for idx, b := range f.Entities {
+		entity := &p4_v1.Entity{}
+		if err := protojson.Unmarshal(b, entity); err != nil {
+			return fmt.Errorf("failed to decode entity %d: %v", idx, err)
+		}
+		s.Entities = append(s.Entities, entity)
+	}
+	return nil
+}
+
+// WriteSnapshot serializes the snapshot as JSON.
+func WriteSnapshot(w io.Writer, s *Snapshot) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
+
+// ReadSnapshot deserializes a snapshot written by WriteSnapshot.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	s := &Snapshot{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %v", err)
+	}
+	return s, nil
+}
+
+func SaveSnapshotFile(path string, s *Snapshot) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error when creating snapshot file: %v", err)
+	}
+	if err := WriteSnapshot(f, s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func LoadSnapshotFile(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error when opening snapshot file: %v", err)
+	}
+	defer f.Close()
+	return ReadSnapshot(f)
+}
+
+// TakeSnapshot reads the full state of the device. A P4Info is required.
+func (c *Client) TakeSnapshot(ctx context.Context) (*Snapshot, error) {
+	p4Info := c.P4Info()
+	if p4Info == nil {
+		return nil, fmt.Errorf("P4Info is missing")
+	}
+	snapshot := &Snapshot{
+		Version:   SnapshotVersion,
+		Timestamp: time.Now(),
+		DeviceID:  c.deviceID,
+		P4Info:    p4Info,
+	}
+
+	pipeConfig, err := c.GetFwdPipe(ctx, GetFwdPipeCookieOnly)
+	if err != nil {
+		return nil, err
+	}
+	if pipeConfig != nil {
+		snapshot.Cookie = pipeConfig.Cookie
+	}
+
+	entities, err := c.readMirroredState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Entities = entities
+
+	var wildcards []*p4_v1.Entity
+	if len(p4Info.Counters) > 0 {
+		wildcards = append(wildcards, &p4_v1.Entity{
+			Entity: &p4_v1.Entity_CounterEntry{CounterEntry: &p4_v1.CounterEntry{}},
+		})
+	}
+	if len(p4Info.Meters) > 0 {
+		wildcards = append(wildcards, &p4_v1.Entity{
+			Entity: &p4_v1.Entity_MeterEntry{MeterEntry: &p4_v1.MeterEntry{}},
+		})
+	}
+	if len(p4Info.DirectCounters) > 0 {
+		wildcards = append(wildcards, &p4_v1.Entity{
+			Entity: &p4_v1.Entity_DirectCounterEntry{DirectCounterEntry: &p4_v1.DirectCounterEntry{
+				TableEntry: &p4_v1.TableEntry{},
+			}},
+		})
+	}
+	if len(p4Info.DirectMeters) > 0 {
+		wildcards = append(wildcards, &p4_v1.Entity{
+			Entity: &p4_v1.Entity_DirectMeterEntry{DirectMeterEntry: &p4_v1.DirectMeterEntry{
+				TableEntry: &p4_v1.TableEntry{},
+			}},
+		})
+	}
+	if len(p4Info.Registers) > 0 {
+		wildcards = append(wildcards, &p4_v1.Entity{
+			Entity: &p4_v1.Entity_RegisterEntry{RegisterEntry: &p4_v1.RegisterEntry{}},
+		})
+	}
+	if len(p4Info.Digests) > 0 {
+		wildcards = append(wildcards, &p4_v1.Entity{
+			Entity: &p4_v1.Entity_DigestEntry{DigestEntry: &p4_v1.DigestEntry{}},
+		})
+	}
+	if len(wildcards) > 0 {
+		entities, err := c.readEntitiesAll(ctx, wildcards...)
+		if err != nil {
+			return nil, fmt.Errorf("error when reading entities: %v", err)
+		}
+		snapshot.Entities = append(snapshot.Entities, entities...)
+	}
+
+	return snapshot, nil
+}
+
+type RestoreOptions struct {
+	// IgnoreP4InfoMismatch allows restoring a snapshot taken with a different
+	// P4Info than the one currently used by the Client. Entity ids are not
+	// translated.
+	IgnoreP4InfoMismatch bool
+}
+
+var DefaultRestoreOptions = RestoreOptions{
+	IgnoreP4InfoMismatch: false,
+}
+
+// RestoreSnapshot restores the state of the device from a snapshot. It can be
+// used on the same device (existing entities which are not in the snapshot are
+// deleted) or on a device on which the pipeline was just set. Table entries,
+// action profiles and PRE entries are restored with Reconcile. Counters,
+// meters (including direct counters and meters, once the table entries exist)
+// and registers are then overwritten with the values from the snapshot, and
+// digests are configured as in the snapshot.
+func (c *Client) RestoreSnapshot(ctx context.Context, snapshot *Snapshot, options RestoreOptions) error {
+	p4Info := c.P4Info()
+	if p4Info == nil {
+		return fmt.Errorf("P4Info is missing")
+	}
+	if snapshot.P4Info != nil && !options.IgnoreP4InfoMismatch && !proto.Equal(snapshot.P4Info, p4Info) {
+		return fmt.Errorf("snapshot was taken with a different P4Info")
+	}
+
+	desired := &DesiredState{
+		ReconcileMulticastGroups: true,
+		ReconcileCloneSessions:   true,
+	}
+	for _, table := range p4Info.Tables {
+		desired.Tables = append(desired.Tables, table.Preamble.Name)
+	}
+	for _, actionProfile := range p4Info.ActionProfiles {
+		desired.ActionProfiles = append(desired.ActionProfiles, actionProfile.Preamble.Name)
+	}
+	var modifies []*p4_v1.Entity
+	desiredDigests := newEntitySet()
+	for _, entity := range snapshot.Entities {
+		switch e := entity.Entity.(type) {
+		case *p4_v1.Entity_TableEntry:
+			desired.TableEntries = append(desired.TableEntries, e.TableEntry)
+		case *p4_v1.Entity_ActionProfileMember:
+			desired.ActionProfileMembers = append(desired.ActionProfileMembers, e.ActionProfileMember)
+		case *p4_v1.Entity_ActionProfileGroup:
+			desired.ActionProfileGroups = append(desired.ActionProfileGroups, e.ActionProfileGroup)
+		case *p4_v1.Entity_PacketReplicationEngineEntry:
+			if group := e.PacketReplicationEngineEntry.GetMulticastGroupEntry(); group != nil {
+				desired.MulticastGroups = append(desired.MulticastGroups, group)
+			} else if session := e.PacketReplicationEngineEntry.GetCloneSessionEntry(); session != nil {
+				desired.CloneSessions = append(desired.CloneSessions, session)
+			}
+		case *p4_v1.Entity_CounterEntry, *p4_v1.Entity_MeterEntry, *p4_v1.Entity_RegisterEntry,
+			*p4_v1.Entity_DirectCounterEntry, *p4_v1.Entity_DirectMeterEntry:
+			modifies = append(modifies, entity)
+		case *p4_v1.Entity_DigestEntry:
+			if _, err := desiredDigests.add(entity); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported entity type in snapshot: %T", e)
+		}
+	}
+
+	if _, err := c.Reconcile(ctx, desired, DefaultReconcileOptions); err != nil {
+		return fmt.Errorf("error when restoring forwarding state: %v", err)
+	}
+
+	for start := 0; start < len(modifies); start += snapshotRestoreBatchSize {
+		end := start + snapshotRestoreBatchSize
+		if end > len(modifies) {
+			end = len(modifies)
+		}
+		updates := make([]*p4_v1.Update, 0, end-start)
+		for _, entity := range modifies[start:end] {
+			updates = append(updates, &p4_v1.Update{Type: p4_v1.Update_MODIFY, Entity: entity})
+		}
+		if err := c.WriteUpdates(ctx, updates); err != nil {
+			return fmt.Errorf("error when restoring counters, meters and registers: %v", err)
+		}
+	}
+
+	if len(p4Info.Digests) > 0 {
+		actualDigests := newEntitySet()
+		entities, err := c.readEntitiesAll(ctx, &p4_v1.Entity{
+			Entity: &p4_v1.Entity_DigestEntry{DigestEntry: &p4_v1.DigestEntry{}},
+		})
+		if err != nil {
+			return fmt.Errorf("error when reading digest entries: %v", err)
+		}
+		for _, entity := range entities {
+			if _, err := actualDigests.add(entity); err != nil {
+				return err
+			}
+		}
+		diff := diffEntitySets(desiredDigests, actualDigests)
+		var updates []*p4_v1.Update
+		for _, entity := range diff.inserts {
+			updates = append(updates, &p4_v1.Update{Type: p4_v1.Update_INSERT, Entity: entity})
+		}
+		for _, entity := range diff.modifies {
+			updates = append(updates, &p4_v1.Update{Type: p4_v1.Update_MODIFY, Entity: entity})
+		}
+		for _, entity := range diff.deletes {
+			updates = append(updates, &p4_v1.Update{Type: p4_v1.Update_DELETE, Entity: entity})
+		}
+		if len(updates) > 0 {
+			if err := c.WriteUpdates(ctx, updates); err != nil {
+				return fmt.Errorf("error when restoring digest configs: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
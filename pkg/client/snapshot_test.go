@@ -0,0 +1,213 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/fakeserver"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	p4Info := newReconcileTestP4Info()
+	p4Info.Counters = []*p4_config_v1.Counter{
+		{Preamble: &p4_config_v1.Preamble{Name: "c", Id: 200}, Size: 2},
+	}
+
+	var c *Client
+	newEntry := func(key byte) *p4_v1.TableEntry {
+		return c.NewTableEntry(
+			"t",
+			map[string]MatchInterface{"f": &ExactMatch{Value: []byte{key}}},
+			c.NewTableActionDirect("a", [][]byte{{key}}),
+			nil,
+		)
+	}
+	counterEntity := &p4_v1.Entity{
+		Entity: &p4_v1.Entity_CounterEntry{CounterEntry: &p4_v1.CounterEntry{
+			CounterId: 200,
+			Index:     &p4_v1.Index{Index: 1},
+			Data:      &p4_v1.CounterData{PacketCount: 10, ByteCount: 1000},
+		}},
+	}
+
+	// the device state, as returned by Read
+	deviceEntities := map[string][]*p4_v1.Entity{}
+	var writes []*p4_v1.Update
+	p4RtClient := &fakeP4RuntimeClient{
+		getForwardingPipelineConfigFn: func(ctx context.Context, in *p4_v1.GetForwardingPipelineConfigRequest, opts ...grpc.CallOption) (*p4_v1.GetForwardingPipelineConfigResponse, error) {
+			return &p4_v1.GetForwardingPipelineConfigResponse{
+				Config: &p4_v1.ForwardingPipelineConfig{Cookie: &p4_v1.ForwardingPipelineConfig_Cookie{Cookie: 42}},
+			}, nil
+		},
+		readFn: func(ctx context.Context, in *p4_v1.ReadRequest, opts ...grpc.CallOption) (p4_v1.P4Runtime_ReadClient, error) {
+			var entities []*p4_v1.Entity
+			for _, entity := range in.Entities {
+				switch e := entity.Entity.(type) {
+				case *p4_v1.Entity_TableEntry:
+					if !e.TableEntry.IsDefaultAction {
+						entities = append(entities, deviceEntities["table"]...)
+					}
+				case *p4_v1.Entity_CounterEntry:
+					entities = append(entities, deviceEntities["counter"]...)
+				}
+			}
+			done := false
+			return &fakeP4RuntimeReadClient{
+				recvFn: func() (*p4_v1.ReadResponse, error) {
+					if done {
+						return nil, io.EOF
+					}
+					done = true
+					return &p4_v1.ReadResponse{Entities: entities}, nil
+				},
+			}, nil
+		},
+		writeFn: func(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+			writes = append(writes, in.Updates...)
+			return &p4_v1.WriteResponse{}, nil
+		},
+	}
+	c = newTestClient(p4RtClient, p4Info)
+
+	deviceEntities["table"] = []*p4_v1.Entity{tableEntryToEntity(newEntry(1)), tableEntryToEntity(newEntry(2))}
+	deviceEntities["counter"] = []*p4_v1.Entity{counterEntity}
+
+	snapshot, err := c.TakeSnapshot(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), snapshot.Cookie)
+	assert.Len(t, snapshot.Entities, 3)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteSnapshot(&buf, snapshot))
+	readSnapshot, err := ReadSnapshot(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, SnapshotVersion, readSnapshot.Version)
+	assert.True(t, snapshot.Timestamp.Equal(readSnapshot.Timestamp))
+	assert.True(t, proto.Equal(snapshot.P4Info, readSnapshot.P4Info))
+	require.Len(t, readSnapshot.Entities, len(snapshot.Entities))
+	for idx := range snapshot.Entities {
+		assert.True(t, proto.Equal(snapshot.Entities[idx], readSnapshot.Entities[idx]))
+	}
+
+	// restore on a device which only has entry 1
+	deviceEntities["table"] = []*p4_v1.Entity{tableEntryToEntity(newEntry(1))}
+	deviceEntities["counter"] = nil
+	require.NoError(t, c.RestoreSnapshot(ctx, readSnapshot, DefaultRestoreOptions))
+	require.Len(t, writes, 2)
+	assert.Equal(t, p4_v1.Update_INSERT, writes[0].Type)
+	assert.Equal(t, []byte{2}, writes[0].Entity.GetTableEntry().Match[0].GetExact().Value)
+	assert.Equal(t, p4_v1.Update_MODIFY, writes[1].Type)
+	assert.True(t, proto.Equal(counterEntity, writes[1].Entity))
+
+	otherP4Info := newTestP4Info("other")
+	otherSnapshot := &Snapshot{Version: SnapshotVersion, Timestamp: time.Now(), P4Info: otherP4Info}
+	assert.Error(t, c.RestoreSnapshot(ctx, otherSnapshot, DefaultRestoreOptions))
+
+	_, err = ReadSnapshot(bytes.NewBufferString(`{"version": 1000}`))
+	assert.Error(t, err)
+}
+
+func TestSnapshotDirectResources(t *testing.T) {
+	ctx := context.Background()
+	p4Info := newReconcileTestP4Info()
+	p4Info.Tables[0].MatchFields[0].Match = &p4_config_v1.MatchField_MatchType_{MatchType: p4_config_v1.MatchField_EXACT}
+	p4Info.Tables[0].ActionRefs = []*p4_config_v1.ActionRef{{Id: 10}}
+	p4Info.DirectCounters = []*p4_config_v1.DirectCounter{
+		{Preamble: &p4_config_v1.Preamble{Name: "dc", Id: 300}, DirectTableId: 1},
+	}
+	p4Info.DirectMeters = []*p4_config_v1.DirectMeter{
+		{Preamble: &p4_config_v1.Preamble{Name: "dm", Id: 400}, DirectTableId: 1},
+	}
+	s := fakeserver.NewServer(1, p4Info)
+	require.NoError(t, s.Start())
+	defer s.Stop()
+	c := newFakeServerClient(t, s, 1, true)
+	c.SetP4Info(p4Info)
+	waitArbitrationStatus(t, c, codes.OK)
+
+	newEntry := func(key byte) *p4_v1.TableEntry {
+		return c.NewTableEntry(
+			"t",
+			map[string]MatchInterface{"f": &ExactMatch{Value: []byte{key}}},
+			c.NewTableActionDirect("a", [][]byte{{key}}),
+			nil,
+		)
+	}
+	directCounter := func(key byte, packets int64) *p4_v1.Update {
+		return &p4_v1.Update{Type: p4_v1.Update_MODIFY, Entity: &p4_v1.Entity{
+			Entity: &p4_v1.Entity_DirectCounterEntry{DirectCounterEntry: &p4_v1.DirectCounterEntry{
+				TableEntry: tableEntryKey(newEntry(key)),
+				Data:       &p4_v1.CounterData{PacketCount: packets},
+			}},
+		}}
+	}
+	directMeter := func(key byte, config *p4_v1.MeterConfig) *p4_v1.Update {
+		return &p4_v1.Update{Type: p4_v1.Update_MODIFY, Entity: &p4_v1.Entity{
+			Entity: &p4_v1.Entity_DirectMeterEntry{DirectMeterEntry: &p4_v1.DirectMeterEntry{
+				TableEntry: tableEntryKey(newEntry(key)),
+				Config:     config,
+			}},
+		}}
+	}
+
+	require.NoError(t, c.InsertTableEntry(ctx, newEntry(1)))
+	require.NoError(t, c.InsertTableEntry(ctx, newEntry(2)))
+	require.NoError(t, c.WriteUpdates(ctx, []*p4_v1.Update{
+		directCounter(1, 10),
+		directMeter(2, &p4_v1.MeterConfig{Cir: 100, Cburst: 10, Pir: 200, Pburst: 20}),
+	}))
+
+	snapshot, err := c.TakeSnapshot(ctx)
+	require.NoError(t, err)
+	var directCounters, directMeters int
+	for _, entity := range snapshot.Entities {
+		switch entity.Entity.(type) {
+		case *p4_v1.Entity_DirectCounterEntry:
+			directCounters++
+		case *p4_v1.Entity_DirectMeterEntry:
+			directMeters++
+		}
+	}
+	assert.Equal(t, 2, directCounters)
+	assert.Equal(t, 2, directMeters)
+	var buf bytes.Buffer
+	require.NoError(t, WriteSnapshot(&buf, snapshot))
+	readSnapshot, err := ReadSnapshot(&buf)
+	require.NoError(t, err)
+
+	// change the counters and meters of both entries
+	require.NoError(t, c.WriteUpdates(ctx, []*p4_v1.Update{
+		directCounter(1, 20),
+		directCounter(2, 30),
+		directMeter(1, &p4_v1.MeterConfig{Cir: 1, Cburst: 1, Pir: 2, Pburst: 2}),
+		directMeter(2, nil),
+	}))
+
+	require.NoError(t, c.RestoreSnapshot(ctx, readSnapshot, DefaultRestoreOptions))
+	restored, err := c.TakeSnapshot(ctx)
+	require.NoError(t, err)
+	require.Len(t, restored.Entities, len(snapshot.Entities))
+	for _, entity := range snapshot.Entities {
+		found := false
+		for _, restoredEntity := range restored.Entities {
+			if proto.Equal(entity, restoredEntity) {
+				found = true
+				break
+			}
+		}
+		assert.True(t, found, "entity %v was not restored", entity)
+	}
+}
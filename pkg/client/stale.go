@@ -0,0 +1,246 @@
+package client
+
+import (
+	"fmt"
+	"math/bits"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/util/conversion"
+)
+
+// p4InfoIndex indexes the objects of a P4Info by id, to validate the entities
+// which are written.
+type p4InfoIndex struct {
+	tables  map[uint32]*p4_config_v1.Table
+	actions map[uint32]*p4_config_v1.Action
+	// ids of all the other P4 objects (action profiles, counters, meters,
+	// registers, digests, ...), which are unique across object types
+	others map[uint32]bool
+}
+
+func newP4InfoIndex(p4Info *p4_config_v1.P4Info) *p4InfoIndex {
+	index := &p4InfoIndex{
+		tables:  make(map[uint32]*p4_config_v1.Table, len(p4Info.Tables)),
+		actions: make(map[uint32]*p4_config_v1.Action, len(p4Info.Actions)),
+		others:  make(map[uint32]bool),
+	}
+	for _, table := range p4Info.Tables {
+		index.tables[table.Preamble.Id] = table
+	}
+	for _, action := range p4Info.Actions {
+		index.actions[action.Preamble.Id] = action
+	}
+	addOthers := func(preamble *p4_config_v1.Preamble) {
+		index.others[preamble.GetId()] = true
+	}
+	for _, o := range p4Info.ActionProfiles {
+		addOthers(o.Preamble)
+	}
+	for _, o := range p4Info.Counters {
+		addOthers(o.Preamble)
+	}
+	for _, o := range p4Info.DirectCounters {
+		addOthers(o.Preamble)
+	}
+	for _, o := range p4Info.Meters {
+		addOthers(o.Preamble)
+	}
+	for _, o := range p4Info.DirectMeters {
+		addOthers(o.Preamble)
+	}
+	for _, o := range p4Info.Registers {
+		addOthers(o.Preamble)
+	}
+	for _, o := range p4Info.Digests {
+		addOthers(o.Preamble)
+	}
+	for _, o := range p4Info.ValueSets {
+		addOthers(o.Preamble)
+	}
+	return index
+}
+
+func (p *p4InfoSnapshot) getIndex() *p4InfoIndex {
+	p.indexOnce.Do(func() {
+		p.index = newP4InfoIndex(p.p4Info)
+	})
+	return p.index
+}
+
+func (index *p4InfoIndex) checkAction(action *p4_v1.Action) error {
+	if action == nil {
+		return nil
+	}
+	p4Action, ok := index.actions[action.ActionId]
+	if !ok {
+		return fmt.Errorf("unknown action id %d", action.ActionId)
+	}
+	for _, param := range action.Params {
+		var p4Param *p4_config_v1.Action_Param
+		for _, p := range p4Action.Params {
+			if p.Id == param.ParamId {
+				p4Param = p
+				break
+			}
+		}
+		if p4Param == nil {
+			return fmt.Errorf("unknown param id %d for action %s", param.ParamId, p4Action.Preamble.Name)
+		}
+		if err := checkBitwidth(p4Param.Bitwidth, param.Value); err != nil {
+			return fmt.Errorf("invalid value for param %s of action %s: %v", p4Param.Name, p4Action.Preamble.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkBitwidth returns an error if value does not fit in bitwidth bits. A
+// bitwidth of 0 (e.g. for fields using a translated type) is not checked.
+func checkBitwidth(bitwidth int32, values ...[]byte) error {
+	if bitwidth == 0 {
+		return nil
+	}
+	for _, value := range values {
+		value = conversion.ToCanonicalBytestring(value)
+		if len(value) == 0 {
+			continue
+		}
+		if l := int32((len(value)-1)*8 + bits.Len8(value[0])); l > bitwidth {
+			return fmt.Errorf("value requires %d bits but bitwidth is %d", l, bitwidth)
+		}
+	}
+	return nil
+}
+
+// checkFieldMatch returns an error if m does not use the match kind of mf, or
+// if its values do not fit in the bitwidth of mf.
+func checkFieldMatch(mf *p4_config_v1.MatchField, m *p4_v1.FieldMatch) error {
+	var matchType p4_config_v1.MatchField_MatchType
+	var values [][]byte
+	switch fm := m.FieldMatchType.(type) {
+	case *p4_v1.FieldMatch_Exact_:
+		matchType = p4_config_v1.MatchField_EXACT
+		values = [][]byte{fm.Exact.Value}
+	case *p4_v1.FieldMatch_Lpm:
+		matchType = p4_config_v1.MatchField_LPM
+		values = [][]byte{fm.Lpm.Value}
+		if mf.Bitwidth > 0 && fm.Lpm.PrefixLen > mf.Bitwidth {
+			return fmt.Errorf("prefix length %d is larger than bitwidth %d", fm.Lpm.PrefixLen, mf.Bitwidth)
+		}
+	case *p4_v1.FieldMatch_Ternary_:
+		matchType = p4_config_v1.MatchField_TERNARY
+		values = [][]byte{fm.Ternary.Value, fm.Ternary.Mask}
+	case *p4_v1.FieldMatch_Range_:
+		matchType = p4_config_v1.MatchField_RANGE
+		values = [][]byte{fm.Range.Low, fm.Range.High}
+	case *p4_v1.FieldMatch_Optional_:
+		matchType = p4_config_v1.MatchField_OPTIONAL
+		values = [][]byte{fm.Optional.Value}
+	default:
+		// other match kinds are architecture-specific
+		return nil
+	}
+	if expected, ok := mf.Match.(*p4_config_v1.MatchField_MatchType_); ok && expected.MatchType != matchType {
+		return fmt.Errorf("match kind is %v but expected %v", matchType, expected.MatchType)
+	}
+	return checkBitwidth(mf.Bitwidth, values...)
+}
+
+func (index *p4InfoIndex) checkTableAction(action *p4_v1.TableAction) error {
+	switch a := action.GetType().(type) {
+	case *p4_v1.TableAction_Action:
+		return index.checkAction(a.Action)
+	case *p4_v1.TableAction_ActionProfileActionSet:
+		for _, apAction := range a.ActionProfileActionSet.ActionProfileActions {
+			if err := index.checkAction(apAction.Action); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (index *p4InfoIndex) checkTableEntry(entry *p4_v1.TableEntry) error {
+	if entry == nil || entry.TableId == 0 {
+		return nil
+	}
+	table, ok := index.tables[entry.TableId]
+	if !ok {
+		return fmt.Errorf("unknown table id %d", entry.TableId)
+	}
+	for _, m := range entry.Match {
+		var matchField *p4_config_v1.MatchField
+		for _, mf := range table.MatchFields {
+			if mf.Id == m.FieldId {
+				matchField = mf
+				break
+			}
+		}
+		if matchField == nil {
+			return fmt.Errorf("unknown match field id %d for table %s", m.FieldId, table.Preamble.Name)
+		}
+		if err := checkFieldMatch(matchField, m); err != nil {
+			return fmt.Errorf("invalid match for field %s of table %s: %v", matchField.Name, table.Preamble.Name, err)
+		}
+	}
+	return index.checkTableAction(entry.Action)
+}
+
+func (index *p4InfoIndex) checkID(id uint32) error {
+	if id != 0 && !index.others[id] {
+		return fmt.Errorf("unknown id %d", id)
+	}
+	return nil
+}
+
+// checkEntity returns an error if the entity refers to P4 objects which are
+// not in the P4Info.
+func (index *p4InfoIndex) checkEntity(entity *p4_v1.Entity) error {
+	switch e := entity.GetEntity().(type) {
+	case *p4_v1.Entity_TableEntry:
+		return index.checkTableEntry(e.TableEntry)
+	case *p4_v1.Entity_ActionProfileMember:
+		if err := index.checkID(e.ActionProfileMember.ActionProfileId); err != nil {
+			return err
+		}
+		return index.checkAction(e.ActionProfileMember.Action)
+	case *p4_v1.Entity_ActionProfileGroup:
+		return index.checkID(e.ActionProfileGroup.ActionProfileId)
+	case *p4_v1.Entity_CounterEntry:
+		return index.checkID(e.CounterEntry.CounterId)
+	case *p4_v1.Entity_DirectCounterEntry:
+		return index.checkTableEntry(e.DirectCounterEntry.TableEntry)
+	case *p4_v1.Entity_MeterEntry:
+		return index.checkID(e.MeterEntry.MeterId)
+	case *p4_v1.Entity_DirectMeterEntry:
+		return index.checkTableEntry(e.DirectMeterEntry.TableEntry)
+	case *p4_v1.Entity_RegisterEntry:
+		return index.checkID(e.RegisterEntry.RegisterId)
+	case *p4_v1.Entity_DigestEntry:
+		return index.checkID(e.DigestEntry.DigestId)
+	case *p4_v1.Entity_ValueSetEntry:
+		return index.checkID(e.ValueSetEntry.ValueSetId)
+	}
+	return nil
+}
+
+// checkStale returns an error wrapping ErrStaleEntity if one of the updates
+// refers to P4 objects which are not in the current P4Info, or uses match
+// kinds or values which do not match the current P4Info, which typically
+// means that the entity was built with a previous P4Info. Entities built with
+// a P4InfoView are also checked by version, see P4InfoView.WriteUpdates. No
+// check is performed if the Client does not have a P4Info.
+func (c *Client) checkStale(updates []*p4_v1.Update) error {
+	p4Info := c.currentP4Info()
+	if p4Info.p4Info == nil {
+		return nil
+	}
+	index := p4Info.getIndex()
+	for idx, update := range updates {
+		if err := index.checkEntity(update.Entity); err != nil {
+			return fmt.Errorf("%w (P4Info version %d): update %d: %v", ErrStaleEntity, p4Info.version, idx, err)
+		}
+	}
+	return nil
+}
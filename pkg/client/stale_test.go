@@ -0,0 +1,223 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// newRenumberedP4Info returns a copy of the reconcile test P4Info, with all ids
+// shifted by offset, except for action param ids which are positional.
+func newRenumberedP4Info(offset uint32) *p4_config_v1.P4Info {
+	p4Info := newReconcileTestP4Info()
+	p4Info.Tables[0].Preamble.Id += offset
+	p4Info.Tables[0].MatchFields[0].Id += offset
+	p4Info.Actions[0].Preamble.Id += offset
+	p4Info.ActionProfiles[0].Preamble.Id += offset
+	return p4Info
+}
+
+func TestP4InfoVersion(t *testing.T) {
+	p4Info := newReconcileTestP4Info()
+	p4RtClient := &fakeP4RuntimeClient{
+		getForwardingPipelineConfigFn: func(ctx context.Context, in *p4_v1.GetForwardingPipelineConfigRequest, opts ...grpc.CallOption) (*p4_v1.GetForwardingPipelineConfigResponse, error) {
+			return &p4_v1.GetForwardingPipelineConfigResponse{
+				Config: &p4_v1.ForwardingPipelineConfig{P4Info: proto.Clone(p4Info).(*p4_config_v1.P4Info)},
+			}, nil
+		},
+	}
+	c := newTestClient(p4RtClient, nil)
+	assert.Nil(t, c.P4Info())
+	assert.Equal(t, uint64(0), c.P4InfoVersion())
+
+	c.SetP4Info(p4Info)
+	assert.Equal(t, p4Info, c.P4Info())
+	assert.Equal(t, uint64(1), c.P4InfoVersion())
+
+	// the P4Info returned by the server is identical
+	_, err := c.GetFwdPipe(context.Background(), GetFwdPipeAll)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), c.P4InfoVersion())
+
+	p4Info = newRenumberedP4Info(1)
+	_, err = c.GetFwdPipe(context.Background(), GetFwdPipeAll)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), c.P4InfoVersion())
+	assert.True(t, proto.Equal(p4Info, c.P4Info()))
+}
+
+func TestStaleEntity(t *testing.T) {
+	var writes int
+	p4RtClient := &fakeP4RuntimeClient{
+		writeFn: func(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+			writes++
+			return &p4_v1.WriteResponse{}, nil
+		},
+	}
+	c := newTestClient(p4RtClient, newReconcileTestP4Info())
+	ctx := context.Background()
+
+	newEntry := func() *p4_v1.TableEntry {
+		return c.NewTableEntry(
+			"t",
+			map[string]MatchInterface{"f": &ExactMatch{Value: []byte{0, 1}}},
+			c.NewTableActionDirect("a", [][]byte{{0, 1}}),
+			nil,
+		)
+	}
+	entry := newEntry()
+	member := c.NewActionProfileMember("ap", 1, "a", [][]byte{{1}})
+	require.NoError(t, c.InsertTableEntry(ctx, entry))
+	require.NoError(t, c.InsertActionProfileMember(ctx, member))
+	assert.Equal(t, 2, writes)
+
+	c.SetP4Info(newRenumberedP4Info(1))
+	err := c.InsertTableEntry(ctx, entry)
+	assert.ErrorIs(t, err, ErrStaleEntity)
+	assert.ErrorContains(t, err, "P4Info version 2")
+	assert.ErrorIs(t, c.InsertActionProfileMember(ctx, member), ErrStaleEntity)
+	assert.Equal(t, 2, writes)
+
+	// rebuilding the entity with the current P4Info
+	require.NoError(t, c.InsertTableEntry(ctx, newEntry()))
+	assert.Equal(t, 3, writes)
+}
+
+func TestStaleEntityReusedIDs(t *testing.T) {
+	p4RtClient := &fakeP4RuntimeClient{
+		writeFn: func(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+			return &p4_v1.WriteResponse{}, nil
+		},
+	}
+	p4Info := newReconcileTestP4Info()
+	p4Info.Tables[0].MatchFields[0].Match = &p4_config_v1.MatchField_MatchType_{MatchType: p4_config_v1.MatchField_EXACT}
+	c := newTestClient(p4RtClient, p4Info)
+	ctx := context.Background()
+
+	entry := c.NewTableEntry(
+		"t",
+		map[string]MatchInterface{"f": &ExactMatch{Value: []byte{1, 0}}},
+		c.NewTableActionDirect("a", [][]byte{{1, 0}}),
+		nil,
+	)
+	require.NoError(t, c.InsertTableEntry(ctx, entry))
+
+	// same ids, but the match field is now an 8-bit LPM field
+	newP4Info := newReconcileTestP4Info()
+	newP4Info.Tables[0].MatchFields[0].Bitwidth = 8
+	newP4Info.Tables[0].MatchFields[0].Match = &p4_config_v1.MatchField_MatchType_{MatchType: p4_config_v1.MatchField_LPM}
+	c.SetP4Info(newP4Info)
+	err := c.InsertTableEntry(ctx, entry)
+	assert.ErrorIs(t, err, ErrStaleEntity)
+	assert.ErrorContains(t, err, "match kind")
+
+	// same ids and match kind, but the match field and the param are now 8-bit
+	newP4Info = newReconcileTestP4Info()
+	newP4Info.Tables[0].MatchFields[0].Bitwidth = 8
+	c.SetP4Info(newP4Info)
+	err = c.InsertTableEntry(ctx, entry)
+	assert.ErrorIs(t, err, ErrStaleEntity)
+	assert.ErrorContains(t, err, "bitwidth")
+	newP4Info = newReconcileTestP4Info()
+	newP4Info.Actions[0].Params[0].Bitwidth = 8
+	c.SetP4Info(newP4Info)
+	assert.ErrorIs(t, c.InsertTableEntry(ctx, entry), ErrStaleEntity)
+}
+
+func TestP4InfoView(t *testing.T) {
+	var writes int
+	p4RtClient := &fakeP4RuntimeClient{
+		writeFn: func(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+			writes++
+			return &p4_v1.WriteResponse{}, nil
+		},
+	}
+	c := newTestClient(p4RtClient, newReconcileTestP4Info())
+	ctx := context.Background()
+
+	view := c.P4InfoView()
+	assert.Equal(t, uint64(1), view.Version())
+	entry := view.NewTableEntry(
+		"t",
+		map[string]MatchInterface{"f": &ExactMatch{Value: []byte{0, 1}}},
+		view.NewTableActionDirect("a", [][]byte{{0, 1}}),
+		nil,
+	)
+	update := &p4_v1.Update{Type: p4_v1.Update_INSERT, Entity: tableEntryToEntity(entry)}
+	require.NoError(t, view.WriteUpdate(ctx, update))
+	assert.False(t, view.Stale())
+
+	// the new P4Info is identical, but the entity was built with the old one
+	c.SetP4Info(newReconcileTestP4Info())
+	assert.True(t, view.Stale())
+	err := view.WriteUpdate(ctx, update)
+	assert.ErrorIs(t, err, ErrStaleEntity)
+	assert.ErrorContains(t, err, "built with P4Info version 1, current version is 2")
+	assert.Equal(t, 1, writes)
+	// the Client only checks the entity against the current P4Info
+	require.NoError(t, c.WriteUpdate(ctx, update))
+	assert.Equal(t, 2, writes)
+}
+
+func TestP4InfoConcurrentSwap(t *testing.T) {
+	p4RtClient := &fakeP4RuntimeClient{
+		writeFn: func(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+			return &p4_v1.WriteResponse{}, nil
+		},
+	}
+	p4Infos := []*p4_config_v1.P4Info{newRenumberedP4Info(0), newRenumberedP4Info(1)}
+	c := newTestClient(p4RtClient, p4Infos[0])
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	stopCh := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for idx := 1; ; idx++ {
+			select {
+			case <-stopCh:
+				return
+			default:
+				c.SetP4Info(p4Infos[idx%2])
+			}
+		}
+	}()
+
+	var builders sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		builders.Add(1)
+		go func() {
+			defer builders.Done()
+			for j := 0; j < 200; j++ {
+				view := c.P4InfoView()
+				entry := view.NewTableEntry(
+					"t",
+					map[string]MatchInterface{"f": &ExactMatch{Value: []byte{0, 1}}},
+					view.NewTableActionDirect("a", [][]byte{{0, 1}}),
+					nil,
+				)
+				// the table, the match field and the action must come from
+				// the same P4Info
+				assert.Equal(t, entry.TableId, entry.Match[0].FieldId)
+				assert.Equal(t, entry.TableId+9, entry.Action.GetAction().ActionId)
+				update := &p4_v1.Update{Type: p4_v1.Update_INSERT, Entity: tableEntryToEntity(entry)}
+				if err := view.WriteUpdate(ctx, update); err != nil {
+					assert.ErrorIs(t, err, ErrStaleEntity)
+				}
+			}
+		}()
+	}
+	builders.Wait()
+	close(stopCh)
+	wg.Wait()
+	assert.Greater(t, c.P4InfoVersion(), uint64(1))
+}
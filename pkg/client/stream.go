@@ -0,0 +1,402 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// activeStream is a StreamChannel RPC started by Run or Start.
+type activeStream struct {
+	stream p4_v1.P4Runtime_StreamChannelClient
+	// the context provided to Start, from which ctx is derived
+	parentCtx context.Context
+	ctx       context.Context
+	cancel    context.CancelFunc
+	doneCh    chan struct{}
+	// receives the error which terminated the receive loop
+	recvErrCh chan error
+	// closed when the receive loop exits
+	recvDoneCh chan struct{}
+}
+
+// Run opens the stream channel, sends an arbitration update and processes
+// stream messages until stopCh is closed or the stream fails. The result of
+// each arbitration update is sent on arbitrationCh (true if the Client is
+// primary), and all the other stream messages are sent on messageCh, unless
+// they are delivered to the stream queues (see WithStreamQueues). Either
+// channel can be nil, in which case the corresponding messages are dropped.
+// All the stream messages are also delivered to the matching subscribers, see
+// Subscribe.
+//
+// When stopCh is closed, the queued stream messages are sent and the stream is
+// closed gracefully, within CloseTimeout, and Run returns nil. Run can be
+// called again after it returns, e.g. to re-establish a failed stream.
+func (c *Client) Run(
+	stopCh <-chan struct{},
+	arbitrationCh chan<- bool,
+	messageCh chan<- *p4_v1.StreamMessageResponse, // all other stream messages besides arbitration
+) error {
+	s, err := c.startStream(context.Background(), arbitrationCh, messageCh)
+	if err != nil {
+		return err
+	}
+	return c.streamLoop(s, stopCh)
+}
+
+// Start is like Run, but runs the stream channel in the background, until ctx
+// is done, Close is called or the stream fails. Use Done and Err to find out
+// when and why the stream terminated. When ctx is done, the stream is
+// terminated without sending the queued messages, and Err returns nil.
+func (c *Client) Start(
+	ctx context.Context,
+	arbitrationCh chan<- bool,
+	messageCh chan<- *p4_v1.StreamMessageResponse,
+) error {
+	s, err := c.startStream(ctx, arbitrationCh, messageCh)
+	if err != nil {
+		return err
+	}
+	go c.streamLoop(s, nil) //nolint:errcheck
+	return nil
+}
+
+// Done returns a channel which is closed when the current (or last) stream
+// started with Run or Start terminates. It returns nil if no stream was ever
+// started.
+func (c *Client) Done() <-chan struct{} {
+	c.streamMu.Lock()
+	defer c.streamMu.Unlock()
+	return c.streamDoneCh
+}
+
+// Err returns the error which terminated the last stream, or nil if the stream
+// is still running, was closed gracefully, or was stopped because the context
+// provided to Start was done.
+func (c *Client) Err() error {
+	c.streamMu.Lock()
+	defer c.streamMu.Unlock()
+	return c.streamErr
+}
+
+// Close closes the stream gracefully, as described in Run, and waits for it to
+// terminate. For Clients created with Dial, it also closes the gRPC connection.
+// After Close, SendMessage, Run and Start return ErrClosed.
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.streamMu.Lock()
+		c.closed = true
+		running := c.streamRunning
+		doneCh := c.streamDoneCh
+		c.streamMu.Unlock()
+		close(c.closeCh)
+		if running {
+			<-doneCh
+		}
+		if c.conn != nil {
+			err = c.conn.Close()
+		}
+	})
+	return err
+}
+
+func closedError(err error) error {
+	if err == nil {
+		return ErrClosed
+	}
+	return fmt.Errorf("%w: %v", ErrClosed, err)
+}
+
+// SendMessage queues a message to be sent on the stream. It blocks if the send
+// buffer (see SendBufferSize) is full. Messages can be queued before the stream
+// is started; once a stream has terminated, SendMessage fails with ErrClosed
+// until the stream is started again, and the messages which were still queued
+// are dropped rather than sent on the next stream. Errors which occur when actually sending
+// the message terminate the stream, and are reported by Run and Err.
+func (c *Client) SendMessage(ctx context.Context, msg *p4_v1.StreamMessageRequest) error {
+	return c.queueMessage(ctx, &queuedMessage{msg: msg})
+}
+
+// queuedMessage is a message waiting in the send buffer.
+type queuedMessage struct {
+	msg *p4_v1.StreamMessageRequest
+	// not nil for messages sent with a SendHandle
+	tracked *trackedMessage
+}
+
+func (c *Client) queueMessage(ctx context.Context, m *queuedMessage) error {
+	c.streamMu.Lock()
+	closed := c.closed
+	running := c.streamRunning
+	doneCh := c.streamDoneCh
+	streamErr := c.streamErr
+	c.streamMu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if doneCh != nil && !running {
+		return closedError(streamErr)
+	}
+	select {
+	case c.streamSendCh <- m:
+		c.Metrics.StreamSendQueueDepth(len(c.streamSendCh))
+	case <-doneCh:
+		return closedError(c.Err())
+	case <-ctx.Done():
+		c.Metrics.StreamSendDropped()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (c *Client) startStream(
+	ctx context.Context,
+	arbitrationCh chan<- bool,
+	messageCh chan<- *p4_v1.StreamMessageResponse,
+) (*activeStream, error) {
+	c.streamMu.Lock()
+	if c.closed {
+		c.streamMu.Unlock()
+		return nil, ErrClosed
+	}
+	if c.streamRunning {
+		c.streamMu.Unlock()
+		return nil, fmt.Errorf("stream is already running")
+	}
+	if c.streamDoneCh != nil {
+		// a message may have been queued concurrently with the termination
+		// of the previous stream
+		c.dropQueuedMessages()
+	}
+	c.streamRunning = true
+	doneCh := make(chan struct{})
+	c.streamDoneCh = doneCh
+	c.streamErr = nil
+	c.streamMu.Unlock()
+
+	if c.runs.Add(1) > 1 {
+		c.Metrics.StreamReconnect()
+	}
+	streamCtx, cancel := context.WithCancel(ctx)
+	stream, err := c.StreamChannel(streamCtx)
+	if err != nil {
+		cancel()
+		err = fmt.Errorf("cannot establish stream: %w", err)
+		c.finishStream(doneCh, err)
+		return nil, err
+	}
+	s := &activeStream{
+		stream:     stream,
+		parentCtx:  ctx,
+		ctx:        streamCtx,
+		cancel:     cancel,
+		doneCh:     doneCh,
+		recvErrCh:  make(chan error, 1),
+		recvDoneCh: make(chan struct{}),
+	}
+	go c.recvLoop(s, arbitrationCh, messageCh)
+	return s, nil
+}
+
+func (c *Client) finishStream(doneCh chan struct{}, err error) {
+	if err != nil {
+		c.log.Error("Stream channel terminated", "error", err)
+	}
+	c.failTracked(err)
+	c.resetArbitration()
+	c.dropQueuedMessages()
+	c.streamMu.Lock()
+	defer c.streamMu.Unlock()
+	c.streamRunning = false
+	c.streamErr = err
+	close(doneCh)
+}
+
+// dropQueuedMessages empties the send buffer when the stream terminates, as
+// the messages must not be sent on the next stream.
+func (c *Client) dropQueuedMessages() {
+	dropped := 0
+	for {
+		select {
+		case <-c.streamSendCh:
+			dropped++
+			c.Metrics.StreamSendDropped()
+			continue
+		default:
+		}
+		break
+	}
+	if dropped > 0 {
+		c.log.Warn("Dropped queued stream messages", "count", dropped)
+		c.Metrics.StreamSendQueueDepth(0)
+	}
+}
+
+func (c *Client) recvLoop(
+	s *activeStream,
+	arbitrationCh chan<- bool,
+	messageCh chan<- *p4_v1.StreamMessageResponse,
+) {
+	defer close(s.recvDoneCh)
+	cacheRebuilt := false
+	// nil until the first arbitration update is received
+	var isPrimary *bool
+	for {
+		in, err := s.stream.Recv()
+		if err == io.EOF {
+			s.recvErrCh <- fmt.Errorf("stream closed by server")
+			return
+		}
+		if err != nil {
+			s.recvErrCh <- err
+			return
+		}
+		c.Metrics.StreamMessageReceived(streamResponseType(in))
+		if streamErr, ok := in.Update.(*p4_v1.StreamMessageResponse_Error); ok {
+			c.correlateStreamError(streamErr.Error)
+		}
+		if !c.publish(s.ctx, in) {
+			return
+		}
+		arbitration, ok := in.Update.(*p4_v1.StreamMessageResponse_Arbitration)
+		if !ok {
+			if !c.dispatch(s.ctx, in, messageCh) {
+				return
+			}
+			continue
+		}
+		if c.cache != nil && !cacheRebuilt && c.P4Info() != nil {
+			// the target may have been reset while we were disconnected
+			cacheRebuilt = true
+			c.rebuildCacheAsync(s.ctx)
+		}
+		primary := c.handleArbitration(arbitration.Arbitration).IsPrimary
+		if isPrimary == nil || *isPrimary != primary {
+			isPrimary = &primary
+			c.Metrics.ArbitrationTransition(primary)
+		}
+		if arbitrationCh != nil {
+			select {
+			case arbitrationCh <- primary:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// send sends a message on the stream. When the stream was terminated by the
+// server, the error returned by Send is io.EOF, and the actual error is
+// returned by the receive loop.
+func (c *Client) send(s *activeStream, msg *p4_v1.StreamMessageRequest) error {
+	err := s.stream.Send(msg)
+	if err == nil {
+		c.Metrics.StreamMessageSent(streamRequestType(msg))
+		return nil
+	}
+	if errors.Is(err, io.EOF) {
+		select {
+		case err = <-s.recvErrCh:
+		case <-s.ctx.Done():
+			err = s.ctx.Err()
+		}
+	}
+	return fmt.Errorf("failed to send stream message: %w", err)
+}
+
+// sendQueued sends a message from the send buffer. The StreamErrorTimeout of
+// a tracked message starts once it has been sent.
+func (c *Client) sendQueued(s *activeStream, m *queuedMessage) error {
+	if err := c.send(s, m.msg); err != nil {
+		return err
+	}
+	if m.tracked != nil {
+		c.markSent(m.tracked)
+	}
+	return nil
+}
+
+// isContextError returns true if err is the result of a context being
+// cancelled or expiring, either locally or as reported by gRPC.
+func isContextError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	code := status.Code(err)
+	return code == codes.Canceled || code == codes.DeadlineExceeded
+}
+
+func (c *Client) streamLoop(s *activeStream, stopCh <-chan struct{}) (err error) {
+	defer func() {
+		if err != nil && s.parentCtx.Err() != nil && isContextError(err) {
+			// the stream was stopped by the caller
+			err = nil
+		}
+		s.cancel()
+		c.finishStream(s.doneCh, err)
+	}()
+	if err := c.send(s, c.arbitrationRequest()); err != nil {
+		return err
+	}
+	for {
+		// when several cases are ready, a done context takes precedence,
+		// and the queued messages are not sent
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+		select {
+		case m := <-c.streamSendCh:
+			c.Metrics.StreamSendQueueDepth(len(c.streamSendCh))
+			if err := c.sendQueued(s, m); err != nil {
+				return err
+			}
+		case <-stopCh:
+			return c.closeStream(s)
+		case <-c.closeCh:
+			return c.closeStream(s)
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case err := <-s.recvErrCh:
+			return err
+		}
+	}
+}
+
+// closeStream sends the queued messages, half-closes the stream and waits for
+// the server to close it, within CloseTimeout. If the stream context is done,
+// e.g. because the context provided to Start was cancelled, the stream is
+// terminated without sending the queued messages.
+func (c *Client) closeStream(s *activeStream) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	timer := time.AfterFunc(c.CloseTimeout, s.cancel)
+	defer timer.Stop()
+flush:
+	for {
+		select {
+		case m := <-c.streamSendCh:
+			if err := c.sendQueued(s, m); err != nil {
+				return fmt.Errorf("failed to flush stream messages before closing: %w", err)
+			}
+		default:
+			break flush
+		}
+	}
+	if err := s.stream.CloseSend(); err != nil {
+		return fmt.Errorf("failed to close stream: %w", err)
+	}
+	select {
+	case <-s.recvDoneCh:
+	case <-s.ctx.Done():
+	}
+	return nil
+}
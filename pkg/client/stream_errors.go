@@ -0,0 +1,265 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+const (
+	defaultStreamErrorTimeout = 5 * time.Second
+)
+
+// StreamError is a decoded p4.v1.StreamError, which the server sends when it
+// fails to process a stream message. It implements the error interface, and
+// the gRPC status interface, so that status.Code returns its canonical code.
+type StreamError struct {
+	Code    codes.Code
+	Message string
+	// Space and SpaceCode are the target-specific error space and code, if
+	// any.
+	Space     string
+	SpaceCode int32
+	// Exactly one of the following is set, depending on the message which
+	// caused the error, as echoed by the server.
+	PacketOut     *p4_v1.PacketOut
+	DigestListAck *p4_v1.DigestListAck
+	Other         *anypb.Any
+}
+
+// DecodeStreamError decodes a StreamError received on the stream.
+func DecodeStreamError(streamErr *p4_v1.StreamError) *StreamError {
+	e := &StreamError{
+		Code:      codes.Code(streamErr.CanonicalCode),
+		Message:   streamErr.Message,
+		Space:     streamErr.Space,
+		SpaceCode: streamErr.Code,
+	}
+	switch details := streamErr.Details.(type) {
+	case *p4_v1.StreamError_PacketOut:
+		e.PacketOut = details.PacketOut.GetPacketOut()
+	case *p4_v1.StreamError_DigestListAck:
+		e.DigestListAck = details.DigestListAck.GetDigestListAck()
+	case *p4_v1.StreamError_Other:
+		e.Other = details.Other.GetOther()
+	}
+	return e
+}
+
+// MessageType returns the type of the message which caused the error
+// (StreamMessagePacket, StreamMessageDigestAck or StreamMessageOther).
+func (e *StreamError) MessageType() string {
+	switch {
+	case e.PacketOut != nil:
+		return StreamMessagePacket
+	case e.DigestListAck != nil:
+		return StreamMessageDigestAck
+	}
+	return StreamMessageOther
+}
+
+func (e *StreamError) Error() string {
+	s := fmt.Sprintf("stream error for %s message: %s", e.MessageType(), e.Code)
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	if e.Space != "" {
+		s += fmt.Sprintf(" (%s code %d)", e.Space, e.SpaceCode)
+	}
+	return s
+}
+
+func (e *StreamError) GRPCStatus() *status.Status {
+	return status.New(e.Code, e.Message)
+}
+
+// concerns returns true if the error was caused by msg.
+func (e *StreamError) concerns(msg *p4_v1.StreamMessageRequest) bool {
+	switch update := msg.Update.(type) {
+	case *p4_v1.StreamMessageRequest_Packet:
+		return e.PacketOut != nil && proto.Equal(e.PacketOut, update.Packet)
+	case *p4_v1.StreamMessageRequest_DigestAck:
+		return e.DigestListAck != nil && proto.Equal(e.DigestListAck, update.DigestAck)
+	}
+	return false
+}
+
+// SendHandle reports the outcome of a stream message sent with
+// SendPacketOutWithHandle or AckDigestListWithHandle. P4Runtime servers only
+// report failures, so a message is considered to have been processed
+// successfully if no StreamError is received for it within StreamErrorTimeout.
+type SendHandle struct {
+	doneCh chan struct{}
+	once   sync.Once
+	err    error
+	// if not nil, called with the outcome
+	callback func(error)
+}
+
+func newSendHandle(callback func(error)) *SendHandle {
+	return &SendHandle{
+		doneCh:   make(chan struct{}),
+		callback: callback,
+	}
+}
+
+func (h *SendHandle) resolve(err error) {
+	h.once.Do(func() {
+		h.err = err
+		close(h.doneCh)
+		if h.callback != nil {
+			go h.callback(err)
+		}
+	})
+}
+
+// Done returns a channel which is closed when the outcome is known.
+func (h *SendHandle) Done() <-chan struct{} {
+	return h.doneCh
+}
+
+// Err returns the outcome, once Done is closed: nil if no error was reported,
+// a *StreamError if the server reported an error for the message, or an error
+// wrapping ErrClosed if the stream terminated before StreamErrorTimeout, in
+// which case the outcome is unknown.
+func (h *SendHandle) Err() error {
+	select {
+	case <-h.doneCh:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// Wait waits for the outcome and returns it, see Err.
+func (h *SendHandle) Wait(ctx context.Context) error {
+	select {
+	case <-h.doneCh:
+		return h.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// trackedMessage is a message sent with a SendHandle, waiting for a
+// StreamError.
+type trackedMessage struct {
+	msg    *p4_v1.StreamMessageRequest
+	handle *SendHandle
+	// set once the message has been sent on the stream, protected by
+	// trackedMu
+	sent  bool
+	timer *time.Timer
+}
+
+func (c *Client) untrack(t *trackedMessage) bool {
+	c.trackedMu.Lock()
+	defer c.trackedMu.Unlock()
+	for idx, other := range c.tracked {
+		if other == t {
+			c.tracked = append(c.tracked[:idx], c.tracked[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// sendTracked queues msg and tracks it until a StreamError is received for it,
+// or until StreamErrorTimeout after it has been sent.
+func (c *Client) sendTracked(ctx context.Context, msg *p4_v1.StreamMessageRequest, callback func(error)) (*SendHandle, error) {
+	t := &trackedMessage{
+		msg:    msg,
+		handle: newSendHandle(callback),
+	}
+	c.trackedMu.Lock()
+	c.tracked = append(c.tracked, t)
+	c.trackedMu.Unlock()
+	if err := c.queueMessage(ctx, &queuedMessage{msg: msg, tracked: t}); err != nil {
+		c.untrack(t)
+		return nil, err
+	}
+	return t.handle, nil
+}
+
+// markSent starts the StreamErrorTimeout of a tracked message, unless it was
+// already resolved.
+func (c *Client) markSent(t *trackedMessage) {
+	c.trackedMu.Lock()
+	defer c.trackedMu.Unlock()
+	for _, other := range c.tracked {
+		if other == t {
+			t.sent = true
+			t.timer = time.AfterFunc(c.StreamErrorTimeout, func() {
+				if c.untrack(t) {
+					t.handle.resolve(nil)
+				}
+			})
+			return
+		}
+	}
+}
+
+// correlateStreamError resolves the oldest tracked message which caused the
+// error, if any. Messages which have not been sent yet cannot have caused it.
+func (c *Client) correlateStreamError(streamErr *p4_v1.StreamError) {
+	e := DecodeStreamError(streamErr)
+	c.trackedMu.Lock()
+	var t *trackedMessage
+	for idx, other := range c.tracked {
+		if other.sent && e.concerns(other.msg) {
+			t = other
+			c.tracked = append(c.tracked[:idx], c.tracked[idx+1:]...)
+			break
+		}
+	}
+	c.trackedMu.Unlock()
+	if t == nil {
+		c.log.Warn("Received stream error", "error", e)
+		return
+	}
+	t.timer.Stop()
+	t.handle.resolve(e)
+}
+
+// failTracked resolves all the tracked messages when the stream terminates.
+func (c *Client) failTracked(err error) {
+	c.trackedMu.Lock()
+	tracked := c.tracked
+	c.tracked = nil
+	c.trackedMu.Unlock()
+	for _, t := range tracked {
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+		t.handle.resolve(closedError(err))
+	}
+}
+
+// SendPacketOutWithHandle is like SendPacketOut, but returns a SendHandle to
+// find out whether the server failed to process the PacketOut.
+func (c *Client) SendPacketOutWithHandle(ctx context.Context, pkt *p4_v1.PacketOut) (*SendHandle, error) {
+	msg := &p4_v1.StreamMessageRequest{Update: &p4_v1.StreamMessageRequest_Packet{Packet: pkt}}
+	return c.sendTracked(ctx, msg, nil)
+}
+
+// SendPacketOutWithCallback is like SendPacketOut, but calls fn from a new
+// goroutine once the outcome is known, as described in SendHandle.
+func (c *Client) SendPacketOutWithCallback(ctx context.Context, pkt *p4_v1.PacketOut, fn func(error)) error {
+	msg := &p4_v1.StreamMessageRequest{Update: &p4_v1.StreamMessageRequest_Packet{Packet: pkt}}
+	_, err := c.sendTracked(ctx, msg, fn)
+	return err
+}
+
+// AckDigestListWithHandle is like AckDigestList, but returns a SendHandle to
+// find out whether the server failed to process the ack.
+func (c *Client) AckDigestListWithHandle(ctx context.Context, digestList *p4_v1.DigestList) (*SendHandle, error) {
+	return c.sendTracked(ctx, digestListAck(digestList), nil)
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/fakeserver"
+)
+
+func TestDecodeStreamError(t *testing.T) {
+	pkt := &p4_v1.PacketOut{Payload: []byte{1}}
+	e := DecodeStreamError(&p4_v1.StreamError{
+		CanonicalCode: int32(codes.InvalidArgument),
+		Message:       "bad metadata",
+		Space:         "target",
+		Code:          42,
+		Details:       &p4_v1.StreamError_PacketOut{PacketOut: &p4_v1.PacketOutError{PacketOut: pkt}},
+	})
+	assert.Equal(t, codes.InvalidArgument, e.Code)
+	assert.True(t, proto.Equal(pkt, e.PacketOut))
+	assert.Nil(t, e.DigestListAck)
+	assert.Equal(t, StreamMessagePacket, e.MessageType())
+	assert.Equal(t, "stream error for packet message: InvalidArgument: bad metadata (target code 42)", e.Error())
+	assert.Equal(t, codes.InvalidArgument, status.Code(e))
+	assert.True(t, e.concerns(&p4_v1.StreamMessageRequest{Update: &p4_v1.StreamMessageRequest_Packet{Packet: &p4_v1.PacketOut{Payload: []byte{1}}}}))
+	assert.False(t, e.concerns(&p4_v1.StreamMessageRequest{Update: &p4_v1.StreamMessageRequest_Packet{Packet: &p4_v1.PacketOut{Payload: []byte{2}}}}))
+
+	ack := &p4_v1.DigestListAck{DigestId: 1, ListId: 2}
+	e = DecodeStreamError(&p4_v1.StreamError{
+		CanonicalCode: int32(codes.PermissionDenied),
+		Details:       &p4_v1.StreamError_DigestListAck{DigestListAck: &p4_v1.DigestListAckError{DigestListAck: ack}},
+	})
+	assert.Equal(t, StreamMessageDigestAck, e.MessageType())
+	assert.Equal(t, "stream error for digest_ack message: PermissionDenied", e.Error())
+	assert.True(t, e.concerns(digestListAck(&p4_v1.DigestList{DigestId: 1, ListId: 2})))
+}
+
+func TestSendPacketOutWithHandle(t *testing.T) {
+	ctx := context.Background()
+	// the P4Info does not define any packet_out metadata
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c := newFakeServerClient(t, s, 1, false, func(options *ClientOptions) {
+		options.StreamErrorTimeout = 200 * time.Millisecond
+	})
+	defer c.Close()
+	arbitrationCh := make(chan bool, 10)
+	require.NoError(t, c.Start(ctx, arbitrationCh, nil))
+	assert.True(t, waitPrimaryStatus(t, arbitrationCh))
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	badPkt := &p4_v1.PacketOut{Payload: []byte{1}, Metadata: []*p4_v1.PacketMetadata{{MetadataId: 1, Value: []byte{1}}}}
+	badHandle, err := c.SendPacketOutWithHandle(ctx, badPkt)
+	require.NoError(t, err)
+	goodHandle, err := c.SendPacketOutWithHandle(ctx, &p4_v1.PacketOut{Payload: []byte{2}})
+	require.NoError(t, err)
+	callbackCh := make(chan error, 1)
+	require.NoError(t, c.SendPacketOutWithCallback(ctx, badPkt, func(err error) {
+		callbackCh <- err
+	}))
+
+	err = badHandle.Wait(waitCtx)
+	var streamErr *StreamError
+	require.ErrorAs(t, err, &streamErr)
+	assert.Equal(t, codes.InvalidArgument, streamErr.Code)
+	assert.True(t, proto.Equal(badPkt, streamErr.PacketOut))
+	assert.Equal(t, err, badHandle.Err())
+
+	// no StreamError within StreamErrorTimeout
+	assert.NoError(t, goodHandle.Wait(waitCtx))
+
+	select {
+	case err := <-callbackCh:
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	case <-waitCtx.Done():
+		require.FailNow(t, "timeout when waiting for callback")
+	}
+	assert.Len(t, s.PacketOuts(), 1)
+}
+
+func TestSendHandleStreamClosed(t *testing.T) {
+	ctx := context.Background()
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c := newFakeServerClient(t, s, 1, false, func(options *ClientOptions) {
+		options.StreamErrorTimeout = time.Hour
+	})
+	arbitrationCh := make(chan bool, 10)
+	require.NoError(t, c.Start(ctx, arbitrationCh, nil))
+	assert.True(t, waitPrimaryStatus(t, arbitrationCh))
+
+	handle, err := c.SendPacketOutWithHandle(ctx, &p4_v1.PacketOut{Payload: []byte{1}})
+	require.NoError(t, err)
+	select {
+	case <-handle.Done():
+		require.FailNow(t, "handle should not be resolved yet")
+	default:
+		assert.NoError(t, handle.Err())
+	}
+	require.NoError(t, c.Close())
+	<-handle.Done()
+	assert.True(t, errors.Is(handle.Err(), ErrClosed))
+
+	_, err = c.SendPacketOutWithHandle(ctx, &p4_v1.PacketOut{})
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.Empty(t, c.tracked)
+}
+
+func TestSendHandleTimeoutStartsWhenSent(t *testing.T) {
+	ctx := context.Background()
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c := newFakeServerClient(t, s, 1, false, func(options *ClientOptions) {
+		options.StreamErrorTimeout = 50 * time.Millisecond
+	})
+	defer c.Close()
+
+	// the message is queued before the stream is started
+	handle, err := c.SendPacketOutWithHandle(ctx, &p4_v1.PacketOut{Payload: []byte{1}})
+	require.NoError(t, err)
+	select {
+	case <-handle.Done():
+		require.FailNow(t, "handle resolved before the message was sent")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	arbitrationCh := make(chan bool, 10)
+	require.NoError(t, c.Start(ctx, arbitrationCh, nil))
+	assert.True(t, waitPrimaryStatus(t, arbitrationCh))
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, handle.Wait(waitCtx))
+	assert.Len(t, s.PacketOuts(), 1)
+}
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/fakeserver"
+)
+
+func waitDone(t *testing.T, c *Client) {
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timeout when waiting for stream to terminate")
+	}
+}
+
+func TestCloseFlushesPacketOuts(t *testing.T) {
+	ctx := context.Background()
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c := newFakeServerClient(t, s, 1, false)
+	arbitrationCh := make(chan bool, 10)
+	require.NoError(t, c.Start(ctx, arbitrationCh, nil))
+	assert.True(t, waitPrimaryStatus(t, arbitrationCh))
+
+	const numPackets = 100
+	for i := 0; i < numPackets; i++ {
+		require.NoError(t, c.SendPacketOut(ctx, &p4_v1.PacketOut{Payload: []byte{byte(i)}}))
+	}
+	require.NoError(t, c.Close())
+	assert.NoError(t, c.Err())
+
+	packetOuts := s.PacketOuts()
+	require.Len(t, packetOuts, numPackets)
+	for i, pkt := range packetOuts {
+		assert.Equal(t, []byte{byte(i)}, pkt.Payload)
+	}
+
+	assert.ErrorIs(t, c.SendPacketOut(ctx, &p4_v1.PacketOut{}), ErrClosed)
+	assert.ErrorIs(t, c.Start(ctx, nil, nil), ErrClosed)
+	// Close is idempotent
+	assert.NoError(t, c.Close())
+}
+
+func TestStartContextCancelled(t *testing.T) {
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c := newFakeServerClient(t, s, 1, false)
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	arbitrationCh := make(chan bool, 10)
+	require.NoError(t, c.Start(ctx, arbitrationCh, nil))
+	assert.ErrorContains(t, c.Start(ctx, nil, nil), "already running")
+	assert.True(t, waitPrimaryStatus(t, arbitrationCh))
+
+	cancel()
+	waitDone(t, c)
+	// stopping the stream with the context is not an error
+	assert.NoError(t, c.Err())
+	assert.Equal(t, ErrClosed, c.SendPacketOut(context.Background(), &p4_v1.PacketOut{}))
+
+	// the stream can be started again, once the server has noticed that the
+	// previous stream (which uses the same election id) is gone
+	require.Eventually(t, func() bool {
+		if err := c.Start(context.Background(), arbitrationCh, nil); err != nil {
+			return false
+		}
+		select {
+		case isPrimary := <-arbitrationCh:
+			return isPrimary
+		case <-c.Done():
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, c.SendPacketOut(context.Background(), &p4_v1.PacketOut{}))
+}
+
+func TestStartContextDeadline(t *testing.T) {
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c := newFakeServerClient(t, s, 1, false)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	arbitrationCh := make(chan bool, 10)
+	require.NoError(t, c.Start(ctx, arbitrationCh, nil))
+	assert.True(t, waitPrimaryStatus(t, arbitrationCh))
+
+	waitDone(t, c)
+	assert.NoError(t, c.Err())
+}
+
+func TestStreamClosedByServer(t *testing.T) {
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c := newFakeServerClient(t, s, 1, false)
+	defer c.Close()
+	arbitrationCh := make(chan bool, 10)
+	require.NoError(t, c.Start(context.Background(), arbitrationCh, nil))
+	assert.True(t, waitPrimaryStatus(t, arbitrationCh))
+
+	s.CloseStreams()
+	waitDone(t, c)
+	assert.Error(t, c.Err())
+	assert.ErrorIs(t, c.SendPacketOut(context.Background(), &p4_v1.PacketOut{}), ErrClosed)
+}
+
+func TestRunStopped(t *testing.T) {
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c := newFakeServerClient(t, s, 1, false)
+	stopCh := make(chan struct{})
+	arbitrationCh := make(chan bool, 10)
+	runErrCh := make(chan error, 1)
+	go func() {
+		runErrCh <- c.Run(stopCh, arbitrationCh, nil)
+	}()
+	assert.True(t, waitPrimaryStatus(t, arbitrationCh))
+	require.NoError(t, c.SendPacketOut(context.Background(), &p4_v1.PacketOut{}))
+	close(stopCh)
+	select {
+	case err := <-runErrCh:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timeout when waiting for Run to return")
+	}
+	assert.Len(t, s.PacketOuts(), 1)
+}
+
+func TestSendBufferSize(t *testing.T) {
+	s := fakeserver.NewServer(1, &p4_config_v1.P4Info{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	c := newFakeServerClient(t, s, 1, false, WithSendBufferSize(1))
+	defer c.Close()
+	// messages are queued until the stream is started
+	require.NoError(t, c.SendPacketOut(context.Background(), &p4_v1.PacketOut{}))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c.SendPacketOut(ctx, &p4_v1.PacketOut{}), context.DeadlineExceeded)
+
+	// a negative size is ignored
+	c = newFakeServerClient(t, s, 1, false, WithSendBufferSize(-1))
+	defer c.Close()
+	assert.Equal(t, defaultSendBufferSize, cap(c.streamSendCh))
+}
+
+// stalledStream is a StreamChannel stream on which Send blocks, until failCh is
+// closed and the stream fails.
+type stalledStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	failCh chan struct{}
+}
+
+func (s *stalledStream) Send(*p4_v1.StreamMessageRequest) error {
+	select {
+	case <-s.failCh:
+		return io.EOF
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *stalledStream) Recv() (*p4_v1.StreamMessageResponse, error) {
+	select {
+	case <-s.failCh:
+		return nil, status.Error(codes.Unavailable, "connection lost")
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+func TestQueuedMessagesDroppedWhenStreamFails(t *testing.T) {
+	ctx := context.Background()
+	failCh := make(chan struct{})
+	p4RtClient := &fakeP4RuntimeClient{
+		streamChannelFn: func(ctx context.Context, opts ...grpc.CallOption) (p4_v1.P4Runtime_StreamChannelClient, error) {
+			return &stalledStream{ctx: ctx, failCh: failCh}, nil
+		},
+	}
+	metrics := &fakeMetrics{}
+	c := newTestClient(p4RtClient, nil)
+	c.Metrics = metrics
+	require.NoError(t, c.Start(ctx, nil, nil))
+
+	// the stream is stuck sending the arbitration update
+	require.NoError(t, c.SendPacketOut(ctx, &p4_v1.PacketOut{Payload: []byte{1}}))
+	handle, err := c.SendPacketOutWithHandle(ctx, &p4_v1.PacketOut{Payload: []byte{2}})
+	require.NoError(t, err)
+	close(failCh)
+	waitDone(t, c)
+	assert.ErrorContains(t, c.Err(), "connection lost")
+
+	// the messages are not sent on the next stream
+	assert.Empty(t, c.streamSendCh)
+	assert.Equal(t, 2, metrics.dropped)
+	<-handle.Done()
+	assert.ErrorIs(t, handle.Err(), ErrClosed)
+}
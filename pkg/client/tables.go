@@ -146,8 +146,8 @@ type TableEntryOptions struct {
 	Priority    int32
 }
 
-func (c *Client) newAction(action string, params [][]byte) *p4_v1.Action {
-	actionID := c.actionId(action)
+func (p *p4InfoSnapshot) newAction(action string, params [][]byte) *p4_v1.Action {
+	actionID := p.actionId(action)
 	directAction := &p4_v1.Action{
 		ActionId: actionID,
 	}
@@ -167,19 +167,28 @@ func (c *Client) NewTableActionDirect(
 	action string,
 	params [][]byte,
 ) *p4_v1.TableAction {
+	return c.P4InfoView().NewTableActionDirect(action, params)
+}
+
+func newTableActionDirect(p4Info *p4InfoSnapshot, action string, params [][]byte) *p4_v1.TableAction {
 	return &p4_v1.TableAction{
-		Type: &p4_v1.TableAction_Action{Action: c.newAction(action, params)},
+		Type: &p4_v1.TableAction_Action{Action: p4Info.newAction(action, params)},
 	}
 }
 
 type ActionProfileActionSet struct {
-	client *Client
+	// P4Info used to build the actions
+	p4Info *p4InfoSnapshot
 	action *p4_v1.TableAction
 }
 
 func (c *Client) NewActionProfileActionSet() *ActionProfileActionSet {
+	return c.P4InfoView().NewActionProfileActionSet()
+}
+
+func newActionProfileActionSet(p4Info *p4InfoSnapshot) *ActionProfileActionSet {
 	return &ActionProfileActionSet{
-		client: c,
+		p4Info: p4Info,
 		action: &p4_v1.TableAction{
 			Type: &p4_v1.TableAction_ActionProfileActionSet{
 				ActionProfileActionSet: &p4_v1.ActionProfileActionSet{},
@@ -198,7 +207,7 @@ func (s *ActionProfileActionSet) AddAction(
 	actionSet.ActionProfileActions = append(
 		actionSet.ActionProfileActions,
 		&p4_v1.ActionProfileAction{
-			Action: s.client.newAction(action, params),
+			Action: s.p4Info.newAction(action, params),
 			Weight: weight,
 			WatchKind: &p4_v1.ActionProfileAction_WatchPort{
 				WatchPort: port.AsBytes(),
@@ -220,7 +229,17 @@ func (c *Client) NewTableEntry(
 	action *p4_v1.TableAction,
 	options *TableEntryOptions,
 ) *p4_v1.TableEntry {
-	tableID := c.tableId(table)
+	return c.P4InfoView().NewTableEntry(table, mfs, action, options)
+}
+
+func (c *Client) newTableEntry(
+	p4Info *p4InfoSnapshot,
+	table string,
+	mfs map[string]MatchInterface,
+	action *p4_v1.TableAction,
+	options *TableEntryOptions,
+) *p4_v1.TableEntry {
+	tableID := p4Info.tableId(table)
 
 	entry := &p4_v1.TableEntry{
 		TableId: tableID,
@@ -232,7 +251,7 @@ func (c *Client) NewTableEntry(
 	//nolint:staticcheck // SA5011 if mfs==nil then for loop is not executed by default
 	//lint:ignore SA5011 This line added for support golint version of VSC
 	for name, mf := range mfs {
-		fieldID := c.matchFieldId(table, name)
+		fieldID := p4Info.matchFieldId(table, name)
 		entry.Match = append(entry.Match, mf.get(fieldID, c.CanonicalBytestrings))
 	}
 
@@ -245,7 +264,7 @@ func (c *Client) NewTableEntry(
 }
 
 func (c *Client) ReadTableEntry(ctx context.Context, table string, mfs []MatchInterface) (*p4_v1.TableEntry, error) {
-	tableID := c.tableId(table)
+	tableID := c.currentP4Info().tableId(table)
 
 	entry := &p4_v1.TableEntry{
 		TableId: tableID,
@@ -273,7 +292,7 @@ func (c *Client) ReadTableEntry(ctx context.Context, table string, mfs []MatchIn
 }
 
 func (c *Client) ReadTableEntryWildcard(ctx context.Context, table string) ([]*p4_v1.TableEntry, error) {
-	tableID := c.tableId(table)
+	tableID := c.currentP4Info().tableId(table)
 
 	entry := &p4_v1.TableEntry{
 		TableId: tableID,
@@ -0,0 +1,595 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/protobuf/encoding/prototext"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/util/conversion"
+)
+
+// The text format for table entries uses P4Info names instead of ids:
+//
+//	<table> <field>=<value> ... [priority=<n>] [idle_timeout=<duration>] -> <action>
+//	<table> default -> <action>
+//
+// Match field values depend on the match kind:
+//
+//	exact:    <value>
+//	lpm:      <value>/<prefix length>
+//	ternary:  <value>&&&<mask>
+//	range:    <low>..<high>
+//	optional: <value>
+//
+// Don't care ternary, range and optional fields can be omitted. Values can be
+// MAC addresses, IPv4 / IPv6 addresses, integers (decimal, 0x..., 0b...) or
+// double-quoted strings, see conversion.ParseLiteral. The action can be one of:
+//
+//	<action>(<param>=<value>, ...)
+//	member:<member id>
+//	group:<group id>
+//	[<action>(<param>=<value>, ...)*<weight>@<watch port>, ...]
+//
+// The last form is a one-shot action profile action set; "@<watch port>" is
+// optional. The action can be omitted altogether (together with "->"), which
+// is useful for DELETE operations.
+//
+// For example:
+//
+//	IngressImpl.dmac hdr.ethernet.dstAddr=00:11:22:33:44:55 -> IngressImpl.fwd(port=3)
+
+const (
+	textFormatArrow       = "->"
+	textFormatDefault     = "default"
+	textFormatPriority    = "priority"
+	textFormatIdleTimeout = "idle_timeout"
+)
+
+// splitRespectingQuotes splits s around each instance of sep (or around
+// whitespace if sep is 0), ignoring separators in double-quoted strings.
+// Empty fields are dropped.
+func splitRespectingQuotes(s string, sep rune) []string {
+	var fields []string
+	var current strings.Builder
+	inQuotes := false
+	flush := func() {
+		if f := strings.TrimSpace(current.String()); f != "" {
+			fields = append(fields, f)
+		}
+		current.Reset()
+	}
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(r)
+		case !inQuotes && ((sep == 0 && (r == ' ' || r == '\t')) || (sep != 0 && r == sep)):
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return fields
+}
+
+func splitKeyValue(s string) (string, string, error) {
+	idx := strings.Index(s, "=")
+	if idx <= 0 {
+		return "", "", fmt.Errorf("expected <name>=<value> but got '%s'", s)
+	}
+	return strings.TrimSpace(s[:idx]), strings.TrimSpace(s[idx+1:]), nil
+}
+
+// isIPHint returns true if one of the components of name (separated by
+// non-alphanumeric characters or by a lower-case to upper-case transition, as
+// in "dstAddr") suggests that the value is an IP address.
+func isIPHint(name string) bool {
+	var components []string
+	start := 0
+	var prev rune
+	for idx, r := range name {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			components = append(components, name[start:idx])
+			start = idx + 1
+		case unicode.IsUpper(r) && unicode.IsLower(prev):
+			components = append(components, name[start:idx])
+			start = idx
+		}
+		prev = r
+	}
+	components = append(components, name[start:])
+	for _, component := range components {
+		switch strings.ToLower(component) {
+		case "ip", "ipv4", "ipv6", "ip4", "ip6", "addr", "address", "ipaddr":
+			return true
+		}
+	}
+	return false
+}
+
+// formatLiteral is the inverse of conversion.ParseLiteral. The name of the
+// field or parameter is used as a hint to format IP addresses.
+func formatLiteral(b []byte, bitwidth int32, name string, preferHex bool) string {
+	numBytes := int((bitwidth + 7) / 8)
+	padded := conversion.ToCanonicalBytestring(b)
+	if len(padded) < numBytes {
+		padded = append(make([]byte, numBytes-len(padded)), padded...)
+	}
+	switch {
+	case bitwidth == 48:
+		return net.HardwareAddr(padded).String()
+	case bitwidth == 32 && isIPHint(name):
+		return net.IP(padded).String()
+	case bitwidth == 128 && isIPHint(name):
+		ip := net.IP(padded)
+		if ip4 := ip.To4(); ip4 != nil {
+			// net.IP.String formats IPv4-mapped addresses as IPv4 addresses,
+			// which would be parsed back as 32-bit values
+			return "::ffff:" + ip4.String()
+		}
+		return ip.String()
+	}
+	trimmed := conversion.ToCanonicalBytestring(b)
+	if len(trimmed) > 8 && isPrintable(trimmed) {
+		return strconv.Quote(string(trimmed))
+	}
+	i := new(big.Int).SetBytes(b)
+	if preferHex || len(trimmed) > 4 {
+		return "0x" + i.Text(16)
+	}
+	return i.Text(10)
+}
+
+func isPrintable(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func fullMask(bitwidth int32) []byte {
+	numBytes := int((bitwidth + 7) / 8)
+	mask := make([]byte, numBytes)
+	for i := range mask {
+		mask[i] = 0xff
+	}
+	if r := bitwidth % 8; r != 0 && numBytes > 0 {
+		mask[0] = 0xff >> (8 - r)
+	}
+	return mask
+}
+
+func (c *Client) parseMatch(mf *p4_config_v1.MatchField, value string) (MatchInterface, error) {
+	switch mf.GetMatchType() {
+	case p4_config_v1.MatchField_EXACT:
+		v, err := conversion.ParseLiteral(value, mf.Bitwidth)
+		if err != nil {
+			return nil, err
+		}
+		return &ExactMatch{Value: v}, nil
+	case p4_config_v1.MatchField_LPM:
+		valueStr, pLenStr, found := strings.Cut(value, "/")
+		v, err := conversion.ParseLiteral(valueStr, mf.Bitwidth)
+		if err != nil {
+			return nil, err
+		}
+		pLen := mf.Bitwidth
+		if found {
+			pLen64, err := strconv.ParseInt(pLenStr, 10, 32)
+			if err != nil || pLen64 < 0 || int32(pLen64) > mf.Bitwidth {
+				return nil, fmt.Errorf("invalid prefix length '%s'", pLenStr)
+			}
+			pLen = int32(pLen64)
+		}
+		return &LpmMatch{Value: v, PLen: pLen}, nil
+	case p4_config_v1.MatchField_TERNARY:
+		valueStr, maskStr, found := strings.Cut(value, "&&&")
+		v, err := conversion.ParseLiteral(valueStr, mf.Bitwidth)
+		if err != nil {
+			return nil, err
+		}
+		mask := fullMask(mf.Bitwidth)
+		if found {
+			if mask, err = conversion.ParseLiteral(maskStr, mf.Bitwidth); err != nil {
+				return nil, err
+			}
+		}
+		return &TernaryMatch{Value: v, Mask: mask}, nil
+	case p4_config_v1.MatchField_RANGE:
+		lowStr, highStr, found := strings.Cut(value, "..")
+		if !found {
+			return nil, fmt.Errorf("expected <low>..<high> for range match but got '%s'", value)
+		}
+		low, err := conversion.ParseLiteral(lowStr, mf.Bitwidth)
+		if err != nil {
+			return nil, err
+		}
+		high, err := conversion.ParseLiteral(highStr, mf.Bitwidth)
+		if err != nil {
+			return nil, err
+		}
+		return &RangeMatch{Low: low, High: high}, nil
+	case p4_config_v1.MatchField_OPTIONAL:
+		v, err := conversion.ParseLiteral(value, mf.Bitwidth)
+		if err != nil {
+			return nil, err
+		}
+		return &OptionalMatch{Value: v}, nil
+	}
+	return nil, fmt.Errorf("unsupported match type for field %s", mf.Name)
+}
+
+// ParseAction builds an action from its text representation, i.e.
+// <action>(<param>=<value>, ...).
+func (c *Client) ParseAction(s string) (*p4_v1.Action, error) {
+	return c.parseAction(c.currentP4Info(), s)
+}
+
+func (c *Client) parseAction(p4Info *p4InfoSnapshot, s string) (*p4_v1.Action, error) {
+	name, paramsStr, found := strings.Cut(s, "(")
+	name = strings.TrimSpace(name)
+	action := p4Info.findAction(name)
+	if action == nil {
+		return nil, fmt.Errorf("action %s not found", name)
+	}
+	out := &p4_v1.Action{ActionId: action.Preamble.Id}
+	if !found {
+		if len(action.Params) > 0 {
+			return nil, fmt.Errorf("missing parameters for action %s", name)
+		}
+		return out, nil
+	}
+	paramsStr = strings.TrimSpace(paramsStr)
+	if !strings.HasSuffix(paramsStr, ")") {
+		return nil, fmt.Errorf("missing closing parenthesis for action %s", name)
+	}
+	values := make(map[string]string)
+	for _, p := range splitRespectingQuotes(strings.TrimSuffix(paramsStr, ")"), ',') {
+		k, v, err := splitKeyValue(p)
+		if err != nil {
+			return nil, err
+		}
+		values[k] = v
+	}
+	for _, param := range action.Params {
+		v, ok := values[param.Name]
+		if !ok {
+			return nil, fmt.Errorf("missing parameter %s for action %s", param.Name, name)
+		}
+		delete(values, param.Name)
+		b, err := conversion.ParseLiteral(v, param.Bitwidth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for parameter %s: %v", param.Name, err)
+		}
+		out.Params = append(out.Params, &p4_v1.Action_Param{
+			ParamId: param.Id,
+			Value:   ToCanonicalIf(b, c.CanonicalBytestrings),
+		})
+	}
+	for k := range values {
+		return nil, fmt.Errorf("unknown parameter %s for action %s", k, name)
+	}
+	return out, nil
+}
+
+func (c *Client) parseTableAction(p4Info *p4InfoSnapshot, s string) (*p4_v1.TableAction, error) {
+	if v, found := strings.CutPrefix(s, "member:"); found {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid member id '%s'", v)
+		}
+		return c.NewTableActionMember(uint32(id)), nil
+	}
+	if v, found := strings.CutPrefix(s, "group:"); found {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group id '%s'", v)
+		}
+		return c.NewTableActionGroup(uint32(id)), nil
+	}
+	if strings.HasPrefix(s, "[") {
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("missing closing bracket for action set")
+		}
+		actionSet := &p4_v1.ActionProfileActionSet{}
+		// we cannot split on commas directly because of action parameters
+		for _, a := range splitActionSet(s[1 : len(s)-1]) {
+			actionStr, weightStr, found := strings.Cut(a, ")*")
+			if !found {
+				return nil, fmt.Errorf("expected <action>(...)*<weight> in action set but got '%s'", a)
+			}
+			weightStr, portStr, hasPort := strings.Cut(weightStr, "@")
+			action, err := c.parseAction(p4Info, actionStr+")")
+			if err != nil {
+				return nil, err
+			}
+			weight, err := strconv.ParseInt(weightStr, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight '%s'", weightStr)
+			}
+			apAction := &p4_v1.ActionProfileAction{Action: action, Weight: int32(weight)}
+			if hasPort {
+				port, err := conversion.ParseLiteral(portStr, 0)
+				if err != nil {
+					return nil, fmt.Errorf("invalid watch port: %v", err)
+				}
+				apAction.WatchKind = &p4_v1.ActionProfileAction_WatchPort{WatchPort: port}
+			}
+			actionSet.ActionProfileActions = append(actionSet.ActionProfileActions, apAction)
+		}
+		return &p4_v1.TableAction{
+			Type: &p4_v1.TableAction_ActionProfileActionSet{ActionProfileActionSet: actionSet},
+		}, nil
+	}
+	action, err := c.parseAction(p4Info, s)
+	if err != nil {
+		return nil, err
+	}
+	return &p4_v1.TableAction{Type: &p4_v1.TableAction_Action{Action: action}}, nil
+}
+
+// splitActionSet splits the contents of an action set on the commas which are
+// not inside parentheses or double quotes.
+func splitActionSet(s string) []string {
+	var out []string
+	depth := 0
+	inQuotes := false
+	start := 0
+	for i, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case inQuotes:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			out = append(out, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		out = append(out, last)
+	}
+	return out
+}
+
+// ParseTableEntry builds a table entry from its text representation. See the
+// description of the format at the top of this file.
+func (c *Client) ParseTableEntry(s string) (*p4_v1.TableEntry, error) {
+	lhs, rhs, hasAction := strings.Cut(s, textFormatArrow)
+	fields := splitRespectingQuotes(lhs, 0)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing table name")
+	}
+	tableName := fields[0]
+	// all names are resolved with the same P4Info
+	p4Info := c.currentP4Info()
+	table := p4Info.findTable(tableName)
+	if table == nil {
+		return nil, fmt.Errorf("table %s not found", tableName)
+	}
+	matchFields := make(map[string]*p4_config_v1.MatchField)
+	for _, mf := range table.MatchFields {
+		matchFields[mf.Name] = mf
+	}
+
+	mfs := make(map[string]MatchInterface)
+	isDefault := false
+	var options *TableEntryOptions
+	for _, f := range fields[1:] {
+		if f == textFormatDefault {
+			if len(mfs) > 0 {
+				return nil, fmt.Errorf("default entries cannot have match fields")
+			}
+			isDefault = true
+			continue
+		}
+		k, v, err := splitKeyValue(f)
+		if err != nil {
+			return nil, err
+		}
+		if mf, ok := matchFields[k]; ok {
+			if isDefault {
+				return nil, fmt.Errorf("default entries cannot have match fields")
+			}
+			m, err := c.parseMatch(mf, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for match field %s: %v", k, err)
+			}
+			mfs[k] = m
+			continue
+		}
+		if options == nil {
+			options = &TableEntryOptions{}
+		}
+		switch k {
+		case textFormatPriority:
+			priority, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid priority '%s'", v)
+			}
+			options.Priority = int32(priority)
+		case textFormatIdleTimeout:
+			timeout, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid idle timeout '%s'", v)
+			}
+			options.IdleTimeout = timeout
+		default:
+			return nil, fmt.Errorf("unknown match field %s for table %s", k, tableName)
+		}
+	}
+
+	if isDefault {
+		mfs = nil
+	}
+
+	var action *p4_v1.TableAction
+	if hasAction {
+		var err error
+		if action, err = c.parseTableAction(p4Info, strings.TrimSpace(rhs)); err != nil {
+			return nil, err
+		}
+	}
+	return c.newTableEntry(p4Info, tableName, mfs, action, options), nil
+}
+
+// ParseTableEntries reads table entries in text format, one per line. Empty
+// lines and lines starting with '#' are ignored.
+func (c *Client) ParseTableEntries(r io.Reader) ([]*p4_v1.TableEntry, error) {
+	var entries []*p4_v1.TableEntry
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := c.ParseTableEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *Client) formatMatch(mf *p4_config_v1.MatchField, m *p4_v1.FieldMatch) string {
+	switch v := m.FieldMatchType.(type) {
+	case *p4_v1.FieldMatch_Exact_:
+		return formatLiteral(v.Exact.Value, mf.Bitwidth, mf.Name, false)
+	case *p4_v1.FieldMatch_Lpm:
+		return fmt.Sprintf("%s/%d", formatLiteral(v.Lpm.Value, mf.Bitwidth, mf.Name, false), v.Lpm.PrefixLen)
+	case *p4_v1.FieldMatch_Ternary_:
+		return fmt.Sprintf("%s&&&%s", formatLiteral(v.Ternary.Value, mf.Bitwidth, mf.Name, false), formatLiteral(v.Ternary.Mask, mf.Bitwidth, mf.Name, true))
+	case *p4_v1.FieldMatch_Range_:
+		return fmt.Sprintf("%s..%s", formatLiteral(v.Range.Low, mf.Bitwidth, mf.Name, false), formatLiteral(v.Range.High, mf.Bitwidth, mf.Name, false))
+	case *p4_v1.FieldMatch_Optional_:
+		return formatLiteral(v.Optional.Value, mf.Bitwidth, mf.Name, false)
+	}
+	return "?"
+}
+
+// FormatAction returns the text representation of an action, using P4Info
+// names.
+func (c *Client) FormatAction(action *p4_v1.Action) (string, error) {
+	return c.formatAction(c.currentP4Info(), action)
+}
+
+func (c *Client) formatAction(p4Info *p4InfoSnapshot, action *p4_v1.Action) (string, error) {
+	p4Action := p4Info.findActionByID(action.ActionId)
+	if p4Action == nil {
+		return "", fmt.Errorf("action %d not found", action.ActionId)
+	}
+	params := make([]string, 0, len(action.Params))
+	for _, param := range action.Params {
+		var p4Param *p4_config_v1.Action_Param
+		for _, p := range p4Action.Params {
+			if p.Id == param.ParamId {
+				p4Param = p
+				break
+			}
+		}
+		if p4Param == nil {
+			return "", fmt.Errorf("parameter %d not found for action %s", param.ParamId, p4Action.Preamble.Name)
+		}
+		params = append(params, fmt.Sprintf("%s=%s", p4Param.Name, formatLiteral(param.Value, p4Param.Bitwidth, p4Param.Name, false)))
+	}
+	return fmt.Sprintf("%s(%s)", p4Action.Preamble.Name, strings.Join(params, ", ")), nil
+}
+
+// FormatTableEntry returns the text representation of a table entry, using
+// P4Info names. See the description of the format at the top of this file.
+func (c *Client) FormatTableEntry(entry *p4_v1.TableEntry) (string, error) {
+	p4Info := c.currentP4Info()
+	table := p4Info.findTableByID(entry.TableId)
+	if table == nil {
+		return "", fmt.Errorf("table %d not found", entry.TableId)
+	}
+	var b strings.Builder
+	b.WriteString(table.Preamble.Name)
+	if entry.IsDefaultAction {
+		b.WriteString(" " + textFormatDefault)
+	}
+	// use the P4Info order for match fields
+	for _, mf := range table.MatchFields {
+		for _, m := range entry.Match {
+			if m.FieldId == mf.Id {
+				fmt.Fprintf(&b, " %s=%s", mf.Name, c.formatMatch(mf, m))
+			}
+		}
+	}
+	if entry.Priority != 0 {
+		fmt.Fprintf(&b, " %s=%d", textFormatPriority, entry.Priority)
+	}
+	if entry.IdleTimeoutNs != 0 {
+		fmt.Fprintf(&b, " %s=%v", textFormatIdleTimeout, time.Duration(entry.IdleTimeoutNs))
+	}
+	if entry.Action == nil {
+		return b.String(), nil
+	}
+	b.WriteString(" " + textFormatArrow + " ")
+	switch a := entry.Action.Type.(type) {
+	case *p4_v1.TableAction_Action:
+		s, err := c.formatAction(p4Info, a.Action)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s)
+	case *p4_v1.TableAction_ActionProfileMemberId:
+		fmt.Fprintf(&b, "member:%d", a.ActionProfileMemberId)
+	case *p4_v1.TableAction_ActionProfileGroupId:
+		fmt.Fprintf(&b, "group:%d", a.ActionProfileGroupId)
+	case *p4_v1.TableAction_ActionProfileActionSet:
+		actions := make([]string, 0, len(a.ActionProfileActionSet.ActionProfileActions))
+		for _, apAction := range a.ActionProfileActionSet.ActionProfileActions {
+			s, err := c.formatAction(p4Info, apAction.Action)
+			if err != nil {
+				return "", err
+			}
+			s = fmt.Sprintf("%s*%d", s, apAction.Weight)
+			if port := apAction.GetWatchPort(); port != nil {
+				s = fmt.Sprintf("%s@%s", s, formatLiteral(port, 0, "", false))
+			}
+			actions = append(actions, s)
+		}
+		fmt.Fprintf(&b, "[%s]", strings.Join(actions, ", "))
+	default:
+		return "", fmt.Errorf("unsupported table action type %T", a)
+	}
+	return b.String(), nil
+}
+
+// FormatEntity returns a human-readable representation of an entity. Table
+// entries use the text format of FormatTableEntry, while other entities (or
+// table entries which cannot be decoded with the current P4Info) are formatted
+// as single-line prototext.
+func (c *Client) FormatEntity(entity *p4_v1.Entity) string {
+	if entry := entity.GetTableEntry(); entry != nil {
+		if s, err := c.FormatTableEntry(entry); err == nil {
+			return s
+		}
+	}
+	return prototext.MarshalOptions{Multiline: false}.Format(entity)
+}
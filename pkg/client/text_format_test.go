@@ -0,0 +1,136 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+func newTextFormatTestP4Info() *p4_config_v1.P4Info {
+	matchField := func(id uint32, name string, bitwidth int32, matchType p4_config_v1.MatchField_MatchType) *p4_config_v1.MatchField {
+		return &p4_config_v1.MatchField{
+			Id:       id,
+			Name:     name,
+			Bitwidth: bitwidth,
+			Match:    &p4_config_v1.MatchField_MatchType_{MatchType: matchType},
+		}
+	}
+	return &p4_config_v1.P4Info{
+		Tables: []*p4_config_v1.Table{
+			{
+				Preamble: &p4_config_v1.Preamble{Name: "IngressImpl.dmac", Id: 1},
+				MatchFields: []*p4_config_v1.MatchField{
+					matchField(1, "hdr.ethernet.dstAddr", 48, p4_config_v1.MatchField_EXACT),
+				},
+			},
+			{
+				Preamble: &p4_config_v1.Preamble{Name: "IngressImpl.acl", Id: 2},
+				MatchFields: []*p4_config_v1.MatchField{
+					matchField(1, "hdr.ipv4.dstAddr", 32, p4_config_v1.MatchField_LPM),
+					matchField(2, "hdr.ipv6.srcAddr", 128, p4_config_v1.MatchField_TERNARY),
+					matchField(3, "hdr.tcp.dstPort", 16, p4_config_v1.MatchField_RANGE),
+					matchField(4, "meta.group", 256, p4_config_v1.MatchField_OPTIONAL),
+					matchField(5, "meta.skip", 32, p4_config_v1.MatchField_EXACT),
+				},
+			},
+		},
+		Actions: []*p4_config_v1.Action{
+			{
+				Preamble: &p4_config_v1.Preamble{Name: "IngressImpl.fwd", Id: 10},
+				Params: []*p4_config_v1.Action_Param{
+					{Id: 1, Name: "port", Bitwidth: 9},
+				},
+			},
+			{
+				Preamble: &p4_config_v1.Preamble{Name: "NoAction", Id: 11},
+			},
+		},
+	}
+}
+
+func TestTextFormatRoundTrip(t *testing.T) {
+	c := newTestClient(&fakeP4RuntimeClient{}, newTextFormatTestP4Info())
+	testCases := []string{
+		"IngressImpl.dmac hdr.ethernet.dstAddr=00:11:22:33:44:55 -> IngressImpl.fwd(port=3)",
+		"IngressImpl.dmac default -> NoAction()",
+		"IngressImpl.dmac hdr.ethernet.dstAddr=00:11:22:33:44:55",
+		"IngressImpl.acl hdr.ipv4.dstAddr=10.0.0.0/8 hdr.ipv6.srcAddr=2001:db8::&&&ffff:ffff:: hdr.tcp.dstPort=80..443 priority=10 idle_timeout=10s -> IngressImpl.fwd(port=511)",
+		"IngressImpl.acl meta.group=\"group-number-1\" priority=1 -> member:5",
+		// IPv4-mapped IPv6 address
+		"IngressImpl.acl hdr.ipv6.srcAddr=::ffff:10.0.0.1&&&ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff priority=1 -> NoAction()",
+		// not an IP address, even though the name contains "ip"
+		"IngressImpl.acl meta.skip=167772161 priority=1 -> NoAction()",
+		"IngressImpl.acl priority=1 -> group:7",
+		"IngressImpl.acl priority=1 -> [IngressImpl.fwd(port=1)*2@1, IngressImpl.fwd(port=2)*3]",
+	}
+	for _, tc := range testCases {
+		entry, err := c.ParseTableEntry(tc)
+		require.NoError(t, err, tc)
+		s, err := c.FormatTableEntry(entry)
+		require.NoError(t, err, tc)
+		assert.Equal(t, tc, s)
+	}
+}
+
+func TestParseTableEntry(t *testing.T) {
+	c := newTestClient(&fakeP4RuntimeClient{}, newTextFormatTestP4Info())
+
+	entry, err := c.ParseTableEntry("IngressImpl.dmac hdr.ethernet.dstAddr=00:00:00:00:00:0a -> IngressImpl.fwd(port=0x3)")
+	require.NoError(t, err)
+	expected := c.NewTableEntry(
+		"IngressImpl.dmac",
+		map[string]MatchInterface{
+			"hdr.ethernet.dstAddr": &ExactMatch{Value: []byte{0, 0, 0, 0, 0, 10}},
+		},
+		c.NewTableActionDirect("IngressImpl.fwd", [][]byte{{3}}),
+		nil,
+	)
+	assert.True(t, proto.Equal(expected, entry))
+
+	entry, err = c.ParseTableEntry("IngressImpl.acl hdr.ipv4.dstAddr=10.1.2.3/8 idle_timeout=1m -> NoAction")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{10, 0, 0, 0}, entry.Match[0].GetLpm().Value)
+	assert.Equal(t, time.Minute.Nanoseconds(), entry.IdleTimeoutNs)
+
+	badCases := []string{
+		"",
+		"IngressImpl.foo -> NoAction()",
+		"IngressImpl.dmac hdr.ethernet.srcAddr=00:11:22:33:44:55",
+		"IngressImpl.dmac hdr.ethernet.dstAddr=10.0.0.1",
+		"IngressImpl.dmac hdr.ethernet.dstAddr=00:11:22:33:44:55 -> IngressImpl.fwd(port=1024)",
+		"IngressImpl.dmac hdr.ethernet.dstAddr=00:11:22:33:44:55 -> IngressImpl.fwd()",
+		"IngressImpl.dmac hdr.ethernet.dstAddr=00:11:22:33:44:55 -> IngressImpl.fwd(port=1, foo=2)",
+		"IngressImpl.acl hdr.tcp.dstPort=80 -> NoAction()",
+		"IngressImpl.dmac default hdr.ethernet.dstAddr=00:11:22:33:44:55 -> NoAction()",
+		"IngressImpl.dmac hdr.ethernet.dstAddr=00:11:22:33:44:55 default -> NoAction()",
+	}
+	for _, tc := range badCases {
+		_, err := c.ParseTableEntry(tc)
+		assert.Error(t, err, tc)
+	}
+}
+
+func TestParseTableEntries(t *testing.T) {
+	c := newTestClient(&fakeP4RuntimeClient{}, newTextFormatTestP4Info())
+	input := `
+# L2 forwarding
+IngressImpl.dmac hdr.ethernet.dstAddr=00:11:22:33:44:55 -> IngressImpl.fwd(port=3)
+IngressImpl.dmac hdr.ethernet.dstAddr=00:11:22:33:44:56 -> IngressImpl.fwd(port=4)
+`
+	entries, err := c.ParseTableEntries(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	_, err = c.ParseTableEntries(strings.NewReader("IngressImpl.dmac default -> NoAction()\nfoo\n"))
+	assert.EqualError(t, err, "line 2: table foo not found")
+
+	assert.Equal(t, "IngressImpl.dmac hdr.ethernet.dstAddr=00:11:22:33:44:55 -> IngressImpl.fwd(port=3)", c.FormatEntity(tableEntryToEntity(entries[0])))
+	assert.Contains(t, c.FormatEntity(&p4_v1.Entity{Entity: &p4_v1.Entity_DigestEntry{DigestEntry: &p4_v1.DigestEntry{DigestId: 5}}}), "digest_id:5")
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/logging"
+)
+
+// tracingClient wraps a P4RuntimeClient and logs all requests, responses and
+// stream messages in Protobuf text format, at the debug level.
+type tracingClient struct {
+	p4_v1.P4RuntimeClient
+	log logging.Logger
+}
+
+// tracingClient implements the p4_v1.P4RuntimeClient interface
+var _ p4_v1.P4RuntimeClient = &tracingClient{}
+
+func newTracingClient(client p4_v1.P4RuntimeClient, logger logging.Logger) *tracingClient {
+	return &tracingClient{P4RuntimeClient: client, log: logger}
+}
+
+func (c *tracingClient) trace(msg string, m proto.Message) {
+	if !c.log.DebugEnabled() {
+		return
+	}
+	c.log.Debug(msg, "message", prototext.MarshalOptions{}.Format(m))
+}
+
+func (c *tracingClient) traceError(msg string, err error) {
+	if !c.log.DebugEnabled() {
+		return
+	}
+	c.log.Debug(msg, "error", err)
+}
+
+func (c *tracingClient) Write(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+	c.trace("Write request", in)
+	resp, err := c.P4RuntimeClient.Write(ctx, in, opts...)
+	if err != nil {
+		c.traceError("Write failed", err)
+	}
+	return resp, err
+}
+
+func (c *tracingClient) Read(ctx context.Context, in *p4_v1.ReadRequest, opts ...grpc.CallOption) (p4_v1.P4Runtime_ReadClient, error) {
+	c.trace("Read request", in)
+	stream, err := c.P4RuntimeClient.Read(ctx, in, opts...)
+	if err != nil {
+		c.traceError("Read failed", err)
+		return nil, err
+	}
+	return &tracingReadClient{P4Runtime_ReadClient: stream, client: c}, nil
+}
+
+func (c *tracingClient) SetForwardingPipelineConfig(ctx context.Context, in *p4_v1.SetForwardingPipelineConfigRequest, opts ...grpc.CallOption) (*p4_v1.SetForwardingPipelineConfigResponse, error) {
+	c.trace("SetForwardingPipelineConfig request", in)
+	resp, err := c.P4RuntimeClient.SetForwardingPipelineConfig(ctx, in, opts...)
+	if err != nil {
+		c.traceError("SetForwardingPipelineConfig failed", err)
+	}
+	return resp, err
+}
+
+func (c *tracingClient) GetForwardingPipelineConfig(ctx context.Context, in *p4_v1.GetForwardingPipelineConfigRequest, opts ...grpc.CallOption) (*p4_v1.GetForwardingPipelineConfigResponse, error) {
+	c.trace("GetForwardingPipelineConfig request", in)
+	resp, err := c.P4RuntimeClient.GetForwardingPipelineConfig(ctx, in, opts...)
+	if err != nil {
+		c.traceError("GetForwardingPipelineConfig failed", err)
+		return resp, err
+	}
+	c.trace("GetForwardingPipelineConfig response", resp)
+	return resp, nil
+}
+
+func (c *tracingClient) Capabilities(ctx context.Context, in *p4_v1.CapabilitiesRequest, opts ...grpc.CallOption) (*p4_v1.CapabilitiesResponse, error) {
+	c.trace("Capabilities request", in)
+	resp, err := c.P4RuntimeClient.Capabilities(ctx, in, opts...)
+	if err != nil {
+		c.traceError("Capabilities failed", err)
+		return resp, err
+	}
+	c.trace("Capabilities response", resp)
+	return resp, nil
+}
+
+func (c *tracingClient) StreamChannel(ctx context.Context, opts ...grpc.CallOption) (p4_v1.P4Runtime_StreamChannelClient, error) {
+	stream, err := c.P4RuntimeClient.StreamChannel(ctx, opts...)
+	if err != nil {
+		c.traceError("StreamChannel failed", err)
+		return nil, err
+	}
+	return &tracingStreamChannelClient{P4Runtime_StreamChannelClient: stream, client: c}, nil
+}
+
+type tracingReadClient struct {
+	p4_v1.P4Runtime_ReadClient
+	client *tracingClient
+}
+
+func (c *tracingReadClient) Recv() (*p4_v1.ReadResponse, error) {
+	resp, err := c.P4Runtime_ReadClient.Recv()
+	if err == nil {
+		c.client.trace("Read response", resp)
+	}
+	return resp, err
+}
+
+type tracingStreamChannelClient struct {
+	p4_v1.P4Runtime_StreamChannelClient
+	client *tracingClient
+}
+
+func (c *tracingStreamChannelClient) Send(msg *p4_v1.StreamMessageRequest) error {
+	c.client.trace("Stream message sent", msg)
+	return c.P4Runtime_StreamChannelClient.Send(msg)
+}
+
+func (c *tracingStreamChannelClient) Recv() (*p4_v1.StreamMessageResponse, error) {
+	msg, err := c.P4Runtime_StreamChannelClient.Recv()
+	if err == nil {
+		c.client.trace("Stream message received", msg)
+	}
+	return msg, err
+}
@@ -0,0 +1,33 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/logging"
+)
+
+func TestTracing(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	p4RtClient := &fakeP4RuntimeClient{
+		writeFn: func(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+			return &p4_v1.WriteResponse{}, nil
+		},
+	}
+	c := NewClientForRole(p4RtClient, 3, &p4_v1.Uint128{High: 0, Low: 1}, &p4_v1.Role{Name: "r"}, WithLogger(logger))
+	require.NoError(t, c.WriteUpdate(context.Background(), &p4_v1.Update{
+		Type:   p4_v1.Update_INSERT,
+		Entity: &p4_v1.Entity{Entity: &p4_v1.Entity_TableEntry{TableEntry: &p4_v1.TableEntry{TableId: 7}}},
+	}))
+	assert.Contains(t, buf.String(), `level=DEBUG msg="Write request" deviceID=3 role=r`)
+	assert.Contains(t, buf.String(), "table_id:7")
+}
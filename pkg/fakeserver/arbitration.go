@@ -0,0 +1,221 @@
+package fakeserver
+
+import (
+	rpc_status "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// uint128 is a comparable representation of p4.v1.Uint128.
+type uint128 struct {
+	high uint64
+	low  uint64
+}
+
+func newUint128(v *p4_v1.Uint128) uint128 {
+	return uint128{high: v.GetHigh(), low: v.GetLow()}
+}
+
+func (v uint128) less(other uint128) bool {
+	return v.high < other.high || (v.high == other.high && v.low < other.low)
+}
+
+func (v uint128) next() uint128 {
+	if v.low == ^uint64(0) {
+		return uint128{high: v.high + 1}
+	}
+	return uint128{high: v.high, low: v.low + 1}
+}
+
+func (v uint128) proto() *p4_v1.Uint128 {
+	return &p4_v1.Uint128{High: v.high, Low: v.low}
+}
+
+// roleState tracks the streams which have sent a valid arbitration message for
+// a given role.
+type roleState struct {
+	streams map[*streamConn]bool
+	// highest election id ever received for this role
+	highest    uint128
+	hasHighest bool
+}
+
+// arbitration implements client arbitration as described in the P4Runtime
+// specification. For each role, the primary is the stream with the highest
+// election id, provided that this election id is at least as high as the
+// highest election id ever received for the role. In particular, when the
+// primary goes away, there is no primary until a client sends an election id
+// at least as high as the one of the previous primary.
+type arbitration struct {
+	roles map[string]*roleState
+}
+
+func newArbitration() *arbitration {
+	return &arbitration{roles: make(map[string]*roleState)}
+}
+
+// arbitrationMessage is a MasterArbitrationUpdate which needs to be sent on a
+// stream.
+type arbitrationMessage struct {
+	conn *streamConn
+	msg  *p4_v1.StreamMessageResponse
+}
+
+func (a *arbitration) primary(role string) *streamConn {
+	rs, ok := a.roles[role]
+	if !ok || !rs.hasHighest {
+		return nil
+	}
+	var primary *streamConn
+	for conn := range rs.streams {
+		if conn.electionID == nil {
+			continue
+		}
+		if primary == nil || primary.electionID.less(*conn.electionID) {
+			primary = conn
+		}
+	}
+	if primary == nil || primary.electionID.less(rs.highest) {
+		return nil
+	}
+	return primary
+}
+
+// isPrimary returns true if the stream is the primary for its role.
+func (a *arbitration) isPrimary(conn *streamConn) bool {
+	return conn.arbitrated && a.primary(conn.role) == conn
+}
+
+// primaries returns the primary stream of each role.
+func (a *arbitration) primaries() []*streamConn {
+	var out []*streamConn
+	for _, role := range sortedKeys(a.roles) {
+		if primary := a.primary(role); primary != nil {
+			out = append(out, primary)
+		}
+	}
+	return out
+}
+
+// checkPrimary is used for the Write and SetForwardingPipelineConfig RPCs: the
+// request must come from the primary client for the role.
+func (a *arbitration) checkPrimary(role string, electionID *p4_v1.Uint128) error {
+	primary := a.primary(role)
+	if primary == nil || electionID == nil || *primary.electionID != newUint128(electionID) {
+		return status.Errorf(codes.PermissionDenied, "not primary for role %q", role)
+	}
+	return nil
+}
+
+// update processes an arbitration message received on the stream. On success,
+// it returns the arbitration messages to send: to all the streams of the role
+// if the primary changed, or only to this stream otherwise. An error
+// terminates the stream.
+func (a *arbitration) update(conn *streamConn, deviceID uint64, req *p4_v1.MasterArbitrationUpdate) ([]arbitrationMessage, error) {
+	if req.DeviceId != deviceID {
+		return nil, status.Errorf(codes.NotFound, "unknown device id %d", req.DeviceId)
+	}
+	role := req.GetRole().GetName()
+	if conn.arbitrated && conn.role != role {
+		return nil, status.Errorf(codes.FailedPrecondition, "role cannot be changed from %q to %q", conn.role, role)
+	}
+	rs, ok := a.roles[role]
+	if !ok {
+		rs = &roleState{streams: make(map[*streamConn]bool)}
+		a.roles[role] = rs
+	}
+	var electionID *uint128
+	if req.ElectionId != nil {
+		id := newUint128(req.ElectionId)
+		for other := range rs.streams {
+			if other != conn && other.electionID != nil && *other.electionID == id {
+				return nil, status.Errorf(codes.InvalidArgument, "election id is already used by another client for role %q", role)
+			}
+		}
+		electionID = &id
+	}
+
+	oldPrimary := a.primary(role)
+	conn.arbitrated = true
+	conn.role = role
+	conn.roleProto = req.Role
+	conn.electionID = electionID
+	rs.streams[conn] = true
+	if electionID != nil && (!rs.hasHighest || rs.highest.less(*electionID)) {
+		rs.highest = *electionID
+		rs.hasHighest = true
+	}
+
+	if a.primary(role) != oldPrimary {
+		return a.notifyRole(role), nil
+	}
+	return []arbitrationMessage{{conn: conn, msg: a.arbitrationResponse(conn)}}, nil
+}
+
+// remove removes the stream and returns the arbitration messages to send if
+// the primary changed as a result.
+func (a *arbitration) remove(conn *streamConn) []arbitrationMessage {
+	if !conn.arbitrated {
+		return nil
+	}
+	rs, ok := a.roles[conn.role]
+	if !ok || !rs.streams[conn] {
+		return nil
+	}
+	oldPrimary := a.primary(conn.role)
+	delete(rs.streams, conn)
+	if oldPrimary == conn {
+		return a.notifyRole(conn.role)
+	}
+	return nil
+}
+
+// demote makes the current primary for the role a backup, by raising the
+// highest election id for the role above the primary's election id. It returns
+// the arbitration messages to send.
+func (a *arbitration) demote(role string) []arbitrationMessage {
+	primary := a.primary(role)
+	if primary == nil {
+		return nil
+	}
+	a.roles[role].highest = primary.electionID.next()
+	return a.notifyRole(role)
+}
+
+func (a *arbitration) notifyRole(role string) []arbitrationMessage {
+	var out []arbitrationMessage
+	for conn := range a.roles[role].streams {
+		out = append(out, arbitrationMessage{conn: conn, msg: a.arbitrationResponse(conn)})
+	}
+	return out
+}
+
+// arbitrationResponse builds the arbitration message for the stream: the
+// status is OK for the primary, ALREADY_EXISTS for backups if there is a
+// primary, and NOT_FOUND otherwise.
+func (a *arbitration) arbitrationResponse(conn *streamConn) *p4_v1.StreamMessageResponse {
+	rs := a.roles[conn.role]
+	primary := a.primary(conn.role)
+	resp := &p4_v1.MasterArbitrationUpdate{
+		DeviceId: conn.deviceID,
+		Role:     conn.roleProto,
+	}
+	switch {
+	case primary == conn:
+		resp.Status = &rpc_status.Status{Code: int32(codes.OK)}
+	case primary != nil:
+		resp.Status = &rpc_status.Status{Code: int32(codes.AlreadyExists), Message: "a primary client is connected"}
+	default:
+		resp.Status = &rpc_status.Status{Code: int32(codes.NotFound), Message: "no primary client is connected"}
+	}
+	if primary != nil {
+		resp.ElectionId = primary.electionID.proto()
+	} else if rs.hasHighest {
+		resp.ElectionId = rs.highest.proto()
+	}
+	return &p4_v1.StreamMessageResponse{
+		Update: &p4_v1.StreamMessageResponse_Arbitration{Arbitration: resp},
+	}
+}
@@ -0,0 +1,362 @@
+package fakeserver
+
+import (
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// StreamMessageType identifies the type of a StreamChannel message sent by the
+// server.
+type StreamMessageType int
+
+const (
+	AnyStreamMessage StreamMessageType = iota
+	ArbitrationMessage
+	PacketInMessage
+	DigestListMessage
+	IdleTimeoutNotificationMessage
+	StreamErrorMessage
+)
+
+func (t StreamMessageType) matches(msg *p4_v1.StreamMessageResponse) bool {
+	switch t {
+	case ArbitrationMessage:
+		return msg.GetArbitration() != nil
+	case PacketInMessage:
+		return msg.GetPacket() != nil
+	case DigestListMessage:
+		return msg.GetDigest() != nil
+	case IdleTimeoutNotificationMessage:
+		return msg.GetIdleTimeoutNotification() != nil
+	case StreamErrorMessage:
+		return msg.GetError() != nil
+	}
+	return true
+}
+
+// StreamFault drops or delays StreamChannel messages sent by the server.
+type StreamFault struct {
+	Type StreamMessageType
+	// Skip is the number of matching messages which are sent normally before
+	// the fault applies.
+	Skip int
+	// Count is the number of matching messages affected by the fault, 0 means
+	// all of them.
+	Count int
+	// Drop drops the message. Otherwise the message is delayed by Delay;
+	// delayed messages are still sent in order.
+	Drop  bool
+	Delay time.Duration
+}
+
+// WriteFault makes Write RPCs fail before they are processed.
+type WriteFault struct {
+	// Every makes every Nth Write RPC fail, 1 means all of them.
+	Every int
+	// Count is the maximum number of failures, 0 means no limit.
+	Count int
+	// Code is the gRPC status code returned to the client, UNAVAILABLE if not
+	// set.
+	Code codes.Code
+	// Applied makes the Write RPCs fail after they are processed, as if the
+	// response was lost.
+	Applied bool
+}
+
+// ReadFault makes Read RPCs fail after some of the entities have been sent.
+type ReadFault struct {
+	// AfterEntities is the number of entities sent to the client before the
+	// failure, 0 meaning that the RPC fails before any response is sent.
+	// Unless it is 0, reads which return AfterEntities entities or fewer are
+	// not affected.
+	AfterEntities int
+	// Count is the maximum number of failures, 0 means no limit.
+	Count int
+	// Code is the gRPC status code returned to the client, UNAVAILABLE if not
+	// set.
+	Code codes.Code
+}
+
+// FaultAction is a disruptive event which can be triggered by a FaultPlan or
+// directly with the corresponding Server method.
+type FaultAction int
+
+const (
+	// CloseStreams terminates all StreamChannel RPCs with UNAVAILABLE (see
+	// Server.CloseStreams).
+	CloseStreams FaultAction = iota
+	// DemotePrimary makes the primary client of the default role a backup
+	// (see Server.DemotePrimary).
+	DemotePrimary
+	// Restart emulates a device reboot (see Server.Restart).
+	Restart
+)
+
+// FaultEvent triggers a FaultAction once. At most one trigger should be set; if
+// none is set, the action is triggered as soon as the plan is applied.
+type FaultEvent struct {
+	// AfterWrites triggers the action after the Nth Write RPC, before the
+	// response is sent to the client.
+	AfterWrites int
+	// AfterStreamMessages triggers the action after the server has received
+	// N StreamChannel messages, across all streams.
+	AfterStreamMessages int
+	// After triggers the action after some time.
+	After time.Duration
+
+	Action FaultAction
+}
+
+// FaultPlan describes how the server should misbehave. It is applied with
+// Server.SetFaultPlan, and counters (number of writes, number of stream
+// messages, ...) start from 0 when the plan is applied. For example, the
+// following plan makes every 3rd Write fail with UNAVAILABLE, drops the first
+// PacketIn and restarts the server after 10 writes:
+//
+//	s.SetFaultPlan(&fakeserver.FaultPlan{
+//		WriteFaults:  []fakeserver.WriteFault{{Every: 3}},
+//		StreamFaults: []fakeserver.StreamFault{{Type: fakeserver.PacketInMessage, Count: 1, Drop: true}},
+//		Events:       []fakeserver.FaultEvent{{AfterWrites: 10, Action: fakeserver.Restart}},
+//	})
+type FaultPlan struct {
+	StreamFaults []StreamFault
+	WriteFaults  []WriteFault
+	ReadFaults   []ReadFault
+	Events       []FaultEvent
+}
+
+// faultState is the runtime state of the active FaultPlan. It is protected by
+// the server mutex.
+type faultState struct {
+	plan *FaultPlan
+	// number of matching messages seen by each StreamFault
+	streamMatches []int
+	// number of failures injected by each WriteFault
+	writeFailures []int
+	// number of failures injected by each ReadFault
+	readFailures   []int
+	writes         int
+	streamMessages int
+	fired          []bool
+	timers         []*time.Timer
+}
+
+func newFaultState(plan *FaultPlan) *faultState {
+	f := &faultState{plan: plan}
+	if plan != nil {
+		f.streamMatches = make([]int, len(plan.StreamFaults))
+		f.writeFailures = make([]int, len(plan.WriteFaults))
+		f.readFailures = make([]int, len(plan.ReadFaults))
+		f.fired = make([]bool, len(plan.Events))
+	}
+	return f
+}
+
+func (f *faultState) stopTimers() {
+	for _, timer := range f.timers {
+		timer.Stop()
+	}
+	f.timers = nil
+}
+
+// fire returns the actions of the events which have not fired yet and for
+// which trigger returns true.
+func (f *faultState) fire(trigger func(event *FaultEvent) bool) []FaultAction {
+	if f.plan == nil {
+		return nil
+	}
+	var actions []FaultAction
+	for idx := range f.plan.Events {
+		event := &f.plan.Events[idx]
+		if f.fired[idx] || !trigger(event) {
+			continue
+		}
+		f.fired[idx] = true
+		actions = append(actions, event.Action)
+	}
+	return actions
+}
+
+// writeReceived is called for each Write RPC. It returns the actions triggered
+// by the write, and an error if the write should fail, in which case applied
+// indicates whether the write should be processed first.
+func (f *faultState) writeReceived() (actions []FaultAction, applied bool, err error) {
+	if f.plan == nil {
+		return nil, false, nil
+	}
+	f.writes++
+	actions = f.fire(func(event *FaultEvent) bool {
+		return event.AfterWrites > 0 && f.writes >= event.AfterWrites
+	})
+	for idx, fault := range f.plan.WriteFaults {
+		if fault.Every <= 0 || f.writes%fault.Every != 0 {
+			continue
+		}
+		if fault.Count > 0 && f.writeFailures[idx] >= fault.Count {
+			continue
+		}
+		f.writeFailures[idx]++
+		code := fault.Code
+		if code == codes.OK {
+			code = codes.Unavailable
+		}
+		return actions, fault.Applied, status.Errorf(code, "injected fault for write #%d", f.writes)
+	}
+	return actions, false, nil
+}
+
+// readReceived is called for each Read RPC returning numEntities entities. It
+// returns an error if the read should fail, once the first sent entities have
+// been sent.
+func (f *faultState) readReceived(numEntities int) (sent int, err error) {
+	if f.plan == nil {
+		return 0, nil
+	}
+	for idx, fault := range f.plan.ReadFaults {
+		if fault.AfterEntities > 0 && fault.AfterEntities >= numEntities {
+			continue
+		}
+		if fault.Count > 0 && f.readFailures[idx] >= fault.Count {
+			continue
+		}
+		f.readFailures[idx]++
+		code := fault.Code
+		if code == codes.OK {
+			code = codes.Unavailable
+		}
+		return fault.AfterEntities, status.Errorf(code, "injected fault after %d entities", fault.AfterEntities)
+	}
+	return 0, nil
+}
+
+// streamMessageReceived is called for each message received on a stream and
+// returns the actions triggered by the message.
+func (f *faultState) streamMessageReceived() []FaultAction {
+	if f.plan == nil {
+		return nil
+	}
+	f.streamMessages++
+	return f.fire(func(event *FaultEvent) bool {
+		return event.AfterStreamMessages > 0 && f.streamMessages >= event.AfterStreamMessages
+	})
+}
+
+// streamMessageSent is called for each message sent on a stream, and returns
+// whether the message should be dropped or delayed.
+func (f *faultState) streamMessageSent(msg *p4_v1.StreamMessageResponse) (bool, time.Duration) {
+	if f.plan == nil {
+		return false, 0
+	}
+	drop := false
+	var delay time.Duration
+	for idx, fault := range f.plan.StreamFaults {
+		if !fault.Type.matches(msg) {
+			continue
+		}
+		f.streamMatches[idx]++
+		n := f.streamMatches[idx] - fault.Skip
+		if n <= 0 || (fault.Count > 0 && n > fault.Count) {
+			continue
+		}
+		if fault.Drop {
+			drop = true
+		} else if fault.Delay > delay {
+			delay = fault.Delay
+		}
+	}
+	return drop, delay
+}
+
+// SetFaultPlan replaces the active FaultPlan. A nil plan restores normal
+// behavior. Events without a trigger are fired before SetFaultPlan returns.
+func (s *Server) SetFaultPlan(plan *FaultPlan) {
+	s.mu.Lock()
+	s.faults.stopTimers()
+	s.faults = newFaultState(plan)
+	faults := s.faults
+	actions := faults.fire(func(event *FaultEvent) bool {
+		return event.AfterWrites <= 0 && event.AfterStreamMessages <= 0 && event.After <= 0
+	})
+	if plan != nil {
+		for idx := range plan.Events {
+			event := &plan.Events[idx]
+			if event.After <= 0 || faults.fired[idx] {
+				continue
+			}
+			idx := idx
+			faults.timers = append(faults.timers, time.AfterFunc(event.After, func() {
+				s.mu.Lock()
+				if s.faults != faults || faults.fired[idx] {
+					s.mu.Unlock()
+					return
+				}
+				faults.fired[idx] = true
+				s.mu.Unlock()
+				s.runFaultActions([]FaultAction{event.Action})
+			}))
+		}
+	}
+	s.mu.Unlock()
+	s.runFaultActions(actions)
+}
+
+func (s *Server) runFaultActions(actions []FaultAction) {
+	for _, action := range actions {
+		switch action {
+		case CloseStreams:
+			s.CloseStreams()
+		case DemotePrimary:
+			s.DemotePrimary("")
+		case Restart:
+			s.Restart()
+		}
+	}
+}
+
+// CloseStreams terminates all StreamChannel RPCs with UNAVAILABLE, as if the
+// connection had been lost while the clients were waiting for stream messages.
+// The clients need to open a new stream and go through arbitration again.
+func (s *Server) CloseStreams() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeStreams()
+}
+
+func (s *Server) closeStreams() {
+	for conn := range s.streams {
+		conn.close(status.Errorf(codes.Unavailable, "stream closed by server"))
+	}
+}
+
+// DemotePrimary makes the primary client for the role a backup, as if a client
+// with a higher election id had become primary and then disconnected. All the
+// clients for the role are notified, and there is no primary until a client
+// sends a higher election id.
+func (s *Server) DemotePrimary(role string) {
+	s.mu.Lock()
+	msgs := s.arbitration.demote(role)
+	s.mu.Unlock()
+	s.sendArbitrationMessages(msgs)
+}
+
+// Restart emulates a device reboot: all streams are closed, arbitration state
+// is lost, and the forwarding state is reset. The forwarding pipeline config
+// is reset to the one provided to NewServer, if any. Messages recorded for
+// inspection (PacketOuts, digest acks) are kept.
+func (s *Server) Restart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeStreams()
+	s.streams = make(map[*streamConn]bool)
+	s.arbitration = newArbitration()
+	s.pipeline = s.initialPipeline
+	s.savedPipeline = nil
+	s.state = nil
+	if s.pipeline != nil {
+		s.state = newState(s.pipeline)
+	}
+}
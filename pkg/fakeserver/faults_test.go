@@ -0,0 +1,202 @@
+package fakeserver
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/client"
+)
+
+type testClient struct {
+	*client.Client
+	arbitrationCh chan bool
+	messageCh     chan *p4_v1.StreamMessageResponse
+	runErrCh      chan error
+}
+
+// startClient creates a Client for the server and runs its stream.
+func startClient(t *testing.T, s *Server, electionID uint64, optionsModifierFns ...func(*client.ClientOptions)) *testClient {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := s.Dial(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	c := &testClient{
+		Client:        client.NewClient(p4_v1.NewP4RuntimeClient(conn), testDeviceID, &p4_v1.Uint128{Low: electionID}, optionsModifierFns...),
+		arbitrationCh: make(chan bool, 10),
+		messageCh:     make(chan *p4_v1.StreamMessageResponse, 10),
+		runErrCh:      make(chan error, 1),
+	}
+	c.SetP4Info(newTestP4Info())
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	go func() {
+		c.runErrCh <- c.Run(stopCh, c.arbitrationCh, c.messageCh)
+	}()
+	return c
+}
+
+func (c *testClient) waitArbitration(t *testing.T) bool {
+	select {
+	case isPrimary := <-c.arbitrationCh:
+		return isPrimary
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timeout when waiting for arbitration")
+	}
+	return false
+}
+
+func (c *testClient) insert(mac byte) error {
+	return c.InsertTableEntry(context.Background(), exactEntry(mac, 1))
+}
+
+func TestWriteFaults(t *testing.T) {
+	s := NewServer(testDeviceID, newTestP4Info())
+	require.NoError(t, s.Start())
+	defer s.Stop()
+	c := startClient(t, s, 1)
+	require.True(t, c.waitArbitration(t))
+
+	s.SetFaultPlan(&FaultPlan{
+		WriteFaults: []WriteFault{{Every: 2, Count: 1}},
+	})
+	assert.NoError(t, c.insert(1))
+	assert.Equal(t, codes.Unavailable, status.Code(c.insert(2)))
+	assert.NoError(t, c.insert(2))
+	assert.Len(t, s.TableEntries(testTableID), 2)
+}
+
+func TestWriteFaultsApplied(t *testing.T) {
+	s := NewServer(testDeviceID, newTestP4Info())
+	require.NoError(t, s.Start())
+	defer s.Stop()
+	c := startClient(t, s, 1)
+	require.True(t, c.waitArbitration(t))
+
+	s.SetFaultPlan(&FaultPlan{
+		WriteFaults: []WriteFault{{Every: 1, Count: 1, Code: codes.DeadlineExceeded, Applied: true}},
+	})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(c.insert(1)))
+	assert.Len(t, s.TableEntries(testTableID), 1)
+	details := client.WriteErrorDetails(c.insert(1))
+	require.Len(t, details, 1)
+	assert.Equal(t, int32(codes.AlreadyExists), details[0].CanonicalCode)
+}
+
+func TestStreamFaults(t *testing.T) {
+	s := NewServer(testDeviceID, newTestP4Info())
+	require.NoError(t, s.Start())
+	defer s.Stop()
+	c := startClient(t, s, 1)
+	require.True(t, c.waitArbitration(t))
+
+	s.SetFaultPlan(&FaultPlan{
+		StreamFaults: []StreamFault{
+			{Type: PacketInMessage, Count: 1, Drop: true},
+			{Type: PacketInMessage, Skip: 1, Delay: 50 * time.Millisecond},
+		},
+	})
+	require.NoError(t, s.SendPacketIn(&p4_v1.PacketIn{Payload: []byte{1}}))
+	start := time.Now()
+	require.NoError(t, s.SendPacketIn(&p4_v1.PacketIn{Payload: []byte{2}}))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	select {
+	case msg := <-c.messageCh:
+		assert.Equal(t, []byte{2}, msg.GetPacket().Payload)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timeout when waiting for PacketIn")
+	}
+}
+
+func TestFaultEvents(t *testing.T) {
+	s := NewServer(testDeviceID, newTestP4Info())
+	require.NoError(t, s.Start())
+	defer s.Stop()
+	// writes must reach the server while the client is not primary
+	c := startClient(t, s, 1, client.WithBackupPolicy(client.BackupPolicySend))
+	require.True(t, c.waitArbitration(t))
+
+	s.DemotePrimary("")
+	assert.False(t, c.waitArbitration(t))
+	assert.Equal(t, codes.PermissionDenied, status.Code(c.insert(1)))
+
+	// a new client with a higher election id becomes primary
+	c2 := startClient(t, s, 2)
+	require.True(t, c2.waitArbitration(t))
+
+	s.SetFaultPlan(&FaultPlan{
+		Events: []FaultEvent{{AfterWrites: 2, Action: Restart}},
+	})
+	require.NoError(t, c2.insert(1))
+	require.NoError(t, c2.insert(2))
+	// the server restarted after the second write
+	assert.Empty(t, s.TableEntries(testTableID))
+	for _, c := range []*testClient{c, c2} {
+		select {
+		case err := <-c.runErrCh:
+			assert.Equal(t, codes.Unavailable, status.Code(err))
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timeout when waiting for stream to be closed")
+		}
+	}
+}
+
+func TestReadFaults(t *testing.T) {
+	s, c := newTestServer(t)
+	counters := &p4_v1.Entity{Entity: &p4_v1.Entity_CounterEntry{CounterEntry: &p4_v1.CounterEntry{CounterId: testCounterID}}}
+	// readResponses returns the number of entities in each response
+	readResponses := func() ([]int, error) {
+		stream, err := c.Read(context.Background(), &p4_v1.ReadRequest{DeviceId: testDeviceID, Entities: []*p4_v1.Entity{counters}})
+		require.NoError(t, err)
+		var sizes []int
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return sizes, nil
+			}
+			if err != nil {
+				return sizes, err
+			}
+			sizes = append(sizes, len(resp.Entities))
+		}
+	}
+
+	sizes, err := readResponses()
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 1, 1, 1}, sizes)
+	s.SetReadChunkSize(3)
+	sizes, err = readResponses()
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 1}, sizes)
+	s.SetReadChunkSize(0)
+	sizes, err = readResponses()
+	require.NoError(t, err)
+	assert.Equal(t, []int{testCounterCells}, sizes)
+
+	s.SetReadChunkSize(1)
+	s.SetFaultPlan(&FaultPlan{
+		ReadFaults: []ReadFault{{AfterEntities: 2, Count: 1, Code: codes.Aborted}},
+	})
+	sizes, err = readResponses()
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Equal(t, []int{1, 1}, sizes)
+	sizes, err = readResponses()
+	require.NoError(t, err)
+	assert.Len(t, sizes, testCounterCells)
+
+	s.SetFaultPlan(&FaultPlan{
+		ReadFaults: []ReadFault{{AfterEntities: testCounterCells}, {AfterEntities: 0}},
+	})
+	sizes, err = readResponses()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Empty(t, sizes)
+}
@@ -0,0 +1,108 @@
+package fakeserver
+
+import (
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+const (
+	packetOutMetadataName = "packet_out"
+	packetInMetadataName  = "packet_in"
+)
+
+// pipeline is a forwarding pipeline config, with P4Info objects indexed by id.
+type pipeline struct {
+	p4Info       *p4_config_v1.P4Info
+	deviceConfig []byte
+	cookie       uint64
+
+	tables         map[uint32]*p4_config_v1.Table
+	actions        map[uint32]*p4_config_v1.Action
+	actionProfiles map[uint32]*p4_config_v1.ActionProfile
+	counters       map[uint32]*p4_config_v1.Counter
+	meters         map[uint32]*p4_config_v1.Meter
+	digests        map[uint32]*p4_config_v1.Digest
+	// direct resources, indexed by table id
+	directCounters map[uint32]*p4_config_v1.DirectCounter
+	directMeters   map[uint32]*p4_config_v1.DirectMeter
+	packetOut      *p4_config_v1.ControllerPacketMetadata
+}
+
+func newPipeline(config *p4_v1.ForwardingPipelineConfig) *pipeline {
+	p4Info := config.P4Info
+	p := &pipeline{
+		p4Info:         p4Info,
+		deviceConfig:   config.P4DeviceConfig,
+		cookie:         config.GetCookie().GetCookie(),
+		tables:         make(map[uint32]*p4_config_v1.Table),
+		actions:        make(map[uint32]*p4_config_v1.Action),
+		actionProfiles: make(map[uint32]*p4_config_v1.ActionProfile),
+		counters:       make(map[uint32]*p4_config_v1.Counter),
+		meters:         make(map[uint32]*p4_config_v1.Meter),
+		digests:        make(map[uint32]*p4_config_v1.Digest),
+		directCounters: make(map[uint32]*p4_config_v1.DirectCounter),
+		directMeters:   make(map[uint32]*p4_config_v1.DirectMeter),
+	}
+	for _, table := range p4Info.Tables {
+		p.tables[table.Preamble.Id] = table
+	}
+	for _, action := range p4Info.Actions {
+		p.actions[action.Preamble.Id] = action
+	}
+	for _, actionProfile := range p4Info.ActionProfiles {
+		p.actionProfiles[actionProfile.Preamble.Id] = actionProfile
+	}
+	for _, counter := range p4Info.Counters {
+		p.counters[counter.Preamble.Id] = counter
+	}
+	for _, meter := range p4Info.Meters {
+		p.meters[meter.Preamble.Id] = meter
+	}
+	for _, digest := range p4Info.Digests {
+		p.digests[digest.Preamble.Id] = digest
+	}
+	for _, counter := range p4Info.DirectCounters {
+		p.directCounters[counter.DirectTableId] = counter
+	}
+	for _, meter := range p4Info.DirectMeters {
+		p.directMeters[meter.DirectTableId] = meter
+	}
+	for _, cpm := range p4Info.ControllerPacketMetadata {
+		if cpm.Preamble.Name == packetOutMetadataName {
+			p.packetOut = cpm
+		}
+	}
+	return p
+}
+
+// tableHasPriority returns true if entries in the table require a priority,
+// i.e. if the table has at least one ternary, range or optional match field.
+func tableHasPriority(table *p4_config_v1.Table) bool {
+	for _, mf := range table.MatchFields {
+		switch mf.GetMatchType() {
+		case p4_config_v1.MatchField_TERNARY, p4_config_v1.MatchField_RANGE, p4_config_v1.MatchField_OPTIONAL:
+			return true
+		}
+	}
+	return false
+}
+
+func findActionRef(table *p4_config_v1.Table, actionID uint32) *p4_config_v1.ActionRef {
+	for _, ref := range table.ActionRefs {
+		if ref.Id == actionID {
+			return ref
+		}
+	}
+	return nil
+}
+
+// actionProfileTables returns the tables implemented with the action profile.
+func (p *pipeline) actionProfileTables(actionProfileID uint32) []*p4_config_v1.Table {
+	var out []*p4_config_v1.Table
+	for _, table := range p.p4Info.Tables {
+		if table.ImplementationId == actionProfileID {
+			out = append(out, table)
+		}
+	}
+	return out
+}
@@ -0,0 +1,383 @@
+package fakeserver
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// Read returns the requested entities, in ReadResponses which include at most
+// the number of entities set with SetReadChunkSize (one by default). Entities
+// are returned in a deterministic order. Reads are not subject to arbitration.
+func (s *Server) Read(req *p4_v1.ReadRequest, stream p4_v1.P4Runtime_ReadServer) error {
+	if err := s.checkDeviceID(req.DeviceId); err != nil {
+		return err
+	}
+	responses, err := s.read(req)
+	for _, resp := range responses {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// SetReadChunkSize sets the maximum number of entities in each ReadResponse.
+// If n is not positive, all the entities are returned in a single response.
+func (s *Server) SetReadChunkSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readChunkSize = n
+}
+
+// read returns the responses to send to the client, and the error with which
+// the RPC fails once they have been sent, if any.
+func (s *Server) read(req *p4_v1.ReadRequest) ([]*p4_v1.ReadResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pipeline == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "no forwarding pipeline config")
+	}
+	var out []*p4_v1.Entity
+	for _, entity := range req.Entities {
+		entities, err := readEntity(s.pipeline, s.state, entity)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, entities...)
+	}
+	sent, err := s.faults.readReceived(len(out))
+	if err != nil {
+		out = out[:sent]
+	} else if len(out) == 0 {
+		return []*p4_v1.ReadResponse{{}}, nil
+	}
+	chunkSize := s.readChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(out)
+	}
+	var responses []*p4_v1.ReadResponse
+	for start := 0; start < len(out); start += chunkSize {
+		end := min(start+chunkSize, len(out))
+		responses = append(responses, &p4_v1.ReadResponse{Entities: out[start:end]})
+	}
+	return responses, err
+}
+
+func readEntity(p *pipeline, st *state, entity *p4_v1.Entity) ([]*p4_v1.Entity, error) {
+	switch e := entity.GetEntity().(type) {
+	case *p4_v1.Entity_TableEntry:
+		return readTableEntries(p, st, e.TableEntry)
+	case *p4_v1.Entity_ActionProfileMember:
+		return readActionProfileMembers(p, st, e.ActionProfileMember)
+	case *p4_v1.Entity_ActionProfileGroup:
+		return readActionProfileGroups(p, st, e.ActionProfileGroup)
+	case *p4_v1.Entity_PacketReplicationEngineEntry:
+		return readPREEntries(st, e.PacketReplicationEngineEntry)
+	case *p4_v1.Entity_CounterEntry:
+		return readCounterEntries(p, st, e.CounterEntry)
+	case *p4_v1.Entity_DirectCounterEntry:
+		return readDirectCounterEntries(p, st, e.DirectCounterEntry)
+	case *p4_v1.Entity_MeterEntry:
+		return readMeterEntries(p, st, e.MeterEntry)
+	case *p4_v1.Entity_DirectMeterEntry:
+		return readDirectMeterEntries(p, st, e.DirectMeterEntry)
+	case *p4_v1.Entity_DigestEntry:
+		return readDigestEntries(p, st, e.DigestEntry)
+	case nil:
+		return nil, status.Errorf(codes.InvalidArgument, "missing entity")
+	default:
+		return nil, status.Errorf(codes.Unimplemented, "entity type %T is not supported", e)
+	}
+}
+
+// selectTableEntries returns the stored entries matching the filter: all tables
+// if the table id is 0, the default entries if is_default_action is set, and
+// a single entry if the match key is set.
+func selectTableEntries(p *pipeline, st *state, filter *p4_v1.TableEntry) ([]*p4_v1.TableEntry, error) {
+	var tableIDs []uint32
+	if filter.TableId == 0 {
+		if len(filter.Match) > 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "match key requires a table id")
+		}
+		tableIDs = sortedIDs(p.tables)
+	} else {
+		if _, ok := p.tables[filter.TableId]; !ok {
+			return nil, status.Errorf(codes.NotFound, "unknown table id %d", filter.TableId)
+		}
+		tableIDs = []uint32{filter.TableId}
+	}
+
+	var out []*p4_v1.TableEntry
+	for _, tableID := range tableIDs {
+		tableSt := st.tables[tableID]
+		if filter.IsDefaultAction {
+			out = append(out, tableSt.defaultEntry)
+			continue
+		}
+		if len(filter.Match) > 0 {
+			match, err := validateMatch(p.tables[tableID], filter.Match)
+			if err != nil {
+				return nil, err
+			}
+			key := tableEntryKey(&p4_v1.TableEntry{Match: match, Priority: filter.Priority})
+			if entry, ok := tableSt.entries[key]; ok {
+				out = append(out, entry)
+			}
+			continue
+		}
+		for _, key := range sortedKeys(tableSt.entries) {
+			out = append(out, tableSt.entries[key])
+		}
+	}
+	return out, nil
+}
+
+func readTableEntries(p *pipeline, st *state, filter *p4_v1.TableEntry) ([]*p4_v1.Entity, error) {
+	entries, err := selectTableEntries(p, st, filter)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*p4_v1.Entity, 0, len(entries))
+	for _, entry := range entries {
+		entry = proto.Clone(entry).(*p4_v1.TableEntry)
+		// direct resources are only returned when requested
+		if filter.CounterData == nil {
+			entry.CounterData = nil
+		}
+		if filter.MeterConfig == nil {
+			entry.MeterConfig = nil
+		}
+		out = append(out, &p4_v1.Entity{Entity: &p4_v1.Entity_TableEntry{TableEntry: entry}})
+	}
+	return out, nil
+}
+
+func selectActionProfiles(p *pipeline, actionProfileID uint32) ([]uint32, error) {
+	if actionProfileID == 0 {
+		return sortedIDs(p.actionProfiles), nil
+	}
+	if _, ok := p.actionProfiles[actionProfileID]; !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown action profile id %d", actionProfileID)
+	}
+	return []uint32{actionProfileID}, nil
+}
+
+func readActionProfileMembers(p *pipeline, st *state, filter *p4_v1.ActionProfileMember) ([]*p4_v1.Entity, error) {
+	actionProfileIDs, err := selectActionProfiles(p, filter.ActionProfileId)
+	if err != nil {
+		return nil, err
+	}
+	var out []*p4_v1.Entity
+	for _, actionProfileID := range actionProfileIDs {
+		members := st.members[actionProfileID]
+		for _, memberID := range sortedIDs(members) {
+			if filter.MemberId != 0 && filter.MemberId != memberID {
+				continue
+			}
+			member := proto.Clone(members[memberID]).(*p4_v1.ActionProfileMember)
+			out = append(out, &p4_v1.Entity{Entity: &p4_v1.Entity_ActionProfileMember{ActionProfileMember: member}})
+		}
+	}
+	return out, nil
+}
+
+func readActionProfileGroups(p *pipeline, st *state, filter *p4_v1.ActionProfileGroup) ([]*p4_v1.Entity, error) {
+	actionProfileIDs, err := selectActionProfiles(p, filter.ActionProfileId)
+	if err != nil {
+		return nil, err
+	}
+	var out []*p4_v1.Entity
+	for _, actionProfileID := range actionProfileIDs {
+		groups := st.groups[actionProfileID]
+		for _, groupID := range sortedIDs(groups) {
+			if filter.GroupId != 0 && filter.GroupId != groupID {
+				continue
+			}
+			group := proto.Clone(groups[groupID]).(*p4_v1.ActionProfileGroup)
+			out = append(out, &p4_v1.Entity{Entity: &p4_v1.Entity_ActionProfileGroup{ActionProfileGroup: group}})
+		}
+	}
+	return out, nil
+}
+
+func readPREEntries(st *state, filter *p4_v1.PacketReplicationEngineEntry) ([]*p4_v1.Entity, error) {
+	var out []*p4_v1.Entity
+	switch {
+	case filter.GetMulticastGroupEntry() != nil:
+		groupID := filter.GetMulticastGroupEntry().MulticastGroupId
+		for _, id := range sortedIDs(st.multicastGroups) {
+			if groupID != 0 && groupID != id {
+				continue
+			}
+			group := proto.Clone(st.multicastGroups[id]).(*p4_v1.MulticastGroupEntry)
+			out = append(out, &p4_v1.Entity{Entity: &p4_v1.Entity_PacketReplicationEngineEntry{
+				PacketReplicationEngineEntry: &p4_v1.PacketReplicationEngineEntry{
+					Type: &p4_v1.PacketReplicationEngineEntry_MulticastGroupEntry{MulticastGroupEntry: group},
+				},
+			}})
+		}
+	case filter.GetCloneSessionEntry() != nil:
+		sessionID := filter.GetCloneSessionEntry().SessionId
+		for _, id := range sortedIDs(st.cloneSessions) {
+			if sessionID != 0 && sessionID != id {
+				continue
+			}
+			session := proto.Clone(st.cloneSessions[id]).(*p4_v1.CloneSessionEntry)
+			out = append(out, &p4_v1.Entity{Entity: &p4_v1.Entity_PacketReplicationEngineEntry{
+				PacketReplicationEngineEntry: &p4_v1.PacketReplicationEngineEntry{
+					Type: &p4_v1.PacketReplicationEngineEntry_CloneSessionEntry{CloneSessionEntry: session},
+				},
+			}})
+		}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "empty PRE entry")
+	}
+	return out, nil
+}
+
+func readCounterEntries(p *pipeline, st *state, filter *p4_v1.CounterEntry) ([]*p4_v1.Entity, error) {
+	var counterIDs []uint32
+	if filter.CounterId == 0 {
+		counterIDs = sortedIDs(p.counters)
+	} else {
+		if _, ok := p.counters[filter.CounterId]; !ok {
+			return nil, status.Errorf(codes.NotFound, "unknown counter id %d", filter.CounterId)
+		}
+		counterIDs = []uint32{filter.CounterId}
+	}
+	var out []*p4_v1.Entity
+	for _, counterID := range counterIDs {
+		cells := st.counters[counterID]
+		start, end, err := cellRange(filter.Index, len(cells))
+		if err != nil {
+			return nil, err
+		}
+		for idx := start; idx < end; idx++ {
+			out = append(out, &p4_v1.Entity{Entity: &p4_v1.Entity_CounterEntry{CounterEntry: &p4_v1.CounterEntry{
+				CounterId: counterID,
+				Index:     &p4_v1.Index{Index: int64(idx)},
+				Data:      proto.Clone(cells[idx]).(*p4_v1.CounterData),
+			}}})
+		}
+	}
+	return out, nil
+}
+
+func readMeterEntries(p *pipeline, st *state, filter *p4_v1.MeterEntry) ([]*p4_v1.Entity, error) {
+	var meterIDs []uint32
+	if filter.MeterId == 0 {
+		meterIDs = sortedIDs(p.meters)
+	} else {
+		if _, ok := p.meters[filter.MeterId]; !ok {
+			return nil, status.Errorf(codes.NotFound, "unknown meter id %d", filter.MeterId)
+		}
+		meterIDs = []uint32{filter.MeterId}
+	}
+	var out []*p4_v1.Entity
+	for _, meterID := range meterIDs {
+		cells := st.meters[meterID]
+		start, end, err := cellRange(filter.Index, len(cells))
+		if err != nil {
+			return nil, err
+		}
+		for idx := start; idx < end; idx++ {
+			entry := &p4_v1.MeterEntry{
+				MeterId: meterID,
+				Index:   &p4_v1.Index{Index: int64(idx)},
+			}
+			if cells[idx] != nil {
+				entry.Config = proto.Clone(cells[idx]).(*p4_v1.MeterConfig)
+			}
+			out = append(out, &p4_v1.Entity{Entity: &p4_v1.Entity_MeterEntry{MeterEntry: entry}})
+		}
+	}
+	return out, nil
+}
+
+// keyOnly returns a copy of the table entry with only the fields which identify
+// it, as used in direct resource entries.
+func keyOnly(entry *p4_v1.TableEntry) *p4_v1.TableEntry {
+	return proto.Clone(&p4_v1.TableEntry{
+		TableId:         entry.TableId,
+		Match:           entry.Match,
+		Priority:        entry.Priority,
+		IsDefaultAction: entry.IsDefaultAction,
+	}).(*p4_v1.TableEntry)
+}
+
+func readDirectCounterEntries(p *pipeline, st *state, filter *p4_v1.DirectCounterEntry) ([]*p4_v1.Entity, error) {
+	tableFilter := filter.TableEntry
+	if tableFilter == nil {
+		tableFilter = &p4_v1.TableEntry{}
+	}
+	entries, err := selectTableEntries(p, st, tableFilter)
+	if err != nil {
+		return nil, err
+	}
+	var out []*p4_v1.Entity
+	for _, entry := range entries {
+		if _, ok := p.directCounters[entry.TableId]; !ok {
+			if tableFilter.TableId != 0 {
+				return nil, status.Errorf(codes.InvalidArgument, "table %d does not have a direct counter", entry.TableId)
+			}
+			continue
+		}
+		data := &p4_v1.CounterData{}
+		if entry.CounterData != nil {
+			data = proto.Clone(entry.CounterData).(*p4_v1.CounterData)
+		}
+		out = append(out, &p4_v1.Entity{Entity: &p4_v1.Entity_DirectCounterEntry{DirectCounterEntry: &p4_v1.DirectCounterEntry{
+			TableEntry: keyOnly(entry),
+			Data:       data,
+		}}})
+	}
+	return out, nil
+}
+
+func readDirectMeterEntries(p *pipeline, st *state, filter *p4_v1.DirectMeterEntry) ([]*p4_v1.Entity, error) {
+	tableFilter := filter.TableEntry
+	if tableFilter == nil {
+		tableFilter = &p4_v1.TableEntry{}
+	}
+	entries, err := selectTableEntries(p, st, tableFilter)
+	if err != nil {
+		return nil, err
+	}
+	var out []*p4_v1.Entity
+	for _, entry := range entries {
+		if _, ok := p.directMeters[entry.TableId]; !ok {
+			if tableFilter.TableId != 0 {
+				return nil, status.Errorf(codes.InvalidArgument, "table %d does not have a direct meter", entry.TableId)
+			}
+			continue
+		}
+		meterEntry := &p4_v1.DirectMeterEntry{TableEntry: keyOnly(entry)}
+		if entry.MeterConfig != nil {
+			meterEntry.Config = proto.Clone(entry.MeterConfig).(*p4_v1.MeterConfig)
+		}
+		out = append(out, &p4_v1.Entity{Entity: &p4_v1.Entity_DirectMeterEntry{DirectMeterEntry: meterEntry}})
+	}
+	return out, nil
+}
+
+func readDigestEntries(p *pipeline, st *state, filter *p4_v1.DigestEntry) ([]*p4_v1.Entity, error) {
+	if filter.DigestId != 0 {
+		if _, ok := p.digests[filter.DigestId]; !ok {
+			return nil, status.Errorf(codes.NotFound, "unknown digest id %d", filter.DigestId)
+		}
+	}
+	var out []*p4_v1.Entity
+	for _, digestID := range sortedIDs(st.digests) {
+		if filter.DigestId != 0 && filter.DigestId != digestID {
+			continue
+		}
+		out = append(out, &p4_v1.Entity{Entity: &p4_v1.Entity_DigestEntry{DigestEntry: &p4_v1.DigestEntry{
+			DigestId: digestID,
+			Config:   proto.Clone(st.digests[digestID]).(*p4_v1.DigestEntry_Config),
+		}}})
+	}
+	return out, nil
+}
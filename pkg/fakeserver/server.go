@@ -0,0 +1,285 @@
+// Package fakeserver implements an in-memory P4Runtime server, which can be
+// used to test controllers end-to-end without a switch. The server keeps the
+// forwarding state (table entries, action profiles, PRE entries, counters,
+// meters and digest configs) for a single device, validates Write requests
+// against the P4Info as required by the P4Runtime specification, and performs
+// client arbitration on the StreamChannel. PacketIns, digests and idle timeout
+// notifications can be injected by the test, and faults (dropped or delayed
+// stream messages, failed writes, closed streams, demotions, restarts) can be
+// scripted with a FaultPlan.
+//
+// The server is typically served over an in-memory bufconn listener:
+//
+//	s := fakeserver.NewServer(deviceID, p4Info)
+//	if err := s.Start(); err != nil { ... }
+//	defer s.Stop()
+//	conn, err := s.Dial(ctx)
+//	p4RtC := client.NewClient(p4_v1.NewP4RuntimeClient(conn), deviceID, electionID)
+package fakeserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+const (
+	// APIVersion is the P4Runtime version reported by the Capabilities RPC.
+	APIVersion = "1.4.0"
+
+	bufconnSize = 1024 * 1024
+)
+
+// Server is an in-memory P4Runtime server for a single device. All methods are
+// safe for concurrent use.
+type Server struct {
+	p4_v1.UnimplementedP4RuntimeServer
+
+	deviceID uint64
+
+	mu sync.Mutex
+	// pipeline provided to NewServer, restored by Restart
+	initialPipeline *pipeline
+	// committed forwarding pipeline config, nil if none
+	pipeline *pipeline
+	// pipeline config saved with VERIFY_AND_SAVE, waiting for COMMIT
+	savedPipeline *pipeline
+	state         *state
+	arbitration   *arbitration
+	// all active StreamChannel RPCs
+	streams map[*streamConn]bool
+	faults  *faultState
+	// maximum number of entities in a ReadResponse, see SetReadChunkSize
+	readChunkSize    int
+	nextDigestListID uint64
+	packetOuts       []*p4_v1.PacketOut
+	digestAcks       []*p4_v1.DigestListAck
+
+	listener   *bufconn.Listener
+	grpcServer *grpc.Server
+}
+
+// NewServer creates a server for the device. If p4Info is not nil, the server
+// starts with a committed forwarding pipeline config using this P4Info (and
+// an empty device config), otherwise a client needs to call
+// SetForwardingPipelineConfig first.
+func NewServer(deviceID uint64, p4Info *p4_config_v1.P4Info) *Server {
+	s := &Server{
+		deviceID:         deviceID,
+		arbitration:      newArbitration(),
+		streams:          make(map[*streamConn]bool),
+		faults:           newFaultState(nil),
+		readChunkSize:    1,
+		nextDigestListID: 1,
+	}
+	if p4Info != nil {
+		s.initialPipeline = newPipeline(&p4_v1.ForwardingPipelineConfig{P4Info: p4Info})
+		s.pipeline = s.initialPipeline
+		s.state = newState(s.pipeline)
+	}
+	return s
+}
+
+// Start serves the P4Runtime service on an in-memory listener. Use Dial to
+// connect to the server.
+func (s *Server) Start() error {
+	s.listener = bufconn.Listen(bufconnSize)
+	s.grpcServer = grpc.NewServer()
+	p4_v1.RegisterP4RuntimeServer(s.grpcServer, s)
+	go s.grpcServer.Serve(s.listener) //nolint:errcheck
+	return nil
+}
+
+// Serve serves the P4Runtime service on lis until Stop is called. It can be
+// used instead of Start to expose the server over TCP.
+func (s *Server) Serve(lis net.Listener) error {
+	s.grpcServer = grpc.NewServer()
+	p4_v1.RegisterP4RuntimeServer(s.grpcServer, s)
+	return s.grpcServer.Serve(lis)
+}
+
+// Dial returns a connection to a server started with Start.
+func (s *Server) Dial(ctx context.Context, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if s.listener == nil {
+		return nil, fmt.Errorf("server was not started")
+	}
+	dialOpts := []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+	return grpc.DialContext(ctx, "bufnet", append(dialOpts, opts...)...)
+}
+
+// Stop stops the gRPC server, closing all streams.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	s.faults.stopTimers()
+	s.mu.Unlock()
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+	}
+}
+
+func (s *Server) checkDeviceID(deviceID uint64) error {
+	if deviceID != s.deviceID {
+		return status.Errorf(codes.NotFound, "unknown device id %d", deviceID)
+	}
+	return nil
+}
+
+func (s *Server) Capabilities(ctx context.Context, req *p4_v1.CapabilitiesRequest) (*p4_v1.CapabilitiesResponse, error) {
+	return &p4_v1.CapabilitiesResponse{P4RuntimeApiVersion: APIVersion}, nil
+}
+
+func (s *Server) SetForwardingPipelineConfig(ctx context.Context, req *p4_v1.SetForwardingPipelineConfigRequest) (*p4_v1.SetForwardingPipelineConfigResponse, error) {
+	if err := s.checkDeviceID(req.DeviceId); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.arbitration.checkPrimary(req.Role, req.ElectionId); err != nil {
+		return nil, err
+	}
+
+	var p *pipeline
+	switch req.Action {
+	case p4_v1.SetForwardingPipelineConfigRequest_VERIFY,
+		p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_SAVE,
+		p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_COMMIT,
+		p4_v1.SetForwardingPipelineConfigRequest_RECONCILE_AND_COMMIT:
+		if req.Config == nil || req.Config.P4Info == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "missing P4Info in forwarding pipeline config")
+		}
+		p = newPipeline(req.Config)
+	case p4_v1.SetForwardingPipelineConfigRequest_COMMIT:
+		if s.savedPipeline == nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "no saved forwarding pipeline config to commit")
+		}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid action %v", req.Action)
+	}
+
+	switch req.Action {
+	case p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_SAVE:
+		s.savedPipeline = p
+	case p4_v1.SetForwardingPipelineConfigRequest_VERIFY_AND_COMMIT:
+		s.pipeline = p
+		s.savedPipeline = nil
+		s.state = newState(p)
+	case p4_v1.SetForwardingPipelineConfigRequest_COMMIT:
+		s.pipeline = s.savedPipeline
+		s.savedPipeline = nil
+		s.state = newState(s.pipeline)
+	case p4_v1.SetForwardingPipelineConfigRequest_RECONCILE_AND_COMMIT:
+		// keep the forwarding state for the P4 objects which still exist
+		s.pipeline = p
+		s.savedPipeline = nil
+		if s.state == nil {
+			s.state = newState(p)
+		} else {
+			s.state = s.state.reconcile(p)
+		}
+	}
+	return &p4_v1.SetForwardingPipelineConfigResponse{}, nil
+}
+
+func (s *Server) GetForwardingPipelineConfig(ctx context.Context, req *p4_v1.GetForwardingPipelineConfigRequest) (*p4_v1.GetForwardingPipelineConfigResponse, error) {
+	if err := s.checkDeviceID(req.DeviceId); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pipeline == nil {
+		return &p4_v1.GetForwardingPipelineConfigResponse{}, nil
+	}
+	config := &p4_v1.ForwardingPipelineConfig{
+		Cookie: &p4_v1.ForwardingPipelineConfig_Cookie{Cookie: s.pipeline.cookie},
+	}
+	switch req.ResponseType {
+	case p4_v1.GetForwardingPipelineConfigRequest_ALL:
+		config.P4Info = s.pipeline.p4Info
+		config.P4DeviceConfig = s.pipeline.deviceConfig
+	case p4_v1.GetForwardingPipelineConfigRequest_P4INFO_AND_COOKIE:
+		config.P4Info = s.pipeline.p4Info
+	case p4_v1.GetForwardingPipelineConfigRequest_DEVICE_CONFIG_AND_COOKIE:
+		config.P4DeviceConfig = s.pipeline.deviceConfig
+	}
+	return &p4_v1.GetForwardingPipelineConfigResponse{Config: proto.Clone(config).(*p4_v1.ForwardingPipelineConfig)}, nil
+}
+
+// P4Info returns the P4Info of the committed forwarding pipeline config, or nil
+// if there is none.
+func (s *Server) P4Info() *p4_config_v1.P4Info {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pipeline == nil {
+		return nil
+	}
+	return s.pipeline.p4Info
+}
+
+// TableEntries returns all the entries of a table (not including the default
+// entry), in an unspecified order.
+func (s *Server) TableEntries(tableID uint32) []*p4_v1.TableEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return nil
+	}
+	table, ok := s.state.tables[tableID]
+	if !ok {
+		return nil
+	}
+	out := make([]*p4_v1.TableEntry, 0, len(table.entries))
+	for _, entry := range table.entries {
+		out = append(out, proto.Clone(entry).(*p4_v1.TableEntry))
+	}
+	return out
+}
+
+// SetCounterData sets the value of an indirect counter cell, e.g. to emulate
+// traffic.
+func (s *Server) SetCounterData(counterID uint32, index int64, data *p4_v1.CounterData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return fmt.Errorf("no forwarding pipeline config")
+	}
+	cells, ok := s.state.counters[counterID]
+	if !ok {
+		return fmt.Errorf("unknown counter id %d", counterID)
+	}
+	if index < 0 || index >= int64(len(cells)) {
+		return fmt.Errorf("index %d is out of range for counter %d", index, counterID)
+	}
+	cells[index] = proto.Clone(data).(*p4_v1.CounterData)
+	return nil
+}
+
+// PacketOuts returns all the PacketOut messages received from primary clients.
+func (s *Server) PacketOuts() []*p4_v1.PacketOut {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*p4_v1.PacketOut(nil), s.packetOuts...)
+}
+
+// DigestAcks returns all the DigestListAck messages received from primary
+// clients.
+func (s *Server) DigestAcks() []*p4_v1.DigestListAck {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*p4_v1.DigestListAck(nil), s.digestAcks...)
+}
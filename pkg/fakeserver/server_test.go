@@ -0,0 +1,397 @@
+package fakeserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+const (
+	testDeviceID = 1
+
+	testTableID      = 1
+	testAclTableID   = 2
+	testActionID     = 10
+	testNoActionID   = 11
+	testCounterID    = 20
+	testDigestID     = 30
+	testPortParamID  = 1
+	testMetadataID   = 1
+	testCounterCells = 4
+)
+
+func newTestP4Info() *p4_config_v1.P4Info {
+	return &p4_config_v1.P4Info{
+		Tables: []*p4_config_v1.Table{
+			{
+				Preamble: &p4_config_v1.Preamble{Name: "dmac", Id: testTableID},
+				MatchFields: []*p4_config_v1.MatchField{
+					{Id: 1, Name: "dstAddr", Bitwidth: 48, Match: &p4_config_v1.MatchField_MatchType_{MatchType: p4_config_v1.MatchField_EXACT}},
+				},
+				ActionRefs: []*p4_config_v1.ActionRef{{Id: testActionID}, {Id: testNoActionID}},
+				Size:       2,
+			},
+			{
+				Preamble: &p4_config_v1.Preamble{Name: "acl", Id: testAclTableID},
+				MatchFields: []*p4_config_v1.MatchField{
+					{Id: 1, Name: "srcAddr", Bitwidth: 32, Match: &p4_config_v1.MatchField_MatchType_{MatchType: p4_config_v1.MatchField_TERNARY}},
+				},
+				ActionRefs:           []*p4_config_v1.ActionRef{{Id: testNoActionID}},
+				ConstDefaultActionId: testNoActionID,
+			},
+		},
+		Actions: []*p4_config_v1.Action{
+			{
+				Preamble: &p4_config_v1.Preamble{Name: "fwd", Id: testActionID},
+				Params:   []*p4_config_v1.Action_Param{{Id: testPortParamID, Name: "port", Bitwidth: 9}},
+			},
+			{Preamble: &p4_config_v1.Preamble{Name: "NoAction", Id: testNoActionID}},
+		},
+		Counters: []*p4_config_v1.Counter{
+			{Preamble: &p4_config_v1.Preamble{Name: "counter", Id: testCounterID}, Size: testCounterCells},
+		},
+		Digests: []*p4_config_v1.Digest{
+			{Preamble: &p4_config_v1.Preamble{Name: "learn", Id: testDigestID}},
+		},
+		ControllerPacketMetadata: []*p4_config_v1.ControllerPacketMetadata{
+			{
+				Preamble: &p4_config_v1.Preamble{Name: packetOutMetadataName},
+				Metadata: []*p4_config_v1.ControllerPacketMetadata_Metadata{{Id: testMetadataID, Name: "egress_port", Bitwidth: 9}},
+			},
+		},
+	}
+}
+
+func newTestServer(t *testing.T) (*Server, p4_v1.P4RuntimeClient) {
+	s := NewServer(testDeviceID, newTestP4Info())
+	require.NoError(t, s.Start())
+	t.Cleanup(s.Stop)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := s.Dial(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return s, p4_v1.NewP4RuntimeClient(conn)
+}
+
+// testStream is a StreamChannel with a goroutine receiving messages.
+type testStream struct {
+	stream p4_v1.P4Runtime_StreamChannelClient
+	msgs   chan *p4_v1.StreamMessageResponse
+	errCh  chan error
+}
+
+func openStream(t *testing.T, c p4_v1.P4RuntimeClient) *testStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stream, err := c.StreamChannel(ctx)
+	require.NoError(t, err)
+	ts := &testStream{
+		stream: stream,
+		msgs:   make(chan *p4_v1.StreamMessageResponse, 100),
+		errCh:  make(chan error, 1),
+	}
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				ts.errCh <- err
+				return
+			}
+			ts.msgs <- msg
+		}
+	}()
+	return ts
+}
+
+func (ts *testStream) arbitrate(t *testing.T, electionID uint64) {
+	require.NoError(t, ts.stream.Send(&p4_v1.StreamMessageRequest{
+		Update: &p4_v1.StreamMessageRequest_Arbitration{Arbitration: &p4_v1.MasterArbitrationUpdate{
+			DeviceId:   testDeviceID,
+			ElectionId: &p4_v1.Uint128{Low: electionID},
+		}},
+	}))
+}
+
+func (ts *testStream) next(t *testing.T) *p4_v1.StreamMessageResponse {
+	select {
+	case msg := <-ts.msgs:
+		return msg
+	case err := <-ts.errCh:
+		require.FailNow(t, "stream error", err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timeout when waiting for stream message")
+	}
+	return nil
+}
+
+func (ts *testStream) nextArbitration(t *testing.T) *p4_v1.MasterArbitrationUpdate {
+	msg := ts.next(t)
+	arbitration := msg.GetArbitration()
+	require.NotNil(t, arbitration, "expected arbitration message, got %v", msg)
+	return arbitration
+}
+
+func exactEntry(mac byte, port byte) *p4_v1.TableEntry {
+	entry := &p4_v1.TableEntry{
+		TableId: testTableID,
+		Match: []*p4_v1.FieldMatch{{
+			FieldId:        1,
+			FieldMatchType: &p4_v1.FieldMatch_Exact_{Exact: &p4_v1.FieldMatch_Exact{Value: []byte{0, 0, 0, 0, 0, mac}}},
+		}},
+	}
+	if port != 0 {
+		entry.Action = &p4_v1.TableAction{Type: &p4_v1.TableAction_Action{Action: &p4_v1.Action{
+			ActionId: testActionID,
+			Params:   []*p4_v1.Action_Param{{ParamId: testPortParamID, Value: []byte{port}}},
+		}}}
+	}
+	return entry
+}
+
+func update(updateType p4_v1.Update_Type, entity *p4_v1.Entity) *p4_v1.Update {
+	return &p4_v1.Update{Type: updateType, Entity: entity}
+}
+
+func tableEntity(entry *p4_v1.TableEntry) *p4_v1.Entity {
+	return &p4_v1.Entity{Entity: &p4_v1.Entity_TableEntry{TableEntry: entry}}
+}
+
+func write(c p4_v1.P4RuntimeClient, electionID uint64, atomicity p4_v1.WriteRequest_Atomicity, updates ...*p4_v1.Update) error {
+	_, err := c.Write(context.Background(), &p4_v1.WriteRequest{
+		DeviceId:   testDeviceID,
+		ElectionId: &p4_v1.Uint128{Low: electionID},
+		Updates:    updates,
+		Atomicity:  atomicity,
+	})
+	return err
+}
+
+func read(t *testing.T, c p4_v1.P4RuntimeClient, entities ...*p4_v1.Entity) []*p4_v1.Entity {
+	stream, err := c.Read(context.Background(), &p4_v1.ReadRequest{DeviceId: testDeviceID, Entities: entities})
+	require.NoError(t, err)
+	var out []*p4_v1.Entity
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		out = append(out, resp.Entities...)
+	}
+	return out
+}
+
+// errorCodes returns the per-update error codes from a Write error.
+func errorCodes(t *testing.T, err error) []codes.Code {
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.Unknown, st.Code())
+	var out []codes.Code
+	for _, detail := range st.Details() {
+		p4Error, ok := detail.(*p4_v1.Error)
+		require.True(t, ok)
+		out = append(out, codes.Code(p4Error.CanonicalCode))
+	}
+	return out
+}
+
+func TestArbitration(t *testing.T) {
+	_, c := newTestServer(t)
+
+	s1 := openStream(t, c)
+	s1.arbitrate(t, 1)
+	arb := s1.nextArbitration(t)
+	assert.Equal(t, int32(codes.OK), arb.Status.Code)
+	assert.Equal(t, uint64(1), arb.ElectionId.Low)
+
+	s2 := openStream(t, c)
+	s2.arbitrate(t, 2)
+	// the primary changed, both clients are notified
+	arb = s2.nextArbitration(t)
+	assert.Equal(t, int32(codes.OK), arb.Status.Code)
+	arb = s1.nextArbitration(t)
+	assert.Equal(t, int32(codes.AlreadyExists), arb.Status.Code)
+	assert.Equal(t, uint64(2), arb.ElectionId.Low)
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(write(c, 1, p4_v1.WriteRequest_CONTINUE_ON_ERROR, update(p4_v1.Update_INSERT, tableEntity(exactEntry(1, 1))))))
+	assert.NoError(t, write(c, 2, p4_v1.WriteRequest_CONTINUE_ON_ERROR, update(p4_v1.Update_INSERT, tableEntity(exactEntry(1, 1)))))
+
+	// duplicate election id
+	s3 := openStream(t, c)
+	s3.arbitrate(t, 2)
+	select {
+	case err := <-s3.errCh:
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timeout when waiting for stream error")
+	}
+
+	// the primary goes away: there is no primary until a client sends an
+	// election id at least as high as 2
+	require.NoError(t, s2.stream.CloseSend())
+	arb = s1.nextArbitration(t)
+	assert.Equal(t, int32(codes.NotFound), arb.Status.Code)
+	assert.Equal(t, uint64(2), arb.ElectionId.Low)
+	s1.arbitrate(t, 3)
+	arb = s1.nextArbitration(t)
+	assert.Equal(t, int32(codes.OK), arb.Status.Code)
+}
+
+func TestWriteAndRead(t *testing.T) {
+	s, c := newTestServer(t)
+	stream := openStream(t, c)
+	stream.arbitrate(t, 1)
+	stream.nextArbitration(t)
+
+	insert := func(entry *p4_v1.TableEntry) error {
+		return write(c, 1, p4_v1.WriteRequest_CONTINUE_ON_ERROR, update(p4_v1.Update_INSERT, tableEntity(entry)))
+	}
+	require.NoError(t, insert(exactEntry(1, 1)))
+	// values are stored in canonical form
+	entry := exactEntry(2, 2)
+	entry.Match[0].GetExact().Value = []byte{2}
+	require.NoError(t, insert(entry))
+	assert.Len(t, s.TableEntries(testTableID), 2)
+
+	entities := read(t, c, tableEntity(&p4_v1.TableEntry{TableId: testTableID}))
+	require.Len(t, entities, 2)
+	assert.Equal(t, []byte{1}, entities[0].GetTableEntry().Match[0].GetExact().Value)
+	assert.Equal(t, []byte{2}, entities[1].GetTableEntry().Match[0].GetExact().Value)
+
+	entities = read(t, c, tableEntity(exactEntry(2, 0)))
+	require.Len(t, entities, 1)
+	assert.Equal(t, []byte{2}, entities[0].GetTableEntry().GetAction().GetAction().Params[0].Value)
+
+	entities = read(t, c, tableEntity(&p4_v1.TableEntry{TableId: testAclTableID, IsDefaultAction: true}))
+	require.Len(t, entities, 1)
+	assert.Equal(t, uint32(testNoActionID), entities[0].GetTableEntry().GetAction().GetAction().ActionId)
+
+	testCases := []struct {
+		name       string
+		updateType p4_v1.Update_Type
+		entry      *p4_v1.TableEntry
+		code       codes.Code
+	}{
+		{"duplicate", p4_v1.Update_INSERT, exactEntry(1, 1), codes.AlreadyExists},
+		{"table full", p4_v1.Update_INSERT, exactEntry(3, 1), codes.ResourceExhausted},
+		{"missing entry", p4_v1.Update_MODIFY, exactEntry(3, 1), codes.NotFound},
+		{"param out of range", p4_v1.Update_MODIFY, &p4_v1.TableEntry{
+			TableId: testTableID,
+			Match:   exactEntry(1, 0).Match,
+			Action: &p4_v1.TableAction{Type: &p4_v1.TableAction_Action{Action: &p4_v1.Action{
+				ActionId: testActionID,
+				Params:   []*p4_v1.Action_Param{{ParamId: testPortParamID, Value: []byte{2, 0}}},
+			}}},
+		}, codes.OutOfRange},
+		{"missing match", p4_v1.Update_INSERT, &p4_v1.TableEntry{TableId: testTableID}, codes.InvalidArgument},
+		{"missing priority", p4_v1.Update_INSERT, &p4_v1.TableEntry{TableId: testAclTableID}, codes.InvalidArgument},
+		{"unknown table", p4_v1.Update_INSERT, &p4_v1.TableEntry{TableId: 100}, codes.NotFound},
+		{"const default action", p4_v1.Update_MODIFY, &p4_v1.TableEntry{TableId: testAclTableID, IsDefaultAction: true}, codes.PermissionDenied},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := write(c, 1, p4_v1.WriteRequest_CONTINUE_ON_ERROR, update(tc.updateType, tableEntity(tc.entry)))
+			assert.Equal(t, []codes.Code{tc.code}, errorCodes(t, err))
+		})
+	}
+}
+
+func TestWriteAtomicity(t *testing.T) {
+	s, c := newTestServer(t)
+	stream := openStream(t, c)
+	stream.arbitrate(t, 1)
+	stream.nextArbitration(t)
+
+	err := write(c, 1, p4_v1.WriteRequest_CONTINUE_ON_ERROR,
+		update(p4_v1.Update_INSERT, tableEntity(exactEntry(1, 1))),
+		update(p4_v1.Update_DELETE, tableEntity(exactEntry(2, 0))),
+	)
+	assert.Equal(t, []codes.Code{codes.OK, codes.NotFound}, errorCodes(t, err))
+	assert.Len(t, s.TableEntries(testTableID), 1)
+
+	err = write(c, 1, p4_v1.WriteRequest_ROLLBACK_ON_ERROR,
+		update(p4_v1.Update_INSERT, tableEntity(exactEntry(2, 1))),
+		update(p4_v1.Update_INSERT, tableEntity(exactEntry(1, 1))),
+	)
+	assert.Equal(t, []codes.Code{codes.Aborted, codes.AlreadyExists}, errorCodes(t, err))
+	assert.Len(t, s.TableEntries(testTableID), 1)
+}
+
+func TestCounters(t *testing.T) {
+	s, c := newTestServer(t)
+	require.NoError(t, s.SetCounterData(testCounterID, 1, &p4_v1.CounterData{PacketCount: 10}))
+
+	entities := read(t, c, &p4_v1.Entity{Entity: &p4_v1.Entity_CounterEntry{CounterEntry: &p4_v1.CounterEntry{CounterId: testCounterID}}})
+	require.Len(t, entities, testCounterCells)
+	assert.Equal(t, int64(10), entities[1].GetCounterEntry().Data.PacketCount)
+
+	stream := openStream(t, c)
+	stream.arbitrate(t, 1)
+	stream.nextArbitration(t)
+	counterUpdate := func(index int64) *p4_v1.Update {
+		return update(p4_v1.Update_MODIFY, &p4_v1.Entity{Entity: &p4_v1.Entity_CounterEntry{CounterEntry: &p4_v1.CounterEntry{
+			CounterId: testCounterID,
+			Index:     &p4_v1.Index{Index: index},
+		}}})
+	}
+	assert.NoError(t, write(c, 1, p4_v1.WriteRequest_CONTINUE_ON_ERROR, counterUpdate(1)))
+	entities = read(t, c, &p4_v1.Entity{Entity: &p4_v1.Entity_CounterEntry{CounterEntry: &p4_v1.CounterEntry{
+		CounterId: testCounterID,
+		Index:     &p4_v1.Index{Index: 1},
+	}}})
+	require.Len(t, entities, 1)
+	assert.Equal(t, int64(0), entities[0].GetCounterEntry().Data.PacketCount)
+	err := write(c, 1, p4_v1.WriteRequest_CONTINUE_ON_ERROR, counterUpdate(testCounterCells))
+	assert.Equal(t, []codes.Code{codes.OutOfRange}, errorCodes(t, err))
+}
+
+func TestStreamInjection(t *testing.T) {
+	s, c := newTestServer(t)
+	primary := openStream(t, c)
+	primary.arbitrate(t, 2)
+	primary.nextArbitration(t)
+	backup := openStream(t, c)
+	backup.arbitrate(t, 1)
+	backup.nextArbitration(t)
+
+	require.NoError(t, s.SendPacketIn(&p4_v1.PacketIn{Payload: []byte("payload")}))
+	assert.Equal(t, []byte("payload"), primary.next(t).GetPacket().Payload)
+
+	_, err := s.SendDigestList(testDigestID, nil)
+	assert.Error(t, err, "digest is not enabled")
+	require.NoError(t, write(c, 2, p4_v1.WriteRequest_CONTINUE_ON_ERROR, update(p4_v1.Update_INSERT, &p4_v1.Entity{
+		Entity: &p4_v1.Entity_DigestEntry{DigestEntry: &p4_v1.DigestEntry{DigestId: testDigestID}},
+	})))
+	listID, err := s.SendDigestList(testDigestID, []*p4_v1.P4Data{{Data: &p4_v1.P4Data_Bitstring{Bitstring: []byte{1}}}})
+	require.NoError(t, err)
+	digest := primary.next(t).GetDigest()
+	require.NotNil(t, digest)
+	assert.Equal(t, listID, digest.ListId)
+
+	// PacketOut from the backup is rejected
+	packetOut := &p4_v1.PacketOut{
+		Payload:  []byte("payload"),
+		Metadata: []*p4_v1.PacketMetadata{{MetadataId: testMetadataID, Value: []byte{1}}},
+	}
+	require.NoError(t, backup.stream.Send(&p4_v1.StreamMessageRequest{Update: &p4_v1.StreamMessageRequest_Packet{Packet: packetOut}}))
+	streamErr := backup.next(t).GetError()
+	require.NotNil(t, streamErr)
+	assert.Equal(t, int32(codes.PermissionDenied), streamErr.CanonicalCode)
+	assert.NotNil(t, streamErr.GetPacketOut())
+
+	require.NoError(t, primary.stream.Send(&p4_v1.StreamMessageRequest{Update: &p4_v1.StreamMessageRequest_Packet{Packet: packetOut}}))
+	require.NoError(t, primary.stream.Send(&p4_v1.StreamMessageRequest{Update: &p4_v1.StreamMessageRequest_DigestAck{
+		DigestAck: &p4_v1.DigestListAck{DigestId: testDigestID, ListId: listID},
+	}}))
+	assert.Eventually(t, func() bool {
+		return len(s.PacketOuts()) == 1 && len(s.DigestAcks()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
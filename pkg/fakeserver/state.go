@@ -0,0 +1,227 @@
+package fakeserver
+
+import (
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+type tableState struct {
+	// indexed by tableEntryKey
+	entries      map[string]*p4_v1.TableEntry
+	defaultEntry *p4_v1.TableEntry
+}
+
+// state is the forwarding state of the device. Stored messages are never
+// mutated in place: they are replaced on MODIFY, which makes it cheap to
+// clone the state.
+type state struct {
+	tables          map[uint32]*tableState
+	members         map[uint32]map[uint32]*p4_v1.ActionProfileMember
+	groups          map[uint32]map[uint32]*p4_v1.ActionProfileGroup
+	multicastGroups map[uint32]*p4_v1.MulticastGroupEntry
+	cloneSessions   map[uint32]*p4_v1.CloneSessionEntry
+	counters        map[uint32][]*p4_v1.CounterData
+	meters          map[uint32][]*p4_v1.MeterConfig
+	digests         map[uint32]*p4_v1.DigestEntry_Config
+}
+
+func initialDefaultEntry(p *pipeline, tableID uint32) *p4_v1.TableEntry {
+	entry := &p4_v1.TableEntry{
+		TableId:         tableID,
+		IsDefaultAction: true,
+	}
+	if actionID := p.tables[tableID].ConstDefaultActionId; actionID != 0 {
+		entry.Action = &p4_v1.TableAction{
+			Type: &p4_v1.TableAction_Action{Action: &p4_v1.Action{ActionId: actionID}},
+		}
+	}
+	return entry
+}
+
+func newState(p *pipeline) *state {
+	s := &state{
+		tables:          make(map[uint32]*tableState),
+		members:         make(map[uint32]map[uint32]*p4_v1.ActionProfileMember),
+		groups:          make(map[uint32]map[uint32]*p4_v1.ActionProfileGroup),
+		multicastGroups: make(map[uint32]*p4_v1.MulticastGroupEntry),
+		cloneSessions:   make(map[uint32]*p4_v1.CloneSessionEntry),
+		counters:        make(map[uint32][]*p4_v1.CounterData),
+		meters:          make(map[uint32][]*p4_v1.MeterConfig),
+		digests:         make(map[uint32]*p4_v1.DigestEntry_Config),
+	}
+	for id := range p.tables {
+		s.tables[id] = &tableState{
+			entries:      make(map[string]*p4_v1.TableEntry),
+			defaultEntry: initialDefaultEntry(p, id),
+		}
+	}
+	for id := range p.actionProfiles {
+		s.members[id] = make(map[uint32]*p4_v1.ActionProfileMember)
+		s.groups[id] = make(map[uint32]*p4_v1.ActionProfileGroup)
+	}
+	for id, counter := range p.counters {
+		cells := make([]*p4_v1.CounterData, counter.Size)
+		for idx := range cells {
+			cells[idx] = &p4_v1.CounterData{}
+		}
+		s.counters[id] = cells
+	}
+	for id, meter := range p.meters {
+		s.meters[id] = make([]*p4_v1.MeterConfig, meter.Size)
+	}
+	return s
+}
+
+func (s *state) clone() *state {
+	c := &state{
+		tables:          make(map[uint32]*tableState, len(s.tables)),
+		members:         make(map[uint32]map[uint32]*p4_v1.ActionProfileMember, len(s.members)),
+		groups:          make(map[uint32]map[uint32]*p4_v1.ActionProfileGroup, len(s.groups)),
+		multicastGroups: make(map[uint32]*p4_v1.MulticastGroupEntry, len(s.multicastGroups)),
+		cloneSessions:   make(map[uint32]*p4_v1.CloneSessionEntry, len(s.cloneSessions)),
+		counters:        make(map[uint32][]*p4_v1.CounterData, len(s.counters)),
+		meters:          make(map[uint32][]*p4_v1.MeterConfig, len(s.meters)),
+		digests:         make(map[uint32]*p4_v1.DigestEntry_Config, len(s.digests)),
+	}
+	for id, table := range s.tables {
+		entries := make(map[string]*p4_v1.TableEntry, len(table.entries))
+		for key, entry := range table.entries {
+			entries[key] = entry
+		}
+		c.tables[id] = &tableState{entries: entries, defaultEntry: table.defaultEntry}
+	}
+	for id, members := range s.members {
+		c.members[id] = make(map[uint32]*p4_v1.ActionProfileMember, len(members))
+		for memberID, member := range members {
+			c.members[id][memberID] = member
+		}
+	}
+	for id, groups := range s.groups {
+		c.groups[id] = make(map[uint32]*p4_v1.ActionProfileGroup, len(groups))
+		for groupID, group := range groups {
+			c.groups[id][groupID] = group
+		}
+	}
+	for id, group := range s.multicastGroups {
+		c.multicastGroups[id] = group
+	}
+	for id, session := range s.cloneSessions {
+		c.cloneSessions[id] = session
+	}
+	for id, cells := range s.counters {
+		c.counters[id] = append([]*p4_v1.CounterData(nil), cells...)
+	}
+	for id, cells := range s.meters {
+		c.meters[id] = append([]*p4_v1.MeterConfig(nil), cells...)
+	}
+	for id, config := range s.digests {
+		c.digests[id] = config
+	}
+	return c
+}
+
+// reconcile returns a new state for pipeline p, which keeps the existing state
+// for all the P4 objects which are still present in the new pipeline.
+func (s *state) reconcile(p *pipeline) *state {
+	old := s.clone()
+	c := newState(p)
+	for id := range c.tables {
+		if table, ok := old.tables[id]; ok {
+			c.tables[id] = table
+		}
+	}
+	for id := range c.members {
+		if members, ok := old.members[id]; ok {
+			c.members[id] = members
+			c.groups[id] = old.groups[id]
+		}
+	}
+	c.multicastGroups = old.multicastGroups
+	c.cloneSessions = old.cloneSessions
+	for id, cells := range c.counters {
+		if oldCells, ok := old.counters[id]; ok && len(oldCells) == len(cells) {
+			c.counters[id] = oldCells
+		}
+	}
+	for id, cells := range c.meters {
+		if oldCells, ok := old.meters[id]; ok && len(oldCells) == len(cells) {
+			c.meters[id] = oldCells
+		}
+	}
+	for id, config := range old.digests {
+		if _, ok := p.digests[id]; ok {
+			c.digests[id] = config
+		}
+	}
+	return c
+}
+
+var deterministicMarshal = proto.MarshalOptions{Deterministic: true}
+
+// tableEntryKey returns a string which uniquely identifies the table entry in
+// its table: the match fields (which are expected to be canonical and sorted
+// by field id) and the priority.
+func tableEntryKey(entry *p4_v1.TableEntry) string {
+	b, err := deterministicMarshal.Marshal(&p4_v1.TableEntry{
+		Match:    entry.Match,
+		Priority: entry.Priority,
+	})
+	if err != nil {
+		// cannot happen for a valid message
+		panic(err)
+	}
+	return string(b)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedIDs[V any](m map[uint32]V) []uint32 {
+	ids := make([]uint32, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// isMemberReferenced returns true if the member is used by a group or a table
+// entry.
+func (s *state) isMemberReferenced(p *pipeline, actionProfileID uint32, memberID uint32) bool {
+	for _, group := range s.groups[actionProfileID] {
+		for _, member := range group.Members {
+			if member.MemberId == memberID {
+				return true
+			}
+		}
+	}
+	for _, table := range p.actionProfileTables(actionProfileID) {
+		for _, entry := range s.tables[table.Preamble.Id].entries {
+			if entry.GetAction().GetActionProfileMemberId() == memberID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isGroupReferenced returns true if the group is used by a table entry.
+func (s *state) isGroupReferenced(p *pipeline, actionProfileID uint32, groupID uint32) bool {
+	for _, table := range p.actionProfileTables(actionProfileID) {
+		for _, entry := range s.tables[table.Preamble.Id].entries {
+			if entry.GetAction().GetActionProfileGroupId() == groupID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,296 @@
+package fakeserver
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// streamConn is a StreamChannel RPC. The arbitration fields are protected by
+// the server mutex.
+type streamConn struct {
+	stream   p4_v1.P4Runtime_StreamChannelServer
+	deviceID uint64
+	// serializes calls to Send, which are made without holding the server
+	// mutex
+	sendMu sync.Mutex
+	// used to terminate the RPC with an error
+	closeCh chan error
+
+	arbitrated bool
+	role       string
+	roleProto  *p4_v1.Role
+	// nil if the client did not provide an election id
+	electionID *uint128
+}
+
+// close terminates the RPC with err. It does not block.
+func (conn *streamConn) close(err error) {
+	select {
+	case conn.closeCh <- err:
+	default:
+	}
+}
+
+// send sends a message on the stream, after applying the active FaultPlan.
+// Errors are ignored: they mean that the stream is being closed, and the
+// StreamChannel handler will return.
+func (s *Server) send(conn *streamConn, msg *p4_v1.StreamMessageResponse) {
+	s.mu.Lock()
+	drop, delay := s.faults.streamMessageSent(msg)
+	s.mu.Unlock()
+	if drop {
+		return
+	}
+	conn.sendMu.Lock()
+	defer conn.sendMu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	conn.stream.Send(msg) //nolint:errcheck
+}
+
+func (s *Server) sendArbitrationMessages(msgs []arbitrationMessage) {
+	for _, m := range msgs {
+		s.send(m.conn, m.msg)
+	}
+}
+
+func (s *Server) StreamChannel(stream p4_v1.P4Runtime_StreamChannelServer) error {
+	conn := &streamConn{
+		stream:   stream,
+		deviceID: s.deviceID,
+		closeCh:  make(chan error, 1),
+	}
+	s.mu.Lock()
+	s.streams[conn] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, conn)
+		msgs := s.arbitration.remove(conn)
+		s.mu.Unlock()
+		s.sendArbitrationMessages(msgs)
+	}()
+
+	recvCh := make(chan *p4_v1.StreamMessageRequest)
+	recvErrCh := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErrCh <- err
+				return
+			}
+			select {
+			case recvCh <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case req := <-recvCh:
+			if err := s.handleStreamMessage(conn, req); err != nil {
+				return err
+			}
+		case err := <-recvErrCh:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case err := <-conn.closeCh:
+			return err
+		}
+	}
+}
+
+func streamError(err error, req *p4_v1.StreamMessageRequest) *p4_v1.StreamMessageResponse {
+	st, _ := status.FromError(err)
+	streamErr := &p4_v1.StreamError{
+		CanonicalCode: int32(st.Code()),
+		Message:       st.Message(),
+	}
+	switch update := req.Update.(type) {
+	case *p4_v1.StreamMessageRequest_Packet:
+		streamErr.Details = &p4_v1.StreamError_PacketOut{PacketOut: &p4_v1.PacketOutError{PacketOut: update.Packet}}
+	case *p4_v1.StreamMessageRequest_DigestAck:
+		streamErr.Details = &p4_v1.StreamError_DigestListAck{DigestListAck: &p4_v1.DigestListAckError{DigestListAck: update.DigestAck}}
+	default:
+		streamErr.Details = &p4_v1.StreamError_Other{Other: &p4_v1.StreamOtherError{}}
+	}
+	return &p4_v1.StreamMessageResponse{Update: &p4_v1.StreamMessageResponse_Error{Error: streamErr}}
+}
+
+// handleStreamMessage processes a message received on the stream. A returned
+// error terminates the stream, while errors for PacketOut and DigestListAck
+// messages are reported with a StreamError message.
+func (s *Server) handleStreamMessage(conn *streamConn, req *p4_v1.StreamMessageRequest) error {
+	s.mu.Lock()
+	actions := s.faults.streamMessageReceived()
+	s.mu.Unlock()
+	defer s.runFaultActions(actions)
+
+	if arbitration, ok := req.Update.(*p4_v1.StreamMessageRequest_Arbitration); ok {
+		s.mu.Lock()
+		msgs, err := s.arbitration.update(conn, s.deviceID, arbitration.Arbitration)
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		s.sendArbitrationMessages(msgs)
+		return nil
+	}
+
+	s.mu.Lock()
+	err := s.handleStreamUpdate(conn, req)
+	s.mu.Unlock()
+	if err != nil {
+		s.send(conn, streamError(err, req))
+	}
+	return nil
+}
+
+func (s *Server) handleStreamUpdate(conn *streamConn, req *p4_v1.StreamMessageRequest) error {
+	switch update := req.Update.(type) {
+	case *p4_v1.StreamMessageRequest_Packet:
+		if !s.arbitration.isPrimary(conn) {
+			return status.Errorf(codes.PermissionDenied, "only the primary client can send PacketOut messages")
+		}
+		if err := s.validatePacketOut(update.Packet); err != nil {
+			return err
+		}
+		s.packetOuts = append(s.packetOuts, proto.Clone(update.Packet).(*p4_v1.PacketOut))
+	case *p4_v1.StreamMessageRequest_DigestAck:
+		if !s.arbitration.isPrimary(conn) {
+			return status.Errorf(codes.PermissionDenied, "only the primary client can ack digest lists")
+		}
+		s.digestAcks = append(s.digestAcks, proto.Clone(update.DigestAck).(*p4_v1.DigestListAck))
+	default:
+		return status.Errorf(codes.Unimplemented, "unsupported stream message")
+	}
+	return nil
+}
+
+func (s *Server) validatePacketOut(pkt *p4_v1.PacketOut) error {
+	if s.pipeline == nil {
+		return status.Errorf(codes.FailedPrecondition, "no forwarding pipeline config")
+	}
+	if len(pkt.Metadata) == 0 {
+		return nil
+	}
+	if s.pipeline.packetOut == nil {
+		return status.Errorf(codes.InvalidArgument, "P4Info does not define %s metadata", packetOutMetadataName)
+	}
+	seen := make(map[uint32]bool, len(pkt.Metadata))
+	for _, md := range pkt.Metadata {
+		var p4Md *p4_config_v1.ControllerPacketMetadata_Metadata
+		for _, m := range s.pipeline.packetOut.Metadata {
+			if m.Id == md.MetadataId {
+				p4Md = m
+			}
+		}
+		if p4Md == nil {
+			return status.Errorf(codes.InvalidArgument, "unknown %s metadata id %d", packetOutMetadataName, md.MetadataId)
+		}
+		if seen[md.MetadataId] {
+			return status.Errorf(codes.InvalidArgument, "duplicate %s metadata %s", packetOutMetadataName, p4Md.Name)
+		}
+		seen[md.MetadataId] = true
+		if _, err := canonicalValue(md.Value, p4Md.Bitwidth, "metadata "+p4Md.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendToPrimaries sends the message to the primary client of each role.
+func (s *Server) sendToPrimaries(msg *p4_v1.StreamMessageResponse) error {
+	s.mu.Lock()
+	primaries := s.arbitration.primaries()
+	s.mu.Unlock()
+	if len(primaries) == 0 {
+		return fmt.Errorf("no primary client is connected")
+	}
+	for _, conn := range primaries {
+		s.send(conn, msg)
+	}
+	return nil
+}
+
+// SendPacketIn sends a PacketIn message to the primary clients.
+func (s *Server) SendPacketIn(pkt *p4_v1.PacketIn) error {
+	return s.sendToPrimaries(&p4_v1.StreamMessageResponse{
+		Update: &p4_v1.StreamMessageResponse_Packet{Packet: pkt},
+	})
+}
+
+// SendDigestList sends a DigestList message to the primary clients and returns
+// its list id. The digest must have been enabled by the client with a
+// DigestEntry.
+func (s *Server) SendDigestList(digestID uint32, data []*p4_v1.P4Data) (uint64, error) {
+	s.mu.Lock()
+	if s.state == nil {
+		s.mu.Unlock()
+		return 0, fmt.Errorf("no forwarding pipeline config")
+	}
+	if _, ok := s.state.digests[digestID]; !ok {
+		s.mu.Unlock()
+		return 0, fmt.Errorf("digest %d is not enabled", digestID)
+	}
+	listID := s.nextDigestListID
+	s.nextDigestListID++
+	s.mu.Unlock()
+
+	err := s.sendToPrimaries(&p4_v1.StreamMessageResponse{
+		Update: &p4_v1.StreamMessageResponse_Digest{Digest: &p4_v1.DigestList{
+			DigestId:  digestID,
+			ListId:    listID,
+			Data:      data,
+			Timestamp: time.Now().UnixNano(),
+		}},
+	})
+	return listID, err
+}
+
+// SendIdleTimeoutNotification sends an IdleTimeoutNotification for the table
+// entries, which are identified by their key, to the primary clients. The
+// entries must exist and have a non-zero idle_timeout_ns.
+func (s *Server) SendIdleTimeoutNotification(entries ...*p4_v1.TableEntry) error {
+	s.mu.Lock()
+	if s.state == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("no forwarding pipeline config")
+	}
+	notification := &p4_v1.IdleTimeoutNotification{Timestamp: time.Now().UnixNano()}
+	for _, entry := range entries {
+		_, _, stored, err := lookupTableEntry(s.pipeline, s.state, entry)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		if stored.IdleTimeoutNs == 0 {
+			s.mu.Unlock()
+			return fmt.Errorf("table entry does not have an idle timeout")
+		}
+		notified := proto.Clone(stored).(*p4_v1.TableEntry)
+		notified.CounterData = nil
+		notified.MeterConfig = nil
+		notification.TableEntry = append(notification.TableEntry, notified)
+	}
+	s.mu.Unlock()
+
+	return s.sendToPrimaries(&p4_v1.StreamMessageResponse{
+		Update: &p4_v1.StreamMessageResponse_IdleTimeoutNotification{IdleTimeoutNotification: notification},
+	})
+}
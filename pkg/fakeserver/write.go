@@ -0,0 +1,881 @@
+package fakeserver
+
+import (
+	"context"
+	"math/big"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/util/conversion"
+)
+
+func (s *Server) Write(ctx context.Context, req *p4_v1.WriteRequest) (*p4_v1.WriteResponse, error) {
+	if err := s.checkDeviceID(req.DeviceId); err != nil {
+		return nil, err
+	}
+	resp, actions, err := s.write(req)
+	// fault actions triggered by this write take effect before the client
+	// receives the response
+	s.runFaultActions(actions)
+	return resp, err
+}
+
+func (s *Server) write(req *p4_v1.WriteRequest) (*p4_v1.WriteResponse, []FaultAction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	actions, applied, err := s.faults.writeReceived()
+	if err != nil {
+		if applied {
+			_, _ = s.applyWrite(req)
+		}
+		return nil, actions, err
+	}
+	resp, err := s.applyWrite(req)
+	return resp, actions, err
+}
+
+func (s *Server) applyWrite(req *p4_v1.WriteRequest) (*p4_v1.WriteResponse, error) {
+	if err := s.arbitration.checkPrimary(req.Role, req.ElectionId); err != nil {
+		return nil, err
+	}
+	if s.pipeline == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "no forwarding pipeline config")
+	}
+
+	atomic := false
+	switch req.Atomicity {
+	case p4_v1.WriteRequest_CONTINUE_ON_ERROR:
+	case p4_v1.WriteRequest_ROLLBACK_ON_ERROR, p4_v1.WriteRequest_DATAPLANE_ATOMIC:
+		atomic = true
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid atomicity %v", req.Atomicity)
+	}
+
+	st := s.state
+	if atomic {
+		st = s.state.clone()
+	}
+	errs := make([]error, len(req.Updates))
+	failed := false
+	for idx, update := range req.Updates {
+		errs[idx] = applyUpdate(s.pipeline, st, update)
+		if errs[idx] != nil {
+			failed = true
+		}
+	}
+	if !failed {
+		s.state = st
+		return &p4_v1.WriteResponse{}, nil
+	}
+	if atomic {
+		// the whole batch is rolled back
+		for idx := range errs {
+			if errs[idx] == nil {
+				errs[idx] = status.Errorf(codes.Aborted, "batch was rolled back")
+			}
+		}
+	}
+	return nil, writeError(errs)
+}
+
+// writeError builds the error returned by the Write RPC when at least one of
+// the updates failed: as per the P4Runtime specification, the status includes
+// one p4.v1.Error for each update.
+func writeError(errs []error) error {
+	st := status.New(codes.Unknown, "write failure")
+	for _, err := range errs {
+		p4Error := &p4_v1.Error{CanonicalCode: int32(codes.OK)}
+		if err != nil {
+			errSt, _ := status.FromError(err)
+			p4Error.CanonicalCode = int32(errSt.Code())
+			p4Error.Message = errSt.Message()
+		}
+		stWithDetails, detailsErr := st.WithDetails(p4Error)
+		if detailsErr != nil {
+			return st.Err()
+		}
+		st = stWithDetails
+	}
+	return st.Err()
+}
+
+func applyUpdate(p *pipeline, st *state, update *p4_v1.Update) error {
+	switch update.Type {
+	case p4_v1.Update_INSERT, p4_v1.Update_MODIFY, p4_v1.Update_DELETE:
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid update type %v", update.Type)
+	}
+	switch e := update.GetEntity().GetEntity().(type) {
+	case *p4_v1.Entity_TableEntry:
+		return writeTableEntry(p, st, update.Type, e.TableEntry)
+	case *p4_v1.Entity_ActionProfileMember:
+		return writeActionProfileMember(p, st, update.Type, e.ActionProfileMember)
+	case *p4_v1.Entity_ActionProfileGroup:
+		return writeActionProfileGroup(p, st, update.Type, e.ActionProfileGroup)
+	case *p4_v1.Entity_PacketReplicationEngineEntry:
+		if group := e.PacketReplicationEngineEntry.GetMulticastGroupEntry(); group != nil {
+			return writeMulticastGroup(st, update.Type, group)
+		}
+		if session := e.PacketReplicationEngineEntry.GetCloneSessionEntry(); session != nil {
+			return writeCloneSession(st, update.Type, session)
+		}
+		return status.Errorf(codes.InvalidArgument, "empty PRE entry")
+	case *p4_v1.Entity_CounterEntry:
+		return writeCounterEntry(p, st, update.Type, e.CounterEntry)
+	case *p4_v1.Entity_DirectCounterEntry:
+		return writeDirectCounterEntry(p, st, update.Type, e.DirectCounterEntry)
+	case *p4_v1.Entity_MeterEntry:
+		return writeMeterEntry(p, st, update.Type, e.MeterEntry)
+	case *p4_v1.Entity_DirectMeterEntry:
+		return writeDirectMeterEntry(p, st, update.Type, e.DirectMeterEntry)
+	case *p4_v1.Entity_DigestEntry:
+		return writeDigestEntry(p, st, update.Type, e.DigestEntry)
+	case nil:
+		return status.Errorf(codes.InvalidArgument, "missing entity")
+	default:
+		return status.Errorf(codes.Unimplemented, "entity type %T is not supported", e)
+	}
+}
+
+// canonicalValue validates a bytestring against the bitwidth from the P4Info
+// and returns its canonical representation. A bitwidth of 0 means that the
+// value is not a fixed-width integer (e.g. a translated type) and is used as
+// is.
+func canonicalValue(value []byte, bitwidth int32, what string) ([]byte, error) {
+	if len(value) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "empty bytestring for %s", what)
+	}
+	if bitwidth == 0 {
+		return value, nil
+	}
+	canonical := conversion.ToCanonicalBytestring(value)
+	if new(big.Int).SetBytes(canonical).BitLen() > int(bitwidth) {
+		return nil, status.Errorf(codes.OutOfRange, "value for %s does not fit in %d bits", what, bitwidth)
+	}
+	return canonical, nil
+}
+
+func fullMask(bitwidth int32) *big.Int {
+	return new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(bitwidth)), big.NewInt(1))
+}
+
+func validateFieldMatch(mf *p4_config_v1.MatchField, m *p4_v1.FieldMatch) (*p4_v1.FieldMatch, error) {
+	what := "match field " + mf.Name
+	out := &p4_v1.FieldMatch{FieldId: m.FieldId}
+	matchTypeErr := status.Errorf(codes.InvalidArgument, "invalid match type for %s, expected %v", what, mf.GetMatchType())
+	switch v := m.FieldMatchType.(type) {
+	case *p4_v1.FieldMatch_Exact_:
+		if mf.GetMatchType() != p4_config_v1.MatchField_EXACT {
+			return nil, matchTypeErr
+		}
+		value, err := canonicalValue(v.Exact.Value, mf.Bitwidth, what)
+		if err != nil {
+			return nil, err
+		}
+		out.FieldMatchType = &p4_v1.FieldMatch_Exact_{Exact: &p4_v1.FieldMatch_Exact{Value: value}}
+	case *p4_v1.FieldMatch_Lpm:
+		if mf.GetMatchType() != p4_config_v1.MatchField_LPM {
+			return nil, matchTypeErr
+		}
+		value, err := canonicalValue(v.Lpm.Value, mf.Bitwidth, what)
+		if err != nil {
+			return nil, err
+		}
+		plen := v.Lpm.PrefixLen
+		if plen <= 0 || plen > mf.Bitwidth {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid prefix length %d for %s (don't care matches must be omitted)", plen, what)
+		}
+		hostMask := fullMask(mf.Bitwidth - plen)
+		if new(big.Int).And(new(big.Int).SetBytes(value), hostMask).Sign() != 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "LPM value for %s has bits set beyond the prefix length", what)
+		}
+		out.FieldMatchType = &p4_v1.FieldMatch_Lpm{Lpm: &p4_v1.FieldMatch_LPM{Value: value, PrefixLen: plen}}
+	case *p4_v1.FieldMatch_Ternary_:
+		if mf.GetMatchType() != p4_config_v1.MatchField_TERNARY {
+			return nil, matchTypeErr
+		}
+		value, err := canonicalValue(v.Ternary.Value, mf.Bitwidth, what)
+		if err != nil {
+			return nil, err
+		}
+		mask, err := canonicalValue(v.Ternary.Mask, mf.Bitwidth, what)
+		if err != nil {
+			return nil, err
+		}
+		maskInt := new(big.Int).SetBytes(mask)
+		if maskInt.Sign() == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "zero mask for %s (don't care matches must be omitted)", what)
+		}
+		if new(big.Int).AndNot(new(big.Int).SetBytes(value), maskInt).Sign() != 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "ternary value for %s has bits set outside of the mask", what)
+		}
+		out.FieldMatchType = &p4_v1.FieldMatch_Ternary_{Ternary: &p4_v1.FieldMatch_Ternary{Value: value, Mask: mask}}
+	case *p4_v1.FieldMatch_Range_:
+		if mf.GetMatchType() != p4_config_v1.MatchField_RANGE {
+			return nil, matchTypeErr
+		}
+		low, err := canonicalValue(v.Range.Low, mf.Bitwidth, what)
+		if err != nil {
+			return nil, err
+		}
+		high, err := canonicalValue(v.Range.High, mf.Bitwidth, what)
+		if err != nil {
+			return nil, err
+		}
+		lowInt, highInt := new(big.Int).SetBytes(low), new(big.Int).SetBytes(high)
+		if lowInt.Cmp(highInt) > 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid range for %s: low is greater than high", what)
+		}
+		if lowInt.Sign() == 0 && highInt.Cmp(fullMask(mf.Bitwidth)) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "full range for %s (don't care matches must be omitted)", what)
+		}
+		out.FieldMatchType = &p4_v1.FieldMatch_Range_{Range: &p4_v1.FieldMatch_Range{Low: low, High: high}}
+	case *p4_v1.FieldMatch_Optional_:
+		if mf.GetMatchType() != p4_config_v1.MatchField_OPTIONAL {
+			return nil, matchTypeErr
+		}
+		value, err := canonicalValue(v.Optional.Value, mf.Bitwidth, what)
+		if err != nil {
+			return nil, err
+		}
+		out.FieldMatchType = &p4_v1.FieldMatch_Optional_{Optional: &p4_v1.FieldMatch_Optional{Value: value}}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported match type for %s", what)
+	}
+	return out, nil
+}
+
+// validateMatch validates the match key and returns it in canonical form,
+// sorted by field id.
+func validateMatch(table *p4_config_v1.Table, match []*p4_v1.FieldMatch) ([]*p4_v1.FieldMatch, error) {
+	fields := make(map[uint32]*p4_config_v1.MatchField, len(table.MatchFields))
+	for _, mf := range table.MatchFields {
+		fields[mf.Id] = mf
+	}
+	seen := make(map[uint32]bool, len(match))
+	out := make([]*p4_v1.FieldMatch, 0, len(match))
+	for _, m := range match {
+		mf, ok := fields[m.FieldId]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown match field id %d in table %s", m.FieldId, table.Preamble.Name)
+		}
+		if seen[m.FieldId] {
+			return nil, status.Errorf(codes.InvalidArgument, "duplicate match field %s", mf.Name)
+		}
+		seen[m.FieldId] = true
+		canonical, err := validateFieldMatch(mf, m)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, canonical)
+	}
+	for _, mf := range table.MatchFields {
+		if mf.GetMatchType() == p4_config_v1.MatchField_EXACT && !seen[mf.Id] {
+			return nil, status.Errorf(codes.InvalidArgument, "missing exact match field %s", mf.Name)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].FieldId < out[j].FieldId })
+	return out, nil
+}
+
+// validateAction validates the action and its parameters, and returns it with
+// canonical parameter values, sorted by parameter id.
+func validateAction(p *pipeline, action *p4_v1.Action) (*p4_v1.Action, error) {
+	if action == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "missing action")
+	}
+	p4Action, ok := p.actions[action.ActionId]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown action id %d", action.ActionId)
+	}
+	params := make(map[uint32]*p4_config_v1.Action_Param, len(p4Action.Params))
+	for _, param := range p4Action.Params {
+		params[param.Id] = param
+	}
+	seen := make(map[uint32]bool, len(action.Params))
+	out := &p4_v1.Action{ActionId: action.ActionId}
+	for _, param := range action.Params {
+		p4Param, ok := params[param.ParamId]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown param id %d for action %s", param.ParamId, p4Action.Preamble.Name)
+		}
+		if seen[param.ParamId] {
+			return nil, status.Errorf(codes.InvalidArgument, "duplicate param %s for action %s", p4Param.Name, p4Action.Preamble.Name)
+		}
+		seen[param.ParamId] = true
+		value, err := canonicalValue(param.Value, p4Param.Bitwidth, "param "+p4Param.Name)
+		if err != nil {
+			return nil, err
+		}
+		out.Params = append(out.Params, &p4_v1.Action_Param{ParamId: param.ParamId, Value: value})
+	}
+	if len(seen) != len(params) {
+		return nil, status.Errorf(codes.InvalidArgument, "missing params for action %s", p4Action.Preamble.Name)
+	}
+	sort.Slice(out.Params, func(i, j int) bool { return out.Params[i].ParamId < out.Params[j].ParamId })
+	return out, nil
+}
+
+func validateTableActionRef(table *p4_config_v1.Table, actionID uint32, isDefault bool) error {
+	ref := findActionRef(table, actionID)
+	if ref == nil {
+		return status.Errorf(codes.InvalidArgument, "action id %d is not valid for table %s", actionID, table.Preamble.Name)
+	}
+	if isDefault && ref.Scope == p4_config_v1.ActionRef_TABLE_ONLY {
+		return status.Errorf(codes.InvalidArgument, "action id %d cannot be the default action for table %s", actionID, table.Preamble.Name)
+	}
+	if !isDefault && ref.Scope == p4_config_v1.ActionRef_DEFAULT_ONLY {
+		return status.Errorf(codes.InvalidArgument, "action id %d can only be the default action for table %s", actionID, table.Preamble.Name)
+	}
+	return nil
+}
+
+func validateTableAction(p *pipeline, st *state, table *p4_config_v1.Table, tableAction *p4_v1.TableAction, isDefault bool) (*p4_v1.TableAction, error) {
+	if tableAction == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "missing action for table %s", table.Preamble.Name)
+	}
+	indirect := table.ImplementationId != 0
+	switch a := tableAction.Type.(type) {
+	case *p4_v1.TableAction_Action:
+		if indirect {
+			return nil, status.Errorf(codes.InvalidArgument, "table %s requires an action profile action", table.Preamble.Name)
+		}
+		if err := validateTableActionRef(table, a.Action.GetActionId(), isDefault); err != nil {
+			return nil, err
+		}
+		action, err := validateAction(p, a.Action)
+		if err != nil {
+			return nil, err
+		}
+		return &p4_v1.TableAction{Type: &p4_v1.TableAction_Action{Action: action}}, nil
+	case *p4_v1.TableAction_ActionProfileMemberId:
+		if !indirect {
+			return nil, status.Errorf(codes.InvalidArgument, "table %s does not have an action profile", table.Preamble.Name)
+		}
+		if _, ok := st.members[table.ImplementationId][a.ActionProfileMemberId]; !ok {
+			return nil, status.Errorf(codes.NotFound, "action profile member %d does not exist", a.ActionProfileMemberId)
+		}
+		return tableAction, nil
+	case *p4_v1.TableAction_ActionProfileGroupId:
+		if !indirect {
+			return nil, status.Errorf(codes.InvalidArgument, "table %s does not have an action profile", table.Preamble.Name)
+		}
+		if _, ok := st.groups[table.ImplementationId][a.ActionProfileGroupId]; !ok {
+			return nil, status.Errorf(codes.NotFound, "action profile group %d does not exist", a.ActionProfileGroupId)
+		}
+		return tableAction, nil
+	case *p4_v1.TableAction_ActionProfileActionSet:
+		if !indirect {
+			return nil, status.Errorf(codes.InvalidArgument, "table %s does not have an action profile", table.Preamble.Name)
+		}
+		if len(a.ActionProfileActionSet.GetActionProfileActions()) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "empty action set")
+		}
+		out := &p4_v1.ActionProfileActionSet{}
+		for _, apAction := range a.ActionProfileActionSet.ActionProfileActions {
+			if apAction.Weight <= 0 {
+				return nil, status.Errorf(codes.InvalidArgument, "action set weights must be positive")
+			}
+			if err := validateTableActionRef(table, apAction.GetAction().GetActionId(), isDefault); err != nil {
+				return nil, err
+			}
+			action, err := validateAction(p, apAction.Action)
+			if err != nil {
+				return nil, err
+			}
+			c := proto.Clone(apAction).(*p4_v1.ActionProfileAction)
+			c.Action = action
+			out.ActionProfileActions = append(out.ActionProfileActions, c)
+		}
+		return &p4_v1.TableAction{Type: &p4_v1.TableAction_ActionProfileActionSet{ActionProfileActionSet: out}}, nil
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "missing action for table %s", table.Preamble.Name)
+}
+
+func writeDefaultEntry(p *pipeline, st *state, updateType p4_v1.Update_Type, table *p4_config_v1.Table, entry *p4_v1.TableEntry) error {
+	if updateType != p4_v1.Update_MODIFY {
+		return status.Errorf(codes.InvalidArgument, "only MODIFY is supported for the default entry")
+	}
+	if len(entry.Match) > 0 || entry.Priority != 0 {
+		return status.Errorf(codes.InvalidArgument, "the default entry cannot have a match key or a priority")
+	}
+	if table.ConstDefaultActionId != 0 {
+		return status.Errorf(codes.PermissionDenied, "the default action of table %s is const", table.Preamble.Name)
+	}
+	if entry.Action == nil {
+		// reset to the initial default action
+		st.tables[table.Preamble.Id].defaultEntry = initialDefaultEntry(p, table.Preamble.Id)
+		return nil
+	}
+	action, err := validateTableAction(p, st, table, entry.Action, true)
+	if err != nil {
+		return err
+	}
+	st.tables[table.Preamble.Id].defaultEntry = &p4_v1.TableEntry{
+		TableId:         table.Preamble.Id,
+		Action:          action,
+		IsDefaultAction: true,
+	}
+	return nil
+}
+
+func writeTableEntry(p *pipeline, st *state, updateType p4_v1.Update_Type, entry *p4_v1.TableEntry) error {
+	table, ok := p.tables[entry.TableId]
+	if !ok {
+		return status.Errorf(codes.NotFound, "unknown table id %d", entry.TableId)
+	}
+	if table.IsConstTable {
+		return status.Errorf(codes.PermissionDenied, "table %s is const", table.Preamble.Name)
+	}
+	if entry.IsDefaultAction {
+		return writeDefaultEntry(p, st, updateType, table, entry)
+	}
+
+	match, err := validateMatch(table, entry.Match)
+	if err != nil {
+		return err
+	}
+	if tableHasPriority(table) {
+		if entry.Priority <= 0 {
+			return status.Errorf(codes.InvalidArgument, "table %s requires a positive priority", table.Preamble.Name)
+		}
+	} else if entry.Priority != 0 {
+		return status.Errorf(codes.InvalidArgument, "table %s does not support priorities", table.Preamble.Name)
+	}
+
+	tableSt := st.tables[entry.TableId]
+	stored := &p4_v1.TableEntry{
+		TableId:  entry.TableId,
+		Match:    match,
+		Priority: entry.Priority,
+	}
+	key := tableEntryKey(stored)
+	existing, exists := tableSt.entries[key]
+
+	switch updateType {
+	case p4_v1.Update_INSERT:
+		if exists {
+			return status.Errorf(codes.AlreadyExists, "entry already exists in table %s", table.Preamble.Name)
+		}
+		if table.Size > 0 && int64(len(tableSt.entries)) >= table.Size {
+			return status.Errorf(codes.ResourceExhausted, "table %s is full", table.Preamble.Name)
+		}
+	case p4_v1.Update_MODIFY:
+		if !exists {
+			return status.Errorf(codes.NotFound, "entry does not exist in table %s", table.Preamble.Name)
+		}
+	case p4_v1.Update_DELETE:
+		if !exists {
+			return status.Errorf(codes.NotFound, "entry does not exist in table %s", table.Preamble.Name)
+		}
+		delete(tableSt.entries, key)
+		return nil
+	}
+
+	if stored.Action, err = validateTableAction(p, st, table, entry.Action, false); err != nil {
+		return err
+	}
+	if entry.IdleTimeoutNs != 0 {
+		if table.IdleTimeoutBehavior != p4_config_v1.Table_NOTIFY_CONTROL {
+			return status.Errorf(codes.InvalidArgument, "table %s does not support idle timeouts", table.Preamble.Name)
+		}
+		if entry.IdleTimeoutNs < 0 {
+			return status.Errorf(codes.InvalidArgument, "negative idle timeout")
+		}
+	}
+	stored.IdleTimeoutNs = entry.IdleTimeoutNs
+	stored.ControllerMetadata = entry.ControllerMetadata
+	stored.Metadata = entry.Metadata
+
+	_, hasDirectCounter := p.directCounters[entry.TableId]
+	_, hasDirectMeter := p.directMeters[entry.TableId]
+	if entry.CounterData != nil && !hasDirectCounter {
+		return status.Errorf(codes.InvalidArgument, "table %s does not have a direct counter", table.Preamble.Name)
+	}
+	if entry.MeterConfig != nil && !hasDirectMeter {
+		return status.Errorf(codes.InvalidArgument, "table %s does not have a direct meter", table.Preamble.Name)
+	}
+	if hasDirectCounter {
+		stored.CounterData = &p4_v1.CounterData{}
+		if entry.CounterData != nil {
+			stored.CounterData = entry.CounterData
+		} else if exists {
+			// counters are preserved by MODIFY unless specified
+			stored.CounterData = existing.CounterData
+		}
+	}
+	if entry.MeterConfig != nil {
+		if err := validateMeterConfig(entry.MeterConfig); err != nil {
+			return err
+		}
+		stored.MeterConfig = entry.MeterConfig
+	} else if exists {
+		stored.MeterConfig = existing.MeterConfig
+	}
+
+	tableSt.entries[key] = proto.Clone(stored).(*p4_v1.TableEntry)
+	return nil
+}
+
+func writeActionProfileMember(p *pipeline, st *state, updateType p4_v1.Update_Type, member *p4_v1.ActionProfileMember) error {
+	actionProfile, ok := p.actionProfiles[member.ActionProfileId]
+	if !ok {
+		return status.Errorf(codes.NotFound, "unknown action profile id %d", member.ActionProfileId)
+	}
+	members := st.members[member.ActionProfileId]
+	_, exists := members[member.MemberId]
+	switch updateType {
+	case p4_v1.Update_INSERT:
+		if exists {
+			return status.Errorf(codes.AlreadyExists, "member %d already exists", member.MemberId)
+		}
+		if actionProfile.Size > 0 && int64(len(members)) >= actionProfile.Size {
+			return status.Errorf(codes.ResourceExhausted, "action profile %s is full", actionProfile.Preamble.Name)
+		}
+	case p4_v1.Update_MODIFY:
+		if !exists {
+			return status.Errorf(codes.NotFound, "member %d does not exist", member.MemberId)
+		}
+	case p4_v1.Update_DELETE:
+		if !exists {
+			return status.Errorf(codes.NotFound, "member %d does not exist", member.MemberId)
+		}
+		if st.isMemberReferenced(p, member.ActionProfileId, member.MemberId) {
+			return status.Errorf(codes.FailedPrecondition, "member %d is still referenced", member.MemberId)
+		}
+		delete(members, member.MemberId)
+		return nil
+	}
+
+	for _, table := range p.actionProfileTables(member.ActionProfileId) {
+		if findActionRef(table, member.GetAction().GetActionId()) == nil {
+			return status.Errorf(codes.InvalidArgument, "action id %d is not valid for table %s", member.GetAction().GetActionId(), table.Preamble.Name)
+		}
+	}
+	action, err := validateAction(p, member.Action)
+	if err != nil {
+		return err
+	}
+	members[member.MemberId] = &p4_v1.ActionProfileMember{
+		ActionProfileId: member.ActionProfileId,
+		MemberId:        member.MemberId,
+		Action:          action,
+	}
+	return nil
+}
+
+func writeActionProfileGroup(p *pipeline, st *state, updateType p4_v1.Update_Type, group *p4_v1.ActionProfileGroup) error {
+	actionProfile, ok := p.actionProfiles[group.ActionProfileId]
+	if !ok {
+		return status.Errorf(codes.NotFound, "unknown action profile id %d", group.ActionProfileId)
+	}
+	if !actionProfile.WithSelector {
+		return status.Errorf(codes.InvalidArgument, "action profile %s does not support groups", actionProfile.Preamble.Name)
+	}
+	groups := st.groups[group.ActionProfileId]
+	existing, exists := groups[group.GroupId]
+	switch updateType {
+	case p4_v1.Update_INSERT:
+		if exists {
+			return status.Errorf(codes.AlreadyExists, "group %d already exists", group.GroupId)
+		}
+		if group.MaxSize < 0 {
+			return status.Errorf(codes.InvalidArgument, "negative max size")
+		}
+		if actionProfile.MaxGroupSize > 0 && group.MaxSize > actionProfile.MaxGroupSize {
+			return status.Errorf(codes.ResourceExhausted, "max size %d exceeds max group size %d for action profile %s", group.MaxSize, actionProfile.MaxGroupSize, actionProfile.Preamble.Name)
+		}
+	case p4_v1.Update_MODIFY:
+		if !exists {
+			return status.Errorf(codes.NotFound, "group %d does not exist", group.GroupId)
+		}
+		if group.MaxSize != 0 && group.MaxSize != existing.MaxSize {
+			return status.Errorf(codes.InvalidArgument, "max size cannot be modified")
+		}
+	case p4_v1.Update_DELETE:
+		if !exists {
+			return status.Errorf(codes.NotFound, "group %d does not exist", group.GroupId)
+		}
+		if st.isGroupReferenced(p, group.ActionProfileId, group.GroupId) {
+			return status.Errorf(codes.FailedPrecondition, "group %d is still referenced", group.GroupId)
+		}
+		delete(groups, group.GroupId)
+		return nil
+	}
+
+	maxSize := group.MaxSize
+	if exists {
+		maxSize = existing.MaxSize
+	}
+	if maxSize == 0 {
+		maxSize = actionProfile.MaxGroupSize
+	}
+	seen := make(map[uint32]bool, len(group.Members))
+	var totalWeight int64
+	for _, member := range group.Members {
+		if _, ok := st.members[group.ActionProfileId][member.MemberId]; !ok {
+			return status.Errorf(codes.NotFound, "member %d does not exist", member.MemberId)
+		}
+		if seen[member.MemberId] {
+			return status.Errorf(codes.InvalidArgument, "duplicate member %d in group %d", member.MemberId, group.GroupId)
+		}
+		seen[member.MemberId] = true
+		if member.Weight <= 0 {
+			return status.Errorf(codes.InvalidArgument, "member weights must be positive")
+		}
+		totalWeight += int64(member.Weight)
+	}
+	if maxSize > 0 && totalWeight > int64(maxSize) {
+		return status.Errorf(codes.ResourceExhausted, "total weight %d exceeds max size %d for group %d", totalWeight, maxSize, group.GroupId)
+	}
+	stored := proto.Clone(group).(*p4_v1.ActionProfileGroup)
+	stored.MaxSize = group.MaxSize
+	if exists {
+		stored.MaxSize = existing.MaxSize
+	}
+	groups[group.GroupId] = stored
+	return nil
+}
+
+func validateReplicas(replicas []*p4_v1.Replica) error {
+	seen := make(map[[2]uint32]bool, len(replicas))
+	for _, replica := range replicas {
+		k := [2]uint32{replica.EgressPort, replica.Instance}
+		if seen[k] {
+			return status.Errorf(codes.InvalidArgument, "duplicate replica (port %d, instance %d)", replica.EgressPort, replica.Instance)
+		}
+		seen[k] = true
+	}
+	return nil
+}
+
+func writeMulticastGroup(st *state, updateType p4_v1.Update_Type, group *p4_v1.MulticastGroupEntry) error {
+	if group.MulticastGroupId == 0 {
+		return status.Errorf(codes.InvalidArgument, "multicast group id cannot be 0")
+	}
+	_, exists := st.multicastGroups[group.MulticastGroupId]
+	switch updateType {
+	case p4_v1.Update_INSERT:
+		if exists {
+			return status.Errorf(codes.AlreadyExists, "multicast group %d already exists", group.MulticastGroupId)
+		}
+	case p4_v1.Update_MODIFY:
+		if !exists {
+			return status.Errorf(codes.NotFound, "multicast group %d does not exist", group.MulticastGroupId)
+		}
+	case p4_v1.Update_DELETE:
+		if !exists {
+			return status.Errorf(codes.NotFound, "multicast group %d does not exist", group.MulticastGroupId)
+		}
+		delete(st.multicastGroups, group.MulticastGroupId)
+		return nil
+	}
+	if err := validateReplicas(group.Replicas); err != nil {
+		return err
+	}
+	st.multicastGroups[group.MulticastGroupId] = proto.Clone(group).(*p4_v1.MulticastGroupEntry)
+	return nil
+}
+
+func writeCloneSession(st *state, updateType p4_v1.Update_Type, session *p4_v1.CloneSessionEntry) error {
+	if session.SessionId == 0 {
+		return status.Errorf(codes.InvalidArgument, "clone session id cannot be 0")
+	}
+	_, exists := st.cloneSessions[session.SessionId]
+	switch updateType {
+	case p4_v1.Update_INSERT:
+		if exists {
+			return status.Errorf(codes.AlreadyExists, "clone session %d already exists", session.SessionId)
+		}
+	case p4_v1.Update_MODIFY:
+		if !exists {
+			return status.Errorf(codes.NotFound, "clone session %d does not exist", session.SessionId)
+		}
+	case p4_v1.Update_DELETE:
+		if !exists {
+			return status.Errorf(codes.NotFound, "clone session %d does not exist", session.SessionId)
+		}
+		delete(st.cloneSessions, session.SessionId)
+		return nil
+	}
+	if session.PacketLengthBytes < 0 {
+		return status.Errorf(codes.InvalidArgument, "negative packet length")
+	}
+	if err := validateReplicas(session.Replicas); err != nil {
+		return err
+	}
+	st.cloneSessions[session.SessionId] = proto.Clone(session).(*p4_v1.CloneSessionEntry)
+	return nil
+}
+
+// cellRange returns the range of cells targeted by an indirect counter or meter
+// entry: all cells if index is not set.
+func cellRange(index *p4_v1.Index, size int) (int, int, error) {
+	if index == nil {
+		return 0, size, nil
+	}
+	if index.Index < 0 || index.Index >= int64(size) {
+		return 0, 0, status.Errorf(codes.OutOfRange, "index %d is out of range", index.Index)
+	}
+	return int(index.Index), int(index.Index) + 1, nil
+}
+
+func writeCounterEntry(p *pipeline, st *state, updateType p4_v1.Update_Type, entry *p4_v1.CounterEntry) error {
+	if _, ok := p.counters[entry.CounterId]; !ok {
+		return status.Errorf(codes.NotFound, "unknown counter id %d", entry.CounterId)
+	}
+	if updateType != p4_v1.Update_MODIFY {
+		return status.Errorf(codes.InvalidArgument, "only MODIFY is supported for counter entries")
+	}
+	cells := st.counters[entry.CounterId]
+	start, end, err := cellRange(entry.Index, len(cells))
+	if err != nil {
+		return err
+	}
+	data := &p4_v1.CounterData{}
+	if entry.Data != nil {
+		data = proto.Clone(entry.Data).(*p4_v1.CounterData)
+	}
+	for idx := start; idx < end; idx++ {
+		cells[idx] = data
+	}
+	return nil
+}
+
+// lookupTableEntry returns the stored entry matching the key of entry, for
+// direct resources.
+func lookupTableEntry(p *pipeline, st *state, entry *p4_v1.TableEntry) (*p4_config_v1.Table, string, *p4_v1.TableEntry, error) {
+	if entry == nil {
+		return nil, "", nil, status.Errorf(codes.InvalidArgument, "missing table entry")
+	}
+	table, ok := p.tables[entry.TableId]
+	if !ok {
+		return nil, "", nil, status.Errorf(codes.NotFound, "unknown table id %d", entry.TableId)
+	}
+	match, err := validateMatch(table, entry.Match)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	key := tableEntryKey(&p4_v1.TableEntry{Match: match, Priority: entry.Priority})
+	stored, ok := st.tables[entry.TableId].entries[key]
+	if !ok {
+		return nil, "", nil, status.Errorf(codes.NotFound, "entry does not exist in table %s", table.Preamble.Name)
+	}
+	return table, key, stored, nil
+}
+
+func writeDirectCounterEntry(p *pipeline, st *state, updateType p4_v1.Update_Type, entry *p4_v1.DirectCounterEntry) error {
+	if updateType != p4_v1.Update_MODIFY {
+		return status.Errorf(codes.InvalidArgument, "only MODIFY is supported for direct counter entries")
+	}
+	table, key, stored, err := lookupTableEntry(p, st, entry.TableEntry)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.directCounters[table.Preamble.Id]; !ok {
+		return status.Errorf(codes.InvalidArgument, "table %s does not have a direct counter", table.Preamble.Name)
+	}
+	updated := proto.Clone(stored).(*p4_v1.TableEntry)
+	updated.CounterData = &p4_v1.CounterData{}
+	if entry.Data != nil {
+		updated.CounterData = proto.Clone(entry.Data).(*p4_v1.CounterData)
+	}
+	st.tables[table.Preamble.Id].entries[key] = updated
+	return nil
+}
+
+func validateMeterConfig(config *p4_v1.MeterConfig) error {
+	if config.Cir < 0 || config.Cburst < 0 || config.Pir < 0 || config.Pburst < 0 {
+		return status.Errorf(codes.InvalidArgument, "meter rates and burst sizes cannot be negative")
+	}
+	if config.Pir < config.Cir {
+		return status.Errorf(codes.InvalidArgument, "PIR must be greater than or equal to CIR")
+	}
+	return nil
+}
+
+func writeMeterEntry(p *pipeline, st *state, updateType p4_v1.Update_Type, entry *p4_v1.MeterEntry) error {
+	if _, ok := p.meters[entry.MeterId]; !ok {
+		return status.Errorf(codes.NotFound, "unknown meter id %d", entry.MeterId)
+	}
+	if updateType != p4_v1.Update_MODIFY {
+		return status.Errorf(codes.InvalidArgument, "only MODIFY is supported for meter entries")
+	}
+	cells := st.meters[entry.MeterId]
+	start, end, err := cellRange(entry.Index, len(cells))
+	if err != nil {
+		return err
+	}
+	var config *p4_v1.MeterConfig
+	if entry.Config != nil {
+		if err := validateMeterConfig(entry.Config); err != nil {
+			return err
+		}
+		config = proto.Clone(entry.Config).(*p4_v1.MeterConfig)
+	}
+	for idx := start; idx < end; idx++ {
+		cells[idx] = config
+	}
+	return nil
+}
+
+func writeDirectMeterEntry(p *pipeline, st *state, updateType p4_v1.Update_Type, entry *p4_v1.DirectMeterEntry) error {
+	if updateType != p4_v1.Update_MODIFY {
+		return status.Errorf(codes.InvalidArgument, "only MODIFY is supported for direct meter entries")
+	}
+	table, key, stored, err := lookupTableEntry(p, st, entry.TableEntry)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.directMeters[table.Preamble.Id]; !ok {
+		return status.Errorf(codes.InvalidArgument, "table %s does not have a direct meter", table.Preamble.Name)
+	}
+	updated := proto.Clone(stored).(*p4_v1.TableEntry)
+	updated.MeterConfig = nil
+	if entry.Config != nil {
+		if err := validateMeterConfig(entry.Config); err != nil {
+			return err
+		}
+		updated.MeterConfig = proto.Clone(entry.Config).(*p4_v1.MeterConfig)
+	}
+	st.tables[table.Preamble.Id].entries[key] = updated
+	return nil
+}
+
+func writeDigestEntry(p *pipeline, st *state, updateType p4_v1.Update_Type, entry *p4_v1.DigestEntry) error {
+	if _, ok := p.digests[entry.DigestId]; !ok {
+		return status.Errorf(codes.NotFound, "unknown digest id %d", entry.DigestId)
+	}
+	_, exists := st.digests[entry.DigestId]
+	switch updateType {
+	case p4_v1.Update_INSERT:
+		if exists {
+			return status.Errorf(codes.AlreadyExists, "digest %d is already configured", entry.DigestId)
+		}
+	case p4_v1.Update_MODIFY:
+		if !exists {
+			return status.Errorf(codes.NotFound, "digest %d is not configured", entry.DigestId)
+		}
+	case p4_v1.Update_DELETE:
+		if !exists {
+			return status.Errorf(codes.NotFound, "digest %d is not configured", entry.DigestId)
+		}
+		delete(st.digests, entry.DigestId)
+		return nil
+	}
+	config := &p4_v1.DigestEntry_Config{}
+	if entry.Config != nil {
+		if entry.Config.MaxTimeoutNs < 0 || entry.Config.MaxListSize < 0 || entry.Config.AckTimeoutNs < 0 {
+			return status.Errorf(codes.InvalidArgument, "digest config values cannot be negative")
+		}
+		config = proto.Clone(entry.Config).(*p4_v1.DigestEntry_Config)
+	}
+	st.digests[entry.DigestId] = config
+	return nil
+}
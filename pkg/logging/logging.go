@@ -0,0 +1,149 @@
+// Package logging defines the Logger interface used by the client and signals
+// packages, with adapters for log/slog and logrus.
+//
+// Loggers use structured fields, passed as alternating keys and values, like
+// log/slog:
+//
+//	logger.Info("Became primary", "electionID", electionID)
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger is a structured, leveled logger. Implementations must be safe for
+// concurrent use.
+type Logger interface {
+	Debug(msg string, keysAndValues ...any)
+	Info(msg string, keysAndValues ...any)
+	Warn(msg string, keysAndValues ...any)
+	Error(msg string, keysAndValues ...any)
+	// With returns a Logger which adds the provided fields to all messages.
+	With(keysAndValues ...any) Logger
+	// DebugEnabled returns true if debug messages are logged, and can be used
+	// to avoid expensive computations for debug messages.
+	DebugEnabled() bool
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Debug(string, ...any) {}
+func (noopLogger) Info(string, ...any)  {}
+func (noopLogger) Warn(string, ...any)  {}
+func (noopLogger) Error(string, ...any) {}
+func (l noopLogger) With(...any) Logger { return l }
+func (noopLogger) DebugEnabled() bool   { return false }
+
+// NewNoopLogger returns a Logger which discards all messages.
+func NewNoopLogger() Logger {
+	return noopLogger{}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a Logger which logs to the provided slog.Logger.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger}
+}
+
+func (l *slogLogger) Debug(msg string, keysAndValues ...any) {
+	l.logger.Debug(msg, keysAndValues...)
+}
+
+func (l *slogLogger) Info(msg string, keysAndValues ...any) {
+	l.logger.Info(msg, keysAndValues...)
+}
+
+func (l *slogLogger) Warn(msg string, keysAndValues ...any) {
+	l.logger.Warn(msg, keysAndValues...)
+}
+
+func (l *slogLogger) Error(msg string, keysAndValues ...any) {
+	l.logger.Error(msg, keysAndValues...)
+}
+
+func (l *slogLogger) With(keysAndValues ...any) Logger {
+	return &slogLogger{logger: l.logger.With(keysAndValues...)}
+}
+
+func (l *slogLogger) DebugEnabled() bool {
+	return l.logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+type logrusLogger struct {
+	logger logrus.FieldLogger
+	// used to check the level, nil if logger is not a *logrus.Logger or a
+	// *logrus.Entry
+	levelLogger *logrus.Logger
+}
+
+// NewLogrusLogger returns a Logger which logs to the provided logrus logger,
+// e.g. logrus.StandardLogger().
+func NewLogrusLogger(logger logrus.FieldLogger) Logger {
+	l := &logrusLogger{logger: logger}
+	switch logger := logger.(type) {
+	case *logrus.Logger:
+		l.levelLogger = logger
+	case *logrus.Entry:
+		l.levelLogger = logger.Logger
+	}
+	return l
+}
+
+func (l *logrusLogger) withFields(keysAndValues []any) logrus.FieldLogger {
+	if len(keysAndValues) == 0 {
+		return l.logger
+	}
+	return l.logger.WithFields(toFields(keysAndValues))
+}
+
+func (l *logrusLogger) Debug(msg string, keysAndValues ...any) {
+	if !l.DebugEnabled() {
+		return
+	}
+	l.withFields(keysAndValues).Debug(msg)
+}
+
+func (l *logrusLogger) Info(msg string, keysAndValues ...any) {
+	l.withFields(keysAndValues).Info(msg)
+}
+
+func (l *logrusLogger) Warn(msg string, keysAndValues ...any) {
+	l.withFields(keysAndValues).Warn(msg)
+}
+
+func (l *logrusLogger) Error(msg string, keysAndValues ...any) {
+	l.withFields(keysAndValues).Error(msg)
+}
+
+func (l *logrusLogger) With(keysAndValues ...any) Logger {
+	return &logrusLogger{logger: l.withFields(keysAndValues), levelLogger: l.levelLogger}
+}
+
+func (l *logrusLogger) DebugEnabled() bool {
+	if l.levelLogger == nil {
+		return true
+	}
+	return l.levelLogger.IsLevelEnabled(logrus.DebugLevel)
+}
+
+// toFields converts alternating keys and values to logrus fields. As with
+// log/slog, a value without a key is logged with the "!BADKEY" key.
+func toFields(keysAndValues []any) logrus.Fields {
+	fields := make(logrus.Fields, len(keysAndValues)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, ok := keysAndValues[i].(string)
+		if !ok || i+1 == len(keysAndValues) {
+			fields["!BADKEY"] = keysAndValues[i]
+			i--
+			continue
+		}
+		fields[key] = keysAndValues[i+1]
+	}
+	return fields
+}
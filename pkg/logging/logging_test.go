@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	assert.False(t, logger.DebugEnabled())
+	logger = logger.With("deviceID", 1)
+	logger.Debug("debug message")
+	logger.Info("info message", "key", "value")
+	assert.NotContains(t, buf.String(), "debug message")
+	assert.Contains(t, buf.String(), `msg="info message" deviceID=1 key=value`)
+}
+
+func TestLogrusLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&buf)
+	l.SetLevel(logrus.InfoLevel)
+	l.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true})
+	logger := NewLogrusLogger(l)
+	assert.False(t, logger.DebugEnabled())
+	logger = logger.With("deviceID", 1)
+	logger.Debug("debug message")
+	logger.Warn("warning message", "key", "value")
+	assert.NotContains(t, buf.String(), "debug message")
+	assert.Contains(t, buf.String(), `level=warning msg="warning message" deviceID=1 key=value`)
+	l.SetLevel(logrus.DebugLevel)
+	assert.True(t, logger.DebugEnabled())
+}
+
+func TestToFields(t *testing.T) {
+	assert.Equal(t, logrus.Fields{"a": 1, "b": 2}, toFields([]any{"a", 1, "b", 2}))
+	assert.Equal(t, logrus.Fields{"a": 1, "!BADKEY": "b"}, toFields([]any{"a", 1, "b"}))
+	assert.Equal(t, logrus.Fields{"!BADKEY": 3, "a": 1}, toFields([]any{3, "a", 1}))
+}
@@ -0,0 +1,461 @@
+// Package manager manages the Clients of a controller which programs several
+// P4Runtime devices.
+//
+// A Manager owns one Client for each device, keyed by server address and device
+// id. It runs and supervises the stream channel of each Client, re-establishing
+// it with exponential backoff when it fails, tracks the primary status and the
+// pipeline cookie of each device, and merges the stream messages of all the
+// devices into a single channel of Events, tagged with the device key:
+//
+//	m := manager.NewManager(manager.DefaultOptions)
+//	defer m.Close()
+//	err := m.AddDevice(manager.DeviceConfig{
+//		Addr:           "10.0.0.1:9559",
+//		DeviceID:       1,
+//		ElectionID:     &p4_v1.Uint128{High: 0, Low: 1},
+//		ConnectOptions: client.DefaultConnectOptions,
+//	})
+//	...
+//	for event := range m.Events() {
+//		...
+//	}
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/client"
+	"github.com/antoninbas/p4runtime-go-client/pkg/logging"
+)
+
+// DeviceKey identifies a device managed by a Manager. Several devices can be
+// exposed by the same P4Runtime server.
+type DeviceKey struct {
+	Addr     string
+	DeviceID uint64
+}
+
+func (k DeviceKey) String() string {
+	return fmt.Sprintf("%s/%d", k.Addr, k.DeviceID)
+}
+
+// DeviceConfig describes how to connect to a device.
+type DeviceConfig struct {
+	Addr       string
+	DeviceID   uint64
+	ElectionID *p4_v1.Uint128
+	// Role is the role of the Client, nil for the default role.
+	Role *p4_v1.Role
+	// ConnectOptions configures the gRPC connection. The Role, ArbitrationCh
+	// and MessageCh fields are ignored.
+	ConnectOptions client.ConnectOptions
+	// ClientOptions are passed to client.NewClientForRole.
+	ClientOptions []func(*client.ClientOptions)
+}
+
+func (c *DeviceConfig) key() DeviceKey {
+	return DeviceKey{Addr: c.Addr, DeviceID: c.DeviceID}
+}
+
+type EventType int
+
+const (
+	// EventArbitration is sent for each arbitration update received for the
+	// device. IsPrimary is set.
+	EventArbitration EventType = iota
+	// EventStreamMessage is sent for each stream message other than
+	// arbitration updates. Message is set.
+	EventStreamMessage
+	// EventDisconnected is sent when the stream channel of the device fails.
+	// Err is set. The Manager re-establishes the stream after a backoff.
+	EventDisconnected
+	// EventRemoved is the last Event sent for a device, after it has been
+	// removed with RemoveDevice.
+	EventRemoved
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventArbitration:
+		return "Arbitration"
+	case EventStreamMessage:
+		return "StreamMessage"
+	case EventDisconnected:
+		return "Disconnected"
+	case EventRemoved:
+		return "Removed"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event is a stream event for a device.
+type Event struct {
+	Device    DeviceKey
+	Type      EventType
+	IsPrimary bool
+	Message   *p4_v1.StreamMessageResponse
+	Err       error
+}
+
+// DeviceStatus is the status of a device managed by a Manager.
+type DeviceStatus struct {
+	Device DeviceKey
+	// Connected is true once an arbitration update has been received on the
+	// current stream.
+	Connected bool
+	IsPrimary bool
+	// HasPipeline is false if the device has no forwarding pipeline config,
+	// or if the pipeline cookie could not be retrieved yet. The cookie is
+	// retrieved in the background after each arbitration update.
+	HasPipeline    bool
+	PipelineCookie uint64
+	// LastError is the error which terminated the previous stream, if any.
+	LastError error
+}
+
+// Options configures a Manager.
+type Options struct {
+	// EventBufferSize is the capacity of the Events channel. Streams are
+	// blocked while the channel is full.
+	EventBufferSize int
+	// ReconnectBackoff is the delay before the first attempt to
+	// re-establish a failed stream. It doubles with each consecutive failure,
+	// up to MaxReconnectBackoff.
+	ReconnectBackoff    time.Duration
+	MaxReconnectBackoff time.Duration
+	// PipelineTimeout is the timeout for the GetForwardingPipelineConfig RPC
+	// used to retrieve the pipeline cookie.
+	PipelineTimeout time.Duration
+	Logger          logging.Logger
+}
+
+var DefaultOptions = Options{
+	EventBufferSize:     1000,
+	ReconnectBackoff:    100 * time.Millisecond,
+	MaxReconnectBackoff: 10 * time.Second,
+	PipelineTimeout:     5 * time.Second,
+	Logger:              logging.NewNoopLogger(),
+}
+
+type device struct {
+	key    DeviceKey
+	conn   *grpc.ClientConn
+	client *client.Client
+	log    logging.Logger
+	stopCh chan struct{}
+	doneCh chan struct{}
+
+	// protected by Manager.mu
+	status DeviceStatus
+}
+
+// Manager manages a set of Clients. It is safe for concurrent use.
+type Manager struct {
+	options Options
+	eventCh chan Event
+
+	mu      sync.Mutex
+	devices map[DeviceKey]*device
+	closed  bool
+
+	// protects the closing of eventCh against concurrent calls to
+	// RemoveDevice
+	eventsMu     sync.RWMutex
+	eventsClosed bool
+}
+
+// NewManager creates a Manager without any device.
+func NewManager(options Options) *Manager {
+	if options.Logger == nil {
+		options.Logger = logging.NewNoopLogger()
+	}
+	return &Manager{
+		options: options,
+		eventCh: make(chan Event, options.EventBufferSize),
+		devices: make(map[DeviceKey]*device),
+	}
+}
+
+// Events returns the channel which receives the Events of all the devices. It
+// is closed by Close.
+func (m *Manager) Events() <-chan Event {
+	return m.eventCh
+}
+
+// AddDevice creates a Client for the device and starts its stream channel in
+// the background. Arbitration updates are reported as Events.
+func (m *Manager) AddDevice(config DeviceConfig) error {
+	key := config.key()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return fmt.Errorf("manager is closed")
+	}
+	if _, ok := m.devices[key]; ok {
+		return fmt.Errorf("device %v already exists", key)
+	}
+	conn, err := client.DialConn(context.Background(), config.Addr, config.ConnectOptions)
+	if err != nil {
+		return fmt.Errorf("cannot connect to device %v: %w", key, err)
+	}
+	d := &device{
+		key:    key,
+		conn:   conn,
+		client: client.NewClientForRole(p4_v1.NewP4RuntimeClient(conn), config.DeviceID, config.ElectionID, config.Role, config.ClientOptions...),
+		log:    m.options.Logger.With("device", key.String()),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+		status: DeviceStatus{Device: key},
+	}
+	m.devices[key] = d
+	go m.runDevice(d)
+	return nil
+}
+
+// RemoveDevice stops the stream channel of the device and closes its
+// connection. An EventRemoved Event is sent for the device.
+func (m *Manager) RemoveDevice(key DeviceKey) error {
+	m.mu.Lock()
+	d, ok := m.devices[key]
+	if ok {
+		delete(m.devices, key)
+	}
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown device %v", key)
+	}
+	m.stopDevice(d)
+	m.eventsMu.RLock()
+	defer m.eventsMu.RUnlock()
+	if !m.eventsClosed {
+		m.eventCh <- Event{Device: key, Type: EventRemoved}
+	}
+	return nil
+}
+
+func (m *Manager) stopDevice(d *device) {
+	close(d.stopCh)
+	<-d.doneCh
+	d.conn.Close()
+}
+
+// Close removes all the devices and closes the Events channel. No EventRemoved
+// Events are sent.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	devices := m.devices
+	m.devices = make(map[DeviceKey]*device)
+	m.mu.Unlock()
+	for _, d := range devices {
+		m.stopDevice(d)
+	}
+	m.eventsMu.Lock()
+	defer m.eventsMu.Unlock()
+	m.eventsClosed = true
+	close(m.eventCh)
+}
+
+// Client returns the Client for the device.
+func (m *Manager) Client(key DeviceKey) (*client.Client, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.devices[key]
+	if !ok {
+		return nil, false
+	}
+	return d.client, true
+}
+
+// Status returns the status of the device.
+func (m *Manager) Status(key DeviceKey) (DeviceStatus, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.devices[key]
+	if !ok {
+		return DeviceStatus{}, false
+	}
+	return d.status, true
+}
+
+// Devices returns the status of all the devices, sorted by key.
+func (m *Manager) Devices() []DeviceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]DeviceStatus, 0, len(m.devices))
+	for _, d := range m.devices {
+		out = append(out, d.status)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Device.Addr != out[j].Device.Addr {
+			return out[i].Device.Addr < out[j].Device.Addr
+		}
+		return out[i].Device.DeviceID < out[j].Device.DeviceID
+	})
+	return out
+}
+
+// RefreshPipelineCookie retrieves the pipeline cookie of the device, e.g.
+// after its forwarding pipeline config was changed by the Client, and updates
+// the DeviceStatus. The cookie is otherwise refreshed with every arbitration
+// update.
+func (m *Manager) RefreshPipelineCookie(ctx context.Context, key DeviceKey) error {
+	m.mu.Lock()
+	d, ok := m.devices[key]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown device %v", key)
+	}
+	return m.refreshPipelineCookie(ctx, d)
+}
+
+func (m *Manager) refreshPipelineCookie(ctx context.Context, d *device) error {
+	config, err := d.client.GetFwdPipe(ctx, client.GetFwdPipeCookieOnly)
+	if err != nil {
+		return err
+	}
+	m.updateStatus(d, func(status *DeviceStatus) {
+		status.HasPipeline = config != nil
+		status.PipelineCookie = 0
+		if config != nil {
+			status.PipelineCookie = config.Cookie
+		}
+	})
+	return nil
+}
+
+// runCookieRefresh retrieves the pipeline cookie of the device each time a
+// refresh is requested on refreshCh, until the device is stopped.
+func (m *Manager) runCookieRefresh(d *device, refreshCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		select {
+		case <-refreshCh:
+		case <-d.stopCh:
+			return
+		}
+		refreshCtx, refreshCancel := context.WithTimeout(ctx, m.options.PipelineTimeout)
+		if err := m.refreshPipelineCookie(refreshCtx, d); err != nil {
+			d.log.Warn("Failed to retrieve pipeline cookie", "error", err)
+		}
+		refreshCancel()
+	}
+}
+
+func (m *Manager) updateStatus(d *device, fn func(*DeviceStatus)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(&d.status)
+}
+
+// sendEvent returns false if the device was stopped before the Event could be
+// sent.
+func (m *Manager) sendEvent(d *device, event Event) bool {
+	select {
+	case m.eventCh <- event:
+		return true
+	case <-d.stopCh:
+		return false
+	}
+}
+
+// runDevice runs the stream channel of the device until it is stopped.
+func (m *Manager) runDevice(d *device) {
+	defer close(d.doneCh)
+	// the pipeline cookie is retrieved by a separate goroutine, so that stream
+	// messages are still forwarded in the meantime
+	refreshCh := make(chan struct{}, 1)
+	var refreshWg sync.WaitGroup
+	refreshWg.Add(1)
+	go func() {
+		defer refreshWg.Done()
+		m.runCookieRefresh(d, refreshCh)
+	}()
+	defer refreshWg.Wait()
+
+	backoff := m.options.ReconnectBackoff
+	for {
+		arbitrationCh := make(chan bool)
+		messageCh := make(chan *p4_v1.StreamMessageResponse)
+		runErrCh := make(chan error, 1)
+		go func() {
+			runErrCh <- d.client.Run(d.stopCh, arbitrationCh, messageCh)
+		}()
+
+		var runErr error
+	stream:
+		for {
+			select {
+			case isPrimary := <-arbitrationCh:
+				backoff = m.options.ReconnectBackoff
+				m.updateStatus(d, func(status *DeviceStatus) {
+					status.Connected = true
+					status.IsPrimary = isPrimary
+				})
+				select {
+				case refreshCh <- struct{}{}:
+				default:
+					// a refresh is already pending
+				}
+				if !m.sendEvent(d, Event{Device: d.key, Type: EventArbitration, IsPrimary: isPrimary}) {
+					<-runErrCh
+					return
+				}
+			case msg := <-messageCh:
+				if !m.sendEvent(d, Event{Device: d.key, Type: EventStreamMessage, Message: msg}) {
+					<-runErrCh
+					return
+				}
+			case runErr = <-runErrCh:
+				break stream
+			case <-d.stopCh:
+				// Run closes the stream gracefully, which requires the
+				// connection
+				<-runErrCh
+				return
+			}
+		}
+
+		if runErr == nil {
+			runErr = fmt.Errorf("stream channel terminated")
+		}
+		d.log.Warn("Stream channel failed, will retry", "error", runErr, "backoff", backoff)
+		m.updateStatus(d, func(status *DeviceStatus) {
+			status.Connected = false
+			status.IsPrimary = false
+			status.LastError = runErr
+		})
+		if !m.sendEvent(d, Event{Device: d.key, Type: EventDisconnected, Err: runErr}) {
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-d.stopCh:
+			return
+		}
+		backoff *= 2
+		if backoff > m.options.MaxReconnectBackoff {
+			backoff = m.options.MaxReconnectBackoff
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package manager
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/client"
+	"github.com/antoninbas/p4runtime-go-client/pkg/fakeserver"
+)
+
+// startServer starts a fake server over TCP and returns its address.
+func startServer(t *testing.T, deviceID uint64, p4Info *p4_config_v1.P4Info) (*fakeserver.Server, string) {
+	s := fakeserver.NewServer(deviceID, p4Info)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(lis) //nolint:errcheck
+	t.Cleanup(s.Stop)
+	return s, lis.Addr().String()
+}
+
+func deviceConfig(addr string, deviceID uint64) DeviceConfig {
+	return DeviceConfig{
+		Addr:           addr,
+		DeviceID:       deviceID,
+		ElectionID:     &p4_v1.Uint128{High: 0, Low: 1},
+		ConnectOptions: client.DefaultConnectOptions,
+	}
+}
+
+func waitEvent(t *testing.T, m *Manager, eventType EventType) Event {
+	for {
+		select {
+		case event := <-m.Events():
+			if event.Type == eventType {
+				return event
+			}
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timeout when waiting for event", "type: %v", eventType)
+		}
+	}
+}
+
+func TestManager(t *testing.T) {
+	s1, addr1 := startServer(t, 1, &p4_config_v1.P4Info{})
+	_, addr2 := startServer(t, 2, nil)
+	key1 := DeviceKey{Addr: addr1, DeviceID: 1}
+	key2 := DeviceKey{Addr: addr2, DeviceID: 2}
+
+	options := DefaultOptions
+	options.ReconnectBackoff = 10 * time.Millisecond
+	m := NewManager(options)
+	defer m.Close()
+
+	require.NoError(t, m.AddDevice(deviceConfig(addr1, 1)))
+	require.NoError(t, m.AddDevice(deviceConfig(addr2, 2)))
+	assert.Error(t, m.AddDevice(deviceConfig(addr1, 1)))
+
+	arbitrated := make(map[DeviceKey]bool)
+	for len(arbitrated) < 2 {
+		event := waitEvent(t, m, EventArbitration)
+		assert.True(t, event.IsPrimary)
+		arbitrated[event.Device] = true
+	}
+	// the pipeline cookie is retrieved in the background
+	var status DeviceStatus
+	require.Eventually(t, func() bool {
+		var ok bool
+		status, ok = m.Status(key1)
+		return ok && status.HasPipeline
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, DeviceStatus{Device: key1, Connected: true, IsPrimary: true, HasPipeline: true}, status)
+	status, ok := m.Status(key2)
+	require.True(t, ok)
+	assert.False(t, status.HasPipeline)
+	assert.Len(t, m.Devices(), 2)
+	c, ok := m.Client(key1)
+	require.True(t, ok)
+	assert.NotNil(t, c)
+
+	// stream messages are tagged with the device
+	require.NoError(t, s1.SendPacketIn(&p4_v1.PacketIn{Payload: []byte{1}}))
+	event := waitEvent(t, m, EventStreamMessage)
+	assert.Equal(t, key1, event.Device)
+	assert.Equal(t, []byte{1}, event.Message.GetPacket().Payload)
+
+	// the stream is re-established after a failure
+	s1.CloseStreams()
+	event = waitEvent(t, m, EventDisconnected)
+	assert.Equal(t, key1, event.Device)
+	assert.Error(t, event.Err)
+	event = waitEvent(t, m, EventArbitration)
+	assert.Equal(t, key1, event.Device)
+	assert.True(t, event.IsPrimary)
+	status, _ = m.Status(key1)
+	assert.True(t, status.Connected)
+	assert.Error(t, status.LastError)
+
+	require.NoError(t, m.RemoveDevice(key2))
+	event = waitEvent(t, m, EventRemoved)
+	assert.Equal(t, key2, event.Device)
+	_, ok = m.Status(key2)
+	assert.False(t, ok)
+	assert.Error(t, m.RemoveDevice(key2))
+
+	m.Close()
+	_, ok = <-m.Events()
+	for ok {
+		_, ok = <-m.Events()
+	}
+	assert.Error(t, m.AddDevice(deviceConfig(addr2, 2)))
+}
+
+func TestRemoveDeviceClosesStream(t *testing.T) {
+	_, addr := startServer(t, 1, &p4_config_v1.P4Info{})
+	key := DeviceKey{Addr: addr, DeviceID: 1}
+	m := NewManager(DefaultOptions)
+	defer m.Close()
+
+	require.NoError(t, m.AddDevice(deviceConfig(addr, 1)))
+	waitEvent(t, m, EventArbitration)
+	c, ok := m.Client(key)
+	require.True(t, ok)
+
+	// the stream is closed gracefully before the connection is closed
+	require.NoError(t, m.RemoveDevice(key))
+	select {
+	case <-c.Done():
+	default:
+		require.FailNow(t, "stream is still running")
+	}
+	assert.NoError(t, c.Err())
+}
@@ -0,0 +1,123 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	// register the P4Runtime message types, which are needed to decode
+	// records
+	_ "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// jsonRecord is the encoding of a Record in a recording file. Messages are
+// encoded with protojson, along with their full type name.
+type jsonRecord struct {
+	Time      time.Time       `json:"time"`
+	Call      uint64          `json:"call"`
+	RPC       string          `json:"rpc"`
+	Direction Direction       `json:"direction"`
+	Type      string          `json:"type,omitempty"`
+	Message   json.RawMessage `json:"message,omitempty"`
+	Code      codes.Code      `json:"code,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+func encodeRecord(rec *Record) (*jsonRecord, error) {
+	jsonRec := &jsonRecord{
+		Time:      rec.Time,
+		Call:      rec.Call,
+		RPC:       rec.RPC,
+		Direction: rec.Direction,
+		Code:      rec.Code,
+		Error:     rec.Error,
+	}
+	if rec.Message != nil {
+		b, err := protojson.Marshal(rec.Message)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encode %s message: %v", rec.RPC, err)
+		}
+		jsonRec.Type = string(rec.Message.ProtoReflect().Descriptor().FullName())
+		jsonRec.Message = b
+	}
+	return jsonRec, nil
+}
+
+func decodeRecord(jsonRec *jsonRecord) (*Record, error) {
+	rec := &Record{
+		Time:      jsonRec.Time,
+		Call:      jsonRec.Call,
+		RPC:       jsonRec.RPC,
+		Direction: jsonRec.Direction,
+		Code:      jsonRec.Code,
+		Error:     jsonRec.Error,
+	}
+	if jsonRec.Type == "" {
+		return rec, nil
+	}
+	msgType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(jsonRec.Type))
+	if err != nil {
+		return nil, fmt.Errorf("unknown message type %s: %v", jsonRec.Type, err)
+	}
+	msg := msgType.New().Interface()
+	if err := protojson.Unmarshal(jsonRec.Message, msg); err != nil {
+		return nil, fmt.Errorf("cannot decode %s message: %v", jsonRec.Type, err)
+	}
+	rec.Message = msg
+	return rec, nil
+}
+
+// ReadRecords reads all the records written by a Recorder.
+func ReadRecords(r io.Reader) ([]*Record, error) {
+	var records []*Record
+	scanner := bufio.NewScanner(r)
+	// P4Info messages in SetForwardingPipelineConfig requests can be large
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var jsonRec jsonRecord
+		if err := json.Unmarshal(scanner.Bytes(), &jsonRec); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		rec, err := decodeRecord(&jsonRec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ReadRecordsFile reads all the records from a recording file.
+func ReadRecordsFile(path string) ([]*Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadRecords(f)
+}
+
+// marshalText is used to show messages in error messages.
+func marshalText(msg proto.Message) string {
+	if msg == nil {
+		return "<nil>"
+	}
+	return protojson.Format(msg)
+}
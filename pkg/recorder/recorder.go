@@ -0,0 +1,232 @@
+// Package recorder records the P4Runtime traffic of a client, and replays it.
+//
+// A Recorder wraps the p4_v1.P4RuntimeClient passed to client.NewClient and
+// logs every request, response and stream message, with a timestamp, to an
+// io.Writer, using one JSON object per line:
+//
+//	rec := recorder.NewRecorder(p4_v1.NewP4RuntimeClient(conn), f)
+//	p4RtC := client.NewClient(rec, deviceID, electionID)
+//
+// Recordings can be loaded with ReadRecords. Replay re-issues the recorded
+// writes against another server, and CompareRequests checks that two
+// recordings contain the same requests, e.g. to verify that a new version of a
+// controller behaves like the previous one.
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// RPC names used in records.
+const (
+	RPCWrite                       = "Write"
+	RPCRead                        = "Read"
+	RPCSetForwardingPipelineConfig = "SetForwardingPipelineConfig"
+	RPCGetForwardingPipelineConfig = "GetForwardingPipelineConfig"
+	RPCStreamChannel               = "StreamChannel"
+	RPCCapabilities                = "Capabilities"
+)
+
+// Direction indicates whether a message was sent or received by the client.
+type Direction string
+
+const (
+	DirectionSend    Direction = "send"
+	DirectionReceive Direction = "recv"
+)
+
+// Record is a message sent or received by the client, or an RPC error.
+type Record struct {
+	Time time.Time
+	// Call identifies the RPC call, so that responses and stream messages
+	// can be matched with the corresponding requests.
+	Call      uint64
+	RPC       string
+	Direction Direction
+	// Message is nil for errors.
+	Message proto.Message
+	// Code and Error are set for RPC errors, which are recorded with
+	// DirectionReceive.
+	Code  codes.Code
+	Error string
+}
+
+// Recorder implements p4_v1.P4RuntimeClient by forwarding all RPCs to another
+// P4RuntimeClient and recording the traffic. It is safe for concurrent use.
+type Recorder struct {
+	client   p4_v1.P4RuntimeClient
+	nextCall atomic.Uint64
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+	err     error
+}
+
+// Recorder implements the p4_v1.P4RuntimeClient interface
+var _ p4_v1.P4RuntimeClient = &Recorder{}
+
+// NewRecorder returns a Recorder which forwards RPCs to client and writes
+// records to w.
+func NewRecorder(client p4_v1.P4RuntimeClient, w io.Writer) *Recorder {
+	return &Recorder{
+		client:  client,
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// Err returns the first error which occurred when writing a record, if any.
+// Recording stops after an error, but RPCs are still forwarded.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(t time.Time, call uint64, rpc string, direction Direction, msg proto.Message, err error) {
+	rec := &Record{
+		Time:      t,
+		Call:      call,
+		RPC:       rpc,
+		Direction: direction,
+		Message:   msg,
+	}
+	if err != nil {
+		st, _ := status.FromError(err)
+		rec.Code = st.Code()
+		rec.Error = st.Message()
+	}
+	jsonRec, encodeErr := encodeRecord(rec)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if encodeErr != nil {
+		r.err = encodeErr
+		return
+	}
+	r.err = r.encoder.Encode(jsonRec)
+}
+
+// recordRequest records the request of an RPC, before the RPC is
+// invoked, and returns the call identifier to use for the response.
+func (r *Recorder) recordRequest(rpc string, req proto.Message) uint64 {
+	call := r.nextCall.Add(1)
+	r.record(time.Now(), call, rpc, DirectionSend, req, nil)
+	return call
+}
+
+// recordResponse records the response or the error of a unary RPC.
+func (r *Recorder) recordResponse(call uint64, rpc string, resp proto.Message, err error) {
+	if err != nil {
+		r.record(time.Now(), call, rpc, DirectionReceive, nil, err)
+		return
+	}
+	r.record(time.Now(), call, rpc, DirectionReceive, resp, nil)
+}
+
+func (r *Recorder) Write(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+	call := r.recordRequest(RPCWrite, in)
+	resp, err := r.client.Write(ctx, in, opts...)
+	r.recordResponse(call, RPCWrite, resp, err)
+	return resp, err
+}
+
+func (r *Recorder) SetForwardingPipelineConfig(ctx context.Context, in *p4_v1.SetForwardingPipelineConfigRequest, opts ...grpc.CallOption) (*p4_v1.SetForwardingPipelineConfigResponse, error) {
+	call := r.recordRequest(RPCSetForwardingPipelineConfig, in)
+	resp, err := r.client.SetForwardingPipelineConfig(ctx, in, opts...)
+	r.recordResponse(call, RPCSetForwardingPipelineConfig, resp, err)
+	return resp, err
+}
+
+func (r *Recorder) GetForwardingPipelineConfig(ctx context.Context, in *p4_v1.GetForwardingPipelineConfigRequest, opts ...grpc.CallOption) (*p4_v1.GetForwardingPipelineConfigResponse, error) {
+	call := r.recordRequest(RPCGetForwardingPipelineConfig, in)
+	resp, err := r.client.GetForwardingPipelineConfig(ctx, in, opts...)
+	r.recordResponse(call, RPCGetForwardingPipelineConfig, resp, err)
+	return resp, err
+}
+
+func (r *Recorder) Capabilities(ctx context.Context, in *p4_v1.CapabilitiesRequest, opts ...grpc.CallOption) (*p4_v1.CapabilitiesResponse, error) {
+	call := r.recordRequest(RPCCapabilities, in)
+	resp, err := r.client.Capabilities(ctx, in, opts...)
+	r.recordResponse(call, RPCCapabilities, resp, err)
+	return resp, err
+}
+
+func (r *Recorder) Read(ctx context.Context, in *p4_v1.ReadRequest, opts ...grpc.CallOption) (p4_v1.P4Runtime_ReadClient, error) {
+	call := r.recordRequest(RPCRead, in)
+	stream, err := r.client.Read(ctx, in, opts...)
+	if err != nil {
+		r.record(time.Now(), call, RPCRead, DirectionReceive, nil, err)
+		return nil, err
+	}
+	return &readClient{P4Runtime_ReadClient: stream, recorder: r, call: call}, nil
+}
+
+func (r *Recorder) StreamChannel(ctx context.Context, opts ...grpc.CallOption) (p4_v1.P4Runtime_StreamChannelClient, error) {
+	call := r.nextCall.Add(1)
+	stream, err := r.client.StreamChannel(ctx, opts...)
+	if err != nil {
+		r.record(time.Now(), call, RPCStreamChannel, DirectionReceive, nil, err)
+		return nil, err
+	}
+	return &streamChannelClient{P4Runtime_StreamChannelClient: stream, recorder: r, call: call}, nil
+}
+
+type readClient struct {
+	p4_v1.P4Runtime_ReadClient
+	recorder *Recorder
+	call     uint64
+}
+
+func (c *readClient) Recv() (*p4_v1.ReadResponse, error) {
+	resp, err := c.P4Runtime_ReadClient.Recv()
+	if err == io.EOF {
+		return resp, err
+	}
+	if err != nil {
+		c.recorder.record(time.Now(), c.call, RPCRead, DirectionReceive, nil, err)
+		return resp, err
+	}
+	c.recorder.record(time.Now(), c.call, RPCRead, DirectionReceive, resp, nil)
+	return resp, nil
+}
+
+type streamChannelClient struct {
+	p4_v1.P4Runtime_StreamChannelClient
+	recorder *Recorder
+	call     uint64
+}
+
+func (c *streamChannelClient) Send(msg *p4_v1.StreamMessageRequest) error {
+	err := c.P4Runtime_StreamChannelClient.Send(msg)
+	if err == nil {
+		c.recorder.record(time.Now(), c.call, RPCStreamChannel, DirectionSend, msg, nil)
+	}
+	return err
+}
+
+func (c *streamChannelClient) Recv() (*p4_v1.StreamMessageResponse, error) {
+	msg, err := c.P4Runtime_StreamChannelClient.Recv()
+	if err == io.EOF {
+		return msg, err
+	}
+	if err != nil {
+		c.recorder.record(time.Now(), c.call, RPCStreamChannel, DirectionReceive, nil, err)
+		return msg, err
+	}
+	c.recorder.record(time.Now(), c.call, RPCStreamChannel, DirectionReceive, msg, nil)
+	return msg, nil
+}
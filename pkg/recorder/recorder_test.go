@@ -0,0 +1,213 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	p4_config_v1 "github.com/p4lang/p4runtime/go/p4/config/v1"
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+
+	"github.com/antoninbas/p4runtime-go-client/pkg/client"
+	"github.com/antoninbas/p4runtime-go-client/pkg/fakeserver"
+)
+
+const (
+	testDeviceID = 1
+	testTableID  = 1
+	testActionID = 10
+)
+
+func newTestP4Info() *p4_config_v1.P4Info {
+	return &p4_config_v1.P4Info{
+		Tables: []*p4_config_v1.Table{{
+			Preamble: &p4_config_v1.Preamble{Name: "dmac", Id: testTableID},
+			MatchFields: []*p4_config_v1.MatchField{
+				{Id: 1, Name: "dstAddr", Bitwidth: 48, Match: &p4_config_v1.MatchField_MatchType_{MatchType: p4_config_v1.MatchField_EXACT}},
+			},
+			ActionRefs: []*p4_config_v1.ActionRef{{Id: testActionID}},
+		}},
+		Actions: []*p4_config_v1.Action{{
+			Preamble: &p4_config_v1.Preamble{Name: "fwd", Id: testActionID},
+			Params:   []*p4_config_v1.Action_Param{{Id: 1, Name: "port", Bitwidth: 9}},
+		}},
+	}
+}
+
+func newTestEntry(p4RtC *client.Client, mac byte) *p4_v1.TableEntry {
+	return p4RtC.NewTableEntry(
+		"dmac",
+		map[string]client.MatchInterface{"dstAddr": &client.ExactMatch{Value: []byte{0, 0, 0, 0, 0, mac}}},
+		p4RtC.NewTableActionDirect("fwd", [][]byte{{1}}),
+		nil,
+	)
+}
+
+// startPrimary starts a fake server and a primary Client for it. p4RtC is
+// wrapped with wrap if not nil.
+func startPrimary(t *testing.T, wrap func(p4_v1.P4RuntimeClient) p4_v1.P4RuntimeClient) (*fakeserver.Server, p4_v1.P4RuntimeClient, *client.Client) {
+	s := fakeserver.NewServer(testDeviceID, newTestP4Info())
+	require.NoError(t, s.Start())
+	t.Cleanup(s.Stop)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := s.Dial(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	var p4RtClient p4_v1.P4RuntimeClient = p4_v1.NewP4RuntimeClient(conn)
+	if wrap != nil {
+		p4RtClient = wrap(p4RtClient)
+	}
+	p4RtC := client.NewClient(p4RtClient, testDeviceID, &p4_v1.Uint128{Low: 1})
+	p4RtC.SetP4Info(newTestP4Info())
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	arbitrationCh := make(chan bool, 1)
+	go p4RtC.Run(stopCh, arbitrationCh, nil) //nolint:errcheck
+	select {
+	case isPrimary := <-arbitrationCh:
+		require.True(t, isPrimary)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timeout when waiting for arbitration")
+	}
+	return s, p4RtClient, p4RtC
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	var recorder *Recorder
+	_, _, p4RtC := startPrimary(t, func(c p4_v1.P4RuntimeClient) p4_v1.P4RuntimeClient {
+		recorder = NewRecorder(c, &buf)
+		return recorder
+	})
+	require.NoError(t, p4RtC.InsertTableEntry(ctx, newTestEntry(p4RtC, 1)))
+	require.NoError(t, p4RtC.InsertTableEntry(ctx, newTestEntry(p4RtC, 2)))
+	require.Error(t, p4RtC.InsertTableEntry(ctx, newTestEntry(p4RtC, 2)))
+	entries, err := p4RtC.ReadTableEntryWildcard(ctx, "dmac")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.NoError(t, recorder.Err())
+
+	records, err := ReadRecords(&buf)
+	require.NoError(t, err)
+	var writes, reads, streamMsgs int
+	for _, rec := range records {
+		switch {
+		case rec.RPC == RPCWrite && rec.Direction == DirectionSend:
+			writes++
+		case rec.RPC == RPCRead && rec.Direction == DirectionReceive:
+			reads++
+		case rec.RPC == RPCStreamChannel:
+			streamMsgs++
+		}
+	}
+	assert.Equal(t, 3, writes)
+	// the fake server sends one entity per ReadResponse
+	assert.Equal(t, 2, reads)
+	// arbitration request and response
+	assert.Equal(t, 2, streamMsgs)
+	last := records[len(records)-1]
+	assert.Equal(t, RPCRead, last.RPC)
+
+	// replay against another server
+	s2, p4RtClient2, _ := startPrimary(t, nil)
+	stats, err := Replay(ctx, p4RtClient2, records, ReplayOptions{ElectionID: &p4_v1.Uint128{Low: 1}})
+	require.NoError(t, err)
+	assert.Equal(t, &ReplayStats{Requests: 3, Failures: 1}, stats)
+	assert.Len(t, s2.TableEntries(testTableID), 2)
+
+	// the outcome is different when replaying against the same server
+	_, err = Replay(ctx, p4RtClient2, records, DefaultReplayOptions)
+	assert.Error(t, err)
+}
+
+// blockingClient is a P4RuntimeClient whose Write calls block until unblocked.
+type blockingClient struct {
+	p4_v1.P4RuntimeClient
+	writeCh chan struct{}
+}
+
+func (c *blockingClient) Write(ctx context.Context, in *p4_v1.WriteRequest, opts ...grpc.CallOption) (*p4_v1.WriteResponse, error) {
+	<-c.writeCh
+	return nil, status.Error(codes.Unavailable, "unavailable")
+}
+
+func TestRecordRequestBeforeResponse(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	w := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.Write(p)
+	})
+	readRecords := func() []*Record {
+		mu.Lock()
+		defer mu.Unlock()
+		records, err := ReadRecords(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		return records
+	}
+	c := &blockingClient{writeCh: make(chan struct{})}
+	recorder := NewRecorder(c, w)
+	doneCh := make(chan error, 1)
+	go func() {
+		_, err := recorder.Write(context.Background(), &p4_v1.WriteRequest{DeviceId: testDeviceID})
+		doneCh <- err
+	}()
+
+	// the request is recorded while the RPC is in progress
+	assert.Eventually(t, func() bool {
+		return len(readRecords()) == 1
+	}, time.Second, 10*time.Millisecond)
+	close(c.writeCh)
+	require.Error(t, <-doneCh)
+	require.NoError(t, recorder.Err())
+
+	records := readRecords()
+	require.Len(t, records, 2)
+	assert.Equal(t, DirectionSend, records[0].Direction)
+	assert.Equal(t, DirectionReceive, records[1].Direction)
+	assert.Equal(t, codes.Unavailable, records[1].Code)
+	assert.Equal(t, records[0].Call, records[1].Call)
+	assert.False(t, records[1].Time.Before(records[0].Time))
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestCompareRequests(t *testing.T) {
+	write := func(mac byte) *Record {
+		return &Record{
+			RPC:       RPCWrite,
+			Direction: DirectionSend,
+			Message: &p4_v1.WriteRequest{Updates: []*p4_v1.Update{{
+				Type: p4_v1.Update_INSERT,
+				Entity: &p4_v1.Entity{Entity: &p4_v1.Entity_TableEntry{TableEntry: &p4_v1.TableEntry{
+					TableId: testTableID,
+					Match: []*p4_v1.FieldMatch{{
+						FieldId:        1,
+						FieldMatchType: &p4_v1.FieldMatch_Exact_{Exact: &p4_v1.FieldMatch_Exact{Value: []byte{mac}}},
+					}},
+				}}},
+			}}},
+		}
+	}
+	response := &Record{RPC: RPCWrite, Direction: DirectionReceive, Code: codes.AlreadyExists}
+
+	assert.NoError(t, CompareRequests([]*Record{write(1), write(2)}, []*Record{write(1), response, write(2)}))
+	assert.Error(t, CompareRequests([]*Record{write(1), write(2)}, []*Record{write(1), write(3)}))
+	assert.Error(t, CompareRequests([]*Record{write(1), write(2)}, []*Record{write(1)}))
+}
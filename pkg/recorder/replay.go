@@ -0,0 +1,148 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	p4_v1 "github.com/p4lang/p4runtime/go/p4/v1"
+)
+
+// ReplayOptions configures Replay.
+type ReplayOptions struct {
+	// DeviceID, if not 0, replaces the device id in replayed requests.
+	DeviceID uint64
+	// ElectionID, if not nil, replaces the election id in replayed requests.
+	// The caller needs to be the primary client for this election id, e.g.
+	// by running a client.Client stream.
+	ElectionID *p4_v1.Uint128
+	// Pipeline also replays SetForwardingPipelineConfig requests.
+	Pipeline bool
+	// PreserveTiming waits between requests, to reproduce the recorded
+	// timing.
+	PreserveTiming bool
+	// IgnoreOutcome does not fail the replay when the outcome (success or
+	// gRPC status code) of a request is different from the recorded one.
+	IgnoreOutcome bool
+}
+
+var DefaultReplayOptions = ReplayOptions{
+	DeviceID:       0,
+	ElectionID:     nil,
+	Pipeline:       false,
+	PreserveTiming: false,
+	IgnoreOutcome:  false,
+}
+
+// ReplayStats is returned by Replay.
+type ReplayStats struct {
+	Requests int
+	Failures int
+}
+
+// recordedOutcome returns the response or error record for the call, or nil
+// if the recording does not include it.
+func recordedOutcome(records []*Record, call uint64) *Record {
+	for _, rec := range records {
+		if rec.Call == call && rec.Direction == DirectionReceive {
+			return rec
+		}
+	}
+	return nil
+}
+
+// Replay re-issues the recorded Write requests (and optionally
+// SetForwardingPipelineConfig requests), in order, using client. Unless
+// options.IgnoreOutcome is set, Replay stops with an error when the outcome of
+// a request differs from the recorded one.
+func Replay(ctx context.Context, client p4_v1.P4RuntimeClient, records []*Record, options ReplayOptions) (*ReplayStats, error) {
+	stats := &ReplayStats{}
+	var last time.Time
+	for idx, rec := range records {
+		if rec.Direction != DirectionSend {
+			continue
+		}
+		if rec.RPC != RPCWrite && !(options.Pipeline && rec.RPC == RPCSetForwardingPipelineConfig) {
+			continue
+		}
+		if options.PreserveTiming && !last.IsZero() {
+			select {
+			case <-time.After(rec.Time.Sub(last)):
+			case <-ctx.Done():
+				return stats, ctx.Err()
+			}
+		}
+		last = rec.Time
+
+		var err error
+		switch req := proto.Clone(rec.Message).(type) {
+		case *p4_v1.WriteRequest:
+			if options.DeviceID != 0 {
+				req.DeviceId = options.DeviceID
+			}
+			if options.ElectionID != nil {
+				req.ElectionId = options.ElectionID
+			}
+			_, err = client.Write(ctx, req)
+		case *p4_v1.SetForwardingPipelineConfigRequest:
+			if options.DeviceID != 0 {
+				req.DeviceId = options.DeviceID
+			}
+			if options.ElectionID != nil {
+				req.ElectionId = options.ElectionID
+			}
+			_, err = client.SetForwardingPipelineConfig(ctx, req)
+		default:
+			return stats, fmt.Errorf("record %d: unexpected message type %T for %s request", idx, rec.Message, rec.RPC)
+		}
+		stats.Requests++
+		if err != nil {
+			stats.Failures++
+		}
+
+		if options.IgnoreOutcome {
+			continue
+		}
+		outcome := recordedOutcome(records[idx+1:], rec.Call)
+		if outcome == nil {
+			continue
+		}
+		if code := status.Code(err); code != outcome.Code {
+			return stats, fmt.Errorf("record %d: %s request returned %v but %v was recorded (error: %v)", idx, rec.RPC, code, outcome.Code, err)
+		}
+	}
+	return stats, nil
+}
+
+// CompareRequests checks that the two recordings include the same requests
+// (unary requests and messages sent on the stream), in the same order.
+// Timestamps and responses are ignored. It returns an error describing the
+// first difference.
+func CompareRequests(expected []*Record, actual []*Record) error {
+	filter := func(records []*Record) []*Record {
+		var out []*Record
+		for _, rec := range records {
+			if rec.Direction == DirectionSend {
+				out = append(out, rec)
+			}
+		}
+		return out
+	}
+	expected, actual = filter(expected), filter(actual)
+	for idx := 0; idx < len(expected) && idx < len(actual); idx++ {
+		e, a := expected[idx], actual[idx]
+		if e.RPC != a.RPC {
+			return fmt.Errorf("request %d: expected %s request but got %s request", idx, e.RPC, a.RPC)
+		}
+		if !proto.Equal(e.Message, a.Message) {
+			return fmt.Errorf("request %d: %s requests differ\nexpected:\n%s\nactual:\n%s", idx, e.RPC, marshalText(e.Message), marshalText(a.Message))
+		}
+	}
+	if len(expected) != len(actual) {
+		return fmt.Errorf("expected %d requests but got %d", len(expected), len(actual))
+	}
+	return nil
+}
@@ -1,11 +1,12 @@
 package signals
 
 import (
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/antoninbas/p4runtime-go-client/pkg/logging"
 )
 
 var capturedSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
@@ -13,8 +14,15 @@ var capturedSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
 // RegisterSignalHandlers registers a signal handler for capturedSignals and starts a goroutine that
 // will block until a signal is received. The first signal received will cause the stopCh channel to
 // be closed, giving the opportunity to the program to exist gracefully. If a second signal is
-// received before then, we will force exit with code 1.
+// received before then, we will force exit with code 1, after logging a warning with the default
+// slog Logger.
 func RegisterSignalHandlers() <-chan struct{} {
+	return RegisterSignalHandlersWithLogger(logging.NewSlogLogger(slog.Default()))
+}
+
+// RegisterSignalHandlersWithLogger is like RegisterSignalHandlers, but logs a
+// warning with the provided Logger before forcing exit.
+func RegisterSignalHandlersWithLogger(logger logging.Logger) <-chan struct{} {
 	notifyCh := make(chan os.Signal, 2)
 	stopCh := make(chan struct{})
 
@@ -22,7 +30,7 @@ func RegisterSignalHandlers() <-chan struct{} {
 		<-notifyCh
 		close(stopCh)
 		<-notifyCh
-		log.Warning("Received second signal, will force exit")
+		logger.Warn("Received second signal, will force exit")
 		os.Exit(1)
 	}()
 
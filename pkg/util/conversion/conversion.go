@@ -3,7 +3,10 @@ package conversion
 import (
 	"encoding/binary"
 	"fmt"
+	"math/big"
 	"net"
+	"regexp"
+	"strings"
 )
 
 func IpToBinary(ipStr string) ([]byte, error) {
@@ -55,3 +58,75 @@ func ToCanonicalBytestring(bytes []byte) []byte {
 	}
 	return bytes[i:]
 }
+
+var macRegexp = regexp.MustCompile(`^([0-9a-fA-F]{2}[:-]){5}[0-9a-fA-F]{2}$`)
+
+// ParseLiteral converts the string representation of a value to a
+// bytestring of ceil(bitwidth/8) bytes. Supported formats are MAC addresses
+// (00:11:22:33:44:55), IPv4 and IPv6 addresses, hexadecimal (0x...), binary
+// (0b...) and decimal integers, and double-quoted strings. If bitwidth is 0
+// (e.g. for fields with P4Runtime translation), the shortest representation is
+// returned.
+func ParseLiteral(s string, bitwidth int32) ([]byte, error) {
+	numBytes := int((bitwidth + 7) / 8)
+	checkWidth := func(b []byte, expectedBitwidth int32) ([]byte, error) {
+		if bitwidth != 0 && bitwidth != expectedBitwidth {
+			return nil, fmt.Errorf("'%s' is a %d-bit value but expected bitwidth is %d", s, expectedBitwidth, bitwidth)
+		}
+		return b, nil
+	}
+	switch {
+	case macRegexp.MatchString(s):
+		b, err := MacToBinary(s)
+		if err != nil {
+			return nil, err
+		}
+		return checkWidth(b, 48)
+	case strings.HasPrefix(s, "\"") && strings.HasSuffix(s, "\"") && len(s) >= 2:
+		b := []byte(s[1 : len(s)-1])
+		if numBytes == 0 {
+			return b, nil
+		}
+		if len(b) > numBytes {
+			return nil, fmt.Errorf("string %s does not fit in %d bits", s, bitwidth)
+		}
+		return append(make([]byte, numBytes-len(b)), b...), nil
+	case strings.Contains(s, ":") || strings.Count(s, ".") == 3:
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("not a valid IP address: %s", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil && !strings.Contains(s, ":") {
+			return checkWidth([]byte(ip4), 32)
+		}
+		return checkWidth([]byte(ip.To16()), 128)
+	}
+
+	i := new(big.Int)
+	var ok bool
+	switch {
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		_, ok = i.SetString(s[2:], 16)
+	case strings.HasPrefix(s, "0b") || strings.HasPrefix(s, "0B"):
+		_, ok = i.SetString(s[2:], 2)
+	default:
+		_, ok = i.SetString(s, 10)
+	}
+	if !ok {
+		return nil, fmt.Errorf("cannot parse value '%s'", s)
+	}
+	if i.Sign() < 0 {
+		return nil, fmt.Errorf("negative values are not supported: %s", s)
+	}
+	if bitwidth != 0 && i.BitLen() > int(bitwidth) {
+		return nil, fmt.Errorf("value %s does not fit in %d bits", s, bitwidth)
+	}
+	b := i.Bytes()
+	if numBytes == 0 {
+		if len(b) == 0 {
+			return []byte{'\x00'}, nil
+		}
+		return b, nil
+	}
+	return append(make([]byte, numBytes-len(b)), b...), nil
+}
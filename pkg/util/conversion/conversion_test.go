@@ -42,3 +42,35 @@ func TestToCanonicalBytestring(t *testing.T) {
 		assert.Equal(t, tc.out, out)
 	}
 }
+
+func TestParseLiteral(t *testing.T) {
+	testCases := []struct {
+		in       string
+		bitwidth int32
+		out      []byte
+		err      bool
+	}{
+		{"00:11:22:33:44:55", 48, []byte{'\x00', '\x11', '\x22', '\x33', '\x44', '\x55'}, false},
+		{"00:11:22:33:44:55", 32, nil, true},
+		{"10.0.0.1", 32, []byte{'\x0a', '\x00', '\x00', '\x01'}, false},
+		{"::1", 128, append(make([]byte, 15), '\x01'), false},
+		{"0xab", 16, []byte{'\x00', '\xab'}, false},
+		{"0b101", 9, []byte{'\x00', '\x05'}, false},
+		{"3", 9, []byte{'\x00', '\x03'}, false},
+		{"256", 8, nil, true},
+		{"300", 0, []byte{'\x01', '\x2c'}, false},
+		{"0", 0, []byte{'\x00'}, false},
+		{"\"ab\"", 32, []byte{'\x00', '\x00', 'a', 'b'}, false},
+		{"foo", 32, nil, true},
+	}
+
+	for _, tc := range testCases {
+		out, err := ParseLiteral(tc.in, tc.bitwidth)
+		if tc.err {
+			assert.Error(t, err, tc.in)
+			continue
+		}
+		assert.NoError(t, err, tc.in)
+		assert.Equal(t, tc.out, out, tc.in)
+	}
+}